@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+var accountIDPattern = regexp.MustCompile(`^\d{12}$`)
+
+// AccountMismatch is reported on /status when a profile's session belongs
+// to a different account than the one configured for it, typically after
+// pasting the wrong keys during a rotation
+type AccountMismatch struct {
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// validateExpectedAccounts rejects anything that isn't a 12-digit account ID
+func validateExpectedAccounts(profiles map[string]ProfileSettings) error {
+	for name, ps := range profiles {
+		if ps.ExpectedAccount != "" && !accountIDPattern.MatchString(ps.ExpectedAccount) {
+			return fmt.Errorf("expectedAccount for %s must be a 12-digit account ID, got %q", name, ps.ExpectedAccount)
+		}
+	}
+	return nil
+}
+
+// accountMismatch compares the profile's last known identity with its
+// expected account. It only reads the identity cache, which login keeps
+// current for profiles with an expectation.
+func accountMismatch(profile string) *AccountMismatch {
+	expected := getProfileSettings(profile).ExpectedAccount
+	if expected == "" {
+		return nil
+	}
+	info := loadIdentity(profile)
+	if info == nil || info.Account == "" || info.Account == expected {
+		return nil
+	}
+	return &AccountMismatch{Expected: expected, Actual: info.Account}
+}
+
+// verifyLoginAccount refreshes the identity right after a login so the
+// comparison reflects the new session rather than a cached one
+func verifyLoginAccount(ctx context.Context, profile string) {
+	if getProfileSettings(profile).ExpectedAccount == "" {
+		return
+	}
+	if _, err := getIdentity(ctx, profile, true); err != nil {
+		return
+	}
+
+	if mismatch := accountMismatch(profile); mismatch != nil {
+		recordAudit(AuditEntry{
+			Action:  "login.account-mismatch",
+			Profile: profile,
+			Result:  "warning",
+			Details: fmt.Sprintf("session is for account %s, expected %s", mismatch.Actual, mismatch.Expected),
+		})
+	}
+}
+
+// withAccountCheck flags an authenticated status whose account is not the
+// expected one. It takes precedence over other warnings: every call made
+// with the session would go to the wrong account.
+func withAccountCheck(status StatusResponse) StatusResponse {
+	if !status.Authenticated {
+		return status
+	}
+	if mismatch := accountMismatch(status.Profile); mismatch != nil {
+		status.AccountMismatch = mismatch
+		status.Warning = fmt.Sprintf("This session is for account %s, but profile %s is expected to use %s",
+			mismatch.Actual, status.Profile, mismatch.Expected)
+	}
+	return status
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// GET /profiles?accounts=true adds the account each profile signs in to,
+// with its alias, so ten accounts can be told apart at a glance. Profiles
+// with a session are looked up through the identity cache, which calls
+// GetCallerIdentity and iam:ListAccountAliases at most once a day. The
+// rest show what was fetched before, or the account their config names.
+
+const (
+	accountSourceIdentity = "identity"
+	accountSourceConfig   = "config"
+)
+
+// ProfileAccount is the account a profile belongs to. Label is what the UI
+// shows, e.g. "123456789012 (prod-payments)".
+type ProfileAccount struct {
+	ID    string `json:"id,omitempty"`
+	Alias string `json:"alias,omitempty"`
+	Label string `json:"label,omitempty"`
+	// Source is "identity" when the account was looked up, or "config"
+	// when it was only read from the profile
+	Source    string     `json:"source,omitempty"`
+	FetchedAt *time.Time `json:"fetchedAt,omitempty"`
+	// Error is the last failed lookup; older results are still shown
+	Error string `json:"error,omitempty"`
+}
+
+func accountLabel(id, alias string) string {
+	if alias == "" {
+		return id
+	}
+	return id + " (" + alias + ")"
+}
+
+// profileAccount resolves profile's account. Only profiles with a usable
+// session are looked up, so listing profiles never asks for a login.
+func profileAccount(ctx context.Context, profile string) *ProfileAccount {
+	var info *IdentityInfo
+	var lookupErr error
+	if creds, err := loadCachedCredentials(profile); err == nil && isCredentialsValid(creds) {
+		info, lookupErr = getIdentity(ctx, profile, false)
+	} else {
+		info = loadIdentity(profile)
+	}
+
+	account := &ProfileAccount{}
+	if lookupErr != nil {
+		account.Error = lookupErr.Error()
+	}
+	if info != nil && info.Account != "" {
+		fetchedAt := info.FetchedAt
+		account.ID = info.Account
+		account.Alias = info.AccountAlias
+		account.Source = accountSourceIdentity
+		account.FetchedAt = &fetchedAt
+		account.Error = info.LastError
+	} else if _, id := profileConfigAccount(profile); id != "" {
+		account.ID = id
+		account.Source = accountSourceConfig
+	}
+	if account.ID == "" && account.Error == "" {
+		return nil
+	}
+	account.Label = accountLabel(account.ID, account.Alias)
+	return account
+}
+
+// withProfileAccounts fills in the account of each profile
+func withProfileAccounts(ctx context.Context, profiles []ProfileInfo) []ProfileInfo {
+	for i := range profiles {
+		profiles[i].Account = profileAccount(ctx, profiles[i].Name)
+	}
+	return profiles
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"time"
+)
+
+// Containers can ask for credentials with labels instead of a manual
+// inject:
+//
+//	aws.profile=dev                  profile to deliver (required)
+//	aws.env-file=/run/aws/creds.env  path inside the container (optional)
+//
+// Delivered files are recorded in the inventory like any other inject, so
+// the renewal job keeps them fresh.
+const (
+	labelAWSProfile = "aws.profile"
+	labelAWSEnvFile = "aws.env-file"
+
+	autoProvisionRetry = 10 * time.Second
+	autoProvisionIdle  = 30 * time.Second
+)
+
+// AutoProvisionSettings enables label-based provisioning. Any process that
+// can start a container can set a label, so only profiles matching one of
+// the glob patterns are handed out.
+type AutoProvisionSettings struct {
+	Enabled  bool     `json:"enabled"`
+	Profiles []string `json:"profiles"`
+}
+
+type dockerEvent struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+}
+
+// events streams engine events matching filters. The event channel closes
+// when the connection drops or ctx ends, and the error channel then says why.
+func (d *dockerClient) events(ctx context.Context, filters map[string][]string) (<-chan dockerEvent, <-chan error, error) {
+	data, err := json.Marshal(filters)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := d.stream(ctx, http.MethodGet, "/events?filters="+url.QueryEscape(string(data)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := make(chan dockerEvent)
+	errc := make(chan error, 1)
+	go func() {
+		defer body.Close()
+		defer close(out)
+		dec := json.NewDecoder(body)
+		for {
+			var e dockerEvent
+			if err := dec.Decode(&e); err != nil {
+				errc <- err
+				return
+			}
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return out, errc, nil
+}
+
+func autoProvisionSettings() *AutoProvisionSettings {
+	ap := loadSettings().AutoProvision
+	if ap == nil || !ap.Enabled {
+		return nil
+	}
+	return ap
+}
+
+func (ap *AutoProvisionSettings) allows(profile string) bool {
+	for _, pattern := range ap.Profiles {
+		if ok, _ := path.Match(pattern, profile); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// provisionContainer delivers the labelled profile's session to a container.
+// A profile without a session is skipped quietly; the container is picked
+// up again after the next login.
+func provisionContainer(ctx context.Context, id string, labels map[string]string) {
+	ap := autoProvisionSettings()
+	profile := labels[labelAWSProfile]
+	if ap == nil || profile == "" {
+		return
+	}
+	if !ap.allows(profile) {
+		recordAudit(AuditEntry{
+			Action:  "autoprovision.denied",
+			Profile: profile,
+			Result:  "error",
+			Details: "profile is not allowed for auto-provisioning",
+			Fields:  map[string]string{"container": id},
+		})
+		return
+	}
+	if isSensitiveProfile(profile) {
+		recordAudit(AuditEntry{
+			Action:  "autoprovision.denied",
+			Profile: profile,
+			Result:  "error",
+			Details: "sensitive profiles need a confirmation, which a label can't give",
+			Fields:  map[string]string{"container": id},
+		})
+		return
+	}
+
+	payload, _, errResp := buildExportPayload(profile, "")
+	if errResp != nil {
+		return
+	}
+	target := ExportTarget{Container: id, Path: labels[labelAWSEnvFile]}
+	if _, err := auditedExport(ctx, "container", target, payload); err != nil {
+		fmt.Fprintf(os.Stderr, "auto-provision %s: %v\n", id, err)
+	}
+}
+
+// provisionRunning delivers to running labelled containers, optionally only
+// those asking for one profile. It catches up on containers started while
+// the watcher wasn't listening and pushes new sessions after a login.
+func provisionRunning(ctx context.Context, docker *dockerClient, profile string) error {
+	label := labelAWSProfile
+	if profile != "" {
+		label += "=" + profile
+	}
+	containers, err := docker.listContainers(ctx, map[string][]string{"label": {label}})
+	if err != nil {
+		return err
+	}
+	for _, ctr := range containers {
+		provisionContainer(ctx, ctr.ID, ctr.Labels)
+	}
+	return nil
+}
+
+// watchLabeledContainers follows container starts for as long as the
+// backend runs, reconnecting whenever the engine goes away. It idles while
+// auto-provisioning is disabled.
+func watchLabeledContainers(ctx context.Context) {
+	logins, unsubscribe := events.subscribe()
+	defer unsubscribe()
+
+	docker := newDockerClient()
+	for ctx.Err() == nil {
+		if autoProvisionSettings() == nil {
+			if !sleepCtx(ctx, autoProvisionIdle) {
+				return
+			}
+			continue
+		}
+
+		err := followLabeledContainers(ctx, docker, logins)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "auto-provision: %v\n", err)
+		}
+		if !sleepCtx(ctx, autoProvisionRetry) {
+			return
+		}
+	}
+}
+
+func followLabeledContainers(ctx context.Context, docker *dockerClient, logins <-chan Event) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	starts, errc, err := docker.events(ctx, map[string][]string{
+		"type":  {"container"},
+		"event": {"start"},
+		"label": {labelAWSProfile},
+	})
+	if err != nil {
+		return err
+	}
+	if err := provisionRunning(ctx, docker, ""); err != nil {
+		return err
+	}
+
+	settingsCheck := time.NewTicker(autoProvisionIdle)
+	defer settingsCheck.Stop()
+	for {
+		select {
+		case e, ok := <-starts:
+			if !ok {
+				return <-errc
+			}
+			provisionContainer(ctx, e.Actor.ID, e.Actor.Attributes)
+		case e := <-logins:
+			if e.Type == eventLogin {
+				if err := provisionRunning(ctx, docker, e.Profile); err != nil {
+					return err
+				}
+			}
+		case <-settingsCheck.C:
+			if autoProvisionSettings() == nil {
+				return nil
+			}
+		}
+	}
+}
+
+// sleepCtx waits for d and reports false if ctx ended first
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
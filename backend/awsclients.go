@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+const fallbackRegion = "us-east-1"
+
+// getProfileRegion returns the configured region for profile, or the
+// fallback region when none is set
+func getProfileRegion(profile string) string {
+	section, err := getProfileSection(profile)
+	if err == nil {
+		if region := section.Key("region").String(); region != "" {
+			return region
+		}
+	}
+	return fallbackRegion
+}
+
+// sessionAWSConfig builds an SDK config that signs with the cached session
+// for profile. All service clients used by dashboard features go through
+// here so they share region and credential handling.
+func sessionAWSConfig(ctx context.Context, profile string) (aws.Config, *CachedCredentials, error) {
+	creds, err := loadCachedCredentials(profile)
+	if err != nil {
+		return aws.Config{}, nil, fmt.Errorf("no cached session for profile %s: %w", profile, err)
+	}
+	if !isCredentialsValid(creds) {
+		return aws.Config{}, nil, fmt.Errorf("cached session for profile %s has expired", profile)
+	}
+
+	cfg, err := staticAWSConfig(ctx, profile, creds)
+	if err != nil {
+		return aws.Config{}, nil, err
+	}
+
+	return cfg, creds, nil
+}
+
+// staticAWSConfig builds an SDK config for profile's region that signs with
+// the given temporary credentials
+func staticAWSConfig(ctx context.Context, profile string, creds *CachedCredentials) (aws.Config, error) {
+	opts := append([]func(*config.LoadOptions) error{
+		config.WithRegion(getProfileRegion(profile)),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
+			creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)),
+	}, awsLoadOptions(profile)...)
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return cfg, nil
+}
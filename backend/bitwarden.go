@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// The Bitwarden integration reads TOTP codes with `bw get totp`, for users
+// who keep their MFA seed in a Bitwarden login item. bw only answers once
+// the vault is unlocked: the session key comes from BW_SESSION in the
+// backend's environment or, so the vault can be unlocked again without a
+// restart, from a file written by `bw unlock --raw`. The extension image
+// ships bw; AppDataDir points it at the vault the host's bw logged in to.
+
+const (
+	defaultBwCommand   = "bw"
+	mfaSourceBitwarden = "bitwarden"
+)
+
+// BitwardenSettings enables the integration. Profiles name their item in
+// profiles.<name>.bitwardenItem.
+type BitwardenSettings struct {
+	Enabled bool `json:"enabled"`
+	// Command is the bw executable, by default found on PATH
+	Command string `json:"command,omitempty"`
+	// SessionFile holds the session key printed by `bw unlock --raw`. It is
+	// read on every call and takes precedence over BW_SESSION.
+	SessionFile string `json:"sessionFile,omitempty"`
+	// AppDataDir is bw's data directory, passed as
+	// BITWARDENCLI_APPDATA_DIR, for a backend that doesn't share the home
+	// directory bw logged in from
+	AppDataDir string `json:"appDataDir,omitempty"`
+}
+
+func bitwardenSettings() *BitwardenSettings {
+	bw := loadSettings().Bitwarden
+	if bw == nil || !bw.Enabled {
+		return nil
+	}
+	return bw
+}
+
+func validateBitwarden(bw *BitwardenSettings, profiles map[string]ProfileSettings) error {
+	if bw != nil && bw.SessionFile != "" && !filepath.IsAbs(bw.SessionFile) {
+		return fmt.Errorf("bitwarden.sessionFile must be an absolute path")
+	}
+	if bw != nil && bw.AppDataDir != "" && !filepath.IsAbs(bw.AppDataDir) {
+		return fmt.Errorf("bitwarden.appDataDir must be an absolute path")
+	}
+	for profile, ps := range profiles {
+		// bw would read a leading dash as a flag
+		if strings.HasPrefix(ps.BitwardenItem, "-") || strings.ContainsAny(ps.BitwardenItem, "\r\n") {
+			return fmt.Errorf("profiles.%s.bitwardenItem is not a valid item name", profile)
+		}
+	}
+	return nil
+}
+
+// bitwardenItem returns the Bitwarden item for profile when the integration
+// is enabled, or ""
+func bitwardenItem(profile string) string {
+	if bitwardenSettings() == nil {
+		return ""
+	}
+	return getProfileSettings(profile).BitwardenItem
+}
+
+// runBw runs bw without prompting, with the session key from the session
+// file when one is set
+func runBw(ctx context.Context, bw *BitwardenSettings, args ...string) (string, error) {
+	return runBwInput(ctx, bw, nil, args...)
+}
+
+// runBwInput is runBw with input on stdin, which is how bw takes items to
+// create or edit without them showing in the process list
+func runBwInput(ctx context.Context, bw *BitwardenSettings, input []byte, args ...string) (string, error) {
+	command := bw.Command
+	if command == "" {
+		command = defaultBwCommand
+	}
+	var env []string
+	if bw.SessionFile != "" {
+		key, err := os.ReadFile(remapPath(bw.SessionFile))
+		if err != nil {
+			return "", fmt.Errorf("reading the Bitwarden session file: %w", err)
+		}
+		env = append(env, "BW_SESSION="+strings.TrimSpace(string(key)))
+	}
+	if bw.AppDataDir != "" {
+		env = append(env, "BITWARDENCLI_APPDATA_DIR="+remapPath(bw.AppDataDir))
+	}
+	if env != nil {
+		env = append(os.Environ(), env...)
+	}
+	args = append(args, "--nointeraction")
+	return runCodeHelperCmd(ctx, "bw", func(ctx context.Context) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, command, args...)
+		cmd.Env = env
+		if input != nil {
+			cmd.Stdin = bytes.NewReader(input)
+		}
+		return cmd
+	})
+}
+
+// bitwardenStatus returns the vault state bw reports: "unlocked", "locked"
+// or "unauthenticated"
+func bitwardenStatus(ctx context.Context, bw *BitwardenSettings) (string, error) {
+	out, err := runBw(ctx, bw, "status")
+	if err != nil {
+		return "", err
+	}
+	var status struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &status); err != nil || status.Status == "" {
+		return "", fmt.Errorf("bw status did not print a vault status")
+	}
+	return status.Status, nil
+}
+
+// bitwardenCode reads the current TOTP code from the profile's item. The
+// code is never included in errors.
+func bitwardenCode(ctx context.Context, profile string) (string, error) {
+	bw := bitwardenSettings()
+	item := bitwardenItem(profile)
+	if bw == nil || item == "" {
+		return "", fmt.Errorf("profile %s has no Bitwarden item", profile)
+	}
+	out, err := runBw(ctx, bw, "get", "totp", item)
+	if err != nil {
+		return "", fmt.Errorf("reading the TOTP code of %s from Bitwarden: %w; check the vault is unlocked", item, err)
+	}
+	code := strings.TrimSpace(out)
+	if !totpCodePattern.MatchString(code) {
+		return "", fmt.Errorf("bw did not print a six-digit code for %s; check the item has a TOTP seed", item)
+	}
+	return code, nil
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// The broker API is the stable surface other Docker Desktop extensions
+// consume through this backend's socket:
+//
+//	GET /broker/v1/status?profile=                     session status
+//	GET /broker/v1/credential-process?profile=&minTtl= credential_process JSON
+//	GET /broker/v1/events                              SSE stream, filtered to
+//	                                                   the consumer's profiles
+//
+// Each consumer authenticates with its own bearer token and must be listed
+// in Settings.Broker.Consumers, which also limits the profiles it may use.
+// That limits a well-behaved consumer, not the socket: any local process
+// can use the UI's routes unless process scoping is on, and then issuing
+// tokens and /credential-process also need a caller in scope.
+
+const (
+	brokerTokensFile    = "broker/tokens.json"
+	brokerContextKey    = "brokerConsumer"
+	credentialProcessV1 = 1
+)
+
+type BrokerSettings struct {
+	Enabled   bool             `json:"enabled"`
+	Consumers []BrokerConsumer `json:"consumers,omitempty"`
+}
+
+// BrokerConsumer allowlists an extension; Profiles are glob patterns
+type BrokerConsumer struct {
+	Name     string   `json:"name"`
+	Profiles []string `json:"profiles"`
+}
+
+type brokerToken struct {
+	Consumer  string    `json:"consumer"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CredentialProcessOutput is the format the AWS SDKs and CLI expect from a
+// credential_process command
+type CredentialProcessOutput struct {
+	Version         int    `json:"Version"`
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken"`
+	Expiration      string `json:"Expiration"`
+}
+
+var brokerMu sync.Mutex
+
+func getBrokerTokensPath() string {
+	return filepath.Join(getCacheDir(), brokerTokensFile)
+}
+
+func hashBrokerToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func loadBrokerTokens() []brokerToken {
+	data, err := os.ReadFile(getBrokerTokensPath())
+	if err != nil {
+		return nil
+	}
+	var tokens []brokerToken
+	json.Unmarshal(data, &tokens)
+	return tokens
+}
+
+func saveBrokerTokens(tokens []brokerToken) error {
+	p := getBrokerTokensPath()
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p, data, 0600)
+}
+
+// brokerConsumer returns the allowlist entry for name, if broker access is
+// enabled and the consumer is listed
+func brokerConsumer(name string) (*BrokerConsumer, bool) {
+	broker := loadSettings().Broker
+	if broker == nil || !broker.Enabled {
+		return nil, false
+	}
+	for i := range broker.Consumers {
+		if broker.Consumers[i].Name == name {
+			return &broker.Consumers[i], true
+		}
+	}
+	return nil, false
+}
+
+func (bc *BrokerConsumer) allows(profile string) bool {
+	for _, pattern := range bc.Profiles {
+		if ok, _ := path.Match(pattern, profile); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// brokerAuth resolves the bearer token to an allowlisted consumer. Only
+// token hashes are stored, so a leaked tokens file can't be replayed.
+func brokerAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		raw := strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+		if raw == "" {
+			return c.JSON(http.StatusUnauthorized, ErrorResponse{
+				Error: "Missing bearer token",
+			})
+		}
+
+		hash := hashBrokerToken(raw)
+		brokerMu.Lock()
+		tokens := loadBrokerTokens()
+		brokerMu.Unlock()
+
+		for _, t := range tokens {
+			if subtle.ConstantTimeCompare([]byte(t.Hash), []byte(hash)) != 1 {
+				continue
+			}
+			consumer, ok := brokerConsumer(t.Consumer)
+			if !ok {
+				return c.JSON(http.StatusForbidden, ErrorResponse{
+					Error: "Consumer is not allowlisted",
+				})
+			}
+			c.Set(brokerContextKey, consumer)
+			return next(c)
+		}
+
+		return c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error: "Invalid token",
+		})
+	}
+}
+
+// brokerProfile reads ?profile= and checks it against the consumer's
+// allowlist, writing the error response itself when it isn't allowed
+func brokerProfile(c echo.Context) (string, bool) {
+	consumer := c.Get(brokerContextKey).(*BrokerConsumer)
+	profile := requestProfile(c, c.QueryParam("profile"))
+	if !consumer.allows(profile) {
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error:   "Profile not allowed for this consumer",
+			Details: profile,
+		})
+		return "", false
+	}
+	return profile, true
+}
+
+func handleBrokerStatus(c echo.Context) error {
+	profile, ok := brokerProfile(c)
+	if !ok {
+		return nil
+	}
+
+	creds, err := loadCachedCredentials(profile)
+	if err != nil || !isCredentialsValid(creds) {
+		return c.JSON(http.StatusOK, StatusResponse{Profile: profile})
+	}
+	return c.JSON(http.StatusOK, newStatusResponse(c, creds))
+}
+
+func handleBrokerCredentialProcess(c echo.Context) error {
+	profile, ok := brokerProfile(c)
+	if !ok {
+		return nil
+	}
+	consumer := c.Get(brokerContextKey).(*BrokerConsumer)
+	minTTL, err := parseMinTTL(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid minTtl",
+			Details: err.Error(),
+		})
+	}
+
+	if isSensitiveProfile(profile) {
+		recordAudit(AuditEntry{
+			Action:  "broker.credentials",
+			Profile: profile,
+			Result:  "denied",
+			Details: "sensitive profiles need a confirmation, which a consumer can't give",
+			Fields:  map[string]string{"consumer": consumer.Name},
+		})
+		return c.JSON(http.StatusForbidden, ErrorResponse{
+			Error:   "Sensitive profile",
+			Details: "profile " + profile + " is sensitive and isn't served to broker consumers",
+		})
+	}
+
+	creds, status, errResp := credentialsForTTL(c.Request().Context(), profile, minTTL)
+	if errResp != nil {
+		return c.JSON(status, errResp)
+	}
+
+	recordAudit(AuditEntry{
+		Action:  "broker.credentials",
+		Profile: profile,
+		Result:  "ok",
+		Fields:  map[string]string{"consumer": consumer.Name},
+	})
+
+	return c.JSON(http.StatusOK, CredentialProcessOutput{
+		Version:         credentialProcessV1,
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		Expiration:      creds.Expiration.UTC().Format(time.RFC3339),
+	})
+}
+
+func handleBrokerEvents(c echo.Context) error {
+	consumer := c.Get(brokerContextKey).(*BrokerConsumer)
+	return streamEvents(c, func(e Event) bool {
+		return e.Profile == "" || consumer.allows(e.Profile)
+	})
+}
+
+// handleIssueBrokerToken creates a token for an allowlisted consumer. The
+// token is only ever returned here; issuing again replaces the old one.
+func handleIssueBrokerToken(c echo.Context) error {
+	name := c.Param("name")
+	if _, ok := brokerConsumer(name); !ok {
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "Consumer is not allowlisted or the broker is disabled",
+		})
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: "Failed to generate token",
+		})
+	}
+	token := hex.EncodeToString(buf)
+
+	brokerMu.Lock()
+	defer brokerMu.Unlock()
+
+	tokens := []brokerToken{}
+	for _, t := range loadBrokerTokens() {
+		if t.Consumer != name {
+			tokens = append(tokens, t)
+		}
+	}
+	tokens = append(tokens, brokerToken{Consumer: name, Hash: hashBrokerToken(token), CreatedAt: time.Now().UTC()})
+	if err := saveBrokerTokens(tokens); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to save token",
+			Details: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusCreated, map[string]string{"consumer": name, "token": token})
+}
+
+func handleRevokeBrokerToken(c echo.Context) error {
+	name := c.Param("name")
+
+	brokerMu.Lock()
+	defer brokerMu.Unlock()
+
+	tokens := []brokerToken{}
+	for _, t := range loadBrokerTokens() {
+		if t.Consumer != name {
+			tokens = append(tokens, t)
+		}
+	}
+	if err := saveBrokerTokens(tokens); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to save tokens",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Token revoked for " + name})
+}
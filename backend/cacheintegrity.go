@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	quarantineSubdir  = "quarantine"
+	eventCacheCorrupt = "cache-corrupt"
+
+	// maxQuarantineLog bounds the recent quarantines kept in memory
+	maxQuarantineLog = 50
+	// staleTempAge is how old a leftover writeFileAtomic temp file must be
+	// before repair removes it, so a write in progress isn't disturbed
+	staleTempAge = time.Minute
+)
+
+// QuarantinedFile is a cache file that couldn't be parsed and was moved
+// aside. Path is where it was; QuarantinePath is where it is now.
+type QuarantinedFile struct {
+	Path           string    `json:"path"`
+	QuarantinePath string    `json:"quarantinePath"`
+	Error          string    `json:"error"`
+	Time           time.Time `json:"time"`
+}
+
+// CacheIntegrityStats counts corrupt cache files found since the backend
+// started, whether by a scan or when a file was read
+type CacheIntegrityStats struct {
+	LastScan           *time.Time        `json:"lastScan,omitempty"`
+	FilesChecked       int               `json:"filesChecked"`
+	Corrupt            int               `json:"corrupt"`
+	Quarantined        int               `json:"quarantined"`
+	QuarantineFailures int               `json:"quarantineFailures"`
+	InQuarantine       int               `json:"inQuarantine"`
+	Recent             []QuarantinedFile `json:"recent"`
+}
+
+// CacheRepairReport is the outcome of POST /cache/repair
+type CacheRepairReport struct {
+	FilesChecked     int               `json:"filesChecked"`
+	Quarantined      []QuarantinedFile `json:"quarantined"`
+	TempFilesRemoved int               `json:"tempFilesRemoved"`
+	Backups          BackupIndexRepair `json:"backups"`
+}
+
+// BackupIndexRepair counts how the backup index changed when rebuilt
+type BackupIndexRepair struct {
+	Kept    int `json:"kept"`
+	Added   int `json:"added"`
+	Dropped int `json:"dropped"`
+}
+
+var cacheIntegrity = struct {
+	mu    sync.Mutex
+	stats CacheIntegrityStats
+}{}
+
+func getQuarantineDir() string {
+	return filepath.Join(getCacheDir(), quarantineSubdir)
+}
+
+// validCacheJSON reports why data isn't a usable JSON document, or nil. An
+// empty file is what a write cut off by a crash usually leaves behind.
+func validCacheJSON(data []byte) error {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return fmt.Errorf("file is empty")
+	}
+	if !json.Valid(data) {
+		return fmt.Errorf("file is not valid JSON")
+	}
+	return nil
+}
+
+// decodeCacheFile unmarshals data read from the cache file at path. A file
+// that isn't JSON at all is quarantined; one that parses but doesn't fit v
+// is left alone, since that is more likely a format change than damage.
+func decodeCacheFile(path string, data []byte, v interface{}) error {
+	if err := validCacheJSON(data); err != nil {
+		quarantineCacheFile(path, err)
+		return fmt.Errorf("corrupt cache file %s: %w", path, err)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// cacheJSONFiles lists the JSON files under the cache directory, leaving
+// out the quarantine and temp files from writes in progress
+func cacheJSONFiles() []string {
+	var files []string
+	filepath.WalkDir(getCacheDir(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path == getQuarantineDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(d.Name(), ".") && strings.HasSuffix(d.Name(), ".json") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files
+}
+
+// quarantineCacheFile moves a corrupt cache file aside so it stops failing
+// every read, keeping it for inspection, and reports it
+func quarantineCacheFile(path string, cause error) *QuarantinedFile {
+	now := time.Now().UTC()
+	rel, err := filepath.Rel(getCacheDir(), path)
+	if err != nil {
+		rel = filepath.Base(path)
+	}
+	name := strings.ReplaceAll(filepath.ToSlash(rel), "/", "__") + "." + now.Format("20060102T150405.000Z")
+	q := &QuarantinedFile{
+		Path:           path,
+		QuarantinePath: filepath.Join(getQuarantineDir(), name),
+		Error:          cause.Error(),
+		Time:           now,
+	}
+
+	err = os.MkdirAll(getQuarantineDir(), 0700)
+	if err == nil {
+		err = os.Rename(path, q.QuarantinePath)
+	}
+	if os.IsNotExist(err) {
+		// Another reader got to it first
+		return nil
+	}
+
+	cacheIntegrity.mu.Lock()
+	cacheIntegrity.stats.Corrupt++
+	if err != nil {
+		cacheIntegrity.stats.QuarantineFailures++
+	} else {
+		cacheIntegrity.stats.Quarantined++
+		cacheIntegrity.stats.Recent = append(cacheIntegrity.stats.Recent, *q)
+		if n := len(cacheIntegrity.stats.Recent); n > maxQuarantineLog {
+			cacheIntegrity.stats.Recent = cacheIntegrity.stats.Recent[n-maxQuarantineLog:]
+		}
+	}
+	cacheIntegrity.mu.Unlock()
+
+	entry := AuditEntry{
+		Action:  "cache-quarantine",
+		Profile: cacheFileProfile(path),
+		Result:  "ok",
+		Details: q.Error,
+		Fields:  map[string]string{"path": path, "quarantine": q.QuarantinePath},
+	}
+	if err != nil {
+		entry.Result = "error"
+		entry.Details = fmt.Sprintf("%s; moving it aside failed: %v", q.Error, err)
+		delete(entry.Fields, "quarantine")
+	}
+	recordAudit(entry)
+	events.publish(Event{Type: eventCacheCorrupt, Profile: entry.Profile, Data: q})
+
+	if err != nil {
+		return nil
+	}
+	return q
+}
+
+// cacheFileProfile returns the profile a cached session file belongs to,
+// or "" for any other cache file
+func cacheFileProfile(path string) string {
+	if profile := sessionFileProfile(path); profile != "" {
+		return profile
+	}
+	// A session left by an earlier version that wasn't migrated
+	if filepath.Dir(path) != getCacheDir() || filepath.Base(path) == filepath.Base(settingsFile) {
+		return ""
+	}
+	return strings.TrimSuffix(filepath.Base(path), ".json")
+}
+
+// scanCacheIntegrity checks every JSON file in the cache and quarantines
+// the ones that don't parse
+func scanCacheIntegrity() (int, []QuarantinedFile) {
+	files := cacheJSONFiles()
+	quarantined := []QuarantinedFile{}
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if err := validCacheJSON(data); err != nil {
+			if q := quarantineCacheFile(path, err); q != nil {
+				quarantined = append(quarantined, *q)
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	cacheIntegrity.mu.Lock()
+	cacheIntegrity.stats.LastScan = &now
+	cacheIntegrity.stats.FilesChecked = len(files)
+	cacheIntegrity.mu.Unlock()
+	return len(files), quarantined
+}
+
+// removeStaleTempFiles deletes temp files left by writes that never
+// finished
+func removeStaleTempFiles() int {
+	removed := 0
+	filepath.WalkDir(getCacheDir(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasPrefix(d.Name(), ".") || !strings.Contains(d.Name(), ".tmp-") {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && time.Since(info.ModTime()) > staleTempAge {
+			if os.Remove(path) == nil {
+				removed++
+			}
+		}
+		return nil
+	})
+	return removed
+}
+
+// backupIDTime parses the timestamp backupFile puts at the start of an ID
+func backupIDTime(id string) (time.Time, bool) {
+	stamp, _, ok := strings.Cut(id, "-")
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse("20060102T150405.000Z", strings.Replace(stamp, "_", ".", 1))
+	return t, err == nil
+}
+
+// rebuildBackupIndex drops index entries whose snapshot is gone and adds
+// snapshots the index lost track of, such as after the index itself was
+// quarantined. Only config and credentials snapshots can be traced back to
+// the file they were taken from.
+func rebuildBackupIndex() (BackupIndexRepair, error) {
+	var repair BackupIndexRepair
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	entries, err := os.ReadDir(getBackupsDir())
+	if os.IsNotExist(err) {
+		return repair, nil
+	}
+	if err != nil {
+		return repair, err
+	}
+	onDisk := make(map[string]os.DirEntry, len(entries))
+	for _, e := range entries {
+		if !e.IsDir() && e.Name() != backupIndexFile && !strings.HasPrefix(e.Name(), ".") {
+			onDisk[e.Name()] = e
+		}
+	}
+
+	var backups []BackupInfo
+	for _, b := range loadBackupIndex() {
+		if _, ok := onDisk[b.ID]; ok {
+			backups = append(backups, b)
+			delete(onDisk, b.ID)
+			repair.Kept++
+		} else {
+			repair.Dropped++
+		}
+	}
+	originals := map[string]string{
+		"config":      absPath(getAWSConfigPath()),
+		"credentials": absPath(getAWSCredentialsPath()),
+	}
+	for id, e := range onDisk {
+		created, ok := backupIDTime(id)
+		_, base, _ := strings.Cut(id, "-")
+		original, known := originals[base]
+		info, err := e.Info()
+		if !ok || !known || err != nil {
+			continue
+		}
+		backups = append(backups, BackupInfo{
+			ID:           id,
+			OriginalPath: original,
+			BackupPath:   filepath.Join(getBackupsDir(), id),
+			CreatedAt:    created,
+			Size:         info.Size(),
+		})
+		repair.Added++
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.Before(backups[j].CreatedAt) })
+	if backups == nil {
+		backups = []BackupInfo{}
+	}
+	return repair, saveBackupIndex(backups)
+}
+
+func handleGetCacheIntegrity(c echo.Context) error {
+	cacheIntegrity.mu.Lock()
+	stats := cacheIntegrity.stats
+	stats.Recent = append([]QuarantinedFile{}, stats.Recent...)
+	cacheIntegrity.mu.Unlock()
+
+	if entries, err := os.ReadDir(getQuarantineDir()); err == nil {
+		stats.InQuarantine = len(entries)
+	}
+	return c.JSON(http.StatusOK, stats)
+}
+
+// handleRepairCache rescans the cache, removes leftover temp files and
+// rebuilds the backup index from the snapshots on disk
+func handleRepairCache(c echo.Context) error {
+	report := CacheRepairReport{}
+	report.FilesChecked, report.Quarantined = scanCacheIntegrity()
+	report.TempFilesRemoved = removeStaleTempFiles()
+
+	backups, err := rebuildBackupIndex()
+	report.Backups = backups
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to rebuild backup index",
+			Details: err.Error(),
+		})
+	}
+
+	recordAudit(AuditEntry{
+		Action: "cache-repair",
+		Result: "ok",
+		Fields: map[string]string{
+			"checked":        fmt.Sprint(report.FilesChecked),
+			"quarantined":    fmt.Sprint(len(report.Quarantined)),
+			"tempRemoved":    fmt.Sprint(report.TempFilesRemoved),
+			"backupsAdded":   fmt.Sprint(backups.Added),
+			"backupsDropped": fmt.Sprint(backups.Dropped),
+		},
+	})
+	return c.JSON(http.StatusOK, report)
+}
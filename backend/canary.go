@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+)
+
+// A canary is a read-only call made with a profile's new session right
+// after login, e.g. s3:ListBucket on the bucket a workflow needs. A login
+// whose session can't make it still succeeds, but the login response says
+// so, rather than the first real command failing later.
+
+const defaultCanaryTimeout = 10 * time.Second
+
+// Canary is one check in a profile's settings. Resource names what the
+// action is tried on, where it needs something: a bucket (optionally
+// bucket/prefix), bucket/key, a parameter or secret name, a table, a queue
+// URL or a topic ARN. Region overrides the profile's region.
+type Canary struct {
+	Action         string `json:"action"`
+	Resource       string `json:"resource,omitempty"`
+	Region         string `json:"region,omitempty"`
+	TimeoutSeconds int    `json:"timeoutSeconds,omitempty"`
+}
+
+type CanaryResult struct {
+	Action     string `json:"action"`
+	Resource   string `json:"resource,omitempty"`
+	OK         bool   `json:"ok"`
+	Code       string `json:"code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+func (c Canary) timeout() time.Duration {
+	if c.TimeoutSeconds > 0 {
+		return time.Duration(c.TimeoutSeconds) * time.Second
+	}
+	return defaultCanaryTimeout
+}
+
+// canaryCheck makes one action's call
+type canaryCheck struct {
+	// resource is what Resource must hold, or "" for actions without one
+	resource string
+	run      func(ctx context.Context, cfg aws.Config, resource string) error
+}
+
+var canaryChecks = map[string]canaryCheck{
+	"sts:GetCallerIdentity": {run: func(ctx context.Context, cfg aws.Config, _ string) error {
+		_, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+		return err
+	}},
+	"ecr:GetAuthorizationToken": {run: func(ctx context.Context, cfg aws.Config, _ string) error {
+		_, err := ecr.NewFromConfig(cfg).GetAuthorizationToken(ctx, &ecr.GetAuthorizationTokenInput{})
+		return err
+	}},
+	"s3:ListBucket": {resource: "bucket or bucket/prefix", run: func(ctx context.Context, cfg aws.Config, resource string) error {
+		bucket, prefix, _ := strings.Cut(resource, "/")
+		in := &s3.ListObjectsV2Input{Bucket: aws.String(bucket), MaxKeys: aws.Int32(1)}
+		if prefix != "" {
+			in.Prefix = aws.String(prefix)
+		}
+		_, err := s3.NewFromConfig(cfg).ListObjectsV2(ctx, in)
+		return err
+	}},
+	"s3:GetObject": {resource: "bucket/key", run: func(ctx context.Context, cfg aws.Config, resource string) error {
+		bucket, key, _ := strings.Cut(resource, "/")
+		_, err := s3.NewFromConfig(cfg).HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+		return err
+	}},
+	"ssm:GetParameter": {resource: "parameter name", run: func(ctx context.Context, cfg aws.Config, resource string) error {
+		_, err := ssm.NewFromConfig(cfg).GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String(resource)})
+		return err
+	}},
+	"secretsmanager:DescribeSecret": {resource: "secret name or ARN", run: func(ctx context.Context, cfg aws.Config, resource string) error {
+		_, err := secretsmanager.NewFromConfig(cfg).DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(resource)})
+		return err
+	}},
+	"dynamodb:DescribeTable": {resource: "table name", run: func(ctx context.Context, cfg aws.Config, resource string) error {
+		_, err := dynamodb.NewFromConfig(cfg).DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(resource)})
+		return err
+	}},
+	"sqs:GetQueueAttributes": {resource: "queue URL", run: func(ctx context.Context, cfg aws.Config, resource string) error {
+		_, err := sqs.NewFromConfig(cfg).GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{QueueUrl: aws.String(resource)})
+		return err
+	}},
+	"sns:GetTopicAttributes": {resource: "topic ARN", run: func(ctx context.Context, cfg aws.Config, resource string) error {
+		_, err := sns.NewFromConfig(cfg).GetTopicAttributes(ctx, &sns.GetTopicAttributesInput{TopicArn: aws.String(resource)})
+		return err
+	}},
+}
+
+func canaryActions() []string {
+	actions := make([]string, 0, len(canaryChecks))
+	for action := range canaryChecks {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	return actions
+}
+
+// validateCanaries checks the canaries in the profile settings
+func validateCanaries(profiles map[string]ProfileSettings) error {
+	for profile, ps := range profiles {
+		for i, canary := range ps.Canaries {
+			check, ok := canaryChecks[canary.Action]
+			switch {
+			case !ok:
+				return fmt.Errorf("profiles.%s.canaries[%d]: action must be one of %s, got %q",
+					profile, i, strings.Join(canaryActions(), ", "), canary.Action)
+			case check.resource != "" && canary.Resource == "":
+				return fmt.Errorf("profiles.%s.canaries[%d]: %s needs a resource, the %s", profile, i, canary.Action, check.resource)
+			case check.resource == "bucket/key" && !validObjectPath(canary.Resource):
+				return fmt.Errorf("profiles.%s.canaries[%d]: %s needs a resource of the form bucket/key", profile, i, canary.Action)
+			case canary.TimeoutSeconds < 0:
+				return fmt.Errorf("profiles.%s.canaries[%d]: timeoutSeconds can't be negative", profile, i)
+			}
+		}
+	}
+	return nil
+}
+
+func validObjectPath(resource string) bool {
+	bucket, key, _ := strings.Cut(resource, "/")
+	return bucket != "" && key != ""
+}
+
+func runCanary(ctx context.Context, profile string, creds *CachedCredentials, canary Canary) CanaryResult {
+	result := CanaryResult{Action: canary.Action, Resource: canary.Resource}
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, canary.timeout())
+	defer cancel()
+	cfg, err := staticAWSConfig(ctx, profile, creds)
+	if err == nil {
+		if canary.Region != "" {
+			cfg.Region = canary.Region
+		}
+		err = canaryChecks[canary.Action].run(ctx, cfg, canary.Resource)
+	}
+	result.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			result.Code = apiErr.ErrorCode()
+		}
+		return result
+	}
+	result.OK = true
+	return result
+}
+
+// withCanaries runs the profile's canaries with its new session and
+// attaches the results to a login's status. Failures are warned about, but
+// the login stands.
+func withCanaries(ctx context.Context, status StatusResponse, creds *CachedCredentials) StatusResponse {
+	canaries := getProfileSettings(status.Profile).Canaries
+	if len(canaries) == 0 {
+		return status
+	}
+
+	var failed []string
+	status.Canaries = make([]CanaryResult, 0, len(canaries))
+	for _, canary := range canaries {
+		result := runCanary(ctx, status.Profile, creds, canary)
+		status.Canaries = append(status.Canaries, result)
+		if !result.OK {
+			failed = append(failed, canary.Action)
+			recordAudit(AuditEntry{
+				Action:  "login.canary",
+				Profile: status.Profile,
+				Result:  "warning",
+				Details: result.Error,
+				Fields:  map[string]string{"action": canary.Action, "resource": canary.Resource, "code": result.Code},
+			})
+		}
+	}
+	if len(failed) > 0 && status.Warning == "" {
+		status.Warning = fmt.Sprintf("The new session for %s failed its canary checks: %s", status.Profile, strings.Join(failed, ", "))
+	}
+	return status
+}
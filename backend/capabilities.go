@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// Capability reports whether a platform-dependent feature works in this
+// build, so the UI can hide it instead of failing at call time
+type Capability struct {
+	Name      string `json:"name"`
+	Available bool   `json:"available"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type VersionInfo struct {
+	Version      string       `json:"version"`
+	GoVersion    string       `json:"goVersion"`
+	OS           string       `json:"os"`
+	Arch         string       `json:"arch"`
+	Capabilities []Capability `json:"capabilities"`
+}
+
+func capability(name string, available bool, reason string) Capability {
+	if available {
+		reason = ""
+	}
+	return Capability{Name: name, Available: available, Reason: reason}
+}
+
+// dockerEngineReachable checks for the engine socket; TCP hosts are assumed
+// reachable since probing them would block the request
+func dockerEngineReachable() bool {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		host = defaultDockerHost
+	}
+	if !strings.HasPrefix(host, "unix://") {
+		return true
+	}
+	_, err := os.Stat(strings.TrimPrefix(host, "unix://"))
+	return err == nil
+}
+
+// machineIDAvailable mirrors the sources readMachineID tries before falling
+// back to the hostname
+func machineIDAvailable() bool {
+	switch runtime.GOOS {
+	case "linux":
+		for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+			if _, err := os.Stat(path); err == nil {
+				return true
+			}
+		}
+	case "darwin":
+		_, err := exec.LookPath("ioreg")
+		return err == nil
+	case "windows":
+		_, err := exec.LookPath("reg")
+		return err == nil
+	}
+	return false
+}
+
+func getCapabilities() []Capability {
+	return []Capability{
+		capability("keychain", false,
+			"no OS keychain backend is built in; sessions are cached in files"),
+		capability("docker-engine", dockerEngineReachable(),
+			"the Docker Engine socket is not mounted, so container injection is unavailable"),
+		capability("wsl2", isWSL2() || runtime.GOOS == "windows",
+			"WSL2 credential sources need Windows or a WSL2 distro"),
+		capability("device-binding", machineIDAvailable(),
+			"no machine ID on "+runtime.GOOS+"/"+runtime.GOARCH+"; the hostname is used instead"),
+	}
+}
+
+func getVersionInfo() *VersionInfo {
+	return &VersionInfo{
+		Version:      version,
+		GoVersion:    runtime.Version(),
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		Capabilities: getCapabilities(),
+	}
+}
+
+func handleGetVersion(c echo.Context) error {
+	return c.JSON(http.StatusOK, getVersionInfo())
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultCapabilityTTL = time.Minute
+	maxCapabilityTTL     = 10 * time.Minute
+)
+
+// A capability link lets the UI hand a single read to something else (a
+// download, a webview, a terminal) without ever holding the credentials
+// itself. The link carries its own claims, signed with a key that only
+// lives in this process, and each nonce is accepted once.
+type capabilityOp struct {
+	description string
+	serve       func(c echo.Context, creds *CachedCredentials) error
+}
+
+var capabilityOps = map[string]capabilityOp{
+	"env-file": {
+		description: "The profile's session as an env file",
+		serve: func(c echo.Context, creds *CachedCredentials) error {
+			c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+creds.Profile+`.env"`)
+			return c.String(http.StatusOK, formatEnvContent(creds))
+		},
+	},
+	"credentials": {
+		description: "The profile's session credentials as JSON",
+		serve: func(c echo.Context, creds *CachedCredentials) error {
+			return c.JSON(http.StatusOK, creds)
+		},
+	},
+	"credential-process": {
+		description: "The profile's session in credential_process format",
+		serve: func(c echo.Context, creds *CachedCredentials) error {
+			return c.JSON(http.StatusOK, CredentialProcessOutput{
+				Version:         credentialProcessV1,
+				AccessKeyID:     creds.AccessKeyID,
+				SecretAccessKey: creds.SecretAccessKey,
+				SessionToken:    creds.SessionToken,
+				Expiration:      creds.Expiration.UTC().Format(time.RFC3339),
+			})
+		},
+	},
+}
+
+type CapabilityLinkRequest struct {
+	Operation  string `json:"operation"`
+	Profile    string `json:"profile"`
+	TTLSeconds int    `json:"ttlSeconds,omitempty"`
+}
+
+type CapabilityLink struct {
+	URL       string    `json:"url"`
+	Operation string    `json:"operation"`
+	Profile   string    `json:"profile"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type capabilityClaims struct {
+	Op      string `json:"op"`
+	Profile string `json:"p"`
+	Expires int64  `json:"exp"`
+	Nonce   string `json:"n"`
+}
+
+var (
+	capabilityKey = func() []byte {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+		return key
+	}()
+
+	capabilityMu   sync.Mutex
+	capabilityUsed = map[string]time.Time{} // nonce -> expiry
+)
+
+func signCapability(payload string) string {
+	mac := hmac.New(sha256.New, capabilityKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func mintCapability(claims capabilityClaims) (string, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + signCapability(payload), nil
+}
+
+// verifyCapability checks the signature and expiry
+func verifyCapability(token string) (*capabilityClaims, bool) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signCapability(payload))) {
+		return nil, false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, false
+	}
+	var claims capabilityClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, false
+	}
+	if time.Now().Unix() >= claims.Expires {
+		return nil, false
+	}
+	return &claims, true
+}
+
+// consumeNonce marks a nonce used, reporting false if it already was.
+// Nonces are remembered until their link expires, after which the expiry
+// check rejects the link anyway.
+func consumeNonce(nonce string, expires time.Time) bool {
+	capabilityMu.Lock()
+	defer capabilityMu.Unlock()
+
+	now := time.Now()
+	for n, exp := range capabilityUsed {
+		if now.After(exp) {
+			delete(capabilityUsed, n)
+		}
+	}
+	if _, used := capabilityUsed[nonce]; used {
+		return false
+	}
+	capabilityUsed[nonce] = expires
+	return true
+}
+
+func handleListCapabilityOps(c echo.Context) error {
+	ops := map[string]string{}
+	for name, op := range capabilityOps {
+		ops[name] = op.description
+	}
+	return c.JSON(http.StatusOK, ops)
+}
+
+// handleCreateCapabilityLink mints a link for one read of one profile. The
+// session must already be usable, so the link never triggers a login.
+func handleCreateCapabilityLink(c echo.Context) error {
+	var req CapabilityLinkRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	if _, ok := capabilityOps[req.Operation]; !ok {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Unknown operation: " + req.Operation,
+		})
+	}
+	ttl := defaultCapabilityTTL
+	if req.TTLSeconds > 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+	if ttl > maxCapabilityTTL {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "ttlSeconds may be at most " + maxCapabilityTTL.String(),
+		})
+	}
+	req.Profile = requestProfile(c, req.Profile)
+
+	if resp := requireConfirmation(c, confirmExport, req.Profile); resp != nil {
+		return c.JSON(http.StatusPreconditionRequired, resp)
+	}
+	if _, status, errResp := loadUsableCredentials(req.Profile); errResp != nil {
+		return c.JSON(status, errResp)
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to create link",
+			Details: err.Error(),
+		})
+	}
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+	token, err := mintCapability(capabilityClaims{
+		Op:      req.Operation,
+		Profile: req.Profile,
+		Expires: expiresAt.Unix(),
+		Nonce:   hex.EncodeToString(nonce),
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to create link",
+			Details: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, CapabilityLink{
+		URL:       "/capability-links/" + token,
+		Operation: req.Operation,
+		Profile:   req.Profile,
+		ExpiresAt: expiresAt.UTC(),
+	})
+}
+
+// handleRedeemCapabilityLink serves a link once. Expired, tampered and
+// replayed links all get the same 404 so they can't be told apart.
+func handleRedeemCapabilityLink(c echo.Context) error {
+	claims, ok := verifyCapability(c.Param("token"))
+	if ok {
+		ok = consumeNonce(claims.Nonce, time.Unix(claims.Expires, 0))
+	}
+	if !ok {
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "Link already used or expired",
+		})
+	}
+
+	entry := AuditEntry{
+		Action:  "capability.redeem",
+		Profile: claims.Profile,
+		Result:  "ok",
+		Fields:  map[string]string{"operation": claims.Op},
+	}
+	creds, status, errResp := loadUsableCredentials(claims.Profile)
+	if errResp != nil {
+		entry.Result = "error"
+		entry.Details = errResp.Error
+		recordAudit(entry)
+		return c.JSON(status, errResp)
+	}
+	recordAudit(entry)
+
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return capabilityOps[claims.Op].serve(c, creds)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultCLIImage = "amazon/aws-cli:latest"
+	cliImagePinFile = "cli/image.json"
+	cliTimeout      = 5 * time.Minute
+	cliLabel        = "com.docker.aws-mfa.cli"
+)
+
+// pinnedImagePattern is an image reference pinned by digest
+var pinnedImagePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._/:-]*@sha256:[a-f0-9]{64}$`)
+
+var cliImageMu sync.Mutex
+
+// CLIRequest is one AWS CLI invocation; Args follow `aws`, e.g.
+// ["s3", "ls"]. They are passed to the container as-is, never to a shell.
+// The image comes from the settings, never from the request, since it
+// receives the session.
+type CLIRequest struct {
+	Profile string   `json:"profile"`
+	Args    []string `json:"args"`
+	Region  string   `json:"region,omitempty"`
+}
+
+type cliImagePin struct {
+	Image string `json:"image"`
+}
+
+func validateCLIImage(image string) error {
+	if image != "" && !pinnedImagePattern.MatchString(image) {
+		return fmt.Errorf("cliImage must be pinned by digest, as <name>@sha256:<digest>")
+	}
+	return nil
+}
+
+// cliImage is the image CLI commands run in: the cliImage setting, or the
+// default image pinned to the digest it had when first used. Later pushes
+// to the default's tag aren't picked up until the pin file is removed.
+func cliImage(ctx context.Context, docker *dockerClient) (string, error) {
+	if image := loadSettings().CLIImage; image != "" {
+		return image, nil
+	}
+
+	cliImageMu.Lock()
+	defer cliImageMu.Unlock()
+	path := filepath.Join(getCacheDir(), cliImagePinFile)
+	var pin cliImagePin
+	if data, err := os.ReadFile(path); err == nil {
+		if decodeCacheFile(path, data, &pin) == nil && pinnedImagePattern.MatchString(pin.Image) {
+			return pin.Image, nil
+		}
+	}
+
+	exists, err := docker.imageExists(ctx, defaultCLIImage)
+	if err == nil && !exists {
+		err = docker.pullImage(ctx, defaultCLIImage)
+	}
+	if err != nil {
+		return "", err
+	}
+	if pin.Image, err = docker.imageDigest(ctx, defaultCLIImage); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(pin)
+	if err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return "", err
+	}
+	return pin.Image, nil
+}
+
+type CLIOutput struct {
+	Text string `json:"text"`
+}
+
+type CLIExit struct {
+	ExitCode int `json:"exitCode"`
+}
+
+// cliEnv wires the session into the container. The environment is visible
+// through docker inspect, which is acceptable for a container that only
+// lives as long as one command.
+func cliEnv(creds *CachedCredentials, region string) []string {
+	return []string{
+		"AWS_ACCESS_KEY_ID=" + creds.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY=" + creds.SecretAccessKey,
+		"AWS_SESSION_TOKEN=" + creds.SessionToken,
+		"AWS_REGION=" + region,
+		"AWS_DEFAULT_REGION=" + region,
+		"AWS_PAGER=",
+	}
+}
+
+// demuxLogs splits the engine's multiplexed log stream into stdout and
+// stderr chunks. Each frame is an 8-byte header (stream type, 3 padding
+// bytes, big-endian length) followed by the payload.
+func demuxLogs(r io.Reader, emit func(stream, text string)) error {
+	br := bufio.NewReader(r)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(br, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		frame := make([]byte, binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.ReadFull(br, frame); err != nil {
+			return err
+		}
+		stream := "stdout"
+		if header[0] == 2 {
+			stream = "stderr"
+		}
+		emit(stream, string(frame))
+	}
+}
+
+// handleRunCLI runs a one-shot AWS CLI command in a throwaway container and
+// streams its output as server-sent events: stdout, stderr, then exit (or
+// error). Setup failures are returned as plain JSON errors instead.
+func handleRunCLI(c echo.Context) error {
+	var req CLIRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	if len(req.Args) == 0 {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "args is required",
+		})
+	}
+	req.Profile = requestProfile(c, req.Profile)
+	if req.Region == "" {
+		req.Region = getProfileRegion(req.Profile)
+	}
+
+	if resp := requireConfirmation(c, confirmExport, req.Profile); resp != nil {
+		return c.JSON(http.StatusPreconditionRequired, resp)
+	}
+	creds, status, errResp := loadUsableCredentials(req.Profile)
+	if errResp != nil {
+		return c.JSON(status, errResp)
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), cliTimeout)
+	defer cancel()
+	docker := newDockerClient()
+
+	image, err := cliImage(ctx, docker)
+	if err == nil {
+		var exists bool
+		exists, err = docker.imageExists(ctx, image)
+		if err == nil && !exists {
+			err = docker.pullImage(ctx, image)
+		}
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to prepare AWS CLI image",
+			Details: err.Error(),
+		})
+	}
+
+	id, err := docker.createContainer(ctx, containerConfig{
+		Image:  image,
+		Cmd:    req.Args,
+		Env:    cliEnv(creds, req.Region),
+		Labels: map[string]string{cliLabel: req.Profile},
+	})
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to create AWS CLI container",
+			Details: err.Error(),
+		})
+	}
+	defer docker.removeContainer(context.Background(), id)
+
+	if err := docker.startContainer(ctx, id); err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to start AWS CLI container",
+			Details: err.Error(),
+		})
+	}
+
+	w := startSSE(c)
+	exitCode, err := streamCLI(ctx, docker, id, w)
+
+	entry := AuditEntry{
+		Action:  "cli",
+		Profile: req.Profile,
+		Result:  "ok",
+		Fields:  map[string]string{"command": strings.Join(req.Args[:min(2, len(req.Args))], " ")},
+	}
+	if err != nil {
+		entry.Result = "error"
+		entry.Details = err.Error()
+		writeSSE(w, "error", ErrorResponse{Error: "AWS CLI run failed", Details: err.Error()})
+	} else {
+		entry.Fields["exitCode"] = fmt.Sprint(exitCode)
+		writeSSE(w, "exit", CLIExit{ExitCode: exitCode})
+	}
+	recordAudit(entry)
+	return nil
+}
+
+func streamCLI(ctx context.Context, docker *dockerClient, id string, w *echo.Response) (int, error) {
+	logs, err := docker.followLogs(ctx, id)
+	if err != nil {
+		return -1, err
+	}
+	defer logs.Close()
+
+	if err := demuxLogs(logs, func(stream, text string) {
+		writeSSE(w, stream, CLIOutput{Text: text})
+	}); err != nil {
+		return -1, err
+	}
+	return docker.waitContainer(ctx, id)
+}
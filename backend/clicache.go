@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With the cliCache setting, logins also write their session to
+// ~/.aws/cli/cache in the AWS CLI's own format, under the key the CLI
+// would look it up by, so `aws --profile prod ...` on the host picks up
+// the extension's session instead of asking for an MFA code again. The
+// CLI only caches role and SSO credentials: a profile with just an
+// mfa_serial never prompts in the CLI, so there is nothing to share.
+
+const (
+	cliCacheSubdir = "cli"
+	// cliCacheExpiryFormat is how botocore serializes the datetimes it
+	// caches for assumed roles
+	cliCacheExpiryFormat = "2006-01-02T15:04:05+00:00"
+)
+
+type cliCacheCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken"`
+	Expiration      string `json:"Expiration"`
+	AccountID       string `json:"AccountId,omitempty"`
+}
+
+type cliCacheEntry struct {
+	ProviderType string              `json:"ProviderType,omitempty"`
+	Credentials  cliCacheCredentials `json:"Credentials"`
+}
+
+func cliCacheDir() string {
+	return filepath.Join(filepath.Dir(getAWSConfigPath()), cliCacheSubdir, "cache")
+}
+
+// pythonJSON renders args the way Python's json.dumps(args,
+// sort_keys=True) does with the given separators, which the CLI hashes
+// into its cache keys. Values are strings and ints.
+func pythonJSON(args map[string]interface{}, itemSep, keySep string) string {
+	keys := make([]string, 0, len(args))
+	for k := range args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString("{")
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteString(itemSep)
+		}
+		b.WriteString(pythonString(k))
+		b.WriteString(keySep)
+		switch v := args[k].(type) {
+		case int:
+			b.WriteString(strconv.Itoa(v))
+		default:
+			b.WriteString(pythonString(fmt.Sprint(v)))
+		}
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// pythonString quotes s like json.dumps, which leaves <, > and & alone
+// and escapes everything outside ASCII
+func pythonString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	quoted := strings.TrimSuffix(buf.String(), "\n")
+
+	var b strings.Builder
+	for _, r := range quoted {
+		switch {
+		case r < 0x80:
+			b.WriteRune(r)
+		case r > 0xffff:
+			r -= 0x10000
+			fmt.Fprintf(&b, `\u%04x\u%04x`, 0xd800+(r>>10), 0xdc00+(r&0x3ff))
+		default:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		}
+	}
+	return b.String()
+}
+
+func cliCacheHash(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// cliCacheKey returns the file the CLI would cache profile's credentials
+// in, and whether they are SSO credentials. It returns "" for profiles the
+// CLI doesn't cache, and for Granted's SSO profiles, which the CLI can't
+// log in to.
+func cliCacheKey(profile string) (string, bool) {
+	section, err := getProfileSection(profile)
+	if err != nil {
+		return "", false
+	}
+
+	if roleARN := sectionValue(section, "role_arn"); roleARN != "" {
+		// Only role_arn's own section counts, as in botocore's
+		// AssumeRoleProvider. A session name botocore generates itself is
+		// left out of the key, but a role_session_name is part of it.
+		if sectionValue(section, "source_profile") == "" && sectionValue(section, "credential_source") == "" {
+			return "", false
+		}
+		args := map[string]interface{}{"RoleArn": roleARN}
+		if v := sectionValue(section, "external_id"); v != "" {
+			args["ExternalId"] = v
+		}
+		if v := sectionValue(section, "mfa_serial"); v != "" {
+			args["SerialNumber"] = v
+		}
+		if v := sectionValue(section, "role_session_name"); v != "" {
+			args["RoleSessionName"] = v
+		}
+		if v := sectionValue(section, "duration_seconds"); v != "" {
+			seconds, err := strconv.Atoi(v)
+			if err != nil {
+				return "", false
+			}
+			args["DurationSeconds"] = seconds
+		}
+		return cliCacheHash(pythonJSON(args, ", ", ": ")), false
+	}
+
+	if isGrantedSSO(section) {
+		return "", false
+	}
+	account, role := sectionValue(section, "sso_account_id"), sectionValue(section, "sso_role_name")
+	if account == "" || role == "" {
+		return "", false
+	}
+	sso, err := getSSOSettings(profile)
+	if err != nil || sso.StartURL == "" {
+		return "", false
+	}
+	args := map[string]interface{}{"startUrl": sso.StartURL, "roleName": role, "accountId": account}
+	if sso.Session != "" {
+		args["sessionName"] = sso.Session
+	}
+	return cliCacheHash(pythonJSON(args, ",", ":")), true
+}
+
+// writeCLICache shares a new session with the CLI, when the setting is on
+// and the profile is one the CLI caches. Sessions minted with an
+// overridden external ID, session policies or tags aren't what the CLI
+// would have asked for, so they aren't shared.
+func writeCLICache(creds *CachedCredentials) error {
+	if !loadSettings().CLICache || creds.ExternalID != "" || creds.SessionPolicy != "" ||
+		len(creds.SessionPolicyARNs) > 0 || len(creds.SessionTags) > 0 {
+		return nil
+	}
+	key, isSSO := cliCacheKey(creds.Profile)
+	if key == "" {
+		return nil
+	}
+
+	entry := cliCacheEntry{Credentials: cliCacheCredentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		Expiration:      creds.Expiration.UTC().Format(cliCacheExpiryFormat),
+	}}
+	if isSSO {
+		entry.ProviderType = "sso"
+		entry.Credentials.Expiration = creds.Expiration.UTC().Format(time.RFC3339)
+	} else if _, account := arnPartitionAccount(creds.RoleARN); account != "" {
+		entry.Credentials.AccountID = account
+	}
+
+	if err := os.MkdirAll(cliCacheDir(), 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(cliCacheDir(), key+".json"), data, 0600)
+}
+
+// removeCLICache deletes the CLI's cache entry for profile if it holds
+// the session with accessKeyID, leaving sessions the CLI minted itself
+func removeCLICache(profile, accessKeyID string) {
+	key, _ := cliCacheKey(profile)
+	if key == "" || accessKeyID == "" {
+		return
+	}
+	path := filepath.Join(cliCacheDir(), key+".json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var entry cliCacheEntry
+	if json.Unmarshal(data, &entry) == nil && entry.Credentials.AccessKeyID == accessKeyID {
+		os.Remove(path)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Labels Compose puts on every container it creates
+const (
+	composeProjectLabel     = "com.docker.compose.project"
+	composeServiceLabel     = "com.docker.compose.service"
+	composeWorkingDirLabel  = "com.docker.compose.project.working_dir"
+	composeConfigFilesLabel = "com.docker.compose.project.config_files"
+)
+
+// How a service's containers stand with respect to AWS credentials
+const (
+	wiringNone        = "none"        // no aws.profile label and nothing delivered
+	wiringPending     = "pending"     // labelled, but not every container has a session
+	wiringProvisioned = "provisioned" // every container holds the current session
+	wiringStale       = "stale"       // delivered sessions are expired or superseded
+	wiringDenied      = "denied"      // labelled with a profile auto-provisioning won't hand out
+)
+
+type ComposeContainer struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+	// Delivery is the container's most recent inventory entry, if any
+	Delivery *InventoryEntry `json:"delivery,omitempty"`
+}
+
+// ComposeService is one service and the AWS profile it is wired to, from its
+// aws.profile label or, for unlabelled services, from what was injected
+type ComposeService struct {
+	Name       string             `json:"name"`
+	Profile    string             `json:"profile,omitempty"`
+	EnvFile    string             `json:"envFile,omitempty"`
+	Status     string             `json:"status"`
+	Containers []ComposeContainer `json:"containers"`
+}
+
+type ComposeProject struct {
+	Name        string           `json:"name"`
+	WorkingDir  string           `json:"workingDir,omitempty"`
+	ConfigFiles string           `json:"configFiles,omitempty"`
+	Services    []ComposeService `json:"services"`
+}
+
+// latestDeliveries indexes the inventory by container, keeping the newest
+// entry for each and marking whether it still holds the current session
+func latestDeliveries(entries []InventoryEntry) map[string]*InventoryEntry {
+	now := time.Now()
+	generations := map[string]string{}
+	latest := map[string]*InventoryEntry{}
+	for i := range entries {
+		e := &entries[i]
+		if e.Revoked {
+			continue
+		}
+		gen, ok := generations[e.Profile]
+		if !ok {
+			if creds, err := loadCachedCredentials(e.Profile); err == nil {
+				gen = sessionGeneration(creds)
+			}
+			generations[e.Profile] = gen
+		}
+		e.Expired = now.After(e.ExpiresAt)
+		e.Current = gen != "" && gen == e.Generation
+
+		if prev, ok := latest[e.ContainerID]; !ok || e.DeliveredAt.After(prev.DeliveredAt) {
+			latest[e.ContainerID] = e
+		}
+	}
+	return latest
+}
+
+// serviceWiring summarises a service from its label and its containers'
+// deliveries. It runs before an unlabelled service's profile is filled in
+// from what was injected, so svc.Profile is the label alone.
+func serviceWiring(svc *ComposeService, ap *AutoProvisionSettings) string {
+	labelled := svc.Profile != ""
+	if labelled && ap != nil && !ap.allows(svc.Profile) {
+		return wiringDenied
+	}
+
+	delivered, current := 0, 0
+	for _, ctr := range svc.Containers {
+		if ctr.Delivery == nil {
+			continue
+		}
+		delivered++
+		if ctr.Delivery.Current && !ctr.Delivery.Expired {
+			current++
+		}
+	}
+	switch {
+	case delivered == 0 && !labelled:
+		return wiringNone
+	case delivered > current:
+		return wiringStale
+	case current == len(svc.Containers):
+		return wiringProvisioned
+	default:
+		return wiringPending
+	}
+}
+
+func handleGetComposeProjects(c echo.Context) error {
+	containers, err := newDockerClient().listContainers(c.Request().Context(), map[string][]string{
+		"label": {composeProjectLabel},
+	})
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to list containers",
+			Details: err.Error(),
+		})
+	}
+
+	inventoryMu.Lock()
+	deliveries := latestDeliveries(loadInventory())
+	inventoryMu.Unlock()
+
+	projects := map[string]*ComposeProject{}
+	services := map[string]*ComposeService{}
+	serviceProject := map[string]string{}
+	for _, ctr := range containers {
+		name := ctr.Labels[composeProjectLabel]
+		p, ok := projects[name]
+		if !ok {
+			p = &ComposeProject{
+				Name:        name,
+				WorkingDir:  ctr.Labels[composeWorkingDirLabel],
+				ConfigFiles: ctr.Labels[composeConfigFilesLabel],
+			}
+			projects[name] = p
+		}
+
+		key := name + "\x00" + ctr.Labels[composeServiceLabel]
+		svc, ok := services[key]
+		if !ok {
+			svc = &ComposeService{
+				Name:    ctr.Labels[composeServiceLabel],
+				Profile: ctr.Labels[labelAWSProfile],
+				EnvFile: ctr.Labels[labelAWSEnvFile],
+			}
+			services[key] = svc
+			serviceProject[key] = name
+		}
+		svc.Containers = append(svc.Containers, ComposeContainer{
+			ID:       ctr.ID,
+			Name:     ctr.Name(),
+			State:    ctr.State,
+			Delivery: deliveries[ctr.ID],
+		})
+	}
+
+	// Services are attached once complete, since appending to a project's
+	// slice would invalidate the pointers held above
+	ap := autoProvisionSettings()
+	for key, svc := range services {
+		svc.Status = serviceWiring(svc, ap)
+		if svc.Profile == "" {
+			for _, ctr := range svc.Containers {
+				if ctr.Delivery != nil {
+					svc.Profile = ctr.Delivery.Profile
+					break
+				}
+			}
+		}
+		p := projects[serviceProject[key]]
+		p.Services = append(p.Services, *svc)
+	}
+
+	out := make([]ComposeProject, 0, len(projects))
+	for _, p := range projects {
+		sort.Slice(p.Services, func(i, j int) bool { return p.Services[i].Name < p.Services[j].Name })
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	confirmTokenHeader = "X-Confirm-Token"
+	confirmTokenTTL    = 2 * time.Minute
+
+	confirmLogin  = "login"
+	confirmExport = "export"
+	// confirmLoginAndExport covers both steps of /login-and-export
+	confirmLoginAndExport = "login-and-export"
+
+	// confirmedContextKey holds the token a request has already redeemed
+	confirmedContextKey = "confirmation"
+
+	codeConfirmationRequired = "CONFIRMATION_REQUIRED"
+)
+
+// Confirmation is a one-time token for a login or export touching
+// sensitive profiles. It is fetched as a separate step and sent back in
+// the X-Confirm-Token header, so a single click can't open a production
+// session by accident.
+type Confirmation struct {
+	Token     string    `json:"token"`
+	Action    string    `json:"action"`
+	Profiles  []string  `json:"profiles"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ConfirmationRequiredResponse is returned with 428 when a request needs a
+// confirmation token it didn't carry, or carried one that doesn't cover it
+type ConfirmationRequiredResponse struct {
+	Error    string   `json:"error"`
+	Code     string   `json:"code"`
+	Details  string   `json:"details,omitempty"`
+	Action   string   `json:"action"`
+	Profiles []string `json:"profiles"`
+}
+
+var confirmations = struct {
+	mu     sync.Mutex
+	tokens map[string]Confirmation
+}{tokens: make(map[string]Confirmation)}
+
+// isSensitiveProfile reports whether logins and exports for profile need a
+// confirmation: the profile's sensitive setting, else `sensitive = true`
+// in its AWS config profile
+func isSensitiveProfile(profile string) bool {
+	return profileFlag(profile, getProfileSettings(profile).Sensitive, "sensitive")
+}
+
+// exportProfiles lists the profiles an export covers, from either a single
+// profile or the "prod:PROD,staging" syntax
+func exportProfiles(profile, profiles string) []string {
+	if profiles == "" {
+		return []string{profile}
+	}
+	prefixes, err := parseProfilePrefixes(profiles)
+	if err != nil {
+		return nil
+	}
+	out := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		out = append(out, p.Profile)
+	}
+	return out
+}
+
+// requireConfirmation checks the request's confirmation token against the
+// sensitive profiles among profiles. A token is used up by the first
+// request it is checked for, whether or not that request then succeeds;
+// later checks in the same request see the token it redeemed.
+func requireConfirmation(c echo.Context, action string, profiles ...string) *ConfirmationRequiredResponse {
+	var sensitive []string
+	for _, p := range profiles {
+		if isSensitiveProfile(p) {
+			sensitive = append(sensitive, p)
+		}
+	}
+	if len(sensitive) == 0 {
+		return nil
+	}
+
+	resp := &ConfirmationRequiredResponse{
+		Error:    "Confirmation required",
+		Code:     codeConfirmationRequired,
+		Details:  "get a token from GET /confirm and send it in the " + confirmTokenHeader + " header",
+		Action:   action,
+		Profiles: sensitive,
+	}
+	conf, ok := c.Get(confirmedContextKey).(Confirmation)
+	if !ok {
+		token := c.Request().Header.Get(confirmTokenHeader)
+		if token == "" {
+			return resp
+		}
+
+		confirmations.mu.Lock()
+		conf, ok = confirmations.tokens[token]
+		delete(confirmations.tokens, token)
+		confirmations.mu.Unlock()
+
+		if !ok || time.Now().After(conf.ExpiresAt) {
+			resp.Details = "the confirmation token is unknown, used or expired"
+			return resp
+		}
+		c.Set(confirmedContextKey, conf)
+	}
+	if conf.Action != action && conf.Action != confirmLoginAndExport {
+		resp.Details = "the confirmation token is for " + conf.Action + ", not " + action
+		return resp
+	}
+	covered := make(map[string]bool, len(conf.Profiles))
+	for _, p := range conf.Profiles {
+		covered[p] = true
+	}
+	for _, p := range sensitive {
+		if !covered[p] {
+			resp.Details = "the confirmation token does not cover " + p
+			return resp
+		}
+	}
+	return nil
+}
+
+// handleConfirm issues a confirmation token for ?action= (login, export
+// or login-and-export) on ?profile=, or on every profile in ?profiles=
+func handleConfirm(c echo.Context) error {
+	action := c.QueryParam("action")
+	if action != confirmLogin && action != confirmExport && action != confirmLoginAndExport {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "action must be login, export or login-and-export",
+		})
+	}
+	profiles := exportProfiles(requestProfile(c, c.QueryParam("profile")), c.QueryParam("profiles"))
+	if len(profiles) == 0 {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid profiles",
+		})
+	}
+	sort.Strings(profiles)
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to issue confirmation",
+			Details: err.Error(),
+		})
+	}
+	now := time.Now()
+	conf := Confirmation{
+		Token:     hex.EncodeToString(buf),
+		Action:    action,
+		Profiles:  profiles,
+		ExpiresAt: now.Add(confirmTokenTTL).UTC(),
+	}
+
+	confirmations.mu.Lock()
+	for token, old := range confirmations.tokens {
+		if now.After(old.ExpiresAt) {
+			delete(confirmations.tokens, token)
+		}
+	}
+	confirmations.tokens[conf.Token] = conf
+	confirmations.mu.Unlock()
+
+	recordAudit(AuditEntry{
+		Action:  "confirm",
+		Profile: strings.Join(profiles, ","),
+		Result:  "ok",
+		Fields:  map[string]string{"for": action},
+	})
+	return c.JSON(http.StatusOK, conf)
+}
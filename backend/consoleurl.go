@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/labstack/echo/v4"
+)
+
+// GET /console-url turns a profile's cached session into a one-click AWS
+// Console sign-in link through the federation endpoint: getSigninToken
+// trades the session for a sign-in token, which goes into a login URL. The
+// endpoint only accepts role sessions, so profiles logged in with
+// GetSessionToken alone can't get a link.
+
+const (
+	consoleURLTimeout = 15 * time.Second
+	// signinTokenLifetime is how long AWS accepts a sign-in token
+	signinTokenLifetime = 15 * time.Minute
+)
+
+var (
+	errNotRoleSession     = errors.New("the AWS sign-in endpoint only accepts role sessions; log in to a profile with a role_arn, SSO or SAML")
+	consoleServicePattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+	consoleRegionPattern  = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d+$`)
+)
+
+// consoleEndpoint is a partition's sign-in and console hosts
+type consoleEndpoint struct {
+	Federation string
+	Console    string
+}
+
+var consoleEndpoints = map[string]consoleEndpoint{
+	"aws":        {"https://signin.aws.amazon.com/federation", "https://console.aws.amazon.com"},
+	"aws-cn":     {"https://signin.amazonaws.cn/federation", "https://console.amazonaws.cn"},
+	"aws-us-gov": {"https://signin.amazonaws-us-gov.com/federation", "https://console.amazonaws-us-gov.com"},
+}
+
+// ConsoleURL is a sign-in link. The link works until LinkExpiresAt; the
+// console session it starts ends with the session it was made from.
+type ConsoleURL struct {
+	Profile          string    `json:"profile"`
+	URL              string    `json:"url"`
+	Destination      string    `json:"destination"`
+	LinkExpiresAt    time.Time `json:"linkExpiresAt"`
+	SessionExpiresAt time.Time `json:"sessionExpiresAt"`
+}
+
+// consoleDestination is the console page to land on: a service's home,
+// in region when given, or the console home
+func consoleDestination(console, service, region string) string {
+	path := "/console/home"
+	if service != "" {
+		path = "/" + service + "/home"
+	}
+	dest := console + path
+	if region != "" {
+		dest += "?region=" + url.QueryEscape(region)
+	}
+	return dest
+}
+
+// signinEndpointOverride is an explicit "signin" entry in the profile's or
+// the global endpoint settings. A profile's catch-all endpoint_url, e.g.
+// LocalStack, doesn't count: the session's secret key would go to it in
+// the query string.
+func signinEndpointOverride(profile string) string {
+	for service, endpoint := range getProfileSettings(profile).Endpoints {
+		if normalizeServiceName(service) == "signin" {
+			return endpoint
+		}
+	}
+	for service, endpoint := range loadSettings().Endpoints {
+		if normalizeServiceName(service) == "signin" {
+			return endpoint
+		}
+	}
+	return ""
+}
+
+// getSigninToken trades a role session for a sign-in token
+func getSigninToken(ctx context.Context, client aws.HTTPClient, federation string, creds *CachedCredentials) (string, error) {
+	session, err := json.Marshal(map[string]string{
+		"sessionId":    creds.AccessKeyID,
+		"sessionKey":   creds.SecretAccessKey,
+		"sessionToken": creds.SessionToken,
+	})
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{"Action": {"getSigninToken"}, "Session": {string(session)}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, federation+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// A *url.Error quotes the URL, whose Session holds the secret key
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return "", fmt.Errorf("sign-in endpoint unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("sign-in endpoint returned %s", resp.Status)
+	}
+
+	var out struct {
+		SigninToken string
+	}
+	if err := json.Unmarshal(data, &out); err != nil || out.SigninToken == "" {
+		return "", errors.New("sign-in endpoint returned no sign-in token")
+	}
+	return out.SigninToken, nil
+}
+
+// handleConsoleURL is GET /console-url?profile=. ?service= (a console path
+// such as "s3" or "cloudwatch") and ?region= pick the page to land on; a
+// service without a region opens in the profile's region. With
+// ?redirect=true the response redirects to the link instead.
+func handleConsoleURL(c echo.Context) error {
+	profile := requestProfile(c, c.QueryParam("profile"))
+	service, region := c.QueryParam("service"), c.QueryParam("region")
+	if service != "" && !consoleServicePattern.MatchString(service) {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid service",
+			Details: fmt.Sprintf("%q is not a console service path", service),
+		})
+	}
+	if region != "" && !consoleRegionPattern.MatchString(region) {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid region",
+			Details: fmt.Sprintf("%q is not a region", region),
+		})
+	}
+	if service != "" && region == "" {
+		region = getProfileRegion(profile)
+	}
+
+	if resp := requireConfirmation(c, confirmExport, profile); resp != nil {
+		return c.JSON(http.StatusPreconditionRequired, resp)
+	}
+	creds, status, errResp := loadUsableCredentials(profile)
+	if errResp != nil {
+		return c.JSON(status, errResp)
+	}
+	if creds.RoleARN == "" {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Cannot sign in to the console",
+			Details: errNotRoleSession.Error(),
+		})
+	}
+
+	partition, _ := arnPartitionAccount(creds.RoleARN)
+	endpoint, ok := consoleEndpoints[partition]
+	if !ok {
+		endpoint = consoleEndpoints["aws"]
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), consoleURLTimeout)
+	defer cancel()
+	cfg, err := staticAWSConfig(ctx, profile, creds)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to load AWS config",
+			Details: err.Error(),
+		})
+	}
+	if override := signinEndpointOverride(profile); override != "" {
+		endpoint.Federation = override
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	entry := AuditEntry{
+		Action:  "console-url",
+		Profile: profile,
+		Result:  "ok",
+		Fields:  map[string]string{"service": service, "region": region},
+	}
+	token, err := getSigninToken(ctx, client, endpoint.Federation, creds)
+	if err != nil {
+		entry.Result = "error"
+		entry.Details = err.Error()
+		recordAudit(entry)
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to get a sign-in token",
+			Details: err.Error(),
+		})
+	}
+	recordAudit(entry)
+
+	result := &ConsoleURL{
+		Profile:          profile,
+		Destination:      consoleDestination(endpoint.Console, service, region),
+		LinkExpiresAt:    time.Now().UTC().Add(signinTokenLifetime),
+		SessionExpiresAt: creds.Expiration,
+	}
+	q := url.Values{"Action": {"login"}, "Destination": {result.Destination}, "SigninToken": {token}}
+	result.URL = endpoint.Federation + "?" + q.Encode()
+
+	if c.QueryParam("redirect") == "true" {
+		return c.Redirect(http.StatusFound, result.URL)
+	}
+	return c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// The container endpoint serves sessions in the format the SDKs' container
+// credential provider expects (AWS_CONTAINER_CREDENTIALS_FULL_URI). Each
+// profile has its own authorization token, written to a volume of its own
+// that consumer containers mount and reference with
+// AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE. The SDKs re-read that file on
+// every refresh, so tokens can rotate freely, and a container without the
+// profile's volume has no way to call the endpoint for it.
+const (
+	// defaultContainerListen is loopback: the SDKs only send plain HTTP
+	// to loopback, so consumers reach it from the backend's network
+	// namespace or through a forwarder in their own
+	defaultContainerListen  = "127.0.0.1:9418"
+	defaultTokenVolume      = "aws-mfa-tokens"
+	defaultTokenRotation    = 15 * time.Minute
+	containerTokenFileMode  = 0600
+	containerTokenFile      = "/token"
+	containerCredentialPath = "/v1/credentials/"
+)
+
+// ContainerEndpointSettings configures the container credentials listener.
+// Like remote access, changes take effect on the next reload or backend
+// start.
+type ContainerEndpointSettings struct {
+	Enabled bool   `json:"enabled"`
+	Listen  string `json:"listen,omitempty"`
+	// Volume prefixes the token volumes: a profile's token is written to
+	// <volume>-<profile>
+	Volume        string   `json:"volume,omitempty"`
+	Profiles      []string `json:"profiles"`
+	RotateMinutes int      `json:"rotateMinutes,omitempty"`
+	// AllowedSources limits callers to these IPs, CIDRs or Docker networks,
+	// by name or ID. Empty allows any caller with a valid token.
+	AllowedSources []string `json:"allowedSources,omitempty"`
+	// AllowForwarded accepts requests carrying proxy headers, which are
+	// refused by default the way IMDSv2 refuses X-Forwarded-For
+	AllowForwarded bool `json:"allowForwarded,omitempty"`
+	// TokenTTLMinutes is how long a token is accepted after it was issued,
+	// even if rotation stops. Zero keeps it valid until it is rotated out.
+	TokenTTLMinutes int `json:"tokenTtlMinutes,omitempty"`
+	// TokenOwners gives a profile's token file a "uid" or "uid:gid" owner,
+	// for consumers that don't run as root; the file is only readable by
+	// its owner
+	TokenOwners map[string]string `json:"tokenOwners,omitempty"`
+}
+
+// ContainerCredentials is the container credential provider's format
+type ContainerCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+}
+
+// containerToken keeps the previous token valid for one rotation, so a
+// consumer that read the file just before it changed isn't locked out
+type containerToken struct {
+	current    string
+	previous   string
+	currentAt  time.Time
+	previousAt time.Time
+}
+
+var (
+	containerTokensMu sync.Mutex
+	containerTokens   = map[string]*containerToken{}
+)
+
+// volume is the volume profile's token is written to
+func (cfg *ContainerEndpointSettings) volume(profile string) string {
+	prefix := cfg.Volume
+	if prefix == "" {
+		prefix = defaultTokenVolume
+	}
+	return prefix + "-" + profile
+}
+
+// tokenOwner is who profile's token file belongs to; validation has
+// already checked the setting parses
+func (cfg *ContainerEndpointSettings) tokenOwner(profile string) fileOwner {
+	owner, _ := parseFileOwner(cfg.TokenOwners[profile])
+	return owner
+}
+
+func (cfg *ContainerEndpointSettings) rotation() time.Duration {
+	if cfg.RotateMinutes > 0 {
+		return time.Duration(cfg.RotateMinutes) * time.Minute
+	}
+	return defaultTokenRotation
+}
+
+func (cfg *ContainerEndpointSettings) tokenTTL() time.Duration {
+	return time.Duration(cfg.TokenTTLMinutes) * time.Minute
+}
+
+// validateContainerEndpoint rejects profiles whose token volume would be an
+// invalid volume name, unusable sources and owners, and a token TTL shorter
+// than the rotation, which would lock consumers out between rotations
+func validateContainerEndpoint(cfg *ContainerEndpointSettings) error {
+	if cfg == nil {
+		return nil
+	}
+	listed := make(map[string]bool, len(cfg.Profiles))
+	for _, profile := range cfg.Profiles {
+		if !profileNamePattern.MatchString(profile) || !volumeNamePattern.MatchString(cfg.volume(profile)) {
+			return fmt.Errorf("containerEndpoint profile %q can't name a token volume", profile)
+		}
+		listed[profile] = true
+	}
+	for profile, owner := range cfg.TokenOwners {
+		if !listed[profile] {
+			return fmt.Errorf("containerEndpoint.tokenOwners: %s is not in profiles", profile)
+		}
+		if _, err := parseFileOwner(owner); err != nil {
+			return fmt.Errorf("containerEndpoint.tokenOwners.%s: %w", profile, err)
+		}
+	}
+	for _, source := range cfg.AllowedSources {
+		if _, _, err := parseContainerSource(source); err != nil {
+			return fmt.Errorf("containerEndpoint.allowedSources: %w", err)
+		}
+	}
+	if cfg.TokenTTLMinutes < 0 {
+		return fmt.Errorf("containerEndpoint.tokenTtlMinutes must not be negative")
+	}
+	if ttl := cfg.tokenTTL(); ttl != 0 && ttl < cfg.rotation() {
+		return fmt.Errorf("containerEndpoint.tokenTtlMinutes (%s) must be at least the rotation interval (%s)", ttl, cfg.rotation())
+	}
+	return nil
+}
+
+// rotateContainerTokens writes a fresh token for every profile and drops
+// the tokens of profiles no longer listed. A token only takes effect once
+// its file is written; if the write fails, the current token stays valid.
+func rotateContainerTokens(ctx context.Context, cfg *ContainerEndpointSettings) {
+	containerTokensMu.Lock()
+	for profile := range containerTokens {
+		if !slices.Contains(cfg.Profiles, profile) {
+			delete(containerTokens, profile)
+		}
+	}
+	containerTokensMu.Unlock()
+
+	for _, profile := range cfg.Profiles {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			fmt.Fprintf(os.Stderr, "container token for %s: %v\n", profile, err)
+			continue
+		}
+		token := hex.EncodeToString(buf)
+
+		if err := writeVolumeFile(ctx, cfg.volume(profile), containerTokenFile, []byte(token), containerTokenFileMode, cfg.tokenOwner(profile)); err != nil {
+			fmt.Fprintf(os.Stderr, "container token for %s: %v\n", profile, err)
+			continue
+		}
+
+		containerTokensMu.Lock()
+		t, ok := containerTokens[profile]
+		if !ok {
+			t = &containerToken{}
+			containerTokens[profile] = t
+		}
+		t.previous, t.current = t.current, token
+		t.previousAt, t.currentAt = t.currentAt, time.Now()
+		containerTokensMu.Unlock()
+	}
+}
+
+// validContainerToken checks presented against the profile's current and
+// previous tokens; with a TTL, a token older than ttl is no longer accepted
+func validContainerToken(profile, presented string, ttl time.Duration) bool {
+	containerTokensMu.Lock()
+	defer containerTokensMu.Unlock()
+
+	t, ok := containerTokens[profile]
+	if !ok || presented == "" {
+		return false
+	}
+	matches := func(token string, issued time.Time) bool {
+		if token == "" || (ttl > 0 && time.Since(issued) > ttl) {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(token), []byte(presented)) == 1
+	}
+	return matches(t.current, t.currentAt) || matches(t.previous, t.previousAt)
+}
+
+func handleContainerCredentials(c echo.Context, cfg *ContainerEndpointSettings) error {
+	profile := c.Param("profile")
+	if !validContainerToken(profile, c.Request().Header.Get(echo.HeaderAuthorization), cfg.tokenTTL()) {
+		return c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error: "Invalid authorization token",
+		})
+	}
+
+	creds, status, errResp := loadUsableCredentials(profile)
+	if errResp != nil {
+		return c.JSON(status, errResp)
+	}
+
+	recordAudit(AuditEntry{
+		Action:  "container-endpoint.credentials",
+		Profile: profile,
+		Result:  "ok",
+		Fields:  map[string]string{"remoteAddr": containerCallerIP(c).String()},
+	})
+
+	return c.JSON(http.StatusOK, ContainerCredentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		Token:           creds.SessionToken,
+		Expiration:      creds.Expiration.UTC().Format(time.RFC3339),
+	})
+}
+
+// newContainerRouter serves the credential route for cfg
+func newContainerRouter(cfg *ContainerEndpointSettings) *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
+	e.Use(middleware.Recover())
+	e.GET(containerCredentialPath+":profile", func(c echo.Context) error {
+		return handleContainerCredentials(c, cfg)
+	}, containerSourceFilter(cfg))
+	return e
+}
+
+// startContainerTokens schedules token rotation and source lookups for cfg,
+// replacing those of an earlier configuration
+func startContainerTokens(cfg *ContainerEndpointSettings) {
+	// The first tokens and source networks are resolved in the background
+	// so a slow or missing engine doesn't hold up startup
+	go func() {
+		rotateContainerTokens(context.Background(), cfg)
+		resolveContainerSources(context.Background(), cfg)
+	}()
+	scheduler.every("rotate-container-tokens", cfg.rotation(), func(ctx context.Context) {
+		rotateContainerTokens(ctx, cfg)
+		resolveContainerSources(ctx, cfg)
+	})
+}
+
+// stopContainerTokens stops rotation and revokes every token
+func stopContainerTokens() {
+	scheduler.stop("rotate-container-tokens")
+	containerTokensMu.Lock()
+	clear(containerTokens)
+	containerTokensMu.Unlock()
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Source filtering keeps the container endpoint to the containers it is
+// meant for. A token alone is enough for anything that can reach the
+// listener, which on 0.0.0.0 includes the host's other networks; the
+// allowlist narrows that to given addresses or Docker networks, and proxy
+// headers are refused so a container can't relay requests for another
+// host, as IMDSv2's hop limit prevents on EC2.
+
+var (
+	dockerNetworkPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+	// forwardingHeaders are set by proxies relaying a request
+	forwardingHeaders = []string{"X-Forwarded-For", "Forwarded", "Via"}
+)
+
+// containerSources holds the resolved allowlist. Docker networks are looked
+// up in the background and their subnets kept until the next lookup
+// succeeds, so an engine hiccup doesn't lock containers out.
+var containerSources = struct {
+	mu       sync.Mutex
+	networks map[string][]*net.IPNet
+}{networks: make(map[string][]*net.IPNet)}
+
+// parseContainerSource reads an allowlist entry as an IP or CIDR, or else a
+// Docker network name, returned as the string
+func parseContainerSource(source string) (*net.IPNet, string, error) {
+	if ip := net.ParseIP(source); ip != nil {
+		bits := 8 * len(ip.To4())
+		if bits == 0 {
+			bits = 8 * net.IPv6len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, "", nil
+	}
+	if strings.Contains(source, "/") {
+		_, subnet, err := net.ParseCIDR(source)
+		if err != nil {
+			return nil, "", fmt.Errorf("%q is not a valid CIDR", source)
+		}
+		return subnet, "", nil
+	}
+	if !dockerNetworkPattern.MatchString(source) {
+		return nil, "", fmt.Errorf("%q is not an IP, CIDR or Docker network name", source)
+	}
+	return nil, source, nil
+}
+
+// resolveContainerSources looks up the subnets of the Docker networks in
+// the allowlist
+func resolveContainerSources(ctx context.Context, cfg *ContainerEndpointSettings) {
+	docker := newDockerClient()
+	for _, source := range cfg.AllowedSources {
+		_, network, err := parseContainerSource(source)
+		if err != nil || network == "" {
+			continue
+		}
+		subnets, err := docker.networkSubnets(ctx, network)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "container endpoint network %s: %v\n", network, err)
+			continue
+		}
+		containerSources.mu.Lock()
+		containerSources.networks[network] = subnets
+		containerSources.mu.Unlock()
+	}
+}
+
+// containerSourceAllowed reports whether ip is in the allowlist. A Docker
+// network that hasn't been resolved yet matches nothing.
+func containerSourceAllowed(cfg *ContainerEndpointSettings, ip net.IP) bool {
+	if len(cfg.AllowedSources) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	containerSources.mu.Lock()
+	defer containerSources.mu.Unlock()
+	for _, source := range cfg.AllowedSources {
+		subnet, network, err := parseContainerSource(source)
+		if err != nil {
+			continue
+		}
+		subnets := []*net.IPNet{subnet}
+		if network != "" {
+			subnets = containerSources.networks[network]
+		}
+		for _, s := range subnets {
+			if s.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// containerCallerIP is the address the connection came from. Unlike
+// RealIP, it ignores headers a caller could set.
+func containerCallerIP(c echo.Context) net.IP {
+	host, _, err := net.SplitHostPort(c.Request().RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// containerSourceFilter refuses relayed requests and callers outside the
+// allowlist before the token is checked
+func containerSourceFilter(cfg *ContainerEndpointSettings) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ip := containerCallerIP(c)
+			reason := ""
+			if !cfg.AllowForwarded {
+				for _, h := range forwardingHeaders {
+					if c.Request().Header.Get(h) != "" {
+						reason = "request was relayed (" + h + " header)"
+						break
+					}
+				}
+			}
+			if reason == "" && !containerSourceAllowed(cfg, ip) {
+				reason = fmt.Sprintf("source %s is not in allowedSources", ip)
+			}
+			if reason == "" {
+				return next(c)
+			}
+
+			recordAudit(AuditEntry{
+				Action:  "container-endpoint.credentials",
+				Profile: c.Param("profile"),
+				Result:  "denied",
+				Details: reason,
+				Fields:  map[string]string{"remoteAddr": ip.String()},
+			})
+			return c.JSON(http.StatusForbidden, ErrorResponse{
+				Error:   "Source not allowed",
+				Details: reason,
+			})
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"gopkg.in/ini.v1"
+)
+
+const (
+	credentialProcessTimeout = 30 * time.Second
+	// Credentials are a few hundred bytes; a process writing more than this
+	// is not speaking the credential_process protocol
+	maxCredentialProcessOutput = 64 * 1024
+)
+
+// processCredentials is the JSON a credential_process prints, as defined
+// for the AWS CLI
+type processCredentials struct {
+	Version         int        `json:"Version"`
+	AccessKeyID     string     `json:"AccessKeyId"`
+	SecretAccessKey string     `json:"SecretAccessKey"`
+	SessionToken    string     `json:"SessionToken,omitempty"`
+	Expiration      *time.Time `json:"Expiration,omitempty"`
+}
+
+// cappedBuffer keeps the first max bytes written to it and notes whether
+// anything was dropped, so a runaway process can't grow the backend
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// profileCredentialProcess returns the profile's credential_process, from
+// the config profile or, as the CLI also allows, the credentials file
+func profileCredentialProcess(profile string) string {
+	if section, err := getProfileSection(profile); err == nil {
+		if cmd := section.Key("credential_process").String(); cmd != "" {
+			return cmd
+		}
+	}
+	if cfg, err := ini.Load(getAWSCredentialsPath()); err == nil {
+		if section, err := cfg.GetSection(profile); err == nil {
+			return section.Key("credential_process").String()
+		}
+	}
+	return ""
+}
+
+// runProfileCommand runs a command configured on profile, such as its
+// credential_process (named by kind in errors), and returns its stdout.
+// Stdout holds secrets, so it never ends up in errors or the audit log;
+// only stderr does, truncated like hook output. Output past maxOutput is
+// an error rather than being cut short.
+func runProfileCommand(ctx context.Context, profile, kind, command string, timeout time.Duration, maxOutput int) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stdout := &cappedBuffer{max: maxOutput}
+	stderr := &cappedBuffer{max: maxHookOutput}
+	cmd := shellCommand(ctx, command)
+	cmd.Env = hookEnv(profile, nil)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = hookWaitDelay
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return nil, fmt.Errorf("%s for %s timed out after %s", kind, profile, timeout)
+	case errors.As(err, &exitErr):
+		return nil, fmt.Errorf("%s for %s exited with %d: %s",
+			kind, profile, exitErr.ExitCode(), strings.TrimSpace(truncateOutput(stderr.buf.String())))
+	case err != nil:
+		return nil, fmt.Errorf("%s for %s: %w", kind, profile, err)
+	}
+	if stdout.truncated {
+		return nil, fmt.Errorf("%s for %s wrote more than %d bytes", kind, profile, maxOutput)
+	}
+	return stdout.buf.Bytes(), nil
+}
+
+// runCredentialProcess executes command and parses its credentials
+func runCredentialProcess(ctx context.Context, profile, command string) (aws.Credentials, error) {
+	out, err := runProfileCommand(ctx, profile, "credential_process", command, credentialProcessTimeout, maxCredentialProcessOutput)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	return parseProcessCredentials(profile, out)
+}
+
+func parseProcessCredentials(profile string, out []byte) (aws.Credentials, error) {
+	var pc processCredentials
+	dec := json.NewDecoder(bytes.NewReader(out))
+	if err := dec.Decode(&pc); err != nil {
+		// The decoder's error can quote the output; don't pass it on
+		return aws.Credentials{}, fmt.Errorf("credential_process for %s did not print credentials JSON", profile)
+	}
+	if pc.Version != 1 {
+		return aws.Credentials{}, fmt.Errorf("credential_process for %s printed unsupported Version %d", profile, pc.Version)
+	}
+	if pc.AccessKeyID == "" || pc.SecretAccessKey == "" {
+		return aws.Credentials{}, fmt.Errorf("credential_process for %s printed no AccessKeyId or SecretAccessKey", profile)
+	}
+
+	creds := aws.Credentials{
+		AccessKeyID:     pc.AccessKeyID,
+		SecretAccessKey: pc.SecretAccessKey,
+		SessionToken:    pc.SessionToken,
+		Source:          "credential_process",
+	}
+	if pc.Expiration != nil {
+		if !pc.Expiration.After(time.Now()) {
+			return aws.Credentials{}, fmt.Errorf("credential_process for %s printed credentials that expired at %s",
+				profile, pc.Expiration.UTC().Format(time.RFC3339))
+		}
+		creds.CanExpire = true
+		creds.Expires = *pc.Expiration
+	}
+	return creds, nil
+}
+
+// getBaseCredentials returns the credentials a login signs with: the
+// profile's keys in the credentials file, or else what its
+// credential_process prints. Like the CLI, static keys win when a profile
+// has both.
+func getBaseCredentials(ctx context.Context, profile string) (aws.Credentials, error) {
+	accessKey, secretKey, err := getProfileCredentials(profile)
+	if err == nil {
+		return aws.Credentials{AccessKeyID: accessKey, SecretAccessKey: secretKey}, nil
+	}
+	if command := profileCredentialProcess(profile); command != "" {
+		return runCredentialProcess(ctx, profile, command)
+	}
+	return aws.Credentials{}, err
+}
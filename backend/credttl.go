@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// STS refuses a TOTP code it has already seen, so a new login has to
+	// wait for the next code after the one just used
+	totpWindow = 30 * time.Second
+
+	codeNeedsReauth = "NEEDS_REAUTH"
+)
+
+var errNoRefresh = errors.New("profile has no role to refresh")
+
+// NeedsReauthResponse is returned when a consumer asked for credentials
+// valid for longer than the session has left and the backend can't extend
+// it on its own. EarliestUsableAt is when a new login can succeed.
+type NeedsReauthResponse struct {
+	Error            string    `json:"error"`
+	Code             string    `json:"code"`
+	Details          string    `json:"details,omitempty"`
+	Profile          string    `json:"profile"`
+	RequiredSeconds  int64     `json:"requiredSeconds"`
+	RemainingSeconds int64     `json:"remainingSeconds"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+	EarliestUsableAt time.Time `json:"earliestUsableAt"`
+}
+
+// parseMinTTL reads ?minTtl= (seconds); zero means no requirement
+func parseMinTTL(c echo.Context) (time.Duration, error) {
+	raw := c.QueryParam("minTtl")
+	if raw == "" {
+		return 0, nil
+	}
+	secs, err := strconv.Atoi(raw)
+	if err != nil || secs < 0 {
+		return 0, fmt.Errorf("minTtl must be a non-negative number of seconds")
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
+// earliestLogin is the soonest a new MFA login can be expected to work:
+// after the TOTP code that minted creds has rotated and after any STS
+// throttling backoff
+func earliestLogin(creds *CachedCredentials) time.Time {
+	at := time.Now()
+	if next := creds.IssuedAt.Add(totpWindow); next.After(at) {
+		at = next
+	}
+	if ts := stsThrottles.status(creds.Profile); ts.Throttled {
+		if next := time.Now().Add(time.Duration(ts.RefreshInterval) * time.Second); next.After(at) {
+			at = next
+		}
+	}
+	return at.UTC()
+}
+
+// refreshForTTL mints a session that outlives the current one where that
+// is possible without the user: a role can be assumed again from an MFA
+// session, since a role session's lifetime doesn't depend on the caller's.
+// A role login has no MFA session of its own, so it uses the MFA session of
+// the base of its source_profile chain if that is logged in.
+func refreshForTTL(ctx context.Context, profile string, ttl time.Duration) (*CachedCredentials, error) {
+	if profileRoleARN(profile) == "" {
+		return nil, errNoRefresh
+	}
+	p := AssumeRoleParams{
+		Profile:      profile,
+		Duration:     SessionDuration(max(defaultRoleDuration, math.Ceil(ttl.Seconds()))),
+		MinRemaining: ttl,
+	}
+	if err := resolveRoleParams(&p); err != nil {
+		return nil, err
+	}
+
+	var creds *CachedCredentials
+	var o roleOverrides
+	if current, err := loadCachedCredentials(profile); err == nil && current.RoleARN != "" {
+		// Keep the external ID, session tags and session policy the login
+		// was made with
+		o = roleOverrides{ExternalID: current.ExternalID, Tags: current.SessionTags, TransitiveTags: current.TransitiveTags,
+			Policy: current.SessionPolicy, PolicyARNs: current.SessionPolicyARNs}
+		if o.ExternalID != "" {
+			p.ExternalID = o.ExternalID
+		}
+		if current.SessionTags != nil {
+			p.Tags, p.TransitiveTags = current.SessionTags, current.TransitiveTags
+		}
+		p.Policy, p.PolicyARNs = current.SessionPolicy, current.SessionPolicyARNs
+		base, hops, err := roleChain(profile)
+		if err != nil {
+			return nil, errNoRefresh
+		}
+		baseCreds, err := loadCachedCredentials(base)
+		if err != nil || !isCredentialsValid(baseCreds) || baseCreds.RoleARN != "" {
+			return nil, errNoRefresh
+		}
+		if len(hops) == 1 {
+			p.Profile = base
+			if creds, _, err = assumeRole(ctx, p); err != nil {
+				return nil, err
+			}
+		} else if creds, err = assumeChainFromSession(ctx, baseCreds, hops, int32(p.Duration), o); err != nil {
+			return nil, err
+		}
+	} else if creds, _, err = assumeRole(ctx, p); err != nil {
+		return nil, err
+	}
+
+	if time.Until(creds.Expiration) < ttl {
+		return nil, fmt.Errorf("role session expires at %s", creds.Expiration.UTC().Format(time.RFC3339))
+	}
+	refreshed := *creds
+	refreshed.Profile = profile
+	refreshed.ExternalID = o.ExternalID
+	refreshed.SessionTags, refreshed.TransitiveTags = p.Tags, p.TransitiveTags
+	refreshed.SessionPolicy, refreshed.SessionPolicyARNs = p.Policy, p.PolicyARNs
+	return &refreshed, nil
+}
+
+// assumeChainFromSession walks a multi-hop role chain from the base
+// profile's MFA session. Every hop after the first is role chaining, so the
+// result never lasts more than an hour.
+func assumeChainFromSession(ctx context.Context, base *CachedCredentials, hops []string, duration int32, o roleOverrides) (*CachedCredentials, error) {
+	cfg, err := staticAWSConfig(ctx, hops[0], base)
+	if err != nil {
+		return nil, err
+	}
+	result, err := assumeRoleChain(ctx, cfg, hops, duration, "", "", o)
+	if err != nil {
+		return nil, err
+	}
+	return &CachedCredentials{
+		AccessKeyID:      *result.AccessKeyId,
+		SecretAccessKey:  *result.SecretAccessKey,
+		SessionToken:     *result.SessionToken,
+		Expiration:       *result.Expiration,
+		DeviceID:         base.DeviceID,
+		IssuedAt:         time.Now().UTC(),
+		RoleARN:          profileRoleARN(hops[len(hops)-1]),
+		ExternalID:       o.ExternalID,
+		SourceGeneration: sessionGeneration(base),
+	}, nil
+}
+
+// credentialsForTTL loads the profile's session, making sure it stays
+// valid for at least ttl. It returns the credentials, or the status and
+// body the handler should respond with.
+func credentialsForTTL(ctx context.Context, profile string, ttl time.Duration) (*CachedCredentials, int, interface{}) {
+	creds, status, errResp := loadUsableCredentials(profile)
+	if errResp != nil {
+		return nil, status, errResp
+	}
+	if time.Until(creds.Expiration) >= ttl {
+		return creds, http.StatusOK, nil
+	}
+
+	details := "log in again with MFA"
+	refreshed, err := refreshForTTL(ctx, profile, ttl)
+	if err == nil {
+		return refreshed, http.StatusOK, nil
+	}
+	if !errors.Is(err, errNoRefresh) {
+		details += " (role refresh failed: " + err.Error() + ")"
+	}
+
+	return nil, http.StatusUnauthorized, NeedsReauthResponse{
+		Error:            "Session expires too soon",
+		Code:             codeNeedsReauth,
+		Details:          details,
+		Profile:          profile,
+		RequiredSeconds:  int64(ttl.Seconds()),
+		RemainingSeconds: secondsRemaining(creds.Expiration),
+		ExpiresAt:        creds.Expiration.UTC(),
+		EarliestUsableAt: earliestLogin(creds),
+	}
+}
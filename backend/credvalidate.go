@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/labstack/echo/v4"
+)
+
+// POST /credentials/validate asks STS whether a cached session is still
+// accepted, which the expiry time alone can't say: the keys it came from
+// may have been deactivated, or the clock the expiry was checked against
+// may be off. It signs GetCallerIdentity with the session, which needs no
+// permissions, so a deny policy on the session (as the console's "revoke
+// active sessions" attaches) doesn't show up here.
+
+const (
+	CredStatusValid     = "valid"
+	CredStatusExpired   = "expired"
+	CredStatusInvalid   = "invalid"
+	CredStatusClockSkew = "clockSkew"
+	CredStatusUnknown   = "unknown"
+
+	credValidateTimeout = 15 * time.Second
+	// SigV4 signatures are accepted up to 15 minutes off; warn well before
+	maxClockSkew = 5 * time.Minute
+)
+
+// expiredSessionCodes mean STS saw the session token as expired even
+// though the local clock says it isn't
+var expiredSessionCodes = map[string]bool{
+	"ExpiredToken":          true,
+	"ExpiredTokenException": true,
+}
+
+// clockSkewCodes mean the request's signing time was too far from AWS's
+var clockSkewCodes = map[string]bool{
+	"RequestExpired":       true,
+	"RequestInTheFuture":   true,
+	"RequestTimeTooSkewed": true,
+}
+
+type ValidateCredentialsRequest struct {
+	Profile string `json:"profile,omitempty"`
+}
+
+// CredentialValidation is STS's answer for a session. ClockSkewSeconds is
+// how far the backend's clock is ahead of AWS's, when STS sent its time.
+type CredentialValidation struct {
+	Profile          string    `json:"profile"`
+	Valid            bool      `json:"valid"`
+	Status           string    `json:"status"`
+	Code             string    `json:"code,omitempty"`
+	Error            string    `json:"error,omitempty"`
+	Account          string    `json:"account,omitempty"`
+	Arn              string    `json:"arn,omitempty"`
+	Expiration       time.Time `json:"expiration"`
+	ClockSkewSeconds int64     `json:"clockSkewSeconds,omitempty"`
+	Warning          string    `json:"warning,omitempty"`
+	CheckedAt        time.Time `json:"checkedAt"`
+}
+
+// responseServerTime reads STS's Date header off a failed call
+func responseServerTime(err error) (time.Time, bool) {
+	var respErr *smithyhttp.ResponseError
+	if !errors.As(err, &respErr) || respErr.Response == nil {
+		return time.Time{}, false
+	}
+	t, parseErr := http.ParseTime(respErr.Response.Header.Get("Date"))
+	return t, parseErr == nil
+}
+
+// validateSession calls GetCallerIdentity with creds and classifies the
+// result. Network failures and throttling leave the status unknown.
+func validateSession(ctx context.Context, profile string, creds *CachedCredentials) (*CredentialValidation, error) {
+	cfg, err := staticAWSConfig(ctx, profile, creds)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &CredentialValidation{
+		Profile:    profile,
+		Expiration: creds.Expiration,
+	}
+	sent := time.Now()
+	out, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	stsThrottles.observe(profile, err)
+	v.CheckedAt = time.Now().UTC()
+
+	var serverTime time.Time
+	var ok bool
+	if err == nil {
+		serverTime, ok = awsmiddleware.GetServerTime(out.ResultMetadata)
+	} else {
+		serverTime, ok = responseServerTime(err)
+	}
+	if ok {
+		// The Date header only has whole seconds, so take the middle of
+		// the round trip as the local time it was sent at
+		local := sent.Add(v.CheckedAt.Sub(sent) / 2)
+		skew := local.Sub(serverTime).Round(time.Second)
+		v.ClockSkewSeconds = int64(skew.Seconds())
+		if skew > maxClockSkew || skew < -maxClockSkew {
+			v.Warning = "the backend's clock is off from AWS's by " + skew.String() +
+				"; expiry times and signatures depend on it, so sync the Docker Desktop VM's clock"
+		}
+	}
+
+	if err == nil {
+		v.Valid = true
+		v.Status = CredStatusValid
+		v.Account = aws.ToString(out.Account)
+		v.Arn = aws.ToString(out.Arn)
+		return v, nil
+	}
+
+	v.Status = CredStatusUnknown
+	v.Error = err.Error()
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		v.Code = apiErr.ErrorCode()
+		switch {
+		case expiredSessionCodes[v.Code]:
+			v.Status = CredStatusExpired
+		case clockSkewCodes[v.Code]:
+			v.Status = CredStatusClockSkew
+		case invalidKeyCodes[v.Code]:
+			v.Status = CredStatusInvalid
+		}
+	}
+	return v, nil
+}
+
+// handleValidateCredentials is POST /credentials/validate. A session that
+// has expired locally is reported without calling STS.
+func handleValidateCredentials(c echo.Context) error {
+	var req ValidateCredentialsRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	profile := requestProfile(c, req.Profile)
+
+	creds, status, errResp := loadUsableCredentials(profile)
+	if errResp != nil {
+		return c.JSON(status, errResp)
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), credValidateTimeout)
+	defer cancel()
+	v, err := validateSession(ctx, profile, creds)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to validate credentials",
+			Details: err.Error(),
+		})
+	}
+
+	if !v.Valid && v.Status != CredStatusUnknown {
+		recordAudit(AuditEntry{
+			Action:  "credentials.invalid",
+			Profile: profile,
+			Result:  "error",
+			Details: v.Error,
+			Fields:  map[string]string{"code": v.Code, "status": v.Status},
+		})
+	}
+	return c.JSON(http.StatusOK, v)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var errDeviceMismatch = errors.New("cached session is bound to a different device")
+
+var (
+	deviceIDOnce sync.Once
+	deviceID     string
+)
+
+var (
+	ioregUUIDPattern = regexp.MustCompile(`"IOPlatformUUID" = "([^"]+)"`)
+	machineGuidRegex = regexp.MustCompile(`MachineGuid\s+REG_SZ\s+(\S+)`)
+)
+
+// readMachineID returns the OS-level machine identifier, falling back to the
+// hostname when none is available
+func readMachineID() string {
+	switch runtime.GOOS {
+	case "linux":
+		for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+			if data, err := os.ReadFile(path); err == nil {
+				if id := strings.TrimSpace(string(data)); id != "" {
+					return id
+				}
+			}
+		}
+	case "darwin":
+		out, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+		if err == nil {
+			if m := ioregUUIDPattern.FindSubmatch(out); m != nil {
+				return string(m[1])
+			}
+		}
+	case "windows":
+		out, err := exec.Command("reg", "query", `HKLM\SOFTWARE\Microsoft\Cryptography`, "/v", "MachineGuid").Output()
+		if err == nil {
+			if m := machineGuidRegex.FindSubmatch(out); m != nil {
+				return string(m[1])
+			}
+		}
+	}
+
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// getDeviceID returns a stable, non-reversible identifier for this machine.
+// The raw machine ID is hashed so it never ends up in cache files.
+func getDeviceID() string {
+	deviceIDOnce.Do(func() {
+		sum := sha256.Sum256([]byte("docker-aws-mfa:" + readMachineID()))
+		deviceID = hex.EncodeToString(sum[:16])
+	})
+	return deviceID
+}
+
+// deviceSourceIdentity is the source identity role logins carry while
+// device binding is on, so the binding reaches AWS: CloudTrail records it on
+// every call, it can't be changed down a role chain, and trust or resource
+// policies can require it with aws:SourceIdentity
+func deviceSourceIdentity() string {
+	return "aws-mfa-" + getDeviceID()
+}
+
+// checkDeviceBinding refuses sessions minted on another machine when device
+// binding is enabled. Sessions without a binding are refused too, since a
+// copied pre-binding cache file is exactly what the option guards against.
+// The check reads DeviceID from the cache file itself, so it only stops a
+// copy that wasn't edited; the source identity is what AWS can enforce.
+func checkDeviceBinding(creds *CachedCredentials) error {
+	if !loadSettings().DeviceBinding {
+		return nil
+	}
+	if creds.DeviceID != getDeviceID() {
+		return errDeviceMismatch
+	}
+	return nil
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDockerHost   = "unix:///var/run/docker.sock"
+	dockerAPIVersion    = "v1.41"
+	dockerClientTimeout = 30 * time.Second
+)
+
+var (
+	errDockerNotFound = errors.New("docker object not found")
+	// volumeNamePattern is what the engine accepts as a named volume
+	volumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+)
+
+// fileOwner is the uid and gid a file copied into a container belongs to;
+// the zero value is root
+type fileOwner struct {
+	UID int
+	GID int
+}
+
+// parseFileOwner reads "uid" or "uid:gid"; the gid defaults to the uid
+func parseFileOwner(s string) (fileOwner, error) {
+	uid, gid, hasGID := strings.Cut(s, ":")
+	var owner fileOwner
+	var err error
+	if owner.UID, err = strconv.Atoi(uid); err != nil || owner.UID < 0 {
+		return fileOwner{}, fmt.Errorf("%q is not uid or uid:gid", s)
+	}
+	owner.GID = owner.UID
+	if hasGID {
+		if owner.GID, err = strconv.Atoi(gid); err != nil || owner.GID < 0 {
+			return fileOwner{}, fmt.Errorf("%q is not uid or uid:gid", s)
+		}
+	}
+	return owner, nil
+}
+
+// dockerClient is a minimal Docker Engine API client. The backend only needs
+// a handful of endpoints, which doesn't justify pulling in the full SDK.
+type dockerClient struct {
+	http    *http.Client
+	baseURL string
+}
+
+type dockerPort struct {
+	IP          string `json:"IP,omitempty"`
+	PrivatePort int    `json:"PrivatePort"`
+	PublicPort  int    `json:"PublicPort,omitempty"`
+	Type        string `json:"Type"`
+}
+
+type dockerEndpoint struct {
+	IPAddress string `json:"IPAddress"`
+}
+
+type dockerContainer struct {
+	ID              string            `json:"Id"`
+	Names           []string          `json:"Names"`
+	Image           string            `json:"Image"`
+	State           string            `json:"State"`
+	Status          string            `json:"Status"`
+	Labels          map[string]string `json:"Labels"`
+	Ports           []dockerPort      `json:"Ports"`
+	NetworkSettings struct {
+		Networks map[string]dockerEndpoint `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// Name returns the container name without the leading slash
+func (c dockerContainer) Name() string {
+	if len(c.Names) == 0 {
+		if len(c.ID) > 12 {
+			return c.ID[:12]
+		}
+		return c.ID
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
+// IPAddress returns the first network address the container is reachable on
+func (c dockerContainer) IPAddress() string {
+	for _, ep := range c.NetworkSettings.Networks {
+		if ep.IPAddress != "" {
+			return ep.IPAddress
+		}
+	}
+	return ""
+}
+
+// newDockerClient connects to DOCKER_HOST, defaulting to the engine socket
+// mounted into the extension container
+func newDockerClient() *dockerClient {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		host = defaultDockerHost
+	}
+
+	if strings.HasPrefix(host, "unix://") {
+		socketPath := strings.TrimPrefix(host, "unix://")
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		}
+		return &dockerClient{
+			http:    &http.Client{Transport: transport},
+			baseURL: "http://docker/" + dockerAPIVersion,
+		}
+	}
+
+	return &dockerClient{
+		http:    &http.Client{},
+		baseURL: strings.Replace(host, "tcp://", "http://", 1) + "/" + dockerAPIVersion,
+	}
+}
+
+func (d *dockerClient) do(ctx context.Context, method, path string, body io.Reader, out interface{}) error {
+	return d.doWithType(ctx, method, path, "application/json", body, out)
+}
+
+func (d *dockerClient) doWithType(ctx context.Context, method, path, contentType string, body io.Reader, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, dockerClientTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, d.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := d.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("docker engine unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%w: %s", errDockerNotFound, apiErr.Message)
+		}
+		return fmt.Errorf("docker engine %s %s: %d %s", method, path, resp.StatusCode, apiErr.Message)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// listContainers returns running containers matching the engine filters
+func (d *dockerClient) listContainers(ctx context.Context, filters map[string][]string) ([]dockerContainer, error) {
+	path := "/containers/json"
+	if len(filters) > 0 {
+		data, err := json.Marshal(filters)
+		if err != nil {
+			return nil, err
+		}
+		path += "?filters=" + url.QueryEscape(string(data))
+	}
+
+	var containers []dockerContainer
+	if err := d.do(ctx, http.MethodGet, path, nil, &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+// inspectContainer resolves a container by ID or name
+func (d *dockerClient) inspectContainer(ctx context.Context, idOrName string) (*dockerContainer, error) {
+	var raw struct {
+		ID     string `json:"Id"`
+		Name   string `json:"Name"`
+		Config struct {
+			Image  string            `json:"Image"`
+			Labels map[string]string `json:"Labels"`
+		} `json:"Config"`
+		State struct {
+			Status string `json:"Status"`
+		} `json:"State"`
+	}
+	if err := d.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(idOrName)+"/json", nil, &raw); err != nil {
+		return nil, err
+	}
+	return &dockerContainer{
+		ID:     raw.ID,
+		Names:  []string{raw.Name},
+		Image:  raw.Config.Image,
+		State:  raw.State.Status,
+		Labels: raw.Config.Labels,
+	}, nil
+}
+
+// networkSubnets returns the subnets of a network, by ID or name
+func (d *dockerClient) networkSubnets(ctx context.Context, idOrName string) ([]*net.IPNet, error) {
+	var raw struct {
+		IPAM struct {
+			Config []struct {
+				Subnet string `json:"Subnet"`
+			} `json:"Config"`
+		} `json:"IPAM"`
+	}
+	if err := d.do(ctx, http.MethodGet, "/networks/"+url.PathEscape(idOrName), nil, &raw); err != nil {
+		return nil, err
+	}
+	var subnets []*net.IPNet
+	for _, cfg := range raw.IPAM.Config {
+		if _, subnet, err := net.ParseCIDR(cfg.Subnet); err == nil {
+			subnets = append(subnets, subnet)
+		}
+	}
+	return subnets, nil
+}
+
+// copyFileToContainer writes a single file into a container through the
+// archive endpoint. The parent directory must already exist.
+func (d *dockerClient) copyFileToContainer(ctx context.Context, id, filePath string, data []byte, mode int64) error {
+	return d.copyFileToContainerAs(ctx, id, filePath, data, mode, fileOwner{})
+}
+
+// copyFileToContainerAs is copyFileToContainer for a file owned by owner
+func (d *dockerClient) copyFileToContainerAs(ctx context.Context, id, filePath string, data []byte, mode int64, owner fileOwner) error {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    path.Base(filePath),
+		Mode:    mode,
+		Uid:     owner.UID,
+		Gid:     owner.GID,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	query := "?path=" + url.QueryEscape(path.Dir(filePath))
+	return d.doWithType(ctx, http.MethodPut, "/containers/"+url.PathEscape(id)+"/archive"+query, "application/x-tar", &buf, nil)
+}
+
+// createSecret stores data as a swarm secret and returns its ID. Secrets
+// are immutable, so callers pick a fresh name per payload.
+func (d *dockerClient) createSecret(ctx context.Context, name string, data []byte, labels map[string]string) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"Name":   name,
+		"Data":   base64.StdEncoding.EncodeToString(data),
+		"Labels": labels,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var created struct {
+		ID string `json:"ID"`
+	}
+	if err := d.do(ctx, http.MethodPost, "/secrets/create", bytes.NewReader(body), &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// containerConfig is the subset of container create options the backend uses
+type containerConfig struct {
+	Image  string
+	Cmd    []string
+	Env    []string
+	Labels map[string]string
+	Mounts []dockerMount
+}
+
+// dockerMount is a HostConfig mount. The backend only mounts named volumes,
+// with Type "volume", so a source can never be taken for a host path.
+type dockerMount struct {
+	Type   string `json:"Type"`
+	Source string `json:"Source"`
+	Target string `json:"Target"`
+}
+
+// createContainer creates (but doesn't start) a container and returns its ID
+func (d *dockerClient) createContainer(ctx context.Context, cfg containerConfig) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"Image":      cfg.Image,
+		"Cmd":        cfg.Cmd,
+		"Env":        cfg.Env,
+		"Labels":     cfg.Labels,
+		"HostConfig": map[string]interface{}{"Mounts": cfg.Mounts},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := d.do(ctx, http.MethodPost, "/containers/create", bytes.NewReader(body), &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (d *dockerClient) removeContainer(ctx context.Context, id string) error {
+	return d.do(ctx, http.MethodDelete, "/containers/"+url.PathEscape(id)+"?force=true", nil, nil)
+}
+
+func (d *dockerClient) startContainer(ctx context.Context, id string) error {
+	return d.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/start", nil, nil)
+}
+
+// imageExists reports whether ref is present locally
+func (d *dockerClient) imageExists(ctx context.Context, ref string) (bool, error) {
+	err := d.do(ctx, http.MethodGet, "/images/"+ref+"/json", nil, nil)
+	if errors.Is(err, errDockerNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// imageDigest returns the repository digest a local image was pulled by,
+// e.g. amazon/aws-cli@sha256:...
+func (d *dockerClient) imageDigest(ctx context.Context, ref string) (string, error) {
+	var image struct {
+		RepoDigests []string `json:"RepoDigests"`
+	}
+	if err := d.do(ctx, http.MethodGet, "/images/"+ref+"/json", nil, &image); err != nil {
+		return "", err
+	}
+	repo := ref
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		repo = ref[:i]
+	}
+	for _, digest := range image.RepoDigests {
+		if strings.HasPrefix(digest, repo+"@") {
+			return digest, nil
+		}
+	}
+	return "", fmt.Errorf("image %s has no repository digest", ref)
+}
+
+// pullImage pulls ref, waiting for the engine's progress stream to finish
+func (d *dockerClient) pullImage(ctx context.Context, ref string) error {
+	body, err := d.stream(ctx, http.MethodPost, "/images/create?fromImage="+url.QueryEscape(ref))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("pull %s: %s", ref, msg.Error)
+		}
+	}
+}
+
+// waitContainer blocks until the container exits and returns its exit code
+func (d *dockerClient) waitContainer(ctx context.Context, id string) (int, error) {
+	body, err := d.stream(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/wait")
+	if err != nil {
+		return -1, err
+	}
+	defer body.Close()
+
+	var result struct {
+		StatusCode int `json:"StatusCode"`
+	}
+	if err := json.NewDecoder(body).Decode(&result); err != nil {
+		return -1, err
+	}
+	return result.StatusCode, nil
+}
+
+// followLogs streams a container's multiplexed stdout/stderr until it exits
+func (d *dockerClient) followLogs(ctx context.Context, id string) (io.ReadCloser, error) {
+	return d.stream(ctx, http.MethodGet, "/containers/"+url.PathEscape(id)+"/logs?follow=1&stdout=1&stderr=1")
+}
+
+// stream issues a request without the client timeout and hands back the
+// body for long-running responses; the caller closes it
+func (d *dockerClient) stream(ctx context.Context, method, path string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, method, d.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("docker engine unreachable: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s", errDockerNotFound, apiErr.Message)
+		}
+		return nil, fmt.Errorf("docker engine %s %s: %d %s", method, path, resp.StatusCode, apiErr.Message)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AssumeRole accepts at most 12 hours, and only when the role's maximum
+// session duration allows it
+const maxRoleSessionSecs = 43200
+
+// SessionDuration is a session length in seconds. In JSON it is either a
+// number of seconds or a duration string such as "8h", "45m" or "1d12h";
+// it is always written back as seconds.
+type SessionDuration int32
+
+func (d *SessionDuration) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*d = 0
+		return nil
+	}
+	var secs int32
+	if err := json.Unmarshal(data, &secs); err == nil {
+		*d = SessionDuration(secs)
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("duration must be seconds or a string like \"8h\"")
+	}
+	parsed, err := parseSessionDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// parseSessionDuration reads "3600", "45m", "8h", "1d" or combinations
+// like "1d12h". Sessions are issued in whole seconds, so anything finer is
+// rejected rather than silently rounded.
+func parseSessionDuration(text string) (SessionDuration, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, nil
+	}
+	if secs, err := strconv.ParseInt(text, 10, 32); err == nil {
+		return SessionDuration(secs), nil
+	}
+
+	var total time.Duration
+	rest := text
+	if days, after, ok := strings.Cut(rest, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", text)
+		}
+		total = time.Duration(n * float64(24*time.Hour))
+		rest = after
+	}
+	if rest != "" {
+		d, err := time.ParseDuration(rest)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("invalid duration %q", text)
+		}
+		total += d
+	}
+	if total%time.Second != 0 {
+		return 0, fmt.Errorf("duration %q is not a whole number of seconds", text)
+	}
+	if total.Seconds() > float64(1<<31-1) {
+		return 0, fmt.Errorf("duration %q is too long", text)
+	}
+	return SessionDuration(total / time.Second), nil
+}
+
+func (d SessionDuration) String() string {
+	return (time.Duration(d) * time.Second).String()
+}
+
+// durationLimits is the range of DurationSeconds an STS operation accepts
+type durationLimits struct {
+	Operation string
+	Min, Max  int32
+}
+
+var (
+	sessionTokenLimits = durationLimits{Operation: "GetSessionToken", Min: minSessionDuration, Max: userMaxSessionSecs}
+	assumeRoleLimits   = durationLimits{Operation: "AssumeRole", Min: minSessionDuration, Max: maxRoleSessionSecs}
+	samlLimits         = durationLimits{Operation: "AssumeRoleWithSAML", Min: minSessionDuration, Max: maxRoleSessionSecs}
+)
+
+// loginDurationLimits picks the limits for how the profile logs in: role
+// profiles assume their role, SAML profiles federate into one, others get
+// a session token
+func loginDurationLimits(profile string) durationLimits {
+	if profileRoleARN(profile) != "" {
+		return assumeRoleLimits
+	}
+	if profileSAMLCommand(profile) != "" {
+		return samlLimits
+	}
+	return sessionTokenLimits
+}
+
+func (l durationLimits) check(d SessionDuration) error {
+	if int32(d) < l.Min || int32(d) > l.Max {
+		return fmt.Errorf("duration %s (%ds) is outside the %s-%s %s allows",
+			d, int32(d), SessionDuration(l.Min), SessionDuration(l.Max), l.Operation)
+	}
+	return nil
+}
+
+// profileDurationSeconds returns duration_seconds from the profile's AWS
+// config, as the AWS CLI reads it, or 0 when it is unset or not a number
+func profileDurationSeconds(profile string) SessionDuration {
+	section, err := getProfileSection(profile)
+	if err != nil {
+		return 0
+	}
+	secs, err := section.Key("duration_seconds").Int()
+	if err != nil || secs <= 0 || secs > 1<<31-1 {
+		return 0
+	}
+	return SessionDuration(secs)
+}
+
+// defaultLoginDuration is the duration for a login that didn't ask for
+// one: the profile's setting, then its duration_seconds, then the global
+// default, then 12 hours. A default outside what the profile's login
+// operation allows is clamped rather than failing the login.
+func defaultLoginDuration(profile string) SessionDuration {
+	d := SessionDuration(defaultDuration)
+	settings := loadSettings()
+	if pd := settings.Profiles[profile].Duration; pd != 0 {
+		d = pd
+	} else if secs := profileDurationSeconds(profile); secs != 0 {
+		d = secs
+	} else if settings.DefaultDuration != 0 {
+		d = settings.DefaultDuration
+	}
+	limits := loginDurationLimits(profile)
+	return min(max(d, SessionDuration(limits.Min)), SessionDuration(limits.Max))
+}
+
+// validateDurations checks configured defaults against what the STS call
+// they feed accepts. The global default may be capped per profile at login,
+// so only per-profile durations are held to the profile's own operation.
+func validateDurations(settings *Settings) error {
+	if d := settings.DefaultDuration; d != 0 {
+		if err := sessionTokenLimits.check(d); err != nil {
+			return fmt.Errorf("defaultDuration: %w", err)
+		}
+	}
+	for profile, ps := range settings.Profiles {
+		if ps.Duration == 0 {
+			continue
+		}
+		if err := loginDurationLimits(profile).check(ps.Duration); err != nil {
+			return fmt.Errorf("profiles.%s.duration: %w", profile, err)
+		}
+	}
+	return nil
+}
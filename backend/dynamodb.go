@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	dynamoLocalPort      = 8000
+	defaultScanLimit     = 10
+	maxScanLimit         = 100
+	dynamoLocalImageHint = "dynamodb-local"
+)
+
+type DynamoLocalInstance struct {
+	ContainerID string `json:"containerId"`
+	Name        string `json:"name"`
+	Image       string `json:"image"`
+	Endpoint    string `json:"endpoint"`
+}
+
+type DynamoTableSummary struct {
+	Name        string             `json:"name"`
+	Status      string             `json:"status"`
+	ItemCount   int64              `json:"itemCount"`
+	SizeBytes   int64              `json:"sizeBytes"`
+	KeySchema   []DynamoKeyElement `json:"keySchema"`
+	BillingMode string             `json:"billingMode,omitempty"`
+}
+
+type DynamoKeyElement struct {
+	Attribute string `json:"attribute"`
+	KeyType   string `json:"keyType"`
+}
+
+type DynamoScanResponse struct {
+	Table            string                   `json:"table"`
+	Items            []map[string]interface{} `json:"items"`
+	Count            int32                    `json:"count"`
+	ScannedCount     int32                    `json:"scannedCount"`
+	HasMore          bool                     `json:"hasMore"`
+	ConsumedCapacity *float64                 `json:"consumedCapacity,omitempty"`
+}
+
+// detectDynamoLocal finds running DynamoDB Local containers via the engine API
+func detectDynamoLocal(ctx context.Context) ([]DynamoLocalInstance, error) {
+	containers, err := newDockerClient().listContainers(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := []DynamoLocalInstance{}
+	for _, ctr := range containers {
+		if !strings.Contains(ctr.Image, dynamoLocalImageHint) {
+			continue
+		}
+		ip := ctr.IPAddress()
+		if ip == "" {
+			continue
+		}
+		instances = append(instances, DynamoLocalInstance{
+			ContainerID: ctr.ID,
+			Name:        ctr.Name(),
+			Image:       ctr.Image,
+			Endpoint:    fmt.Sprintf("http://%s:%d", ip, dynamoLocalPort),
+		})
+	}
+	return instances, nil
+}
+
+// dynamoClientFor builds a client for the request: the profile's viewer
+// session by default, or a DynamoDB Local container when ?local= names one
+// (or "true" for the first detected instance). DynamoDB Local accepts any
+// credentials.
+func dynamoClientFor(c echo.Context) (*dynamodb.Client, error) {
+	ctx := c.Request().Context()
+	local := c.QueryParam("local")
+
+	if local == "" {
+		profile := requestProfile(c, c.QueryParam("profile"))
+		cfg, err := viewerAWSConfig(ctx, profile)
+		if err != nil {
+			return nil, err
+		}
+		return dynamodb.NewFromConfig(cfg), nil
+	}
+
+	instances, err := detectDynamoLocal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, inst := range instances {
+		if local == "true" || inst.ContainerID == local || strings.HasPrefix(inst.ContainerID, local) || inst.Name == local {
+			return dynamodb.New(dynamodb.Options{
+				Region:       fallbackRegion,
+				BaseEndpoint: aws.String(inst.Endpoint),
+				Credentials:  credentials.NewStaticCredentialsProvider("local", "local", ""),
+			}), nil
+		}
+	}
+	return nil, fmt.Errorf("no DynamoDB Local container matching %q", local)
+}
+
+// attributeToJSON converts a DynamoDB attribute into a plain JSON value
+func attributeToJSON(av ddbtypes.AttributeValue) interface{} {
+	switch v := av.(type) {
+	case *ddbtypes.AttributeValueMemberS:
+		return v.Value
+	case *ddbtypes.AttributeValueMemberN:
+		if n, err := strconv.ParseFloat(v.Value, 64); err == nil {
+			return n
+		}
+		return v.Value
+	case *ddbtypes.AttributeValueMemberBOOL:
+		return v.Value
+	case *ddbtypes.AttributeValueMemberNULL:
+		return nil
+	case *ddbtypes.AttributeValueMemberB:
+		return base64.StdEncoding.EncodeToString(v.Value)
+	case *ddbtypes.AttributeValueMemberSS:
+		return v.Value
+	case *ddbtypes.AttributeValueMemberNS:
+		return v.Value
+	case *ddbtypes.AttributeValueMemberBS:
+		out := make([]string, len(v.Value))
+		for i, b := range v.Value {
+			out[i] = base64.StdEncoding.EncodeToString(b)
+		}
+		return out
+	case *ddbtypes.AttributeValueMemberL:
+		out := make([]interface{}, len(v.Value))
+		for i, item := range v.Value {
+			out[i] = attributeToJSON(item)
+		}
+		return out
+	case *ddbtypes.AttributeValueMemberM:
+		return itemToJSON(v.Value)
+	}
+	return nil
+}
+
+func itemToJSON(item map[string]ddbtypes.AttributeValue) map[string]interface{} {
+	out := make(map[string]interface{}, len(item))
+	for k, v := range item {
+		out[k] = attributeToJSON(v)
+	}
+	return out
+}
+
+func handleListDynamoLocal(c echo.Context) error {
+	instances, err := detectDynamoLocal(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to query Docker engine",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, instances)
+}
+
+func handleListDynamoTables(c echo.Context) error {
+	client, err := dynamoClientFor(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Failed to create DynamoDB client",
+			Details: err.Error(),
+		})
+	}
+
+	tables := []string{}
+	paginator := dynamodb.NewListTablesPaginator(client, &dynamodb.ListTablesInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(c.Request().Context())
+		if err != nil {
+			return c.JSON(http.StatusBadGateway, ErrorResponse{
+				Error:   "Failed to list tables",
+				Details: err.Error(),
+			})
+		}
+		tables = append(tables, page.TableNames...)
+	}
+
+	return c.JSON(http.StatusOK, tables)
+}
+
+func handleDescribeDynamoTable(c echo.Context) error {
+	client, err := dynamoClientFor(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Failed to create DynamoDB client",
+			Details: err.Error(),
+		})
+	}
+
+	out, err := client.DescribeTable(c.Request().Context(), &dynamodb.DescribeTableInput{
+		TableName: aws.String(c.Param("name")),
+	})
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to describe table",
+			Details: err.Error(),
+		})
+	}
+
+	table := out.Table
+	summary := DynamoTableSummary{
+		Name:      aws.ToString(table.TableName),
+		Status:    string(table.TableStatus),
+		ItemCount: aws.ToInt64(table.ItemCount),
+		SizeBytes: aws.ToInt64(table.TableSizeBytes),
+	}
+	if table.BillingModeSummary != nil {
+		summary.BillingMode = string(table.BillingModeSummary.BillingMode)
+	}
+	for _, k := range table.KeySchema {
+		summary.KeySchema = append(summary.KeySchema, DynamoKeyElement{
+			Attribute: aws.ToString(k.AttributeName),
+			KeyType:   string(k.KeyType),
+		})
+	}
+
+	return c.JSON(http.StatusOK, summary)
+}
+
+// handleScanDynamoTable returns a small sample of items; it is a sanity
+// check, not a data export, so the limit is capped
+func handleScanDynamoTable(c echo.Context) error {
+	limit := defaultScanLimit
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	if limit > maxScanLimit {
+		limit = maxScanLimit
+	}
+
+	client, err := dynamoClientFor(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Failed to create DynamoDB client",
+			Details: err.Error(),
+		})
+	}
+
+	out, err := client.Scan(c.Request().Context(), &dynamodb.ScanInput{
+		TableName:              aws.String(c.Param("name")),
+		Limit:                  aws.Int32(int32(limit)),
+		ReturnConsumedCapacity: ddbtypes.ReturnConsumedCapacityTotal,
+	})
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to scan table",
+			Details: err.Error(),
+		})
+	}
+
+	resp := DynamoScanResponse{
+		Table:        c.Param("name"),
+		Items:        make([]map[string]interface{}, 0, len(out.Items)),
+		Count:        out.Count,
+		ScannedCount: out.ScannedCount,
+		HasMore:      len(out.LastEvaluatedKey) > 0,
+	}
+	for _, item := range out.Items {
+		resp.Items = append(resp.Items, itemToJSON(item))
+	}
+	if out.ConsumedCapacity != nil {
+		resp.ConsumedCapacity = out.ConsumedCapacity.CapacityUnits
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
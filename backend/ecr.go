@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrtypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultKeepLastImages     = 30
+	defaultExpireUntaggedDays = 14
+)
+
+type CreateRepositoryRequest struct {
+	Profile            string `json:"profile"`
+	RepositoryName     string `json:"repositoryName"`
+	ScanOnPush         *bool  `json:"scanOnPush,omitempty"`
+	ImmutableTags      bool   `json:"immutableTags,omitempty"`
+	LifecyclePolicy    string `json:"lifecyclePolicy,omitempty"`
+	KeepLastImages     int    `json:"keepLastImages,omitempty"`
+	ExpireUntaggedDays int    `json:"expireUntaggedDays,omitempty"`
+}
+
+type RepositoryResponse struct {
+	Profile         string `json:"profile"`
+	RepositoryName  string `json:"repositoryName"`
+	RepositoryARN   string `json:"repositoryArn"`
+	RepositoryURI   string `json:"repositoryUri"`
+	ScanOnPush      bool   `json:"scanOnPush"`
+	LifecyclePolicy string `json:"lifecyclePolicy"`
+}
+
+type lifecycleRule struct {
+	RulePriority int                    `json:"rulePriority"`
+	Description  string                 `json:"description"`
+	Selection    map[string]interface{} `json:"selection"`
+	Action       map[string]string      `json:"action"`
+}
+
+// defaultLifecyclePolicy expires untagged images after a few days and caps
+// the number of images kept, which covers the usual dev repository
+func defaultLifecyclePolicy(keepLast, untaggedDays int) (string, error) {
+	policy := map[string][]lifecycleRule{
+		"rules": {
+			{
+				RulePriority: 1,
+				Description:  fmt.Sprintf("Expire untagged images after %d days", untaggedDays),
+				Selection: map[string]interface{}{
+					"tagStatus":   "untagged",
+					"countType":   "sinceImagePushed",
+					"countUnit":   "days",
+					"countNumber": untaggedDays,
+				},
+				Action: map[string]string{"type": "expire"},
+			},
+			{
+				RulePriority: 2,
+				Description:  fmt.Sprintf("Keep only the last %d images", keepLast),
+				Selection: map[string]interface{}{
+					"tagStatus":   "any",
+					"countType":   "imageCountMoreThan",
+					"countNumber": keepLast,
+				},
+				Action: map[string]string{"type": "expire"},
+			},
+		},
+	}
+
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func createRepository(ctx context.Context, req *CreateRepositoryRequest) (*RepositoryResponse, error) {
+	cfg, _, err := sessionAWSConfig(ctx, req.Profile)
+	if err != nil {
+		return nil, err
+	}
+	client := ecr.NewFromConfig(cfg)
+
+	scanOnPush := req.ScanOnPush == nil || *req.ScanOnPush
+	mutability := ecrtypes.ImageTagMutabilityMutable
+	if req.ImmutableTags {
+		mutability = ecrtypes.ImageTagMutabilityImmutable
+	}
+
+	created, err := client.CreateRepository(ctx, &ecr.CreateRepositoryInput{
+		RepositoryName:             aws.String(req.RepositoryName),
+		ImageTagMutability:         mutability,
+		ImageScanningConfiguration: &ecrtypes.ImageScanningConfiguration{ScanOnPush: scanOnPush},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	policy := req.LifecyclePolicy
+	if policy == "" {
+		policy, err = defaultLifecyclePolicy(req.KeepLastImages, req.ExpireUntaggedDays)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := client.PutLifecyclePolicy(ctx, &ecr.PutLifecyclePolicyInput{
+		RepositoryName:      aws.String(req.RepositoryName),
+		LifecyclePolicyText: aws.String(policy),
+	}); err != nil {
+		return nil, fmt.Errorf("repository created but lifecycle policy failed: %w", err)
+	}
+
+	return &RepositoryResponse{
+		Profile:         req.Profile,
+		RepositoryName:  aws.ToString(created.Repository.RepositoryName),
+		RepositoryARN:   aws.ToString(created.Repository.RepositoryArn),
+		RepositoryURI:   aws.ToString(created.Repository.RepositoryUri),
+		ScanOnPush:      scanOnPush,
+		LifecyclePolicy: policy,
+	}, nil
+}
+
+func handleCreateRepository(c echo.Context) error {
+	var req CreateRepositoryRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	req.Profile = requestProfile(c, req.Profile)
+	if req.RepositoryName == "" {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Repository name is required",
+		})
+	}
+	if req.KeepLastImages <= 0 {
+		req.KeepLastImages = defaultKeepLastImages
+	}
+	if req.ExpireUntaggedDays <= 0 {
+		req.ExpireUntaggedDays = defaultExpireUntaggedDays
+	}
+	if req.LifecyclePolicy != "" && !json.Valid([]byte(req.LifecyclePolicy)) {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Lifecycle policy must be valid JSON",
+		})
+	}
+
+	repo, err := createRepository(c.Request().Context(), &req)
+	var exists *ecrtypes.RepositoryAlreadyExistsException
+	if errors.As(err, &exists) {
+		return c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "Repository already exists",
+			Details: err.Error(),
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to create repository",
+			Details: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusCreated, repo)
+}
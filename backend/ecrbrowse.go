@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrtypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultECRCacheTTL = 5 * time.Minute
+	// A manifest fetched by digest can never change
+	ecrDigestCacheTTL = 24 * time.Hour
+	ecrCacheMaxItems  = 500
+
+	ecrCacheHeader = "X-Cache"
+)
+
+// Manifest types the browser understands; ECR converts between Docker and
+// OCI formats on request
+var ecrManifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// ECRCacheSettings opts the ECR browser into caching listings and
+// manifests, so paging through a large registry doesn't repeat the same
+// calls against the API's rate limits
+type ECRCacheSettings struct {
+	Enabled    bool `json:"enabled"`
+	TTLSeconds int  `json:"ttlSeconds,omitempty"`
+}
+
+type ECRRepository struct {
+	Name      string     `json:"name"`
+	URI       string     `json:"uri"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+}
+
+type ECRImage struct {
+	Digest    string     `json:"digest"`
+	Tags      []string   `json:"tags"`
+	PushedAt  *time.Time `json:"pushedAt,omitempty"`
+	SizeBytes int64      `json:"sizeBytes"`
+	MediaType string     `json:"mediaType,omitempty"`
+}
+
+type ECRManifest struct {
+	Repository string `json:"repository"`
+	Digest     string `json:"digest"`
+	Tag        string `json:"tag,omitempty"`
+	MediaType  string `json:"mediaType"`
+	Manifest   string `json:"manifest"`
+}
+
+type ECRCacheStats struct {
+	Enabled bool  `json:"enabled"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries"`
+}
+
+type ecrCacheEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// ecrResponseCache holds ECR responses in memory, keyed by the session
+// that fetched them so a new login never sees another account's results
+type ecrResponseCache struct {
+	mu      sync.Mutex
+	entries map[string]*ecrCacheEntry
+	hits    int64
+	misses  int64
+}
+
+var ecrCache = &ecrResponseCache{entries: map[string]*ecrCacheEntry{}}
+
+func ecrCacheSettings() *ECRCacheSettings {
+	return loadSettings().ECRCache
+}
+
+func (s *ECRCacheSettings) ttl() time.Duration {
+	if s.TTLSeconds > 0 {
+		return time.Duration(s.TTLSeconds) * time.Second
+	}
+	return defaultECRCacheTTL
+}
+
+// readThrough returns the cached value for key, or fetches and stores it.
+// With caching off, or refresh set, it always fetches. The second result
+// says what happened, for the X-Cache header.
+func (c *ecrResponseCache) readThrough(key string, ttl time.Duration, refresh bool, fetch func() (interface{}, error)) (interface{}, string, error) {
+	settings := ecrCacheSettings()
+	if settings == nil || !settings.Enabled {
+		v, err := fetch()
+		return v, "bypass", err
+	}
+	if ttl == 0 {
+		ttl = settings.ttl()
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok && !refresh && now.Before(e.expiresAt) {
+		c.hits++
+		c.mu.Unlock()
+		return e.value, "hit", nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	v, err := fetch()
+	if err != nil {
+		return nil, "miss", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = &ecrCacheEntry{value: v, expiresAt: now.Add(ttl)}
+	c.evict(now)
+	return v, "miss", nil
+}
+
+// evict drops expired entries and then the soonest to expire until the
+// cache is back under its limit
+func (c *ecrResponseCache) evict(now time.Time) {
+	for key, e := range c.entries {
+		if !now.Before(e.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+	if len(c.entries) <= ecrCacheMaxItems {
+		return
+	}
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return c.entries[keys[i]].expiresAt.Before(c.entries[keys[j]].expiresAt) })
+	for _, key := range keys[:len(keys)-ecrCacheMaxItems] {
+		delete(c.entries, key)
+	}
+}
+
+func (c *ecrResponseCache) stats() ECRCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	settings := ecrCacheSettings()
+	return ECRCacheStats{
+		Enabled: settings != nil && settings.Enabled,
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Entries: len(c.entries),
+	}
+}
+
+func (c *ecrResponseCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]*ecrCacheEntry{}
+}
+
+// ecrBrowseClient returns an ECR client for the profile's session and the
+// cache key prefix for that session
+func ecrBrowseClient(ctx context.Context, profile string) (*ecr.Client, string, error) {
+	cfg, creds, err := sessionAWSConfig(ctx, profile)
+	if err != nil {
+		return nil, "", err
+	}
+	return ecr.NewFromConfig(cfg), profile + "\x00" + cfg.Region + "\x00" + sessionGeneration(creds), nil
+}
+
+func listECRRepositories(ctx context.Context, client *ecr.Client) ([]ECRRepository, error) {
+	repos := []ECRRepository{}
+	paginator := ecr.NewDescribeRepositoriesPaginator(client, &ecr.DescribeRepositoriesInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range page.Repositories {
+			repos = append(repos, ECRRepository{
+				Name:      aws.ToString(r.RepositoryName),
+				URI:       aws.ToString(r.RepositoryUri),
+				CreatedAt: r.CreatedAt,
+			})
+		}
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Name < repos[j].Name })
+	return repos, nil
+}
+
+// listECRImages returns the repository's images, newest push first
+func listECRImages(ctx context.Context, client *ecr.Client, repository string) ([]ECRImage, error) {
+	images := []ECRImage{}
+	paginator := ecr.NewDescribeImagesPaginator(client, &ecr.DescribeImagesInput{
+		RepositoryName: aws.String(repository),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range page.ImageDetails {
+			tags := d.ImageTags
+			if tags == nil {
+				tags = []string{}
+			}
+			images = append(images, ECRImage{
+				Digest:    aws.ToString(d.ImageDigest),
+				Tags:      tags,
+				PushedAt:  d.ImagePushedAt,
+				SizeBytes: aws.ToInt64(d.ImageSizeInBytes),
+				MediaType: aws.ToString(d.ImageManifestMediaType),
+			})
+		}
+	}
+	sort.SliceStable(images, func(i, j int) bool {
+		a, b := images[i].PushedAt, images[j].PushedAt
+		return a != nil && (b == nil || a.After(*b))
+	})
+	return images, nil
+}
+
+var errImageNotFound = errors.New("image not found")
+
+func getECRManifest(ctx context.Context, client *ecr.Client, repository, tag, digest string) (*ECRManifest, error) {
+	id := ecrtypes.ImageIdentifier{}
+	if digest != "" {
+		id.ImageDigest = aws.String(digest)
+	} else {
+		id.ImageTag = aws.String(tag)
+	}
+	out, err := client.BatchGetImage(ctx, &ecr.BatchGetImageInput{
+		RepositoryName:     aws.String(repository),
+		ImageIds:           []ecrtypes.ImageIdentifier{id},
+		AcceptedMediaTypes: ecrManifestMediaTypes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Images) == 0 {
+		if len(out.Failures) > 0 {
+			return nil, fmt.Errorf("%w: %s", errImageNotFound, aws.ToString(out.Failures[0].FailureReason))
+		}
+		return nil, errImageNotFound
+	}
+
+	img := out.Images[0]
+	return &ECRManifest{
+		Repository: repository,
+		Digest:     aws.ToString(img.ImageId.ImageDigest),
+		Tag:        tag,
+		MediaType:  aws.ToString(img.ImageManifestMediaType),
+		Manifest:   aws.ToString(img.ImageManifest),
+	}, nil
+}
+
+// ecrBrowseError maps ECR failures onto responses; a missing repository or
+// image is the caller's problem, anything else is upstream
+func ecrBrowseError(c echo.Context, err error) error {
+	var noRepo *ecrtypes.RepositoryNotFoundException
+	switch {
+	case errors.As(err, &noRepo):
+		return c.JSON(http.StatusNotFound, ErrorResponse{Error: "Repository not found", Details: err.Error()})
+	case errors.Is(err, errImageNotFound):
+		return c.JSON(http.StatusNotFound, ErrorResponse{Error: "Image not found", Details: err.Error()})
+	}
+	return c.JSON(http.StatusBadGateway, ErrorResponse{Error: "ECR request failed", Details: err.Error()})
+}
+
+// ecrBrowse runs one cached browser request: ?refresh=true skips the cache
+// and the X-Cache header says whether the response came from it
+func ecrBrowse(c echo.Context, op string, ttl time.Duration, fetch func(context.Context, *ecr.Client) (interface{}, error)) error {
+	profile := requestProfile(c, c.QueryParam("profile"))
+	ctx := c.Request().Context()
+	client, prefix, err := ecrBrowseClient(ctx, profile)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error:   "No valid session",
+			Details: err.Error(),
+		})
+	}
+
+	refresh, _ := strconv.ParseBool(c.QueryParam("refresh"))
+	v, cache, err := ecrCache.readThrough(prefix+"\x00"+op, ttl, refresh, func() (interface{}, error) {
+		return fetch(ctx, client)
+	})
+	c.Response().Header().Set(ecrCacheHeader, cache)
+	if err != nil {
+		return ecrBrowseError(c, err)
+	}
+	return c.JSON(http.StatusOK, v)
+}
+
+func handleListECRRepositories(c echo.Context) error {
+	return ecrBrowse(c, "repositories", 0, func(ctx context.Context, client *ecr.Client) (interface{}, error) {
+		return listECRRepositories(ctx, client)
+	})
+}
+
+// Repository names may contain slashes, so they are passed as
+// ?repository= rather than in the path
+func handleListECRImages(c echo.Context) error {
+	repository := c.QueryParam("repository")
+	if repository == "" {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Repository is required",
+		})
+	}
+	return ecrBrowse(c, "images\x00"+repository, 0, func(ctx context.Context, client *ecr.Client) (interface{}, error) {
+		return listECRImages(ctx, client, repository)
+	})
+}
+
+// handleGetECRManifest fetches a manifest by ?digest= or ?tag=. Digests
+// are immutable and cached for longer than tags, which can be moved.
+func handleGetECRManifest(c echo.Context) error {
+	repository := c.QueryParam("repository")
+	tag, digest := c.QueryParam("tag"), c.QueryParam("digest")
+	if repository == "" {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Repository is required",
+		})
+	}
+	if (tag == "") == (digest == "") {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Exactly one of tag or digest is required",
+		})
+	}
+
+	op, ttl := "manifest\x00"+repository+"\x00tag\x00"+tag, time.Duration(0)
+	if digest != "" {
+		op, ttl = "manifest\x00"+repository+"\x00digest\x00"+digest, ecrDigestCacheTTL
+	}
+	return ecrBrowse(c, op, ttl, func(ctx context.Context, client *ecr.Client) (interface{}, error) {
+		return getECRManifest(ctx, client, repository, tag, digest)
+	})
+}
+
+func handleECRCacheStats(c echo.Context) error {
+	return c.JSON(http.StatusOK, ecrCache.stats())
+}
+
+func handleFlushECRCache(c echo.Context) error {
+	ecrCache.flush()
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+const (
+	stsEndpointsRegional = "regional"
+	stsEndpointsLegacy   = "legacy"
+	// stsEndpointsGlobal sends every STS call to the global endpoint; it's
+	// only available as a profile setting
+	stsEndpointsGlobal = "global"
+	globalSTSEndpoint  = "https://sts.amazonaws.com"
+)
+
+// legacySTSRegions are the regions the global STS endpoint serves in legacy
+// mode. Regions launched later always use their own endpoint.
+var legacySTSRegions = map[string]bool{
+	"ap-northeast-1": true, "ap-south-1": true, "ap-southeast-1": true, "ap-southeast-2": true,
+	"aws-global": true, "ca-central-1": true, "eu-central-1": true, "eu-north-1": true,
+	"eu-west-1": true, "eu-west-2": true, "eu-west-3": true, "sa-east-1": true,
+	"us-east-1": true, "us-east-2": true, "us-west-1": true, "us-west-2": true,
+}
+
+// normalizeServiceName maps both settings keys ("secretsmanager") and SDK
+// service IDs ("Secrets Manager") to the same form
+func normalizeServiceName(service string) string {
+	return strings.ToLower(strings.ReplaceAll(service, " ", ""))
+}
+
+// validateEndpoints rejects endpoint overrides that aren't absolute URLs
+func validateEndpoints(endpoints map[string]string) error {
+	for service, raw := range endpoints {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+			return fmt.Errorf("endpoint for %s must be an absolute http(s) URL, got %q", service, raw)
+		}
+	}
+	return nil
+}
+
+func validateSTSEndpointMode(mode string) error {
+	switch mode {
+	case "", stsEndpointsRegional, stsEndpointsLegacy:
+		return nil
+	}
+	return fmt.Errorf("stsRegionalEndpoints must be %q or %q, got %q", stsEndpointsRegional, stsEndpointsLegacy, mode)
+}
+
+// validateProfileSTSEndpoints checks the stsEndpoint of each profile's
+// settings
+func validateProfileSTSEndpoints(profiles map[string]ProfileSettings) error {
+	for profile, ps := range profiles {
+		switch ps.STSEndpoint {
+		case "", stsEndpointsGlobal, stsEndpointsRegional:
+			continue
+		}
+		return fmt.Errorf("profiles.%s: stsEndpoint must be %q or %q, got %q", profile, stsEndpointsGlobal, stsEndpointsRegional, ps.STSEndpoint)
+	}
+	return nil
+}
+
+// stsEndpointMode resolves the profile's STS endpoint mode: its
+// stsEndpoint setting, then sts_regional_endpoints like the CLI, from the
+// AWS config profile's key or else the stsRegionalEndpoints setting, then
+// regional, which is also the SDK's default
+func stsEndpointMode(profile string) string {
+	if mode := getProfileSettings(profile).STSEndpoint; mode != "" {
+		return mode
+	}
+	if section, err := getProfileSection(profile); err == nil {
+		if mode := section.Key("sts_regional_endpoints").String(); mode != "" && validateSTSEndpointMode(mode) == nil {
+			return mode
+		}
+	}
+	if mode := loadSettings().STSRegionalEndpoints; mode != "" {
+		return mode
+	}
+	return stsEndpointsRegional
+}
+
+// awsLoadOptions are the endpoint-related options every LoadDefaultConfig
+// call in the backend includes: service overrides, the profile's FIPS and
+// dual-stack toggles and its STS endpoint mode
+func awsLoadOptions(profile string) []func(*config.LoadOptions) error {
+	ps := getProfileSettings(profile)
+	fips := profileFlag(profile, ps.FIPS, "use_fips_endpoint")
+	dualStack := profileFlag(profile, ps.DualStack, "use_dualstack_endpoint")
+	// There is no FIPS or dual-stack global endpoint; like the SDK, those
+	// stay regional whatever the mode
+	stsMode := stsEndpointMode(profile)
+	if fips || dualStack {
+		stsMode = stsEndpointsRegional
+	}
+
+	overrides, endpointURL := endpointOverrides(profile)
+	opts := []func(*config.LoadOptions) error{withEndpointOverrides(overrides, endpointURL, stsMode)}
+	if fips {
+		opts = append(opts, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+	if dualStack {
+		opts = append(opts, config.WithUseDualStackEndpoint(aws.DualStackEndpointStateEnabled))
+	}
+	return opts
+}
+
+// validateProfileEndpoints checks the endpoint overrides in each profile's
+// settings
+func validateProfileEndpoints(profiles map[string]ProfileSettings) error {
+	for profile, ps := range profiles {
+		endpoints := ps.Endpoints
+		if ps.EndpointURL != "" {
+			endpoints = map[string]string{"all services": ps.EndpointURL}
+			for service, endpoint := range ps.Endpoints {
+				endpoints[service] = endpoint
+			}
+		}
+		if err := validateEndpoints(endpoints); err != nil {
+			return fmt.Errorf("profiles.%s: %w", profile, err)
+		}
+	}
+	return nil
+}
+
+// profileEndpointURL is where all of profile's calls go: its endpointUrl
+// setting, or endpoint_url in the AWS config profile unless the profile
+// sets ignore_configure_endpoint_urls like the CLI allows
+func profileEndpointURL(profile string) string {
+	if endpoint := getProfileSettings(profile).EndpointURL; endpoint != "" {
+		return endpoint
+	}
+	section, err := getProfileSection(profile)
+	if err != nil || section.HasKey("ignore_configure_endpoint_urls") && section.Key("ignore_configure_endpoint_urls").MustBool(false) {
+		return ""
+	}
+	endpoint := sectionValue(section, "endpoint_url")
+	if validateEndpoints(map[string]string{"endpoint_url": endpoint}) != nil {
+		return ""
+	}
+	return endpoint
+}
+
+// endpointOverrides returns the per-service endpoints for profile's calls,
+// the profile's own over Settings.Endpoints, and the endpoint for every
+// other service, or ""
+func endpointOverrides(profile string) (map[string]string, string) {
+	overrides := map[string]string{}
+	for service, endpoint := range loadSettings().Endpoints {
+		overrides[normalizeServiceName(service)] = endpoint
+	}
+	endpointURL := profileEndpointURL(profile)
+	if endpointURL != "" {
+		// The profile's endpoint beats the global per-service ones
+		overrides = map[string]string{}
+	}
+	for service, endpoint := range getProfileSettings(profile).Endpoints {
+		overrides[normalizeServiceName(service)] = endpoint
+	}
+	return overrides, endpointURL
+}
+
+// withEndpointOverrides routes services listed in overrides to their URL,
+// e.g. interface VPC endpoints where the public ones are blocked, and all
+// others to endpointURL when set, e.g. LocalStack. STS calls go to the
+// global endpoint when stsMode says so. Everything else uses the SDK's own
+// resolution.
+func withEndpointOverrides(overrides map[string]string, endpointURL, stsMode string) config.LoadOptionsFunc {
+	return config.WithEndpointResolverWithOptions(aws.EndpointResolverWithOptionsFunc(
+		func(service, region string, _ ...interface{}) (aws.Endpoint, error) {
+			endpoint, ok := overrides[normalizeServiceName(service)]
+			if !ok && endpointURL != "" {
+				endpoint, ok = endpointURL, true
+			}
+			if ok {
+				return aws.Endpoint{
+					URL:               endpoint,
+					SigningRegion:     region,
+					HostnameImmutable: true,
+					Source:            aws.EndpointSourceCustom,
+				}, nil
+			}
+			if normalizeServiceName(service) == "sts" && useGlobalSTS(stsMode, region) {
+				return aws.Endpoint{
+					URL:               globalSTSEndpoint,
+					SigningRegion:     "us-east-1",
+					HostnameImmutable: true,
+				}, nil
+			}
+			return aws.Endpoint{}, &aws.EndpointNotFoundError{}
+		}))
+}
+
+// useGlobalSTS reports whether STS calls from region go to the global
+// endpoint: in legacy mode only from the older regions, in global mode from
+// every region of the aws partition, the only one with a global endpoint
+func useGlobalSTS(mode, region string) bool {
+	switch mode {
+	case stsEndpointsLegacy:
+		return legacySTSRegions[region]
+	case stsEndpointsGlobal:
+		return regionPartition(region) == "aws"
+	}
+	return false
+}
+
+// stsEndpointUsed returns the host an STS call was sent to, read off its
+// result, and whether it was the global endpoint
+func stsEndpointUsed(metadata middleware.Metadata) (string, bool) {
+	resp, ok := awsmiddleware.GetRawResponse(metadata).(*smithyhttp.Response)
+	if !ok || resp.Request == nil {
+		return "", false
+	}
+	host := resp.Request.URL.Host
+	return host, host == globalSTSHost
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	eventBufferSize   = 16
+	maxRecentEvents   = 50
+	eventKeepalive    = 30 * time.Second
+	eventLogin        = "login"
+	eventCleared      = "cleared"
+	eventSettingsSave = "settings"
+)
+
+// Event is a notification about session state. Events never carry
+// credential material, so the stream is safe to expose read-only.
+type Event struct {
+	Type    string      `json:"type"`
+	Profile string      `json:"profile,omitempty"`
+	Time    time.Time   `json:"time"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+	// recent keeps the last events for GET /snapshot, and seq counts every
+	// event published
+	recent []Event
+	seq    uint64
+}
+
+var events = &eventBus{subs: map[chan Event]struct{}{}}
+
+// publish sends the event to the SSE stream and to whichever notification
+// channels are routed for its type
+func (b *eventBus) publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	b.broadcast(e)
+	routeNotification(e)
+}
+
+// broadcast fans the event out to every subscriber; slow subscribers miss
+// events rather than blocking the publisher
+func (b *eventBus) broadcast(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	b.recent = append(b.recent, e)
+	if len(b.recent) > maxRecentEvents {
+		b.recent = b.recent[len(b.recent)-maxRecentEvents:]
+	}
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// history returns the recent events, newest first, and the sequence
+// number of the last one
+func (b *eventBus) history() ([]Event, uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	recent := make([]Event, len(b.recent))
+	for i, e := range b.recent {
+		recent[len(b.recent)-1-i] = e
+	}
+	return recent, b.seq
+}
+
+func (b *eventBus) subscribe() (chan Event, func()) {
+	ch := make(chan Event, eventBufferSize)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+// handleEvents streams events as server-sent events until the client leaves
+func handleEvents(c echo.Context) error {
+	return streamEvents(c, nil)
+}
+
+// streamEvents writes events accepted by allow (all when nil) to the client
+func streamEvents(c echo.Context, allow func(Event) bool) error {
+	ch, unsubscribe := events.subscribe()
+	defer unsubscribe()
+
+	w := startSSE(c)
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			w.Flush()
+		case e := <-ch:
+			if allow != nil && !allow(e) {
+				continue
+			}
+			writeSSE(w, e.Type, e)
+		}
+	}
+}
+
+// startSSE sends the headers of a server-sent event stream
+func startSSE(c echo.Context) *echo.Response {
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+	return w
+}
+
+// writeSSE writes one event with a JSON payload and flushes it
+func writeSSE(w *echo.Response, event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	w.Flush()
+}
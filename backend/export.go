@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+)
+
+// errInvalidTarget marks delivery failures caused by the request rather
+// than by the destination
+var errInvalidTarget = errors.New("invalid export target")
+
+// ExportTarget says where a sink should deliver. Each sink reads only the
+// fields it lists in Fields().
+type ExportTarget struct {
+	Path      string `json:"path,omitempty"`
+	Container string `json:"container,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Volume    string `json:"volume,omitempty"`
+	URL       string `json:"url,omitempty"`
+	Token     string `json:"token,omitempty"`
+	// Vault is the password manager vault the 1password sink writes to
+	Vault string `json:"vault,omitempty"`
+	// RenewAt sets the container sink's renewal policy, see InventoryEntry
+	RenewAt *float64 `json:"renewAt,omitempty"`
+}
+
+// ExportPayload is an env file plus the sessions rendered into it
+type ExportPayload struct {
+	Content  string
+	Sessions []*CachedCredentials
+	Prefixes []ProfilePrefix
+}
+
+type ExportReceipt struct {
+	Sink     string            `json:"sink"`
+	Location string            `json:"location"`
+	Details  map[string]string `json:"details,omitempty"`
+}
+
+// ExportSink is a delivery mechanism for credentials. New mechanisms are
+// added by implementing it and listing it in exportSinks.
+type ExportSink interface {
+	Description() string
+	Fields() []string
+	Deliver(ctx context.Context, target ExportTarget, payload *ExportPayload) (*ExportReceipt, error)
+}
+
+// exportValidator is implemented by sinks that can check a target up front,
+// before there are credentials to deliver
+type exportValidator interface {
+	Validate(target ExportTarget) error
+}
+
+var exportSinks = map[string]ExportSink{
+	"file":           fileSink{},
+	"container":      containerSink{},
+	"docker-secret":  dockerSecretSink{},
+	"volume":         volumeSink{},
+	"webhook":        webhookSink{},
+	"clipboard-once": clipboardSink{},
+	"vault":          vaultSink{},
+	"1password":      onePasswordSink{},
+	"bitwarden":      bitwardenSink{},
+}
+
+type ExportSinkInfo struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Fields      []string `json:"fields"`
+}
+
+type ExportRequest struct {
+	Sink     string       `json:"sink"`
+	Profile  string       `json:"profile,omitempty"`
+	Profiles string       `json:"profiles,omitempty"`
+	Target   ExportTarget `json:"target"`
+	// Async queues the export and retries it if the target is unavailable
+	Async bool `json:"async,omitempty"`
+}
+
+// deliverExport runs the named sink and stamps the receipt with its name
+func deliverExport(ctx context.Context, sink string, target ExportTarget, payload *ExportPayload) (*ExportReceipt, error) {
+	if err := validateExport(sink, target); err != nil {
+		return nil, err
+	}
+	receipt, err := exportSinks[sink].Deliver(ctx, target, payload)
+	if err != nil {
+		return nil, err
+	}
+	receipt.Sink = sink
+	return receipt, nil
+}
+
+// validateExport checks the sink exists and, where the sink supports it,
+// that the target is usable
+func validateExport(sink string, target ExportTarget) error {
+	s, ok := exportSinks[sink]
+	if !ok {
+		return fmt.Errorf("%w: unknown sink %q", errInvalidTarget, sink)
+	}
+	if v, ok := s.(exportValidator); ok {
+		return v.Validate(target)
+	}
+	return nil
+}
+
+// exportErrorStatus maps a delivery error to the status a handler returns
+func exportErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errInvalidTarget):
+		return http.StatusBadRequest
+	case errors.Is(err, errDockerNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// buildExportPayload renders one profile's session, or several prefixed
+// sessions when profiles uses the "prod:PROD,staging" syntax
+func buildExportPayload(profile, profiles string) (*ExportPayload, int, *ErrorResponse) {
+	if profiles != "" {
+		prefixes, err := parseProfilePrefixes(profiles)
+		if err != nil {
+			return nil, http.StatusBadRequest, &ErrorResponse{
+				Error:   "Invalid profiles",
+				Details: err.Error(),
+			}
+		}
+		content, sessions, status, errResp := buildMultiProfileEnv(prefixes)
+		if errResp != nil {
+			return nil, status, errResp
+		}
+		return &ExportPayload{Content: content, Sessions: sessions, Prefixes: prefixes}, http.StatusOK, nil
+	}
+
+	creds, status, errResp := loadUsableCredentials(profile)
+	if errResp != nil {
+		return nil, status, errResp
+	}
+	return &ExportPayload{
+		Content:  formatEnvContent(creds),
+		Sessions: []*CachedCredentials{creds},
+	}, http.StatusOK, nil
+}
+
+func handleListExportSinks(c echo.Context) error {
+	sinks := make([]ExportSinkInfo, 0, len(exportSinks))
+	for name, s := range exportSinks {
+		sinks = append(sinks, ExportSinkInfo{Name: name, Description: s.Description(), Fields: s.Fields()})
+	}
+	sort.Slice(sinks, func(i, j int) bool { return sinks[i].Name < sinks[j].Name })
+	return c.JSON(http.StatusOK, sinks)
+}
+
+func handleExport(c echo.Context) error {
+	var req ExportRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	if _, ok := exportSinks[req.Sink]; !ok {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Unknown sink",
+			Details: req.Sink,
+		})
+	}
+	req.Profile = requestProfile(c, req.Profile)
+	if resp := requireConfirmation(c, confirmExport, exportProfiles(req.Profile, req.Profiles)...); resp != nil {
+		return c.JSON(http.StatusPreconditionRequired, resp)
+	}
+
+	payload, status, errResp := buildExportPayload(req.Profile, req.Profiles)
+	if errResp != nil {
+		return c.JSON(status, errResp)
+	}
+
+	if req.Async {
+		job, err := enqueueExport(req.Sink, req.Profile, req.Profiles, req.Target)
+		if err != nil {
+			return c.JSON(exportErrorStatus(err), ErrorResponse{
+				Error:   "Export not queued",
+				Details: err.Error(),
+			})
+		}
+		return c.JSON(http.StatusAccepted, job)
+	}
+
+	receipt, err := auditedExport(c.Request().Context(), req.Sink, req.Target, payload)
+	if err != nil {
+		return c.JSON(exportErrorStatus(err), ErrorResponse{
+			Error:   "Export failed",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, receipt)
+}
+
+// auditedExport delivers the payload and records the outcome
+func auditedExport(ctx context.Context, sink string, target ExportTarget, payload *ExportPayload) (*ExportReceipt, error) {
+	entry := AuditEntry{
+		Action:  "export",
+		Profile: payloadProfiles(payload),
+		Result:  "ok",
+		Fields:  map[string]string{"sink": sink},
+	}
+
+	receipt, err := deliverExport(ctx, sink, target, payload)
+	if err != nil {
+		entry.Result = "error"
+		entry.Details = err.Error()
+	} else if sink != "clipboard-once" {
+		// The clipboard location is the claim URL, which works as a password
+		entry.Fields["location"] = receipt.Location
+	}
+	recordAudit(entry)
+	return receipt, err
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// The 1password and bitwarden sinks keep a password manager item per
+// profile up to date with its session, for teams whose tooling reads AWS
+// credentials from an entry, e.g. `op read op://vault/aws-prod/...`. They
+// run the same CLIs as the token code integrations, with the command,
+// account and session key from those settings, but don't need them
+// enabled. Secrets are handed over on stdin or in a private temp file,
+// never as arguments.
+
+const defaultPasswordItemPrefix = "aws-"
+
+// passwordItemField is one field of an exported item
+type passwordItemField struct {
+	Name      string
+	Value     string
+	Concealed bool
+}
+
+func passwordItemFields(creds *CachedCredentials) []passwordItemField {
+	return []passwordItemField{
+		{Name: "aws_access_key_id", Value: creds.AccessKeyID},
+		{Name: "aws_secret_access_key", Value: creds.SecretAccessKey, Concealed: true},
+		{Name: "aws_session_token", Value: creds.SessionToken, Concealed: true},
+		{Name: "expiration", Value: creds.Expiration.UTC().Format(time.RFC3339)},
+		{Name: "profile", Value: creds.Profile},
+	}
+}
+
+// passwordItemTitle names the item for a session: the target name, or
+// aws-<profile> without one. Several sessions get one item each, named
+// <name>-<profile>.
+func passwordItemTitle(name string, creds *CachedCredentials, multi bool) string {
+	switch {
+	case name == "":
+		return defaultPasswordItemPrefix + creds.Profile
+	case multi:
+		return name + "-" + creds.Profile
+	}
+	return name
+}
+
+// validPasswordItemName rejects names the CLIs would read as flags
+func validPasswordItemName(field, value string) error {
+	if strings.HasPrefix(value, "-") || strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("%w: %s can't start with a dash or span lines", errInvalidTarget, field)
+	}
+	return nil
+}
+
+// onePasswordCLI is the op configuration, which may be left out entirely
+func onePasswordCLI() *OnePasswordSettings {
+	if op := loadSettings().OnePassword; op != nil {
+		return op
+	}
+	return &OnePasswordSettings{}
+}
+
+// onePasswordSink creates or updates a secure note in a 1Password vault
+type onePasswordSink struct{}
+
+func (onePasswordSink) Description() string {
+	return "Write the session to a 1Password item with op"
+}
+func (onePasswordSink) Fields() []string { return []string{"name", "vault"} }
+
+func (onePasswordSink) Validate(target ExportTarget) error {
+	if err := validPasswordItemName("name", target.Name); err != nil {
+		return err
+	}
+	return validPasswordItemName("vault", target.Vault)
+}
+
+// opItemMissing matches op's error for an item that isn't there
+func opItemMissing(err error) bool {
+	return strings.Contains(err.Error(), "isn't an item")
+}
+
+// setOpFields sets the session fields on an item as op prints it, keeping
+// any other fields the item has
+func setOpFields(item map[string]interface{}, fields []passwordItemField) {
+	existing, _ := item["fields"].([]interface{})
+	for _, f := range fields {
+		fieldType := "STRING"
+		if f.Concealed {
+			fieldType = "CONCEALED"
+		}
+		found := false
+		for _, e := range existing {
+			if m, ok := e.(map[string]interface{}); ok && m["label"] == f.Name {
+				m["value"] = f.Value
+				m["type"] = fieldType
+				found = true
+			}
+		}
+		if !found {
+			existing = append(existing, map[string]interface{}{
+				"id":    f.Name,
+				"label": f.Name,
+				"type":  fieldType,
+				"value": f.Value,
+			})
+		}
+	}
+	item["fields"] = existing
+}
+
+// writeOpTemplate writes an item template where only the backend's user
+// can read it, for op's --template flag
+func writeOpTemplate(item map[string]interface{}) (string, error) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("", "op-item-*.json")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// upsertOpItem writes one session and returns the item's ID
+func upsertOpItem(ctx context.Context, op *OnePasswordSettings, vault, title string, creds *CachedCredentials) (string, error) {
+	var vaultArgs []string
+	if vault != "" {
+		vaultArgs = []string{"--vault", vault}
+	}
+
+	item := map[string]interface{}{"title": title, "category": "SECURE_NOTE"}
+	args := append([]string{"item", "create", "--format", "json"}, vaultArgs...)
+	out, err := runOp(ctx, op, append([]string{"item", "get", title, "--format", "json"}, vaultArgs...)...)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal([]byte(out), &item); err != nil {
+			return "", fmt.Errorf("op printed an item that isn't JSON for %s", title)
+		}
+		id, _ := item["id"].(string)
+		args = append([]string{"item", "edit", id, "--format", "json"}, vaultArgs...)
+	case !opItemMissing(err):
+		return "", fmt.Errorf("looking up %s in 1Password: %w", title, err)
+	}
+	setOpFields(item, passwordItemFields(creds))
+
+	template, err := writeOpTemplate(item)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(template)
+
+	out, err = runOp(ctx, op, append(args, "--template", template)...)
+	if err != nil {
+		return "", fmt.Errorf("writing %s to 1Password: %w", title, err)
+	}
+	var written struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal([]byte(out), &written)
+	return written.ID, nil
+}
+
+func (onePasswordSink) Deliver(ctx context.Context, target ExportTarget, payload *ExportPayload) (*ExportReceipt, error) {
+	op := onePasswordCLI()
+	multi := len(payload.Sessions) > 1
+
+	var titles, ids []string
+	for _, creds := range payload.Sessions {
+		title := passwordItemTitle(target.Name, creds, multi)
+		id, err := upsertOpItem(ctx, op, target.Vault, title, creds)
+		if err != nil {
+			return nil, err
+		}
+		titles = append(titles, title)
+		ids = append(ids, id)
+	}
+
+	receipt := &ExportReceipt{
+		Location: strings.Join(titles, ","),
+		Details:  map[string]string{"itemIds": strings.Join(ids, ",")},
+	}
+	if target.Vault != "" {
+		receipt.Details["vault"] = target.Vault
+	}
+	return receipt, nil
+}
+
+// bitwardenCLI is the bw configuration, which may be left out entirely
+func bitwardenCLI() *BitwardenSettings {
+	if bw := loadSettings().Bitwarden; bw != nil {
+		return bw
+	}
+	return &BitwardenSettings{}
+}
+
+// bitwardenSink creates or updates a secure note in the Bitwarden vault.
+// The vault must be unlocked, as for reading codes.
+type bitwardenSink struct{}
+
+func (bitwardenSink) Description() string {
+	return "Write the session to a Bitwarden item with bw"
+}
+func (bitwardenSink) Fields() []string { return []string{"name"} }
+
+func (bitwardenSink) Validate(target ExportTarget) error {
+	return validPasswordItemName("name", target.Name)
+}
+
+// Bitwarden item and field types, as bw's JSON numbers them
+const (
+	bwItemSecureNote = 2
+	bwFieldText      = 0
+	bwFieldHidden    = 1
+)
+
+// setBwFields sets the session fields on an item as bw prints it, keeping
+// any other fields the item has
+func setBwFields(item map[string]interface{}, fields []passwordItemField) {
+	existing, _ := item["fields"].([]interface{})
+	for _, f := range fields {
+		fieldType := bwFieldText
+		if f.Concealed {
+			fieldType = bwFieldHidden
+		}
+		found := false
+		for _, e := range existing {
+			if m, ok := e.(map[string]interface{}); ok && m["name"] == f.Name {
+				m["value"] = f.Value
+				m["type"] = fieldType
+				found = true
+			}
+		}
+		if !found {
+			existing = append(existing, map[string]interface{}{
+				"name":  f.Name,
+				"value": f.Value,
+				"type":  fieldType,
+			})
+		}
+	}
+	item["fields"] = existing
+}
+
+// findBwItem returns the item named title, or nil. bw searches loosely, so
+// only an exact name counts, and more than one is an error rather than a
+// guess.
+func findBwItem(ctx context.Context, bw *BitwardenSettings, title string) (map[string]interface{}, error) {
+	out, err := runBw(ctx, bw, "list", "items", "--search", title)
+	if err != nil {
+		return nil, fmt.Errorf("looking up %s in Bitwarden: %w; check the vault is unlocked", title, err)
+	}
+	var items []map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &items); err != nil {
+		return nil, fmt.Errorf("bw printed an item list that isn't JSON")
+	}
+	var match map[string]interface{}
+	for _, item := range items {
+		if item["name"] != title {
+			continue
+		}
+		if match != nil {
+			return nil, fmt.Errorf("%w: more than one Bitwarden item is named %s", errInvalidTarget, title)
+		}
+		match = item
+	}
+	return match, nil
+}
+
+// upsertBwItem writes one session and returns the item's ID
+func upsertBwItem(ctx context.Context, bw *BitwardenSettings, title string, creds *CachedCredentials) (string, error) {
+	item, err := findBwItem(ctx, bw, title)
+	if err != nil {
+		return "", err
+	}
+	args := []string{"create", "item"}
+	if item != nil {
+		id, _ := item["id"].(string)
+		args = []string{"edit", "item", id}
+	} else {
+		item = map[string]interface{}{
+			"type":       bwItemSecureNote,
+			"name":       title,
+			"secureNote": map[string]interface{}{"type": 0},
+		}
+	}
+	setBwFields(item, passwordItemFields(creds))
+
+	data, err := json.Marshal(item)
+	if err != nil {
+		return "", err
+	}
+	// bw takes items the way `bw encode` prints them
+	encoded := []byte(base64.StdEncoding.EncodeToString(data))
+	out, err := runBwInput(ctx, bw, encoded, args...)
+	if err != nil {
+		return "", fmt.Errorf("writing %s to Bitwarden: %w", title, err)
+	}
+	var written struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal([]byte(out), &written)
+	return written.ID, nil
+}
+
+func (bitwardenSink) Deliver(ctx context.Context, target ExportTarget, payload *ExportPayload) (*ExportReceipt, error) {
+	bw := bitwardenCLI()
+	// Pick up items created elsewhere before looking for them
+	if _, err := runBw(ctx, bw, "sync"); err != nil {
+		return nil, fmt.Errorf("syncing the Bitwarden vault: %w", err)
+	}
+	multi := len(payload.Sessions) > 1
+
+	var titles, ids []string
+	for _, creds := range payload.Sessions {
+		title := passwordItemTitle(target.Name, creds, multi)
+		id, err := upsertBwItem(ctx, bw, title, creds)
+		if err != nil {
+			return nil, err
+		}
+		titles = append(titles, title)
+		ids = append(ids, id)
+	}
+	return &ExportReceipt{
+		Location: strings.Join(titles, ","),
+		Details:  map[string]string{"itemIds": strings.Join(ids, ",")},
+	}, nil
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	exportJobsSubdir = "exports"
+	exportJobsFile   = "jobs.json"
+
+	exportQueueTick    = 30 * time.Second
+	maxExportAttempts  = 6
+	exportRetryBase    = 30 * time.Second
+	exportRetryMax     = 15 * time.Minute
+	exportJobRetention = 24 * time.Hour
+	maxExportJobs      = 200
+	maxExportWorkers   = 4
+
+	jobPending   = "pending"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// ExportJob is a queued export. Only the profile and target are stored;
+// the env file is rendered from the cached session on every attempt, so
+// the queue never holds credentials and a retry sends the newest session.
+type ExportJob struct {
+	ID            string         `json:"id"`
+	Sink          string         `json:"sink"`
+	Profile       string         `json:"profile,omitempty"`
+	Profiles      string         `json:"profiles,omitempty"`
+	Target        ExportTarget   `json:"target"`
+	Status        string         `json:"status"`
+	Attempts      int            `json:"attempts"`
+	MaxAttempts   int            `json:"maxAttempts"`
+	LastError     string         `json:"lastError,omitempty"`
+	NextAttemptAt *time.Time     `json:"nextAttemptAt,omitempty"`
+	CreatedAt     time.Time      `json:"createdAt"`
+	UpdatedAt     time.Time      `json:"updatedAt"`
+	Receipt       *ExportReceipt `json:"receipt,omitempty"`
+}
+
+// view is the job as the API returns it, without the target's token
+func (j *ExportJob) view() ExportJob {
+	v := *j
+	v.Target.Token = ""
+	return v
+}
+
+var exportQueue = struct {
+	mu     sync.Mutex
+	jobs   []*ExportJob
+	loaded bool
+}{}
+
+// exportWorkers bounds how many deliveries run at once, so a burst of
+// retries against a hung share doesn't pile up goroutines
+var exportWorkers = make(chan struct{}, maxExportWorkers)
+
+func getExportJobsPath() string {
+	return filepath.Join(getCacheDir(), exportJobsSubdir, exportJobsFile)
+}
+
+// loadExportJobs reads the queue on first use. Jobs that were running when
+// the backend stopped are attempted again.
+func loadExportJobs() {
+	if exportQueue.loaded {
+		return
+	}
+	exportQueue.loaded = true
+
+	data, err := os.ReadFile(getExportJobsPath())
+	if err != nil {
+		return
+	}
+	var jobs []*ExportJob
+	if err := decodeCacheFile(getExportJobsPath(), data, &jobs); err != nil {
+		return
+	}
+	for _, job := range jobs {
+		if job.Status == jobRunning {
+			job.Status = jobPending
+		}
+	}
+	exportQueue.jobs = jobs
+}
+
+func saveExportJobs() error {
+	data, err := json.MarshalIndent(exportQueue.jobs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(getExportJobsPath(), data, 0600)
+}
+
+// persistExportJobs saves the queue where no caller can report the error;
+// the jobs stay queued in memory either way
+func persistExportJobs() {
+	if err := saveExportJobs(); err != nil {
+		fmt.Fprintf(os.Stderr, "export queue: %v\n", err)
+	}
+}
+
+// pruneExportJobs drops finished jobs past their retention, then the
+// oldest finished ones while the queue is over its limit
+func pruneExportJobs(now time.Time) {
+	kept := exportQueue.jobs[:0]
+	for _, job := range exportQueue.jobs {
+		finished := job.Status == jobSucceeded || job.Status == jobFailed
+		if !finished || now.Sub(job.UpdatedAt) < exportJobRetention {
+			kept = append(kept, job)
+		}
+	}
+	for i := 0; len(kept) > maxExportJobs && i < len(kept); {
+		if kept[i].Status == jobSucceeded || kept[i].Status == jobFailed {
+			kept = append(kept[:i], kept[i+1:]...)
+			continue
+		}
+		i++
+	}
+	exportQueue.jobs = kept
+}
+
+// exportRetryDelay doubles from exportRetryBase with each failed attempt
+func exportRetryDelay(attempts int) time.Duration {
+	delay := exportRetryBase
+	for i := 1; i < attempts && delay < exportRetryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, exportRetryMax)
+}
+
+// retryableExportError reports whether another attempt could succeed. A
+// bad target or a container that no longer exists won't fix itself.
+func retryableExportError(err error) bool {
+	return !errors.Is(err, errInvalidTarget) && !errors.Is(err, errDockerNotFound)
+}
+
+// enqueueExport validates the export and queues it, starting the first
+// attempt right away
+func enqueueExport(sink, profile, profiles string, target ExportTarget) (*ExportJob, error) {
+	if sink == "clipboard-once" {
+		return nil, fmt.Errorf("%w: clipboard-once exports can't be queued", errInvalidTarget)
+	}
+	if err := validateExport(sink, target); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	job := &ExportJob{
+		ID:            hex.EncodeToString(buf),
+		Sink:          sink,
+		Profile:       profile,
+		Profiles:      profiles,
+		Target:        target,
+		Status:        jobPending,
+		MaxAttempts:   maxExportAttempts,
+		NextAttemptAt: &now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	exportQueue.mu.Lock()
+	loadExportJobs()
+	exportQueue.jobs = append(exportQueue.jobs, job)
+	pruneExportJobs(now)
+	err := saveExportJobs()
+	view := job.view()
+	exportQueue.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	go processExportQueue(context.Background())
+	return &view, nil
+}
+
+// processExportQueue starts every pending job that is due
+func processExportQueue(ctx context.Context) {
+	now := time.Now().UTC()
+
+	exportQueue.mu.Lock()
+	loadExportJobs()
+	var due []*ExportJob
+	for _, job := range exportQueue.jobs {
+		if job.Status == jobPending && (job.NextAttemptAt == nil || !job.NextAttemptAt.After(now)) {
+			job.Status = jobRunning
+			job.UpdatedAt = now
+			due = append(due, job)
+		}
+	}
+	if len(due) > 0 {
+		persistExportJobs()
+	}
+	exportQueue.mu.Unlock()
+
+	for _, job := range due {
+		go runExportJob(ctx, job)
+	}
+}
+
+// runExportJob makes one attempt and schedules the next if it failed
+func runExportJob(ctx context.Context, job *ExportJob) {
+	exportWorkers <- struct{}{}
+	defer func() { <-exportWorkers }()
+
+	exportQueue.mu.Lock()
+	sink, profile, profiles, target := job.Sink, job.Profile, job.Profiles, job.Target
+	exportQueue.mu.Unlock()
+
+	var receipt *ExportReceipt
+	retryable := false
+	payload, _, errResp := buildExportPayload(profile, profiles)
+	var err error
+	if errResp != nil {
+		// Without a usable session there is nothing to retry with
+		err = errors.New(errResp.Error)
+	} else if receipt, err = deliverExport(ctx, sink, target, payload); err != nil {
+		retryable = retryableExportError(err)
+	}
+
+	now := time.Now().UTC()
+	exportQueue.mu.Lock()
+	job.Attempts++
+	job.UpdatedAt = now
+	job.NextAttemptAt = nil
+	switch {
+	case err == nil:
+		job.Status = jobSucceeded
+		job.LastError = ""
+		job.Receipt = receipt
+	case retryable && job.Attempts < job.MaxAttempts:
+		job.Status = jobPending
+		job.LastError = err.Error()
+		next := now.Add(exportRetryDelay(job.Attempts))
+		job.NextAttemptAt = &next
+	default:
+		job.Status = jobFailed
+		job.LastError = err.Error()
+	}
+	persistExportJobs()
+	finished := *job
+	exportQueue.mu.Unlock()
+
+	if finished.Status == jobPending {
+		return
+	}
+	entry := AuditEntry{
+		Action:  "export",
+		Profile: finished.Profile,
+		Result:  "ok",
+		Fields: map[string]string{
+			"sink":     finished.Sink,
+			"job":      finished.ID,
+			"attempts": fmt.Sprint(finished.Attempts),
+		},
+	}
+	if finished.Profiles != "" {
+		entry.Profile = finished.Profiles
+	}
+	if finished.Status == jobFailed {
+		entry.Result = "error"
+		entry.Details = finished.LastError
+	} else {
+		entry.Fields["location"] = finished.Receipt.Location
+	}
+	recordAudit(entry)
+}
+
+// handleListExportJobs lists queued and recent exports, newest first,
+// optionally only those with ?status=
+func handleListExportJobs(c echo.Context) error {
+	return c.JSON(http.StatusOK, listExportJobs(c.QueryParam("status")))
+}
+
+// listExportJobs returns the jobs with status, or all of them, newest first
+func listExportJobs(status string) []ExportJob {
+	exportQueue.mu.Lock()
+	loadExportJobs()
+	jobs := make([]ExportJob, 0, len(exportQueue.jobs))
+	for _, job := range exportQueue.jobs {
+		if status == "" || job.Status == status {
+			jobs = append(jobs, job.view())
+		}
+	}
+	exportQueue.mu.Unlock()
+
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	return jobs
+}
+
+func handleGetExportJob(c echo.Context) error {
+	exportQueue.mu.Lock()
+	defer exportQueue.mu.Unlock()
+	loadExportJobs()
+
+	for _, job := range exportQueue.jobs {
+		if job.ID == c.Param("id") {
+			return c.JSON(http.StatusOK, job.view())
+		}
+	}
+	return c.JSON(http.StatusNotFound, ErrorResponse{
+		Error: "Export job not found",
+	})
+}
+
+// handleDeleteExportJob cancels a pending job or forgets a finished one. A
+// job in the middle of an attempt can't be cancelled.
+func handleDeleteExportJob(c echo.Context) error {
+	exportQueue.mu.Lock()
+	defer exportQueue.mu.Unlock()
+	loadExportJobs()
+
+	for i, job := range exportQueue.jobs {
+		if job.ID != c.Param("id") {
+			continue
+		}
+		if job.Status == jobRunning {
+			return c.JSON(http.StatusConflict, ErrorResponse{
+				Error: "Export job is running",
+			})
+		}
+		exportQueue.jobs = append(exportQueue.jobs[:i], exportQueue.jobs[i+1:]...)
+		if err := saveExportJobs(); err != nil {
+			return c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Failed to save export queue",
+				Details: err.Error(),
+			})
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+	return c.JSON(http.StatusNotFound, ErrorResponse{
+		Error: "Export job not found",
+	})
+}
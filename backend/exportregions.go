@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+type RegionExport struct {
+	Region string `json:"region"`
+	Path   string `json:"path,omitempty"`
+	Env    string `json:"env,omitempty"`
+}
+
+// resolveExportRegions reads ?regions= (comma-separated), falling back to
+// the configured export region list
+func resolveExportRegions(c echo.Context) []string {
+	var regions []string
+	for _, r := range strings.Split(c.QueryParam("regions"), ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			regions = append(regions, r)
+		}
+	}
+	if len(regions) == 0 {
+		regions = loadSettings().ExportRegions
+	}
+	return regions
+}
+
+func withRegionEnv(envContent, region string) string {
+	return envContent + fmt.Sprintf("AWS_REGION=%s\nAWS_DEFAULT_REGION=%s\n", region, region)
+}
+
+// regionEnvPath inserts the region before the extension:
+// ./deploy/app.env -> ./deploy/app.us-east-1.env
+func regionEnvPath(path, region string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + region + ext
+}
+
+// handleGetRegionEnvFiles renders one env block per region for the same
+// session; called from handleGetEnvFile when ?mode=regions
+func handleGetRegionEnvFiles(c echo.Context, envContent string) error {
+	regions := resolveExportRegions(c)
+	if len(regions) == 0 {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "No regions given and no export regions configured",
+		})
+	}
+
+	exports := make([]RegionExport, 0, len(regions))
+	for _, region := range regions {
+		exports = append(exports, RegionExport{
+			Region: region,
+			Env:    withRegionEnv(envContent, region),
+		})
+	}
+	return c.JSON(http.StatusOK, exports)
+}
+
+// handleExportRegionEnvFiles writes one env file per region next to the
+// requested path; called from handleExportEnvFile when ?mode=regions
+func handleExportRegionEnvFiles(c echo.Context, envContent, outputPath string) error {
+	regions := resolveExportRegions(c)
+	if len(regions) == 0 {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "No regions given and no export regions configured",
+		})
+	}
+
+	exports := make([]RegionExport, 0, len(regions))
+	for _, region := range regions {
+		path := regionEnvPath(outputPath, region)
+		payload := &ExportPayload{Content: withRegionEnv(envContent, region)}
+		if _, err := deliverExport(c.Request().Context(), "file", ExportTarget{Path: path}, payload); err != nil {
+			return c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Failed to write env file",
+				Details: err.Error(),
+			})
+		}
+		exports = append(exports, RegionExport{Region: region, Path: path})
+	}
+
+	return c.JSON(http.StatusOK, exports)
+}
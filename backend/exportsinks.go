@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultSecretName  = "aws-credentials"
+	defaultVolumePath  = "/aws-credentials.env"
+	volumeMountPoint   = "/export"
+	webhookTimeout     = 10 * time.Second
+	clipboardExportTTL = 2 * time.Minute
+	exportLabel        = "com.docker.aws-mfa.export"
+)
+
+// sinkHTTPClient delivers exports over HTTP without following redirects: a
+// 307 or 308 would resend the credentials, and any auth header, to wherever
+// it points, past the checks Validate made on the configured address
+var sinkHTTPClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// fileSink writes the env file to a path in the backend's filesystem
+type fileSink struct{}
+
+func (fileSink) Description() string { return "Write an env file to a path" }
+func (fileSink) Fields() []string    { return []string{"path"} }
+
+func (fileSink) Validate(target ExportTarget) error {
+	if target.Path == "" {
+		return fmt.Errorf("%w: path is required", errInvalidTarget)
+	}
+	return nil
+}
+
+// Deliver writes to the mounted location when the path is a remapped host
+// path, and reports where the file actually is
+func (fileSink) Deliver(_ context.Context, target ExportTarget, payload *ExportPayload) (*ExportReceipt, error) {
+	path := remapPath(target.Path)
+	if err := os.WriteFile(path, []byte(payload.Content), 0600); err != nil {
+		return nil, err
+	}
+	return &ExportReceipt{Location: path}, nil
+}
+
+// containerSink copies the env file into a running container and records
+// the delivery in the inventory
+type containerSink struct{}
+
+func (containerSink) Description() string {
+	return "Copy an env file into a running container"
+}
+func (containerSink) Fields() []string { return []string{"container", "path"} }
+
+func (containerSink) Validate(target ExportTarget) error {
+	if target.Container == "" {
+		return fmt.Errorf("%w: container is required", errInvalidTarget)
+	}
+	if target.Path != "" && !path.IsAbs(target.Path) {
+		return fmt.Errorf("%w: path must be absolute inside the container", errInvalidTarget)
+	}
+	if target.RenewAt != nil && (*target.RenewAt < 0 || *target.RenewAt >= 1) {
+		return fmt.Errorf("%w: renewAt must be between 0 and 1", errInvalidTarget)
+	}
+	return nil
+}
+
+func (containerSink) Deliver(ctx context.Context, target ExportTarget, payload *ExportPayload) (*ExportReceipt, error) {
+	if target.Path == "" {
+		target.Path = defaultInjectPath
+	}
+
+	docker := newDockerClient()
+	ctr, err := docker.inspectContainer(ctx, target.Container)
+	if err != nil {
+		return nil, err
+	}
+	if err := docker.copyFileToContainer(ctx, ctr.ID, target.Path, []byte(payload.Content), injectedFileMode); err != nil {
+		return nil, fmt.Errorf("failed to copy credentials into container: %w", err)
+	}
+
+	for i, creds := range payload.Sessions {
+		prefix := ""
+		if i < len(payload.Prefixes) {
+			prefix = payload.Prefixes[i].Prefix
+		}
+		if err := recordDelivery(ctr, creds, mechanismInject, target.Path, prefix, target.RenewAt); err != nil {
+			return nil, fmt.Errorf("credentials injected but inventory update failed: %w", err)
+		}
+	}
+
+	return &ExportReceipt{
+		Location: ctr.Name() + ":" + target.Path,
+		Details: map[string]string{
+			"containerId":   ctr.ID,
+			"containerName": ctr.Name(),
+			"path":          target.Path,
+		},
+	}, nil
+}
+
+// dockerSecretSink stores the env file as a swarm secret. Secrets can't be
+// updated, so each session gets its own secret named after its generation.
+type dockerSecretSink struct{}
+
+func (dockerSecretSink) Description() string {
+	return "Create a Docker swarm secret (requires swarm mode)"
+}
+func (dockerSecretSink) Fields() []string { return []string{"name"} }
+
+func (dockerSecretSink) Deliver(ctx context.Context, target ExportTarget, payload *ExportPayload) (*ExportReceipt, error) {
+	base := target.Name
+	if base == "" {
+		base = defaultSecretName
+	}
+	name := base + "-" + payloadGeneration(payload)
+
+	id, err := newDockerClient().createSecret(ctx, name, []byte(payload.Content), map[string]string{
+		exportLabel: payloadProfiles(payload),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ExportReceipt{
+		Location: name,
+		Details:  map[string]string{"secretId": id},
+	}, nil
+}
+
+// volumeSink writes the env file into a named volume through a throwaway
+// container created from the backend's own image, which is always present
+type volumeSink struct{}
+
+func (volumeSink) Description() string { return "Write an env file into a named Docker volume" }
+func (volumeSink) Fields() []string    { return []string{"volume", "path"} }
+
+func (volumeSink) Validate(target ExportTarget) error {
+	if target.Volume == "" {
+		return fmt.Errorf("%w: volume is required", errInvalidTarget)
+	}
+	if !volumeNamePattern.MatchString(target.Volume) {
+		return fmt.Errorf("%w: %q is not a volume name", errInvalidTarget, target.Volume)
+	}
+	if target.Path != "" && (!path.IsAbs(target.Path) || path.Clean(target.Path) != target.Path || target.Path == "/") {
+		return fmt.Errorf("%w: path must be an absolute file path within the volume", errInvalidTarget)
+	}
+	return nil
+}
+
+func (volumeSink) Deliver(ctx context.Context, target ExportTarget, payload *ExportPayload) (*ExportReceipt, error) {
+	if target.Path == "" {
+		target.Path = defaultVolumePath
+	}
+
+	if err := writeVolumeFile(ctx, target.Volume, target.Path, []byte(payload.Content), injectedFileMode, fileOwner{}); err != nil {
+		return nil, err
+	}
+	return &ExportReceipt{Location: target.Volume + ":" + target.Path}, nil
+}
+
+// webhookSink POSTs the sessions as JSON. Plain HTTP is only allowed to
+// loopback addresses.
+type webhookSink struct{}
+
+func (webhookSink) Description() string { return "POST the credentials as JSON to a webhook" }
+func (webhookSink) Fields() []string    { return []string{"url"} }
+
+type webhookSession struct {
+	Profile string `json:"profile"`
+	CredentialProcessOutput
+}
+
+func (webhookSink) Validate(target ExportTarget) error {
+	u, err := url.Parse(target.URL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%w: url must be absolute", errInvalidTarget)
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && isLoopbackHost(u.Hostname())) {
+		return fmt.Errorf("%w: url must use https unless it points at localhost", errInvalidTarget)
+	}
+	return nil
+}
+
+func (webhookSink) Deliver(ctx context.Context, target ExportTarget, payload *ExportPayload) (*ExportReceipt, error) {
+	u, err := url.Parse(target.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]webhookSession, 0, len(payload.Sessions))
+	for _, creds := range payload.Sessions {
+		sessions = append(sessions, webhookSession{
+			Profile: creds.Profile,
+			CredentialProcessOutput: CredentialProcessOutput{
+				Version:         credentialProcessV1,
+				AccessKeyID:     creds.AccessKeyID,
+				SecretAccessKey: creds.SecretAccessKey,
+				SessionToken:    creds.SessionToken,
+				Expiration:      creds.Expiration.UTC().Format(time.RFC3339),
+			},
+		})
+	}
+	body, err := json.Marshal(map[string]interface{}{"sessions": sessions})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+	resp, err := sinkHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("webhook unreachable: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	return &ExportReceipt{
+		Location: u.Scheme + "://" + u.Host + u.Path,
+		Details:  map[string]string{"status": resp.Status},
+	}, nil
+}
+
+// writeVolumeFile writes one file, owned by owner, into a named volume
+// through a stopped helper container created from the backend's own image
+func writeVolumeFile(ctx context.Context, volume, filePath string, data []byte, mode int64, owner fileOwner) error {
+	docker := newDockerClient()
+	hostname, _ := os.Hostname()
+	self, err := docker.inspectContainer(ctx, hostname)
+	if err != nil {
+		return fmt.Errorf("volume export needs the backend to run as a container: %w", err)
+	}
+
+	id, err := docker.createContainer(ctx, containerConfig{
+		Image:  self.Image,
+		Mounts: []dockerMount{{Type: "volume", Source: volume, Target: volumeMountPoint}},
+		Labels: map[string]string{exportLabel: "volume"},
+	})
+	if err != nil {
+		return err
+	}
+	defer docker.removeContainer(context.Background(), id)
+
+	if err := docker.copyFileToContainerAs(ctx, id, path.Join(volumeMountPoint, filePath), data, mode, owner); err != nil {
+		return fmt.Errorf("failed to write into volume: %w", err)
+	}
+	return nil
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// clipboardSink parks the env file behind a single-use claim URL. The UI
+// fetches it once and puts it on the clipboard; the backend itself has no
+// access to the host clipboard.
+type clipboardSink struct{}
+
+type clipboardExport struct {
+	content   string
+	expiresAt time.Time
+}
+
+var (
+	clipboardMu      sync.Mutex
+	clipboardExports = map[string]clipboardExport{}
+)
+
+func (clipboardSink) Description() string {
+	return "Hold the env file for a single retrieval, for copying to the clipboard"
+}
+func (clipboardSink) Fields() []string { return []string{} }
+
+func (clipboardSink) Deliver(_ context.Context, _ ExportTarget, payload *ExportPayload) (*ExportReceipt, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(buf)
+	expiresAt := time.Now().Add(clipboardExportTTL)
+
+	clipboardMu.Lock()
+	pruneClipboardExports()
+	clipboardExports[token] = clipboardExport{content: payload.Content, expiresAt: expiresAt}
+	clipboardMu.Unlock()
+
+	return &ExportReceipt{
+		Location: "/export/claim/" + token,
+		Details:  map[string]string{"expiresAt": expiresAt.UTC().Format(time.RFC3339)},
+	}, nil
+}
+
+// pruneClipboardExports drops unclaimed exports; callers hold clipboardMu
+func pruneClipboardExports() {
+	now := time.Now()
+	for token, e := range clipboardExports {
+		if now.After(e.expiresAt) {
+			delete(clipboardExports, token)
+		}
+	}
+}
+
+// handleClaimExport returns a clipboard export once and forgets it
+func handleClaimExport(c echo.Context) error {
+	clipboardMu.Lock()
+	pruneClipboardExports()
+	e, ok := clipboardExports[c.Param("token")]
+	delete(clipboardExports, c.Param("token"))
+	clipboardMu.Unlock()
+
+	if !ok {
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "Export already claimed or expired",
+		})
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.String(http.StatusOK, e.content)
+}
+
+// payloadGeneration identifies the sessions in a payload
+func payloadGeneration(payload *ExportPayload) string {
+	if len(payload.Sessions) == 1 {
+		return sessionGeneration(payload.Sessions[0])
+	}
+	keys := make([]string, 0, len(payload.Sessions))
+	for _, s := range payload.Sessions {
+		keys = append(keys, s.AccessKeyID)
+	}
+	return sessionGeneration(&CachedCredentials{AccessKeyID: strings.Join(keys, ",")})
+}
+
+func payloadProfiles(payload *ExportPayload) string {
+	profiles := make([]string, 0, len(payload.Sessions))
+	for _, s := range payload.Sessions {
+		profiles = append(profiles, s.Profile)
+	}
+	return strings.Join(profiles, ",")
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultVaultAddr = "http://127.0.0.1:8200"
+	defaultVaultPath = "secret/aws"
+	vaultTimeout     = 10 * time.Second
+)
+
+// vaultSink writes sessions into a KV v2 secrets engine, using the field
+// names of Vault's AWS secrets engine so consumers can read either the
+// same way. Address and token fall back to VAULT_ADDR and VAULT_TOKEN.
+type vaultSink struct{}
+
+// VaultSecret is one session as a KV secret. Path includes the mount.
+type VaultSecret struct {
+	Path string            `json:"path"`
+	Data map[string]string `json:"data"`
+}
+
+func (vaultSink) Description() string {
+	return "Write the session to a HashiCorp Vault KV v2 secret"
+}
+func (vaultSink) Fields() []string { return []string{"url", "path", "token"} }
+
+func vaultAddr(target ExportTarget) string {
+	if target.URL != "" {
+		return target.URL
+	}
+	if addr := os.Getenv("VAULT_ADDR"); addr != "" {
+		return addr
+	}
+	return defaultVaultAddr
+}
+
+func (vaultSink) Validate(target ExportTarget) error {
+	u, err := url.Parse(vaultAddr(target))
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%w: url must be the Vault address", errInvalidTarget)
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && isLoopbackHost(u.Hostname())) {
+		return fmt.Errorf("%w: url must use https unless Vault runs on localhost", errInvalidTarget)
+	}
+	if target.Path != "" && !strings.Contains(strings.Trim(target.Path, "/"), "/") {
+		return fmt.Errorf("%w: path must be <mount>/<secret path>", errInvalidTarget)
+	}
+	if target.Token == "" && os.Getenv("VAULT_TOKEN") == "" {
+		return fmt.Errorf("%w: token is required", errInvalidTarget)
+	}
+	return nil
+}
+
+// vaultSecrets shapes a payload as KV secrets. A single session goes to
+// the path itself; several go to one secret per profile beneath it.
+func vaultSecrets(secretPath string, payload *ExportPayload) []VaultSecret {
+	if secretPath == "" {
+		secretPath = defaultVaultPath
+	}
+	secretPath = strings.Trim(secretPath, "/")
+
+	secrets := make([]VaultSecret, 0, len(payload.Sessions))
+	for _, creds := range payload.Sessions {
+		p := secretPath
+		if len(payload.Sessions) > 1 {
+			p = path.Join(secretPath, creds.Profile)
+		}
+		secrets = append(secrets, VaultSecret{
+			Path: p,
+			Data: map[string]string{
+				"access_key":     creds.AccessKeyID,
+				"secret_key":     creds.SecretAccessKey,
+				"security_token": creds.SessionToken,
+				"expiration":     creds.Expiration.UTC().Format(time.RFC3339),
+				"profile":        creds.Profile,
+			},
+		})
+	}
+	return secrets
+}
+
+// kvDataURL maps "mount/rest" to the KV v2 data endpoint
+func kvDataURL(addr, secretPath string) string {
+	mount, rest, _ := strings.Cut(secretPath, "/")
+	return strings.TrimRight(addr, "/") + "/v1/" + mount + "/data/" + rest
+}
+
+func (vaultSink) Deliver(ctx context.Context, target ExportTarget, payload *ExportPayload) (*ExportReceipt, error) {
+	addr := vaultAddr(target)
+	token := target.Token
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, vaultTimeout)
+	defer cancel()
+
+	var written []string
+	for _, secret := range vaultSecrets(target.Path, payload) {
+		body, err := json.Marshal(map[string]interface{}{"data": secret.Data})
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, kvDataURL(addr, secret.Path), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("X-Vault-Token", token)
+
+		resp, err := sinkHTTPClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("vault unreachable: %w", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return nil, fmt.Errorf("vault returned %s writing %s", resp.Status, secret.Path)
+		}
+		written = append(written, secret.Path)
+	}
+
+	return &ExportReceipt{
+		Location: strings.Join(written, ","),
+		Details:  map[string]string{"address": addr},
+	}, nil
+}
+
+// handleGetVaultPayload returns the secrets the vault sink would write,
+// for teams that push them with `vault kv put` themselves
+func handleGetVaultPayload(c echo.Context) error {
+	profile := requestProfile(c, c.QueryParam("profile"))
+	if resp := requireConfirmation(c, confirmExport, exportProfiles(profile, c.QueryParam("profiles"))...); resp != nil {
+		return c.JSON(http.StatusPreconditionRequired, resp)
+	}
+	payload, status, errResp := buildExportPayload(profile, c.QueryParam("profiles"))
+	if errResp != nil {
+		return c.JSON(status, errResp)
+	}
+
+	secrets := vaultSecrets(c.QueryParam("path"), payload)
+	if c.QueryParam("format") == "commands" {
+		var b strings.Builder
+		for _, s := range secrets {
+			data, _ := json.Marshal(s.Data)
+			mount, rest, _ := strings.Cut(s.Path, "/")
+			fmt.Fprintf(&b, "echo %s | vault kv put -mount=%s %s -\n", shellQuote(string(data)), shellQuote(mount), shellQuote(rest))
+		}
+		return c.String(http.StatusOK, b.String())
+	}
+	return c.JSON(http.StatusOK, secrets)
+}
+
+// shellQuote single-quotes s for a POSIX shell, so quotes in a secret or
+// path can't end the string
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
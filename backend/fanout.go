@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxFanoutConcurrency = 8
+	fanoutProfileToken   = "{profile}"
+)
+
+// quickAction is a self-contained task run against one authenticated
+// profile. params come from the fanout request and are shared by all
+// profiles, with {profile} expanded.
+type quickAction func(ctx context.Context, creds *CachedCredentials, params map[string]string) (interface{}, error)
+
+var quickActions = map[string]quickAction{
+	"ecr-login":  quickECRLogin,
+	"env-export": quickEnvExport,
+	"identity":   quickIdentity,
+}
+
+// releasesCredentials marks the actions that hand a session to something
+// outside the backend, which sensitive profiles need a confirmation for
+var releasesCredentials = map[string]bool{
+	"ecr-login":  true,
+	"env-export": true,
+}
+
+type FanoutRequest struct {
+	Actions  []string          `json:"actions"`
+	Profiles []string          `json:"profiles"`
+	Params   map[string]string `json:"params,omitempty"`
+}
+
+// FanoutResult holds one profile's outcome; actions run in order and stop
+// at the first failure
+type FanoutResult struct {
+	Profile    string                 `json:"profile"`
+	OK         bool                   `json:"ok"`
+	Results    map[string]interface{} `json:"results"`
+	FailedStep string                 `json:"failedStep,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	DurationMs int64                  `json:"durationMs"`
+}
+
+type FanoutResponse struct {
+	Actions   []string       `json:"actions"`
+	Succeeded int            `json:"succeeded"`
+	Failed    int            `json:"failed"`
+	Results   []FanoutResult `json:"results"`
+}
+
+type ECRLoginResult struct {
+	Registry  string    `json:"registry"`
+	Username  string    `json:"username"`
+	Password  string    `json:"password"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// quickECRLogin returns registry credentials for `docker login`
+func quickECRLogin(ctx context.Context, creds *CachedCredentials, _ map[string]string) (interface{}, error) {
+	cfg, err := staticAWSConfig(ctx, creds.Profile, creds)
+	if err != nil {
+		return nil, err
+	}
+	out, err := ecr.NewFromConfig(cfg).GetAuthorizationToken(ctx, &ecr.GetAuthorizationTokenInput{})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.AuthorizationData) == 0 {
+		return nil, fmt.Errorf("no authorization data returned")
+	}
+
+	auth := out.AuthorizationData[0]
+	decoded, err := base64.StdEncoding.DecodeString(aws.ToString(auth.AuthorizationToken))
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorization token: %w", err)
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid authorization token")
+	}
+
+	return ECRLoginResult{
+		Registry:  aws.ToString(auth.ProxyEndpoint),
+		Username:  username,
+		Password:  password,
+		ExpiresAt: aws.ToTime(auth.ExpiresAt),
+	}, nil
+}
+
+// quickEnvExport writes the session to params["path"]; with more than one
+// profile the path should contain {profile}
+func quickEnvExport(_ context.Context, creds *CachedCredentials, params map[string]string) (interface{}, error) {
+	path := params["path"]
+	if path == "" {
+		return nil, fmt.Errorf("params.path is required")
+	}
+	// Like the file sink, write to where a host path is mounted
+	path = remapPath(path)
+	if err := os.WriteFile(path, []byte(formatEnvContent(creds)), 0600); err != nil {
+		return nil, err
+	}
+	return map[string]string{"path": path}, nil
+}
+
+func quickIdentity(ctx context.Context, creds *CachedCredentials, _ map[string]string) (interface{}, error) {
+	return getIdentity(ctx, creds.Profile, false)
+}
+
+func expandFanoutParams(params map[string]string, profile string) map[string]string {
+	expanded := make(map[string]string, len(params))
+	for k, v := range params {
+		expanded[k] = strings.ReplaceAll(v, fanoutProfileToken, profile)
+	}
+	return expanded
+}
+
+func runFanoutProfile(ctx context.Context, req *FanoutRequest, profile string) (result FanoutResult) {
+	start := time.Now()
+	result = FanoutResult{Profile: profile, Results: map[string]interface{}{}}
+	defer func() {
+		result.DurationMs = time.Since(start).Milliseconds()
+	}()
+
+	creds, _, errResp := loadUsableCredentials(profile)
+	if errResp != nil {
+		result.Error = errResp.Error
+		return result
+	}
+
+	params := expandFanoutParams(req.Params, profile)
+	for _, name := range req.Actions {
+		out, err := quickActions[name](ctx, creds, params)
+		if err != nil {
+			result.FailedStep = name
+			result.Error = err.Error()
+			return result
+		}
+		result.Results[name] = out
+	}
+	result.OK = true
+	return result
+}
+
+// runFanout runs the actions for every profile concurrently, bounded so a
+// long profile list doesn't trip STS or ECR rate limits
+func runFanout(ctx context.Context, req *FanoutRequest) *FanoutResponse {
+	results := make([]FanoutResult, len(req.Profiles))
+	sem := make(chan struct{}, maxFanoutConcurrency)
+	var wg sync.WaitGroup
+
+	for i, profile := range req.Profiles {
+		wg.Add(1)
+		go func(i int, profile string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = runFanoutProfile(ctx, req, profile)
+		}(i, profile)
+	}
+	wg.Wait()
+
+	resp := &FanoutResponse{Actions: req.Actions, Results: results}
+	for _, r := range results {
+		if r.OK {
+			resp.Succeeded++
+		} else {
+			resp.Failed++
+		}
+	}
+	return resp
+}
+
+func validateFanout(req *FanoutRequest) error {
+	if len(req.Actions) == 0 {
+		return fmt.Errorf("at least one action is required")
+	}
+	for _, name := range req.Actions {
+		if _, ok := quickActions[name]; !ok {
+			names := make([]string, 0, len(quickActions))
+			for n := range quickActions {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown action %q, expected one of %s", name, strings.Join(names, ", "))
+		}
+	}
+	if len(req.Profiles) == 0 {
+		return fmt.Errorf("at least one profile is required")
+	}
+	seen := map[string]bool{}
+	for _, p := range req.Profiles {
+		if seen[p] {
+			return fmt.Errorf("profile %s listed twice", p)
+		}
+		seen[p] = true
+	}
+	if path, ok := req.Params["path"]; ok && len(req.Profiles) > 1 && !strings.Contains(path, fanoutProfileToken) {
+		return fmt.Errorf("params.path must contain %s when exporting several profiles", fanoutProfileToken)
+	}
+	return nil
+}
+
+func handleFanout(c echo.Context) error {
+	var req FanoutRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	if err := validateFanout(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid fanout request",
+			Details: err.Error(),
+		})
+	}
+
+	for _, action := range req.Actions {
+		if !releasesCredentials[action] {
+			continue
+		}
+		if resp := requireConfirmation(c, confirmExport, req.Profiles...); resp != nil {
+			return c.JSON(http.StatusPreconditionRequired, resp)
+		}
+		break
+	}
+
+	resp := runFanout(c.Request().Context(), &req)
+	for _, r := range resp.Results {
+		entry := AuditEntry{
+			Action:  "fanout",
+			Profile: r.Profile,
+			Result:  "ok",
+			Fields:  map[string]string{"actions": strings.Join(req.Actions, ",")},
+		}
+		if !r.OK {
+			entry.Result = "error"
+			entry.Details = r.Error
+		}
+		recordAudit(entry)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// Granted (granted.dev) writes the profiles `granted sso populate`
+// generates with its own copies of the SSO keys, granted_sso_start_url and
+// so on, and a credential_process that runs `granted credential-process`.
+// The AWS CLI ignores the granted_ keys, and the backend doesn't run
+// granted, so these profiles are read as the SSO profiles they are and
+// logged in to with the device flow like any other. That reuses a portal
+// token Granted exported to ~/.aws/sso/cache, with its ExportSSOToken
+// setting. Generated profiles are left as Granted wrote them.
+
+const (
+	grantedKeyPrefix = "granted_"
+	// grantedGeneratedFromKey marks generated profiles, which
+	// `granted sso populate --prune` may remove again
+	grantedGeneratedFromKey = "common_fate_generated_from"
+	sourceTypeGrantedSSO    = "grantedSSO"
+)
+
+// GrantedInfo describes a profile Granted manages
+type GrantedInfo struct {
+	// GeneratedFrom is where Granted generated the profile from, e.g.
+	// "aws-sso", or "" for a profile written by hand
+	GeneratedFrom string `json:"generatedFrom,omitempty"`
+	// CredentialProcess is set when the profile's credential_process runs
+	// granted, which the backend logs in without
+	CredentialProcess bool `json:"credentialProcess"`
+}
+
+// sectionValue reads key without adding it to section when it's missing
+func sectionValue(section *ini.Section, key string) string {
+	if !section.HasKey(key) {
+		return ""
+	}
+	return section.Key(key).String()
+}
+
+// ssoProfileKey reads one of a profile's sso_ keys, or Granted's form of it
+func ssoProfileKey(section *ini.Section, key string) string {
+	if value := sectionValue(section, key); value != "" {
+		return value
+	}
+	return sectionValue(section, grantedKeyPrefix+key)
+}
+
+// isGrantedSSO reports whether profile section uses Granted's SSO keys
+// rather than the CLI's
+func isGrantedSSO(section *ini.Section) bool {
+	return sectionValue(section, "sso_start_url") == "" && sectionValue(section, "sso_session") == "" &&
+		sectionValue(section, grantedKeyPrefix+"sso_start_url") != ""
+}
+
+// isGrantedCredentialProcess matches `granted credential-process ...`, also
+// through dgranted or a full path
+func isGrantedCredentialProcess(command string) bool {
+	fields := strings.Fields(command)
+	if len(fields) < 2 || fields[1] != "credential-process" {
+		return false
+	}
+	name := strings.TrimSuffix(filepath.Base(strings.Trim(fields[0], `"'`)), ".exe")
+	return name == "granted" || name == "dgranted"
+}
+
+// grantedProfileInfo returns what Granted left in profile section, or nil
+// for a profile Granted has nothing to do with
+func grantedProfileInfo(section *ini.Section) *GrantedInfo {
+	info := &GrantedInfo{
+		GeneratedFrom:     sectionValue(section, grantedGeneratedFromKey),
+		CredentialProcess: isGrantedCredentialProcess(sectionValue(section, "credential_process")),
+	}
+	if info.GeneratedFrom == "" && !info.CredentialProcess && !isGrantedSSO(section) {
+		return nil
+	}
+	return info
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+const (
+	hookPreLogin       = "preLogin"
+	hookPostLogin      = "postLogin"
+	defaultHookTimeout = 30 * time.Second
+	maxHookOutput      = 4096
+	// hookWaitDelay bounds how long a timed-out hook's children may keep
+	// its output open
+	hookWaitDelay = time.Second
+)
+
+// LoginHook is a user-defined shell command run around a profile's login
+type LoginHook struct {
+	Command        string `json:"command"`
+	TimeoutSeconds int    `json:"timeoutSeconds,omitempty"`
+}
+
+type HookResult struct {
+	Stage      string `json:"stage"`
+	Command    string `json:"command"`
+	ExitCode   int    `json:"exitCode"`
+	Output     string `json:"output,omitempty"`
+	Error      string `json:"error,omitempty"`
+	TimedOut   bool   `json:"timedOut,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+func (h LoginHook) timeout() time.Duration {
+	if h.TimeoutSeconds > 0 {
+		return time.Duration(h.TimeoutSeconds) * time.Second
+	}
+	return defaultHookTimeout
+}
+
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// hookEnv exposes the profile to every hook and, after login, the new
+// session so hooks can call AWS without another credential lookup
+func hookEnv(profile string, creds *CachedCredentials) []string {
+	env := append(os.Environ(), "AWS_PROFILE="+profile)
+	if creds != nil {
+		env = append(env,
+			"AWS_ACCESS_KEY_ID="+creds.AccessKeyID,
+			"AWS_SECRET_ACCESS_KEY="+creds.SecretAccessKey,
+			"AWS_SESSION_TOKEN="+creds.SessionToken,
+			"AWS_SESSION_EXPIRATION="+creds.Expiration.UTC().Format(time.RFC3339),
+		)
+	}
+	return env
+}
+
+func runHook(ctx context.Context, stage, profile string, hook LoginHook, creds *CachedCredentials) HookResult {
+	ctx, cancel := context.WithTimeout(ctx, hook.timeout())
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := shellCommand(ctx, hook.Command)
+	cmd.Env = hookEnv(profile, creds)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.WaitDelay = hookWaitDelay
+
+	start := time.Now()
+	err := cmd.Run()
+	result := HookResult{
+		Stage:      stage,
+		Command:    hook.Command,
+		Output:     truncateOutput(out.String()),
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result.TimedOut = true
+		result.ExitCode = -1
+		result.Error = fmt.Sprintf("timed out after %s", hook.timeout())
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+		result.Error = err.Error()
+	case err != nil:
+		result.ExitCode = -1
+		result.Error = err.Error()
+	}
+
+	entry := AuditEntry{
+		Action:  "hook." + stage,
+		Profile: profile,
+		Result:  "ok",
+		Details: result.Output,
+		Fields:  map[string]string{"command": hook.Command, "exitCode": fmt.Sprint(result.ExitCode)},
+	}
+	if result.Error != "" {
+		entry.Result = "error"
+	}
+	recordAudit(entry)
+
+	return result
+}
+
+// runLoginHooks runs the stage's hooks in order. Pre-login hooks stop at
+// the first failure, which aborts the login; post-login hooks all run and
+// never undo a login that already succeeded.
+func runLoginHooks(ctx context.Context, stage, profile string, creds *CachedCredentials) ([]HookResult, bool) {
+	ps := getProfileSettings(profile)
+	hooks := ps.PreLogin
+	if stage == hookPostLogin {
+		hooks = ps.PostLogin
+	}
+
+	results := []HookResult{}
+	for _, hook := range hooks {
+		result := runHook(ctx, stage, profile, hook, creds)
+		results = append(results, result)
+		if result.Error != "" && stage == hookPreLogin {
+			return results, false
+		}
+	}
+	return results, true
+}
+
+func truncateOutput(s string) string {
+	if len(s) <= maxHookOutput {
+		return s
+	}
+	return s[:maxHookOutput] + "\n[output truncated]"
+}
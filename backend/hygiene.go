@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	hygieneSubdir          = "hygiene"
+	hygieneReportFile      = "report.json"
+	hygieneKeysFile        = "keys.json"
+	hygieneTick            = time.Hour
+	defaultHygieneInterval = 24 * time.Hour
+	defaultMaxKeyAgeDays   = 90
+	// Exports older than this are left out of the report; whatever they
+	// wrote has long expired
+	hygieneExportWindow = 30 * 24 * time.Hour
+	hygieneAuditScan    = 5000
+
+	eventHygiene = "hygiene"
+
+	hygieneKeyAge          = "key-age"
+	hygieneNoMFA           = "no-mfa"
+	hygieneSessionDuration = "session-duration"
+	hygienePlaintextCache  = "plaintext-cache"
+	hygieneRiskyExport     = "risky-export"
+
+	severityWarning = "warning"
+	severityInfo    = "info"
+)
+
+// Directories every local user can write to and list
+var sharedTempDirs = []string{"/tmp", "/var/tmp", "/dev/shm"}
+
+type HygieneSettings struct {
+	Disabled      bool `json:"disabled,omitempty"`
+	IntervalHours int  `json:"intervalHours,omitempty"`
+	MaxKeyAgeDays int  `json:"maxKeyAgeDays,omitempty"`
+}
+
+// KeyAge is how old a profile's long-term access key is. CreatedAt comes
+// from IAM when the profile may list its own keys; otherwise the age counts
+// from when the backend first saw the key and is a lower bound.
+type KeyAge struct {
+	Profile   string     `json:"profile"`
+	KeyID     string     `json:"keyId"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	FirstSeen time.Time  `json:"firstSeen"`
+	AgeDays   int        `json:"ageDays"`
+	Source    string     `json:"source"` // "iam" or "first-seen"
+}
+
+type HygieneFinding struct {
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	Profile  string `json:"profile,omitempty"`
+	Location string `json:"location,omitempty"`
+	Message  string `json:"message"`
+}
+
+// HygieneReport summarises local credential hygiene. Counts has the number
+// of findings per check, so the event can carry it without the details.
+type HygieneReport struct {
+	GeneratedAt time.Time        `json:"generatedAt"`
+	Keys        []KeyAge         `json:"keys"`
+	Findings    []HygieneFinding `json:"findings"`
+	Counts      map[string]int   `json:"counts"`
+}
+
+// keyAgeRecord is stored per key fingerprint, so a rotated key starts over
+type keyAgeRecord struct {
+	FirstSeen time.Time  `json:"firstSeen"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+}
+
+var hygieneMu sync.Mutex
+
+func hygieneSettings() HygieneSettings {
+	if s := loadSettings().Hygiene; s != nil {
+		return *s
+	}
+	return HygieneSettings{}
+}
+
+func (s HygieneSettings) interval() time.Duration {
+	if s.IntervalHours > 0 {
+		return time.Duration(s.IntervalHours) * time.Hour
+	}
+	return defaultHygieneInterval
+}
+
+func (s HygieneSettings) maxKeyAgeDays() int {
+	if s.MaxKeyAgeDays > 0 {
+		return s.MaxKeyAgeDays
+	}
+	return defaultMaxKeyAgeDays
+}
+
+func getHygieneFile(name string) string {
+	return filepath.Join(getCacheDir(), hygieneSubdir, name)
+}
+
+func loadHygieneReport() *HygieneReport {
+	data, err := os.ReadFile(getHygieneFile(hygieneReportFile))
+	if err != nil {
+		return nil
+	}
+	var report HygieneReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil
+	}
+	return &report
+}
+
+func saveHygieneJSON(name string, v interface{}) error {
+	path := getHygieneFile(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+func loadKeyAgeRecords() map[string]*keyAgeRecord {
+	records := map[string]*keyAgeRecord{}
+	if data, err := os.ReadFile(getHygieneFile(hygieneKeysFile)); err == nil {
+		json.Unmarshal(data, &records)
+	}
+	return records
+}
+
+// lookupKeyCreated asks IAM when the access key was created, signing with
+// the profile's session when it has one since many accounts require MFA
+// for IAM calls
+func lookupKeyCreated(ctx context.Context, profile, accessKey string) (*time.Time, error) {
+	cfg, _, err := sessionAWSConfig(ctx, profile)
+	if err != nil {
+		if cfg, err = baseKeysConfig(ctx, profile); err != nil {
+			return nil, err
+		}
+	}
+
+	out, err := iam.NewFromConfig(cfg).ListAccessKeys(ctx, &iam.ListAccessKeysInput{})
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range out.AccessKeyMetadata {
+		if aws.ToString(key.AccessKeyId) == accessKey && key.CreateDate != nil {
+			created := key.CreateDate.UTC()
+			return &created, nil
+		}
+	}
+	return nil, fmt.Errorf("access key not listed for the calling user")
+}
+
+// checkKeyAges reports the age of every profile's long-term key and flags
+// those older than the limit
+func checkKeyAges(ctx context.Context, report *HygieneReport, profiles []ProfileInfo, maxDays int) {
+	records := loadKeyAgeRecords()
+	now := time.Now().UTC()
+
+	for _, p := range profiles {
+		accessKey, _, err := getProfileCredentials(p.Name)
+		if err != nil {
+			continue
+		}
+		id := keyFingerprint(accessKey)
+		rec, ok := records[id]
+		if !ok {
+			rec = &keyAgeRecord{FirstSeen: now}
+			records[id] = rec
+		}
+		if rec.CreatedAt == nil {
+			if created, err := lookupKeyCreated(ctx, p.Name, accessKey); err == nil {
+				rec.CreatedAt = created
+			}
+		}
+
+		age := KeyAge{Profile: p.Name, KeyID: id, FirstSeen: rec.FirstSeen, Source: "first-seen"}
+		since := rec.FirstSeen
+		if rec.CreatedAt != nil {
+			age.CreatedAt, age.Source, since = rec.CreatedAt, "iam", *rec.CreatedAt
+		}
+		age.AgeDays = int(now.Sub(since) / (24 * time.Hour))
+		report.Keys = append(report.Keys, age)
+
+		if age.AgeDays > maxDays {
+			msg := fmt.Sprintf("access key is %d days old, rotate it (limit %d days)", age.AgeDays, maxDays)
+			if age.Source == "first-seen" {
+				msg = fmt.Sprintf("access key has been in use for at least %d days, rotate it (limit %d days)", age.AgeDays, maxDays)
+			}
+			report.add(HygieneFinding{Check: hygieneKeyAge, Severity: severityWarning, Profile: p.Name, Message: msg})
+		}
+	}
+
+	if err := saveHygieneJSON(hygieneKeysFile, records); err != nil {
+		fmt.Fprintf(os.Stderr, "hygiene: %v\n", err)
+	}
+}
+
+// checkMFA flags profiles that can get credentials without a second factor:
+// long-term keys or a role to assume, but no mfa_serial to go with them
+func checkMFA(report *HygieneReport, profiles []ProfileInfo) {
+	for _, p := range profiles {
+		_, _, keyErr := getProfileCredentials(p.Name)
+		roleARN := profileRoleARN(p.Name)
+		if keyErr != nil && roleARN == "" {
+			continue
+		}
+		if _, err := roleMFASerial(p.Name); err == nil {
+			continue
+		}
+		msg := "profile has long-term keys but no mfa_serial"
+		if roleARN != "" {
+			msg = "profile assumes " + roleARN + " without an mfa_serial"
+		}
+		report.add(HygieneFinding{Check: hygieneNoMFA, Severity: severityWarning, Profile: p.Name, Message: msg})
+	}
+}
+
+// readCachedSession parses a cache file without the device binding check,
+// since the report is about what sits on disk
+func readCachedSession(profile string) (*CachedCredentials, error) {
+	data, err := os.ReadFile(getCacheFile(profile))
+	if err != nil {
+		return nil, err
+	}
+	var creds CachedCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, err
+	}
+	if creds.Profile == "" {
+		creds.Profile = profile
+	}
+	return &creds, nil
+}
+
+// checkSessionDurations flags sessions issued for longer than a maxLifetime
+// rule allows. The policy job clears them once they reach the limit; the
+// finding points at the login duration that should be lowered.
+func checkSessionDurations(report *HygieneReport, sessions []*CachedCredentials, rules []PolicyRule) {
+	for _, creds := range sessions {
+		lifetime := creds.Expiration.Sub(sessionIssuedAt(creds))
+		for _, rule := range rules {
+			if rule.Type != policyMaxLifetime || rule.MaxHours <= 0 || !rule.appliesTo(creds.Profile) {
+				continue
+			}
+			if lifetime > rule.maxLifetime() {
+				report.add(HygieneFinding{
+					Check:    hygieneSessionDuration,
+					Severity: severityWarning,
+					Profile:  creds.Profile,
+					Message: fmt.Sprintf("session was issued for %s but policy %q allows %gh",
+						lifetime.Round(time.Minute), rule.Name, rule.MaxHours),
+				})
+			}
+		}
+	}
+}
+
+// checkPlaintextCache looks at what is stored unencrypted: the session cache
+// and the credentials file
+func checkPlaintextCache(report *HygieneReport, sessions []*CachedCredentials, deviceBinding bool) {
+	now := time.Now()
+	for _, creds := range sessions {
+		path := getCacheFile(creds.Profile)
+		if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 {
+			report.add(HygieneFinding{
+				Check:    hygienePlaintextCache,
+				Severity: severityWarning,
+				Profile:  creds.Profile,
+				Location: path,
+				Message:  fmt.Sprintf("cached session is readable by other users (mode %04o)", info.Mode().Perm()),
+			})
+		}
+		if now.After(creds.Expiration) {
+			report.add(HygieneFinding{
+				Check:    hygienePlaintextCache,
+				Severity: severityInfo,
+				Profile:  creds.Profile,
+				Location: path,
+				Message:  "expired session is still on disk, clear it",
+			})
+		}
+	}
+
+	if len(sessions) > 0 && !deviceBinding {
+		report.add(HygieneFinding{
+			Check:    hygienePlaintextCache,
+			Severity: severityInfo,
+			Location: getCacheDir(),
+			Message:  fmt.Sprintf("%d session(s) are cached in plaintext without device binding, so a copied cache file works on any machine", len(sessions)),
+		})
+	}
+
+	credsPath := getAWSCredentialsPath()
+	if info, err := os.Stat(credsPath); err == nil && info.Mode().Perm()&0077 != 0 {
+		report.add(HygieneFinding{
+			Check:    hygienePlaintextCache,
+			Severity: severityWarning,
+			Location: credsPath,
+			Message:  fmt.Sprintf("credentials file is readable by other users (mode %04o)", info.Mode().Perm()),
+		})
+	}
+}
+
+// riskyExportReason says why an exported env file that is still on disk is
+// exposed, or returns "" when it isn't
+func riskyExportReason(location string) string {
+	if !filepath.IsAbs(location) {
+		return ""
+	}
+	info, err := os.Stat(location)
+	if err != nil {
+		return ""
+	}
+
+	var reasons []string
+	for _, dir := range sharedTempDirs {
+		if location == dir || strings.HasPrefix(location, dir+"/") {
+			reasons = append(reasons, "is in the shared directory "+dir)
+			break
+		}
+	}
+	for dir := filepath.Dir(location); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			reasons = append(reasons, "is inside the git working tree "+dir)
+			break
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		reasons = append(reasons, fmt.Sprintf("is readable by other users (mode %04o)", info.Mode().Perm()))
+	}
+	return strings.Join(reasons, " and ")
+}
+
+// checkExports flags recent file exports left somewhere exposed, using the
+// locations recorded in the audit log
+func checkExports(report *HygieneReport) {
+	entries, err := readAudit("", hygieneAuditScan)
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Now().Add(-hygieneExportWindow)
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		if entry.Action != "export" || entry.Result != "ok" || entry.Time.Before(cutoff) {
+			continue
+		}
+		location := entry.Fields["location"]
+		if entry.Fields["sink"] != "file" || location == "" || seen[location] {
+			continue
+		}
+		seen[location] = true
+		if reason := riskyExportReason(location); reason != "" {
+			report.add(HygieneFinding{
+				Check:    hygieneRiskyExport,
+				Severity: severityWarning,
+				Profile:  entry.Profile,
+				Location: location,
+				Message:  "exported env file " + reason,
+			})
+		}
+	}
+}
+
+func (r *HygieneReport) add(f HygieneFinding) {
+	r.Findings = append(r.Findings, f)
+	r.Counts[f.Check]++
+}
+
+// generateHygieneReport runs every check, stores the report and announces
+// it on the event bus
+func generateHygieneReport(ctx context.Context) (*HygieneReport, error) {
+	hygieneMu.Lock()
+	defer hygieneMu.Unlock()
+
+	settings := loadSettings()
+	report := &HygieneReport{
+		GeneratedAt: time.Now().UTC(),
+		Keys:        []KeyAge{},
+		Findings:    []HygieneFinding{},
+		Counts:      map[string]int{},
+	}
+
+	profiles, err := getProfiles()
+	if err != nil {
+		return nil, err
+	}
+	var sessions []*CachedCredentials
+	for _, profile := range cachedProfiles() {
+		if creds, err := readCachedSession(profile); err == nil {
+			sessions = append(sessions, creds)
+		}
+	}
+
+	checkKeyAges(ctx, report, profiles, hygieneSettings().maxKeyAgeDays())
+	checkMFA(report, profiles)
+	checkSessionDurations(report, sessions, settings.Policies)
+	checkPlaintextCache(report, sessions, settings.DeviceBinding)
+	checkExports(report)
+
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		return report.Findings[i].Severity == severityWarning && report.Findings[j].Severity != severityWarning
+	})
+
+	if err := saveHygieneJSON(hygieneReportFile, report); err != nil {
+		return nil, err
+	}
+	events.publish(Event{
+		Type: eventHygiene,
+		Data: map[string]interface{}{"findings": len(report.Findings), "counts": report.Counts},
+	})
+	return report, nil
+}
+
+// runHygieneReport is the scheduler job. It ticks hourly and regenerates
+// once the stored report is older than the configured interval, so changing
+// the interval doesn't need a restart.
+func runHygieneReport(ctx context.Context) {
+	settings := hygieneSettings()
+	if settings.Disabled {
+		return
+	}
+	if last := loadHygieneReport(); last != nil && time.Since(last.GeneratedAt) < settings.interval() {
+		return
+	}
+	if _, err := generateHygieneReport(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "hygiene report: %v\n", err)
+	}
+}
+
+// handleGetHygieneReport returns the last report, generating one if none
+// has been made yet
+func handleGetHygieneReport(c echo.Context) error {
+	report := loadHygieneReport()
+	if report == nil {
+		var err error
+		if report, err = generateHygieneReport(c.Request().Context()); err != nil {
+			return c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Failed to generate hygiene report",
+				Details: err.Error(),
+			})
+		}
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+func handleRefreshHygieneReport(c echo.Context) error {
+	report, err := generateHygieneReport(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to generate hygiene report",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	identitySubdir     = "identity"
+	identityTTL        = 24 * time.Hour
+	identityBaseRetry  = 30 * time.Second
+	identityMaxRetry   = time.Hour
+	userMaxSessionSecs = 129600 // GetSessionToken limit for IAM users
+	rootMaxSessionSecs = 3600   // and for the root user
+)
+
+// IdentityInfo is the slow-changing identity data the dashboard shows for a
+// profile. Failed lookups back off exponentially while any previously
+// fetched data keeps being served.
+type IdentityInfo struct {
+	Profile            string     `json:"profile"`
+	Account            string     `json:"account,omitempty"`
+	Arn                string     `json:"arn,omitempty"`
+	UserID             string     `json:"userId,omitempty"`
+	AccountAlias       string     `json:"accountAlias,omitempty"`
+	MaxSessionDuration int32      `json:"maxSessionDuration,omitempty"`
+	RoleMaxDuration    int32      `json:"roleMaxDuration,omitempty"`
+	FetchedAt          time.Time  `json:"fetchedAt"`
+	ExpiresAt          time.Time  `json:"expiresAt"`
+	Failures           int        `json:"failures,omitempty"`
+	RetryAfter         *time.Time `json:"retryAfter,omitempty"`
+	LastError          string     `json:"lastError,omitempty"`
+	Cached             bool       `json:"cached"`
+}
+
+var identityMu sync.Mutex
+
+func getIdentityCacheFile(profile string) string {
+	return filepath.Join(getCacheDir(), identitySubdir, filepath.Base(profile)+".json")
+}
+
+func loadIdentity(profile string) *IdentityInfo {
+	path := getIdentityCacheFile(profile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var info IdentityInfo
+	if err := decodeCacheFile(path, data, &info); err != nil {
+		return nil
+	}
+	return &info
+}
+
+func saveIdentity(info *IdentityInfo) error {
+	path := getIdentityCacheFile(info.Profile)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// identityBackoff doubles the retry delay per consecutive failure
+func identityBackoff(failures int) time.Duration {
+	delay := identityBaseRetry
+	for i := 1; i < failures && delay < identityMaxRetry; i++ {
+		delay *= 2
+	}
+	if delay > identityMaxRetry {
+		delay = identityMaxRetry
+	}
+	return delay
+}
+
+func fetchIdentity(ctx context.Context, profile string) (*IdentityInfo, error) {
+	cfg, err := viewerAWSConfig(ctx, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	caller, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	stsThrottles.observe(profile, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %w", err)
+	}
+
+	now := time.Now().UTC()
+	info := &IdentityInfo{
+		Profile:            profile,
+		Account:            aws.ToString(caller.Account),
+		Arn:                aws.ToString(caller.Arn),
+		UserID:             aws.ToString(caller.UserId),
+		MaxSessionDuration: userMaxSessionSecs,
+		FetchedAt:          now,
+		ExpiresAt:          now.Add(identityTTL),
+	}
+	if strings.HasSuffix(info.Arn, ":root") {
+		info.MaxSessionDuration = rootMaxSessionSecs
+	}
+
+	// Aliases and role limits are nice-to-have; many users can't read them
+	iamClient := iam.NewFromConfig(cfg)
+	if aliases, err := iamClient.ListAccountAliases(ctx, &iam.ListAccountAliasesInput{}); err == nil && len(aliases.AccountAliases) > 0 {
+		info.AccountAlias = aliases.AccountAliases[0]
+	}
+	if section, err := getProfileSection(profile); err == nil {
+		if roleARN := section.Key("role_arn").String(); roleARN != "" {
+			roleName := roleARN[strings.LastIndex(roleARN, "/")+1:]
+			if role, err := iamClient.GetRole(ctx, &iam.GetRoleInput{RoleName: aws.String(roleName)}); err == nil {
+				info.RoleMaxDuration = aws.ToInt32(role.Role.MaxSessionDuration)
+			}
+		}
+	}
+
+	return info, nil
+}
+
+// getIdentity serves the cached identity while fresh, refetching after the
+// TTL or when forced. Failures are recorded with a backoff; during backoff
+// no refetch is attempted unless forced.
+func getIdentity(ctx context.Context, profile string, force bool) (*IdentityInfo, error) {
+	identityMu.Lock()
+	defer identityMu.Unlock()
+
+	now := time.Now()
+	cached := loadIdentity(profile)
+	if !force && cached != nil {
+		fresh := cached.LastError == "" && now.Before(cached.ExpiresAt)
+		backingOff := cached.RetryAfter != nil && now.Before(*cached.RetryAfter)
+		if backingOff && cached.FetchedAt.IsZero() {
+			return nil, fmt.Errorf("%s (retrying after %s)", cached.LastError, cached.RetryAfter.Format(time.RFC3339))
+		}
+		// Stale data beats adding to an STS throttling problem
+		throttled := !cached.FetchedAt.IsZero() && stsThrottles.status(profile).Throttled
+		if fresh || backingOff || throttled {
+			cached.Cached = true
+			return cached, nil
+		}
+	}
+
+	info, err := fetchIdentity(ctx, profile)
+	if err != nil {
+		failed := &IdentityInfo{Profile: profile}
+		if cached != nil {
+			failed = cached
+		}
+		failed.Failures++
+		failed.LastError = err.Error()
+		retryAfter := now.Add(identityBackoff(failed.Failures)).UTC()
+		failed.RetryAfter = &retryAfter
+		saveIdentity(failed)
+
+		if failed.FetchedAt.IsZero() {
+			return nil, err
+		}
+		failed.Cached = true
+		return failed, nil
+	}
+
+	if err := saveIdentity(info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// invalidateIdentity drops cached identity data; with an empty profile the
+// whole cache is cleared
+func invalidateIdentity(profile string) error {
+	identityMu.Lock()
+	defer identityMu.Unlock()
+
+	if profile == "" {
+		return os.RemoveAll(filepath.Join(getCacheDir(), identitySubdir))
+	}
+	if err := os.Remove(getIdentityCacheFile(profile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func handleGetIdentity(c echo.Context) error {
+	profile := requestProfile(c, c.QueryParam("profile"))
+
+	info, err := getIdentity(c.Request().Context(), profile, false)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to look up identity",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, info)
+}
+
+// handleRefreshIdentity invalidates the cache and refetches. Without
+// ?profile= it only invalidates, letting the next reads repopulate lazily.
+func handleRefreshIdentity(c echo.Context) error {
+	profile := c.QueryParam("profile")
+	if profile == "" {
+		if err := invalidateIdentity(""); err != nil {
+			return c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Failed to invalidate identity cache",
+				Details: err.Error(),
+			})
+		}
+		return c.JSON(http.StatusOK, map[string]string{"message": "Identity cache cleared"})
+	}
+
+	info, err := getIdentity(c.Request().Context(), profile, true)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to look up identity",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, info)
+}
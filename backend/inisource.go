@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KeyOrigin records where a profile value was read from, so the UI can point
+// users at the exact file and line to edit
+type KeyOrigin struct {
+	File    string           `json:"file"`
+	Section string           `json:"section"`
+	Line    int              `json:"line"`
+	Kind    string           `json:"kind"` // "config" or "credentials"
+	Source  CredentialSource `json:"source"`
+}
+
+// iniLineIndex maps section name -> key name -> 1-based line number. The
+// section header itself is stored under the empty key.
+type iniLineIndex map[string]map[string]int
+
+// indexIniLines scans an ini file and records the line of every section
+// header and key. go-ini does not expose positions, so this is a light
+// parallel parse that only needs to agree with it on names.
+func indexIniLines(path string) (iniLineIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	index := iniLineIndex{}
+	section := "DEFAULT"
+	index[section] = map[string]int{}
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				continue
+			}
+			section = strings.TrimSpace(line[1:end])
+			if _, ok := index[section]; !ok {
+				index[section] = map[string]int{"": lineNo}
+			}
+			continue
+		}
+
+		if eq := strings.IndexAny(line, "=:"); eq > 0 {
+			key := strings.TrimSpace(line[:eq])
+			if _, ok := index[section][key]; !ok {
+				index[section][key] = lineNo
+			}
+		}
+	}
+
+	return index, scanner.Err()
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// sourceForPath resolves which credential source a file belongs to. With
+// SourceAuto this is the detected location that supplied the path.
+func sourceForPath(path string) CredentialSource {
+	settings := loadSettings()
+	if settings.CredentialSource != SourceAuto {
+		return settings.CredentialSource
+	}
+	for _, p := range discoverAWSPaths() {
+		if p.ConfigPath == path || p.CredsPath == path {
+			return p.Source
+		}
+	}
+	return SourceLinux
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/ini.v1"
+)
+
+const (
+	backupsDir      = ".docker/aws-mfa-cache/backups"
+	backupIndexFile = "index.json"
+	lockTimeout     = 5 * time.Second
+)
+
+// BackupInfo describes a snapshot of an AWS config/credentials file taken
+// before the backend modified it
+type BackupInfo struct {
+	ID           string    `json:"id"`
+	OriginalPath string    `json:"originalPath"`
+	BackupPath   string    `json:"backupPath"`
+	CreatedAt    time.Time `json:"createdAt"`
+	Size         int64     `json:"size"`
+}
+
+var errBackupNotFound = errors.New("backup not found")
+
+var (
+	backupMu sync.Mutex
+	// backedUp tracks files already snapshotted during this process run, so
+	// only the first write of a session triggers a backup
+	backedUp = map[string]bool{}
+)
+
+func init() {
+	// Write "key = value" like the AWS CLI instead of go-ini's column
+	// alignment, so edits don't reformat untouched sections
+	ini.PrettyFormat = false
+	ini.PrettyEqual = true
+}
+
+func getBackupsDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, backupsDir)
+}
+
+// lockIniFile takes an advisory lock on path.lock, shared with any other
+// process that follows the same convention (e.g. the host CLI)
+func lockIniFile(path string) (func(), error) {
+	lock := flock.New(path + ".lock")
+
+	ctx, cancel := context.WithTimeout(context.Background(), lockTimeout)
+	defer cancel()
+
+	locked, err := lock.TryLockContext(ctx, 100*time.Millisecond)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	if !locked {
+		return nil, fmt.Errorf("timed out waiting for lock on %s", path)
+	}
+
+	return func() { lock.Unlock() }, nil
+}
+
+func loadBackupIndex() []BackupInfo {
+	var backups []BackupInfo
+	path := filepath.Join(getBackupsDir(), backupIndexFile)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		decodeCacheFile(path, data, &backups)
+	}
+	return backups
+}
+
+func saveBackupIndex(backups []BackupInfo) error {
+	data, err := json.MarshalIndent(backups, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(getBackupsDir(), backupIndexFile), data, 0600)
+}
+
+// backupFile copies path into the backups directory. Missing files are not
+// an error; there is simply nothing to back up.
+func backupFile(path string) (*BackupInfo, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(getBackupsDir(), 0700); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	id := fmt.Sprintf("%s-%s", now.Format("20060102T150405.000Z"), filepath.Base(path))
+	id = strings.ReplaceAll(id, ".", "_")
+	info := BackupInfo{
+		ID:           id,
+		OriginalPath: absPath(path),
+		BackupPath:   filepath.Join(getBackupsDir(), id),
+		CreatedAt:    now,
+		Size:         int64(len(data)),
+	}
+
+	if err := os.WriteFile(info.BackupPath, data, 0600); err != nil {
+		return nil, err
+	}
+
+	backupMu.Lock()
+	defer backupMu.Unlock()
+	if err := saveBackupIndex(append(loadBackupIndex(), info)); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// backupOnce snapshots path the first time it is written in this session
+func backupOnce(path string) error {
+	backupMu.Lock()
+	done := backedUp[path]
+	backupMu.Unlock()
+	if done {
+		return nil
+	}
+
+	if _, err := backupFile(path); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+
+	backupMu.Lock()
+	backedUp[path] = true
+	backupMu.Unlock()
+	return nil
+}
+
+// writeFileAtomic replaces path via a temp file and rename so readers never
+// observe a partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	// Flush before the rename so a crash can't leave an empty file behind
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// modifyIniText is the single entry point for editing AWS ini files: it
+// locks the file, backs it up on first write, re-reads it under the lock,
+// applies fn to its contents and writes the result atomically. A missing
+// file reads as empty.
+func modifyIniText(path string, fn func(data []byte) ([]byte, error)) error {
+	unlock, err := lockIniFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := backupOnce(path); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	data, err = fn(data)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// modifyIniFile edits an AWS ini file through go-ini, which keeps comments
+// but normalizes layout
+func modifyIniFile(path string, fn func(cfg *ini.File) error) error {
+	return modifyIniText(path, func(data []byte) ([]byte, error) {
+		cfg, err := ini.Load(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+
+		if err := fn(cfg); err != nil {
+			return nil, err
+		}
+
+		var buf strings.Builder
+		if _, err := cfg.WriteTo(&buf); err != nil {
+			return nil, err
+		}
+		return []byte(buf.String()), nil
+	})
+}
+
+// restoreBackup writes a backup over its original file, snapshotting the
+// current contents first so a restore can itself be undone
+func restoreBackup(id string) (*BackupInfo, error) {
+	var backup *BackupInfo
+	for _, b := range loadBackupIndex() {
+		if b.ID == id {
+			b := b
+			backup = &b
+			break
+		}
+	}
+	if backup == nil {
+		return nil, fmt.Errorf("%w: %s", errBackupNotFound, id)
+	}
+
+	data, err := os.ReadFile(backup.BackupPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	unlock, err := lockIniFile(backup.OriginalPath)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if _, err := backupFile(backup.OriginalPath); err != nil {
+		return nil, fmt.Errorf("failed to back up current file: %w", err)
+	}
+
+	if err := writeFileAtomic(backup.OriginalPath, data, 0600); err != nil {
+		return nil, err
+	}
+	return backup, nil
+}
+
+func handleListBackups(c echo.Context) error {
+	backups := loadBackupIndex()
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+	if backups == nil {
+		backups = []BackupInfo{}
+	}
+	return c.JSON(http.StatusOK, backups)
+}
+
+func handleRestoreBackup(c echo.Context) error {
+	backup, err := restoreBackup(c.Param("id"))
+	if errors.Is(err, errBackupNotFound) {
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "Backup not found",
+			Details: err.Error(),
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to restore backup",
+			Details: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Restored " + backup.OriginalPath,
+		"id":      backup.ID,
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultInjectPath = "/tmp/aws-credentials.env"
+	// The app user inside the container is unknown, so the file has to be
+	// readable by it; the container filesystem is the isolation boundary
+	injectedFileMode = 0644
+)
+
+type InjectRequest struct {
+	Profile   string `json:"profile"`
+	Container string `json:"container"`
+	Path      string `json:"path,omitempty"`
+	// Profiles injects several prefixed sessions at once, using the same
+	// "prod:PROD,staging" syntax as ?profiles= on /env
+	Profiles string `json:"profiles,omitempty"`
+	// RenewAt overrides the renewal policy, see InventoryEntry
+	RenewAt *float64 `json:"renewAt,omitempty"`
+}
+
+type InjectResponse struct {
+	ContainerID   string          `json:"containerId"`
+	ContainerName string          `json:"containerName"`
+	Path          string          `json:"path"`
+	Generation    string          `json:"generation,omitempty"`
+	ExpiresAt     string          `json:"expiresAt,omitempty"`
+	Profiles      []ProfilePrefix `json:"profiles,omitempty"`
+}
+
+// handleInjectCredentials copies the profile's env file into a running
+// container through the container export sink
+func handleInjectCredentials(c echo.Context) error {
+	var req InjectRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	if req.Container == "" {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Container is required",
+		})
+	}
+	req.Profile = requestProfile(c, req.Profile)
+	if resp := requireConfirmation(c, confirmExport, exportProfiles(req.Profile, req.Profiles)...); resp != nil {
+		return c.JSON(http.StatusPreconditionRequired, resp)
+	}
+
+	payload, status, errResp := buildExportPayload(req.Profile, req.Profiles)
+	if errResp != nil {
+		return c.JSON(status, errResp)
+	}
+
+	target := ExportTarget{Container: req.Container, Path: req.Path, RenewAt: req.RenewAt}
+	receipt, err := deliverExport(c.Request().Context(), "container", target, payload)
+	if err != nil {
+		return c.JSON(exportErrorStatus(err), ErrorResponse{
+			Error:   "Failed to inject credentials",
+			Details: err.Error(),
+		})
+	}
+
+	resp := InjectResponse{
+		ContainerID:   receipt.Details["containerId"],
+		ContainerName: receipt.Details["containerName"],
+		Path:          receipt.Details["path"],
+	}
+	if req.Profiles != "" {
+		resp.Profiles, _ = parseProfilePrefixes(req.Profiles)
+	} else {
+		resp.Generation = sessionGeneration(payload.Sessions[0])
+		resp.ExpiresAt = payload.Sessions[0].Expiration.UTC().Format(time.RFC3339)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
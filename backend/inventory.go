@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	inventorySubdir = "inventory"
+	inventoryFile   = "containers.json"
+
+	mechanismInject = "inject"
+
+	revokedEnvContent = "# AWS credentials revoked by the AWS MFA extension\n"
+)
+
+// InventoryEntry records one place credentials were handed to a container
+type InventoryEntry struct {
+	ContainerID   string    `json:"containerId"`
+	ContainerName string    `json:"containerName"`
+	Profile       string    `json:"profile"`
+	Generation    string    `json:"generation"`
+	Mechanism     string    `json:"mechanism"`
+	Path          string    `json:"path,omitempty"`
+	Prefix        string    `json:"prefix,omitempty"`
+	IssuedAt      time.Time `json:"issuedAt,omitempty"`
+	DeliveredAt   time.Time `json:"deliveredAt"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+	Expired       bool      `json:"expired"`
+	Current       bool      `json:"current"`
+	Revoked       bool      `json:"revoked,omitempty"`
+	// RenewAt is the fraction of the session lifetime after which a newer
+	// session is pushed to the container; 0 disables proactive renewal
+	RenewAt    *float64   `json:"renewAt,omitempty"`
+	RenewDueAt *time.Time `json:"renewDueAt,omitempty"`
+}
+
+type RevokeRequest struct {
+	ContainerIDs []string `json:"containerIds,omitempty"`
+	Profile      string   `json:"profile,omitempty"`
+}
+
+type RevokeResult struct {
+	ContainerID   string `json:"containerId"`
+	ContainerName string `json:"containerName"`
+	Path          string `json:"path,omitempty"`
+	Revoked       bool   `json:"revoked"`
+	Error         string `json:"error,omitempty"`
+}
+
+var inventoryMu sync.Mutex
+
+// The inventory lives in its own directory so clearing cached sessions
+// doesn't forget which containers still hold copies of them
+func getInventoryPath() string {
+	return filepath.Join(getCacheDir(), inventorySubdir, inventoryFile)
+}
+
+// sessionGeneration identifies a session without revealing its key
+func sessionGeneration(creds *CachedCredentials) string {
+	sum := sha256.Sum256([]byte(creds.AccessKeyID))
+	return hex.EncodeToString(sum[:4])
+}
+
+func loadInventory() []InventoryEntry {
+	data, err := os.ReadFile(getInventoryPath())
+	if err != nil {
+		return []InventoryEntry{}
+	}
+	var entries []InventoryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return []InventoryEntry{}
+	}
+	return entries
+}
+
+func saveInventory(entries []InventoryEntry) error {
+	path := getInventoryPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// recordDelivery adds or replaces the inventory entry for a container,
+// profile and path; a container receiving a newer session replaces the old
+// record. A nil renewAt keeps the entry's existing renewal policy.
+func recordDelivery(ctr *dockerContainer, creds *CachedCredentials, mechanism, path, prefix string, renewAt *float64) error {
+	inventoryMu.Lock()
+	defer inventoryMu.Unlock()
+
+	entry := InventoryEntry{
+		ContainerID:   ctr.ID,
+		ContainerName: ctr.Name(),
+		Profile:       creds.Profile,
+		Generation:    sessionGeneration(creds),
+		Mechanism:     mechanism,
+		Path:          path,
+		Prefix:        prefix,
+		IssuedAt:      creds.IssuedAt,
+		DeliveredAt:   time.Now().UTC(),
+		ExpiresAt:     creds.Expiration,
+		RenewAt:       renewAt,
+	}
+
+	entries := loadInventory()
+	for i, e := range entries {
+		if e.ContainerID == ctr.ID && e.Profile == creds.Profile && e.Mechanism == mechanism && e.Path == path {
+			if entry.RenewAt == nil {
+				entry.RenewAt = e.RenewAt
+			}
+			entries[i] = entry
+			return saveInventory(entries)
+		}
+	}
+	return saveInventory(append(entries, entry))
+}
+
+func handleGetInventory(c echo.Context) error {
+	inventoryMu.Lock()
+	entries := loadInventory()
+	inventoryMu.Unlock()
+
+	now := time.Now()
+	generations := map[string]string{}
+	for i := range entries {
+		e := &entries[i]
+		e.Expired = now.After(e.ExpiresAt)
+
+		gen, ok := generations[e.Profile]
+		if !ok {
+			if creds, err := loadCachedCredentials(e.Profile); err == nil {
+				gen = sessionGeneration(creds)
+			}
+			generations[e.Profile] = gen
+		}
+		e.Current = gen != "" && gen == e.Generation
+		if due, ok := renewalDue(e); ok {
+			e.RenewDueAt = &due
+		}
+	}
+
+	return c.JSON(http.StatusOK, entries)
+}
+
+// handleRevokeInventory overwrites delivered env files with a stub. With no
+// filters it revokes everything; containers that no longer exist are dropped
+// from the inventory.
+func handleRevokeInventory(c echo.Context) error {
+	var req RevokeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	selected := map[string]bool{}
+	for _, id := range req.ContainerIDs {
+		selected[id] = true
+	}
+
+	inventoryMu.Lock()
+	defer inventoryMu.Unlock()
+
+	ctx := c.Request().Context()
+	docker := newDockerClient()
+	results := []RevokeResult{}
+	kept := []InventoryEntry{}
+
+	for _, e := range loadInventory() {
+		match := (len(selected) == 0 || selected[e.ContainerID] || selected[e.ContainerName]) &&
+			(req.Profile == "" || req.Profile == e.Profile)
+		if !match || e.Revoked {
+			kept = append(kept, e)
+			continue
+		}
+
+		result := RevokeResult{ContainerID: e.ContainerID, ContainerName: e.ContainerName, Path: e.Path}
+		_, err := docker.inspectContainer(ctx, e.ContainerID)
+		if errors.Is(err, errDockerNotFound) {
+			// Gone containers take their credentials with them
+			result.Revoked = true
+			results = append(results, result)
+			continue
+		}
+
+		if err != nil {
+			result.Error = err.Error()
+		} else if e.Path == "" {
+			result.Error = "credentials were not delivered as a file and cannot be scrubbed; restart the container"
+		} else if err := docker.copyFileToContainer(ctx, e.ContainerID, e.Path, []byte(revokedEnvContent), injectedFileMode); err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Revoked = true
+			e.Revoked = true
+		}
+		results = append(results, result)
+		kept = append(kept, e)
+	}
+
+	if err := saveInventory(kept); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to update inventory",
+			Details: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, results)
+}
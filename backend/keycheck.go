@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	keyCheckSubdir          = "keycheck"
+	keyCheckTick            = 5 * time.Minute
+	defaultKeyCheckInterval = 12 * time.Hour
+	// Network failures say nothing about the keys, so they are retried
+	// sooner than a full interval
+	keyCheckRetry = 30 * time.Minute
+	// At most this many profiles are checked per tick, so a long profile
+	// list is spread out instead of hitting STS all at once
+	keyCheckBatch = 2
+
+	KeyStatusValid   = "valid"
+	KeyStatusInvalid = "invalid"
+	KeyStatusUnknown = "unknown"
+)
+
+// These mean AWS no longer accepts the keys: deleted or deactivated
+// (InvalidClientTokenId) or a secret that no longer matches
+var invalidKeyCodes = map[string]bool{
+	"InvalidClientTokenId":        true,
+	"SignatureDoesNotMatch":       true,
+	"UnrecognizedClientException": true,
+}
+
+type KeyValidationSettings struct {
+	Disabled        bool `json:"disabled,omitempty"`
+	IntervalMinutes int  `json:"intervalMinutes,omitempty"`
+}
+
+// KeyValidation is the last background check of a profile's long-term
+// keys. KeyID fingerprints the access key that was checked, so a rotated
+// key is never reported with the old key's result.
+type KeyValidation struct {
+	Profile   string    `json:"profile"`
+	Status    string    `json:"status"`
+	Code      string    `json:"code,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	KeyID     string    `json:"keyId"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+var keyCheckMu sync.Mutex
+
+func keyCheckInterval() time.Duration {
+	if s := loadSettings().KeyValidation; s != nil && s.IntervalMinutes > 0 {
+		return time.Duration(s.IntervalMinutes) * time.Minute
+	}
+	return defaultKeyCheckInterval
+}
+
+func keyFingerprint(accessKey string) string {
+	sum := sha256.Sum256([]byte(accessKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+func getKeyCheckFile(profile string) string {
+	return filepath.Join(getCacheDir(), keyCheckSubdir, filepath.Base(profile)+".json")
+}
+
+func loadKeyValidation(profile string) *KeyValidation {
+	data, err := os.ReadFile(getKeyCheckFile(profile))
+	if err != nil {
+		return nil
+	}
+	var v KeyValidation
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil
+	}
+	return &v
+}
+
+func saveKeyValidation(v *KeyValidation) error {
+	path := getKeyCheckFile(v.Profile)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// currentKeyValidation returns the stored result only if it was made
+// against accessKey
+func currentKeyValidation(profile, accessKey string) *KeyValidation {
+	v := loadKeyValidation(profile)
+	if v == nil || accessKey == "" || v.KeyID != keyFingerprint(accessKey) {
+		return nil
+	}
+	return v
+}
+
+// baseKeysConfig builds an SDK config that signs with the profile's
+// long-term keys (or its credential_process) rather than an MFA session
+func baseKeysConfig(ctx context.Context, profile string) (aws.Config, error) {
+	base, err := getBaseCredentials(ctx, profile)
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	opts := append([]func(*config.LoadOptions) error{
+		config.WithRegion(getProfileRegion(profile)),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(base.AccessKeyID, base.SecretAccessKey, base.SessionToken)),
+	}, awsLoadOptions(profile)...)
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return cfg, nil
+}
+
+// validateBaseKeys calls GetCallerIdentity with the long-term keys. It
+// needs no MFA and no IAM permissions, so only a key problem makes it fail
+// with an auth error.
+func validateBaseKeys(ctx context.Context, profile string) (*KeyValidation, error) {
+	accessKey, _, err := getProfileCredentials(profile)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := baseKeysConfig(ctx, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &KeyValidation{
+		Profile:   profile,
+		Status:    KeyStatusValid,
+		KeyID:     keyFingerprint(accessKey),
+		CheckedAt: time.Now().UTC(),
+	}
+	_, err = sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	stsThrottles.observe(profile, err)
+	if err != nil {
+		v.Status = KeyStatusUnknown
+		v.Error = err.Error()
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			v.Code = apiErr.ErrorCode()
+			if invalidKeyCodes[v.Code] {
+				v.Status = KeyStatusInvalid
+			}
+		}
+	}
+
+	keyCheckMu.Lock()
+	defer keyCheckMu.Unlock()
+	if err := saveKeyValidation(v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// keyCheckDue reports when a profile should next be checked; a key that
+// changed since the last check is due immediately
+func keyCheckDue(profile string, interval time.Duration) (time.Time, bool) {
+	accessKey, _, err := getProfileCredentials(profile)
+	if err != nil {
+		return time.Time{}, false
+	}
+	v := currentKeyValidation(profile, accessKey)
+	if v == nil {
+		return time.Time{}, true
+	}
+	if v.Status == KeyStatusUnknown && keyCheckRetry < interval {
+		interval = keyCheckRetry
+	}
+	return v.CheckedAt.Add(interval), true
+}
+
+// runKeyValidation is the scheduler job. It checks the most overdue
+// profiles first, a few per tick, and leaves profiles STS is already
+// throttling alone.
+func runKeyValidation(ctx context.Context) {
+	settings := loadSettings()
+	if settings.KeyValidation != nil && settings.KeyValidation.Disabled {
+		return
+	}
+	profiles, err := getProfiles()
+	if err != nil {
+		return
+	}
+
+	type dueProfile struct {
+		name string
+		at   time.Time
+	}
+	interval := keyCheckInterval()
+	now := time.Now()
+	var due []dueProfile
+	for _, p := range profiles {
+		if stsThrottles.status(p.Name).Throttled {
+			continue
+		}
+		if at, ok := keyCheckDue(p.Name, interval); ok && !now.Before(at) {
+			due = append(due, dueProfile{p.Name, at})
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].at.Before(due[j].at) })
+
+	for _, d := range due[:min(keyCheckBatch, len(due))] {
+		v, err := validateBaseKeys(ctx, d.name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "validate keys for %s: %v\n", d.name, err)
+			continue
+		}
+		if v.Status == KeyStatusInvalid {
+			recordAudit(AuditEntry{
+				Action:  "keys.invalid",
+				Profile: d.name,
+				Result:  "error",
+				Details: v.Error,
+				Fields:  map[string]string{"code": v.Code},
+			})
+		}
+	}
+}
+
+// handleValidateKeys checks a profile's keys now, e.g. right after they
+// were rotated
+func handleValidateKeys(c echo.Context) error {
+	profile := c.Param("name")
+	v, err := validateBaseKeys(c.Request().Context(), profile)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Failed to validate keys",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, v)
+}
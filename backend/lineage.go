@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	lineageBase   = "base"
+	lineageMFA    = "mfa"
+	lineageViewer = "viewer"
+	lineageRole   = "role"
+)
+
+// LineageNode is one set of credentials in a profile's chain. IDs are
+// session generations (as in the container inventory), so a derived
+// session can be traced back without exposing any key material.
+type LineageNode struct {
+	ID        string     `json:"id"`
+	Parent    string     `json:"parent,omitempty"`
+	Kind      string     `json:"kind"`
+	ARN       string     `json:"arn,omitempty"`
+	MFASerial string     `json:"mfaSerial,omitempty"`
+	IssuedAt  *time.Time `json:"issuedAt,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Valid     bool       `json:"valid"`
+	// STSEndpoint is where an MFA session was minted
+	STSEndpoint string `json:"stsEndpoint,omitempty"`
+	STSGlobal   bool   `json:"stsGlobal,omitempty"`
+}
+
+type SessionLineage struct {
+	Profile string        `json:"profile"`
+	Nodes   []LineageNode `json:"nodes"`
+}
+
+func lineageNode(kind, parent string, creds *CachedCredentials) LineageNode {
+	node := LineageNode{
+		ID:          sessionGeneration(creds),
+		Parent:      parent,
+		Kind:        kind,
+		ARN:         creds.RoleARN,
+		ExpiresAt:   &creds.Expiration,
+		Valid:       isCredentialsValid(creds),
+		STSEndpoint: creds.STSEndpoint,
+		STSGlobal:   creds.STSGlobal,
+	}
+	if !creds.IssuedAt.IsZero() {
+		node.IssuedAt = &creds.IssuedAt
+	}
+	return node
+}
+
+// roleSessionsFor returns the cached role sessions derived from profile,
+// oldest first
+func roleSessionsFor(profile string) []*CachedCredentials {
+	files, _ := filepath.Glob(filepath.Join(getCacheDir(), rolesSubdir, "*.json"))
+	sessions := []*CachedCredentials{}
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		var creds CachedCredentials
+		if json.Unmarshal(data, &creds) != nil || creds.Profile != profile {
+			continue
+		}
+		sessions = append(sessions, &creds)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].IssuedAt.Before(sessions[j].IssuedAt)
+	})
+	return sessions
+}
+
+// buildLineage links the profile's long-term keys to the MFA session minted
+// from them, the viewer session, and the role sessions assumed from the MFA
+// session
+func buildLineage(profile string) *SessionLineage {
+	lineage := &SessionLineage{Profile: profile, Nodes: []LineageNode{}}
+
+	base := ""
+	if accessKey, _, err := getProfileCredentials(profile); err == nil {
+		node := LineageNode{
+			ID:    sessionGeneration(&CachedCredentials{AccessKeyID: accessKey}),
+			Kind:  lineageBase,
+			Valid: true,
+		}
+		if info := loadIdentity(profile); info != nil {
+			node.ARN = info.Arn
+		}
+		base = node.ID
+		lineage.Nodes = append(lineage.Nodes, node)
+	}
+
+	mfa := ""
+	if creds, err := loadCachedCredentials(profile); err == nil {
+		node := lineageNode(lineageMFA, base, creds)
+		node.MFASerial, _ = getMFASerial(profile)
+		mfa = node.ID
+		lineage.Nodes = append(lineage.Nodes, node)
+	}
+
+	if creds, err := loadViewerCredentials(profile); err == nil {
+		lineage.Nodes = append(lineage.Nodes, lineageNode(lineageViewer, base, creds))
+	}
+
+	// A role session whose parent isn't the current MFA session was
+	// assumed from an earlier one that has since been replaced
+	for _, creds := range roleSessionsFor(profile) {
+		parent := creds.SourceGeneration
+		if parent == "" {
+			parent = mfa
+		}
+		lineage.Nodes = append(lineage.Nodes, lineageNode(lineageRole, parent, creds))
+	}
+
+	return lineage
+}
+
+func handleGetLineage(c echo.Context) error {
+	profile := c.Param("profile")
+	if _, err := getProfileSection(profile); err != nil {
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "Profile not found",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, buildLineage(profile))
+}
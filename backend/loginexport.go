@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// LoginAndExportRequest is a login plus the export to run right after it.
+// Target paths may contain {profile}.
+type LoginAndExportRequest struct {
+	LoginRequest
+	Sink   string       `json:"sink,omitempty"`
+	Target ExportTarget `json:"target"`
+	// Async queues the export instead of running it before responding, so
+	// an unavailable target is retried rather than failing the request
+	Async bool `json:"async,omitempty"`
+}
+
+// LoginAndExportResponse carries both results. When the export fails the
+// login has still happened, so Login is set alongside ExportError.
+type LoginAndExportResponse struct {
+	Login       *StatusResponse `json:"login"`
+	Export      *ExportReceipt  `json:"export,omitempty"`
+	ExportError string          `json:"exportError,omitempty"`
+	Job         *ExportJob      `json:"job,omitempty"`
+}
+
+// handleLoginAndExport logs in and immediately exports the new session.
+// The export target is validated first so a bad target never costs the
+// user a token code.
+func handleLoginAndExport(c echo.Context) error {
+	var req LoginAndExportRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	if req.Sink == "" {
+		req.Sink = "file"
+	}
+
+	profile, _ := resolveProfile(req.Profile)
+	target := req.Target
+	target.Path = strings.ReplaceAll(target.Path, fanoutProfileToken, profile)
+	if req.Async && req.Sink == "clipboard-once" {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid export target",
+			Details: "clipboard-once exports can't be queued",
+		})
+	}
+	if err := validateExport(req.Sink, target); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid export target",
+			Details: err.Error(),
+		})
+	}
+
+	// Checked before the login, whose own check then sees the same token
+	if resp := requireConfirmation(c, confirmLoginAndExport, profile); resp != nil {
+		return c.JSON(http.StatusPreconditionRequired, resp)
+	}
+	status, code, errBody := loginProfile(c, &req.LoginRequest)
+	if errBody != nil {
+		return c.JSON(code, errBody)
+	}
+	resp := LoginAndExportResponse{Login: status}
+
+	if req.Async {
+		job, err := enqueueExport(req.Sink, req.Profile, "", target)
+		if err != nil {
+			resp.ExportError = err.Error()
+			return c.JSON(exportErrorStatus(err), resp)
+		}
+		resp.Job = job
+		return c.JSON(http.StatusAccepted, resp)
+	}
+
+	payload, code, errResp := buildExportPayload(req.Profile, "")
+	if errResp != nil {
+		resp.ExportError = errResp.Error
+		return c.JSON(code, resp)
+	}
+
+	receipt, err := auditedExport(c.Request().Context(), req.Sink, target, payload)
+	if err != nil {
+		resp.ExportError = err.Error()
+		return c.JSON(exportErrorStatus(err), resp)
+	}
+	resp.Export = receipt
+	return c.JSON(http.StatusOK, resp)
+}
@@ -3,9 +3,9 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"os"
@@ -13,6 +13,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -40,6 +41,8 @@ const (
 	SourceWSL2        CredentialSource = "wsl2"
 	SourceWindows     CredentialSource = "windows"
 	SourceCustom      CredentialSource = "custom"
+	// SourceMounted is a host home mounted through a pathRemaps entry
+	SourceMounted CredentialSource = "mounted"
 )
 
 // Settings stores user preferences
@@ -48,6 +51,56 @@ type Settings struct {
 	CustomConfigPath string           `json:"customConfigPath,omitempty"`
 	CustomCredsPath  string           `json:"customCredsPath,omitempty"`
 	WSL2Distro       string           `json:"wsl2Distro,omitempty"`
+	DeviceBinding    bool             `json:"deviceBinding,omitempty"`
+	TeamSync         *TeamSyncSettings `json:"teamSync,omitempty"`
+	ExportRegions    []string          `json:"exportRegions,omitempty"`
+	LatencyRegions   []string          `json:"latencyRegions,omitempty"`
+	KeyValidation    *KeyValidationSettings `json:"keyValidation,omitempty"`
+	ViewerSessions   bool              `json:"viewerSessions,omitempty"`
+	ViewerPolicy     string            `json:"viewerPolicy,omitempty"`
+	EnvPrefixes      map[string]string `json:"envPrefixes,omitempty"`
+	RemoteAccess     *RemoteAccessSettings `json:"remoteAccess,omitempty"`
+	Policies         []PolicyRule          `json:"policies,omitempty"`
+	Endpoints        map[string]string     `json:"endpoints,omitempty"`
+	Profiles         map[string]ProfileSettings `json:"profiles,omitempty"`
+	DefaultProfile   string                     `json:"defaultProfile,omitempty"`
+	Broker           *BrokerSettings            `json:"broker,omitempty"`
+	Notifications    *NotificationSettings      `json:"notifications,omitempty"`
+	AutoProvision    *AutoProvisionSettings     `json:"autoProvision,omitempty"`
+	ContainerEndpoint *ContainerEndpointSettings `json:"containerEndpoint,omitempty"`
+	// DefaultDuration is the session length for logins that don't ask for
+	// one, as seconds or a string like "8h"
+	DefaultDuration   SessionDuration            `json:"defaultDuration,omitempty"`
+	Hygiene           *HygieneSettings           `json:"hygiene,omitempty"`
+	ECRCache          *ECRCacheSettings          `json:"ecrCache,omitempty"`
+	PathRemaps        []PathRemap                `json:"pathRemaps,omitempty"`
+	// STSRegionalEndpoints is "regional" (the default) or "legacy", which
+	// sends STS calls from the older regions to sts.amazonaws.com. A
+	// profile's sts_regional_endpoints takes precedence.
+	STSRegionalEndpoints string `json:"stsRegionalEndpoints,omitempty"`
+	// ProcessScope limits /credentials to descendants of registered
+	// parent processes
+	ProcessScope *ProcessScopeSettings `json:"processScope,omitempty"`
+	// YubiKey reads token codes from a YubiKey's OATH accounts
+	YubiKey *YubiKeySettings `json:"yubikey,omitempty"`
+	// OnePassword reads token codes from 1Password items with the op CLI
+	OnePassword *OnePasswordSettings `json:"onePassword,omitempty"`
+	// Bitwarden reads token codes from Bitwarden items with the bw CLI
+	Bitwarden *BitwardenSettings `json:"bitwarden,omitempty"`
+	// Pass reads token codes from password-store entries with pass otp
+	Pass *PassSettings `json:"pass,omitempty"`
+	// Timezone is the IANA timezone expiry times are shown in, by default
+	// the host's
+	Timezone string `json:"timezone,omitempty"`
+	// CLICache also writes role and SSO sessions to ~/.aws/cli/cache for
+	// the AWS CLI to reuse
+	CLICache bool `json:"cliCache,omitempty"`
+	// CredentialsFileSessions also writes each login's session to the
+	// credentials file as [<profile>-mfa]
+	CredentialsFileSessions bool `json:"credentialsFileSessions,omitempty"`
+	// CLIImage is the image POST /cli runs commands in, pinned by digest;
+	// by default amazon/aws-cli, pinned when first pulled
+	CLIImage string `json:"cliImage,omitempty"`
 }
 
 // EnvironmentInfo provides information about the runtime environment
@@ -61,6 +114,12 @@ type EnvironmentInfo struct {
 	ActiveSource    CredentialSource `json:"activeSource"`
 	HomeDir         string           `json:"homeDir"`
 	WindowsHomeDir  string           `json:"windowsHomeDir,omitempty"`
+	Arch            string           `json:"arch"`
+	Capabilities    []Capability     `json:"capabilities"`
+	SourceHealth    []SourceHealth   `json:"sourceHealth"`
+	// SourceIdentity is what role logins pass as their source identity
+	// while device binding is on
+	SourceIdentity  string           `json:"sourceIdentity,omitempty"`
 }
 
 // AWSPathInfo describes a potential AWS config location
@@ -73,31 +132,96 @@ type AWSPathInfo struct {
 }
 
 type CachedCredentials struct {
-	AccessKeyID     string    `json:"accessKeyId"`
-	SecretAccessKey string    `json:"secretAccessKey"`
-	SessionToken    string    `json:"sessionToken"`
-	Expiration      time.Time `json:"expiration"`
-	Profile         string    `json:"profile"`
+	AccessKeyID      string    `json:"accessKeyId"`
+	SecretAccessKey  string    `json:"secretAccessKey"`
+	SessionToken     string    `json:"sessionToken"`
+	Expiration       time.Time `json:"expiration"`
+	Profile          string    `json:"profile"`
+	DeviceID         string    `json:"deviceId,omitempty"`
+	IssuedAt         time.Time `json:"issuedAt,omitempty"`
+	RoleARN          string    `json:"roleArn,omitempty"`
+	ExternalID       string    `json:"externalId,omitempty"` // set when a login overrode external_id
+	SessionTags      map[string]string `json:"sessionTags,omitempty"`
+	TransitiveTags   []string          `json:"transitiveTags,omitempty"`
+	SessionPolicy     string   `json:"sessionPolicy,omitempty"`
+	SessionPolicyARNs []string `json:"sessionPolicyArns,omitempty"`
+	SourceGeneration string    `json:"sourceGeneration,omitempty"` // session these were derived from
+	// STSEndpoint is the host GetSessionToken was sent to, and STSGlobal
+	// whether that was the global endpoint
+	STSEndpoint string `json:"stsEndpoint,omitempty"`
+	STSGlobal   bool   `json:"stsGlobal,omitempty"`
 }
 
 type ProfileInfo struct {
-	Name      string `json:"name"`
-	Region    string `json:"region"`
-	MFASerial string `json:"mfaSerial"`
-	Source    string `json:"source,omitempty"`
+	Name               string               `json:"name"`
+	Region             string               `json:"region"`
+	MFASerial          string               `json:"mfaSerial"`
+	MFAType            string               `json:"mfaType,omitempty"`
+	SSO                bool                 `json:"sso,omitempty"`
+	SourceType         string               `json:"sourceType"`
+	SSOSession         *SSOSessionInfo      `json:"ssoSession,omitempty"`
+	Source             string               `json:"source,omitempty"`
+	ConfigFile         string               `json:"configFile,omitempty"`
+	ConfigSection      string               `json:"configSection,omitempty"`
+	CredentialsFile    string               `json:"credentialsFile,omitempty"`
+	CredentialsSection string               `json:"credentialsSection,omitempty"`
+	Keys               map[string]KeyOrigin `json:"keys,omitempty"`
+	KeyStatus          *KeyValidation       `json:"keyStatus,omitempty"`
+	// Role describes the role a role_arn profile switches to
+	Role *RoleInfo `json:"role,omitempty"`
+	// Granted is set for profiles generated or managed by Granted
+	Granted *GrantedInfo `json:"granted,omitempty"`
+	// Account is only filled in for GET /profiles?accounts=true
+	Account *ProfileAccount `json:"account,omitempty"`
 }
 
 type LoginRequest struct {
 	Profile   string `json:"profile"`
 	TokenCode string `json:"tokenCode"`
-	Duration  int32  `json:"duration,omitempty"`
+	Duration  SessionDuration `json:"duration,omitempty"`
+	// ExternalID overrides the role profile's external_id for this login
+	ExternalID string `json:"externalId,omitempty"`
+	// Tags are merged over the role profile's tags; TransitiveTags
+	// replaces its transitive_session_tags
+	Tags           map[string]string `json:"tags,omitempty"`
+	TransitiveTags []string          `json:"transitiveTags,omitempty"`
+	// Policy and PolicyARNs replace the profile's sessionPolicy and
+	// sessionPolicyArns settings for this login
+	Policy     string   `json:"policy,omitempty"`
+	PolicyARNs []string `json:"policyArns,omitempty"`
+}
+
+func (r *LoginRequest) roleOverrides() roleOverrides {
+	return roleOverrides{ExternalID: r.ExternalID, Tags: r.Tags, TransitiveTags: r.TransitiveTags,
+		Policy: r.Policy, PolicyARNs: r.PolicyARNs}
 }
 
 type StatusResponse struct {
-	Profile       string     `json:"profile"`
-	Authenticated bool       `json:"authenticated"`
-	Expiration    *time.Time `json:"expiration,omitempty"`
-	TimeRemaining string     `json:"timeRemaining,omitempty"`
+	Profile          string     `json:"profile"`
+	Authenticated    bool       `json:"authenticated"`
+	Expiration       *time.Time `json:"expiration,omitempty"`
+	ExpiresAt        string     `json:"expiresAt,omitempty"`
+	SecondsRemaining int64      `json:"secondsRemaining"`
+	TimeRemaining    string     `json:"timeRemaining,omitempty"`
+	// ExpiresAtLocal is ExpiresAt in Timezone, the timezone setting or the
+	// host's, with ExpiresDay "today", "tomorrow", "later" or "expired"
+	// there and ExpiresHint saying so in the request's language
+	ExpiresAtLocal   string     `json:"expiresAtLocal,omitempty"`
+	Timezone         string     `json:"timezone,omitempty"`
+	ExpiresDay       string     `json:"expiresDay,omitempty"`
+	ExpiresHint      string     `json:"expiresHint,omitempty"`
+	Warning          string     `json:"warning,omitempty"`
+	Throttle         *ThrottleStatus `json:"throttle,omitempty"`
+	Resolution       string          `json:"profileResolution,omitempty"`
+	Hooks            []HookResult    `json:"hooks,omitempty"`
+	AccountMismatch  *AccountMismatch `json:"accountMismatch,omitempty"`
+	// Canaries are the results of the profile's canary checks, on login
+	Canaries         []CanaryResult   `json:"canaries,omitempty"`
+	// DurationSeconds is the session length the login asked STS for, after
+	// defaults and policy caps
+	DurationSeconds int32 `json:"durationSeconds,omitempty"`
+	// PollInterval is how soon to ask for the status again
+	PollInterval int64 `json:"pollIntervalSeconds,omitempty"`
 }
 
 type ErrorResponse struct {
@@ -105,7 +229,19 @@ type ErrorResponse struct {
 	Details string `json:"details,omitempty"`
 }
 
-var currentSettings *Settings
+var (
+	// settingsMu guards currentSettings, which PUT /settings and reloads
+	// replace while requests read it
+	settingsMu      sync.RWMutex
+	currentSettings *Settings
+)
+
+// setCurrentSettings swaps in settings for every later loadSettings
+func setCurrentSettings(settings *Settings) {
+	settingsMu.Lock()
+	currentSettings = settings
+	settingsMu.Unlock()
+}
 
 // WSL2 and environment detection
 
@@ -244,6 +380,8 @@ func discoverAWSPaths() []AWSPathInfo {
 		}
 	}
 
+	paths = append(paths, mountedHomes()...)
+
 	return paths
 }
 
@@ -253,6 +391,7 @@ func getEnvironmentInfo() *EnvironmentInfo {
 		IsWindows: runtime.GOOS == "windows",
 		IsLinux:   runtime.GOOS == "linux" && !isWSL2(),
 		IsMacOS:   runtime.GOOS == "darwin",
+		Arch:      runtime.GOARCH,
 	}
 
 	info.HomeDir, _ = os.UserHomeDir()
@@ -268,6 +407,11 @@ func getEnvironmentInfo() *EnvironmentInfo {
 
 	settings := loadSettings()
 	info.ActiveSource = settings.CredentialSource
+	info.Capabilities = getCapabilities()
+	info.SourceHealth = currentSourceHealth()
+	if settings.DeviceBinding {
+		info.SourceIdentity = deviceSourceIdentity()
+	}
 
 	return info
 }
@@ -280,25 +424,33 @@ func getSettingsPath() string {
 }
 
 func loadSettings() *Settings {
-	if currentSettings != nil {
-		return currentSettings
+	settingsMu.RLock()
+	settings := currentSettings
+	settingsMu.RUnlock()
+	if settings != nil {
+		return settings
 	}
 
-	settings := &Settings{
+	settings = &Settings{
 		CredentialSource: SourceAuto,
 	}
 
 	data, err := os.ReadFile(getSettingsPath())
 	if err == nil {
-		json.Unmarshal(data, settings)
+		decodeCacheFile(getSettingsPath(), data, settings)
 	}
 
-	currentSettings = settings
-	return settings
+	// Another request may have read or saved the settings meanwhile
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	if currentSettings == nil {
+		currentSettings = settings
+	}
+	return currentSettings
 }
 
 func saveSettings(settings *Settings) error {
-	currentSettings = settings
+	setCurrentSettings(settings)
 
 	dir := filepath.Dir(getSettingsPath())
 	if err := os.MkdirAll(dir, 0700); err != nil {
@@ -310,7 +462,7 @@ func saveSettings(settings *Settings) error {
 		return err
 	}
 
-	return os.WriteFile(getSettingsPath(), data, 0600)
+	return writeFileAtomic(getSettingsPath(), data, 0600)
 }
 
 // AWS path resolution based on settings
@@ -321,7 +473,7 @@ func getAWSConfigPath() string {
 	switch settings.CredentialSource {
 	case SourceCustom:
 		if settings.CustomConfigPath != "" {
-			return settings.CustomConfigPath
+			return remapPath(settings.CustomConfigPath)
 		}
 	case SourceWindows:
 		if isWSL2() {
@@ -333,6 +485,10 @@ func getAWSConfigPath() string {
 			userProfile := os.Getenv("USERPROFILE")
 			return filepath.Join(userProfile, ".aws", "config")
 		}
+	case SourceMounted:
+		if homes := mountedHomes(); len(homes) > 0 {
+			return homes[0].ConfigPath
+		}
 	case SourceLinux, SourceWSL2:
 		// Use native Linux path
 	case SourceAuto:
@@ -356,7 +512,7 @@ func getAWSCredentialsPath() string {
 	switch settings.CredentialSource {
 	case SourceCustom:
 		if settings.CustomCredsPath != "" {
-			return settings.CustomCredsPath
+			return remapPath(settings.CustomCredsPath)
 		}
 	case SourceWindows:
 		if isWSL2() {
@@ -368,6 +524,10 @@ func getAWSCredentialsPath() string {
 			userProfile := os.Getenv("USERPROFILE")
 			return filepath.Join(userProfile, ".aws", "credentials")
 		}
+	case SourceMounted:
+		if homes := mountedHomes(); len(homes) > 0 {
+			return homes[0].CredsPath
+		}
 	case SourceLinux, SourceWSL2:
 		// Use native Linux path
 	case SourceAuto:
@@ -392,11 +552,21 @@ func getCacheDir() string {
 	return filepath.Join(home, cacheDir)
 }
 
-func getCacheFile(profile string) string {
-	if profile == "" {
-		profile = "default"
+// clearSession removes every cached session for profile and notifies
+// event subscribers
+func clearSession(profile string) error {
+	removeSessionCopies(profile)
+	// Sessions cached under other sources or accounts the profile pointed
+	// at are cleared too
+	for _, f := range profileSessionFiles(profile) {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
-	return filepath.Join(getCacheDir(), profile+".json")
+	os.Remove(getViewerCacheFile(profile))
+	clearRoleSessions(profile)
+	events.publish(Event{Type: eventCleared, Profile: profile})
+	return nil
 }
 
 func loadCachedCredentials(profile string) (*CachedCredentials, error) {
@@ -407,7 +577,11 @@ func loadCachedCredentials(profile string) (*CachedCredentials, error) {
 	}
 
 	var creds CachedCredentials
-	if err := json.Unmarshal(data, &creds); err != nil {
+	if err := decodeCacheFile(cacheFile, data, &creds); err != nil {
+		return nil, err
+	}
+
+	if err := checkDeviceBinding(&creds); err != nil {
 		return nil, err
 	}
 
@@ -425,7 +599,7 @@ func saveCachedCredentials(creds *CachedCredentials) error {
 	}
 
 	cacheFile := getCacheFile(creds.Profile)
-	return os.WriteFile(cacheFile, data, 0600)
+	return writeFileAtomic(cacheFile, data, 0600)
 }
 
 func isCredentialsValid(creds *CachedCredentials) bool {
@@ -447,9 +621,18 @@ func getProfiles() ([]ProfileInfo, error) {
 	var profiles []ProfileInfo
 	settings := loadSettings()
 
+	// Locate keys in both files so each value can be traced to its origin.
+	// The credentials file is optional here; only key names are exposed.
+	credsPath := getAWSCredentialsPath()
+	configLines, _ := indexIniLines(configPath)
+	credsLines, _ := indexIniLines(credsPath)
+	credsCfg, _ := ini.Load(credsPath)
+	configSource := sourceForPath(configPath)
+	credsSource := sourceForPath(credsPath)
+
 	for _, section := range cfg.Sections() {
 		name := section.Name()
-		if name == "DEFAULT" {
+		if name == "DEFAULT" || strings.HasPrefix(name, "sso-session ") {
 			continue
 		}
 
@@ -460,36 +643,129 @@ func getProfiles() ([]ProfileInfo, error) {
 		}
 
 		mfaSerial := section.Key("mfa_serial").String()
-		if mfaSerial == "" {
-			continue // Skip profiles without MFA
+		sso := section.HasKey("sso_session") || section.HasKey("sso_start_url") || isGrantedSSO(section)
+		role := section.HasKey("role_arn") && !sso
+		if mfaSerial == "" && !sso && !section.HasKey("saml_command") && !role {
+			continue // Skip profiles that log in with neither MFA, SSO, SAML nor a role
 		}
 
-		profiles = append(profiles, ProfileInfo{
-			Name:      profileName,
-			Region:    section.Key("region").String(),
-			MFASerial: mfaSerial,
-			Source:    string(settings.CredentialSource),
-		})
+		info := ProfileInfo{
+			Name:          profileName,
+			Region:        section.Key("region").String(),
+			MFASerial:     mfaSerial,
+			SSO:           sso,
+			Source:        string(settings.CredentialSource),
+			ConfigFile:    absPath(configPath),
+			ConfigSection: name,
+			Keys:          map[string]KeyOrigin{},
+		}
+		if mfaSerial != "" {
+			info.MFAType = mfaType(mfaSerial)
+		}
+		info.SourceType = profileSourceType(section)
+		info.Granted = grantedProfileInfo(section)
+		if session := section.Key("sso_session").String(); session != "" {
+			info.SSOSession = &SSOSessionInfo{Name: session}
+			if ss, err := ssoSessionSettings(cfg, session); err != nil {
+				info.SSOSession.Error = err.Error()
+			} else {
+				info.SSOSession.StartURL = ss.StartURL
+				info.SSOSession.Region = ss.Region
+				info.SSOSession.RegistrationScopes = ssoScopes(ss)
+			}
+		}
+
+		for _, key := range section.Keys() {
+			info.Keys[key.Name()] = KeyOrigin{
+				File:    info.ConfigFile,
+				Section: name,
+				Line:    configLines[name][key.Name()],
+				Kind:    "config",
+				Source:  configSource,
+			}
+		}
+
+		if credsCfg != nil {
+			if credsSection, err := credsCfg.GetSection(profileName); err == nil {
+				info.CredentialsFile = absPath(credsPath)
+				info.CredentialsSection = profileName
+				for _, key := range credsSection.Keys() {
+					info.Keys[key.Name()] = KeyOrigin{
+						File:    info.CredentialsFile,
+						Section: profileName,
+						Line:    credsLines[profileName][key.Name()],
+						Kind:    "credentials",
+						Source:  credsSource,
+					}
+				}
+				info.KeyStatus = currentKeyValidation(profileName, credsSection.Key("aws_access_key_id").String())
+			}
+		}
+		if role {
+			// After the keys are listed, as looking up absent keys adds them
+			info.Role = profileRoleInfo(profileName, section)
+		}
+
+		profiles = append(profiles, info)
 	}
 
 	return profiles, nil
 }
 
-func getMFASerial(profile string) (string, error) {
+// Profile source types, i.e. how a profile gets its session
+const (
+	sourceTypeMFA        = "mfa"
+	sourceTypeRole       = "assumeRole"
+	sourceTypeSSOSession = "ssoSession"
+	sourceTypeSSOLegacy  = "ssoLegacy"
+	sourceTypeProcess    = "credentialProcess"
+)
+
+func profileSourceType(section *ini.Section) string {
+	switch {
+	case section.Key("sso_session").String() != "":
+		return sourceTypeSSOSession
+	case section.Key("sso_start_url").String() != "":
+		return sourceTypeSSOLegacy
+	case isGrantedSSO(section):
+		return sourceTypeGrantedSSO
+	case section.Key("saml_command").String() != "":
+		return sourceTypeSAML
+	case section.Key("role_arn").String() != "":
+		return sourceTypeRole
+	case section.Key("credential_process").String() != "":
+		return sourceTypeProcess
+	default:
+		return sourceTypeMFA
+	}
+}
+
+func profileSectionName(profile string) string {
+	if profile == "default" {
+		return profile
+	}
+	return "profile " + profile
+}
+
+func getProfileSection(profile string) (*ini.Section, error) {
 	configPath := getAWSConfigPath()
 	cfg, err := ini.Load(configPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to load AWS config: %w", err)
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
-	sectionName := profile
-	if profile != "default" {
-		sectionName = "profile " + profile
+	section, err := cfg.GetSection(profileSectionName(profile))
+	if err != nil {
+		return nil, fmt.Errorf("profile not found: %s", profile)
 	}
 
-	section, err := cfg.GetSection(sectionName)
+	return section, nil
+}
+
+func getMFASerial(profile string) (string, error) {
+	section, err := getProfileSection(profile)
 	if err != nil {
-		return "", fmt.Errorf("profile not found: %s", profile)
+		return "", err
 	}
 
 	mfaSerial := section.Key("mfa_serial").String()
@@ -522,26 +798,90 @@ func getProfileCredentials(profile string) (accessKey, secretKey string, err err
 	return accessKey, secretKey, nil
 }
 
-func performMFALogin(ctx context.Context, profile, tokenCode string, duration int32) (*CachedCredentials, error) {
+// performMFALogin mints the profile's session with the MFA code. Profiles
+// with a role_arn assume that role; others get a session token.
+func performMFALogin(ctx context.Context, profile, tokenCode string, o roleOverrides, duration int32) (*CachedCredentials, error) {
+	var creds *CachedCredentials
+	var err error
+	if roleARN := profileRoleARN(profile); roleARN != "" {
+		creds, err = performRoleLogin(ctx, profile, roleARN, tokenCode, o, duration)
+	} else {
+		creds, err = getMFASessionToken(ctx, profile, tokenCode, duration)
+	}
+	return creds, err
+}
+
+// finishLogin completes a login once its session is minted, the same way
+// for every login method: it caches the session, records the login with
+// fields in the audit log, runs the post-login hooks and builds the status
+// with the account check and canaries. hooks are the pre-login results.
+func finishLogin(c echo.Context, creds *CachedCredentials, hooks []HookResult, fields map[string]string) (*StatusResponse, error) {
+	if err := storeLoginSession(creds); err != nil {
+		recordAudit(AuditEntry{Action: "login", Profile: creds.Profile, Result: "error", Details: err.Error(), Fields: fields})
+		return nil, err
+	}
+	fields["expiresAt"] = creds.Expiration.UTC().Format(time.RFC3339)
+	recordAudit(AuditEntry{Action: "login", Profile: creds.Profile, Result: "ok", Fields: fields})
+
+	ctx := c.Request().Context()
+	post, _ := runLoginHooks(ctx, hookPostLogin, creds.Profile, creds)
+	status := newStatusResponse(c, creds)
+	status.Hooks = append(hooks, post...)
+	verifyLoginAccount(ctx, creds.Profile)
+	status = withAccountCheck(status)
+	status = withCanaries(ctx, status, creds)
+	return &status, nil
+}
+
+// storeLoginSession caches a freshly minted session and announces the login
+func storeLoginSession(creds *CachedCredentials) error {
+	if err := saveCachedCredentials(creds); err != nil {
+		return fmt.Errorf("failed to cache credentials: %w", err)
+	}
+	if err := writeCLICache(creds); err != nil {
+		fmt.Fprintf(os.Stderr, "AWS CLI cache for %s: %v\n", creds.Profile, err)
+	}
+	if err := writeMFASection(creds); err != nil {
+		fmt.Fprintf(os.Stderr, "credentials file section for %s: %v\n", creds.Profile, err)
+	}
+
+	events.publish(Event{
+		Type:    eventLogin,
+		Profile: creds.Profile,
+		Data:    map[string]string{"expiresAt": creds.Expiration.UTC().Format(time.RFC3339)},
+	})
+	resolvePrompts(creds.Profile)
+	return nil
+}
+
+func getMFASessionToken(ctx context.Context, profile, tokenCode string, duration int32) (*CachedCredentials, error) {
 	mfaSerial, err := getMFASerial(profile)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkMFASerial(ctx, profile, mfaSerial); err != nil {
+		return nil, err
+	}
 
-	// Get base credentials from the credentials file
-	accessKey, secretKey, err := getProfileCredentials(profile)
+	// Get base credentials from the credentials file or credential_process
+	base, err := getBaseCredentials(ctx, profile)
 	if err != nil {
 		return nil, err
 	}
+	if base.SessionToken != "" {
+		return nil, fmt.Errorf("credential_process for %s returned temporary credentials; "+
+			"GetSessionToken needs long-term keys, so set role_arn to assume a role with them instead", profile)
+	}
 
 	// Load AWS config with explicit credentials
 	configPath := getAWSConfigPath()
-	cfg, err := config.LoadDefaultConfig(ctx,
+	opts := append([]func(*config.LoadOptions) error{
 		config.WithSharedConfigFiles([]string{configPath}),
 		config.WithSharedCredentialsFiles([]string{getAWSCredentialsPath()}),
 		config.WithSharedConfigProfile(profile),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")),
-	)
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(base.AccessKeyID, base.SecretAccessKey, "")),
+	}, awsLoadOptions(profile)...)
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
@@ -555,38 +895,44 @@ func performMFALogin(ctx context.Context, profile, tokenCode string, duration in
 		SerialNumber:    aws.String(mfaSerial),
 		TokenCode:       aws.String(tokenCode),
 	})
+	stsThrottles.observe(profile, err)
 	if err != nil {
 		return nil, fmt.Errorf("MFA authentication failed: %w", err)
 	}
+	endpoint, global := stsEndpointUsed(result.ResultMetadata)
 
-	creds := &CachedCredentials{
+	return &CachedCredentials{
 		AccessKeyID:     *result.Credentials.AccessKeyId,
 		SecretAccessKey: *result.Credentials.SecretAccessKey,
 		SessionToken:    *result.Credentials.SessionToken,
 		Expiration:      *result.Credentials.Expiration,
 		Profile:         profile,
-	}
-
-	if err := saveCachedCredentials(creds); err != nil {
-		return nil, fmt.Errorf("failed to cache credentials: %w", err)
-	}
-
-	return creds, nil
+		DeviceID:        getDeviceID(),
+		IssuedAt:        time.Now().UTC(),
+		STSEndpoint:     endpoint,
+		STSGlobal:       global,
+	}, nil
 }
 
-func formatTimeRemaining(expiration time.Time) string {
-	remaining := time.Until(expiration)
-	if remaining < 0 {
-		return "expired"
-	}
-
-	hours := int(remaining.Hours())
-	minutes := int(remaining.Minutes()) % 60
-
-	if hours > 0 {
-		return fmt.Sprintf("%dh %dm", hours, minutes)
+// newStatusResponse builds an authenticated status for creds, rendering the
+// human-readable remaining time in the locale requested by the client
+func newStatusResponse(c echo.Context, creds *CachedCredentials) StatusResponse {
+	locale := localeForRequest(c)
+	c.Response().Header().Set("Content-Language", locale.Tag.String())
+	loc := displayTimezone(c)
+
+	return StatusResponse{
+		Profile:          creds.Profile,
+		Authenticated:    true,
+		Expiration:       &creds.Expiration,
+		ExpiresAt:        creds.Expiration.UTC().Format(time.RFC3339),
+		SecondsRemaining: secondsRemaining(creds.Expiration),
+		TimeRemaining:    locale.formatRemaining(creds.Expiration),
+		ExpiresAtLocal:   creds.Expiration.In(loc).Format(time.RFC3339),
+		Timezone:         loc.String(),
+		ExpiresDay:       expiresDay(creds.Expiration, loc),
+		ExpiresHint:      locale.formatExpiry(creds.Expiration, loc),
 	}
-	return fmt.Sprintf("%dm", minutes)
 }
 
 // HTTP Handlers
@@ -599,6 +945,72 @@ func handleGetSettings(c echo.Context) error {
 	return c.JSON(http.StatusOK, loadSettings())
 }
 
+// validateSettings checks settings before they replace the current ones,
+// whether they come from PUT /settings or a reload of the settings file
+func validateSettings(settings *Settings) error {
+	if err := validateEndpoints(settings.Endpoints); err != nil {
+		return err
+	}
+	if err := validateExpectedAccounts(settings.Profiles); err != nil {
+		return err
+	}
+	if err := validateNotifications(settings.Notifications); err != nil {
+		return err
+	}
+	if err := validateContainerEndpoint(settings.ContainerEndpoint); err != nil {
+		return err
+	}
+	if err := validateYubiKey(settings.YubiKey, settings.Profiles); err != nil {
+		return err
+	}
+	if err := validateOnePassword(settings.OnePassword, settings.Profiles); err != nil {
+		return err
+	}
+	if err := validateBitwarden(settings.Bitwarden, settings.Profiles); err != nil {
+		return err
+	}
+	if err := validatePass(settings.Pass, settings.Profiles); err != nil {
+		return err
+	}
+	if err := validateTokenSources(settings.Profiles); err != nil {
+		return err
+	}
+	if err := validateSessionPolicies(settings.Profiles); err != nil {
+		return err
+	}
+	if err := validateViewerRoles(settings.Profiles); err != nil {
+		return err
+	}
+	if err := validateProfileSTSEndpoints(settings.Profiles); err != nil {
+		return err
+	}
+	if err := validateCanaries(settings.Profiles); err != nil {
+		return err
+	}
+	if err := validateProfileEndpoints(settings.Profiles); err != nil {
+		return err
+	}
+	if err := validateDurations(settings); err != nil {
+		return err
+	}
+	if err := validatePolicies(settings.Policies); err != nil {
+		return err
+	}
+	if err := validateSTSEndpointMode(settings.STSRegionalEndpoints); err != nil {
+		return err
+	}
+	if err := validatePathRemaps(settings.PathRemaps); err != nil {
+		return err
+	}
+	if err := validateTimezone(settings.Timezone); err != nil {
+		return err
+	}
+	if err := validateCLIImage(settings.CLIImage); err != nil {
+		return err
+	}
+	return validateProcessScope(settings.ProcessScope)
+}
+
 func handleUpdateSettings(c echo.Context) error {
 	var settings Settings
 	if err := c.Bind(&settings); err != nil {
@@ -606,6 +1018,12 @@ func handleUpdateSettings(c echo.Context) error {
 			Error: "Invalid settings",
 		})
 	}
+	if err := validateSettings(&settings); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid settings",
+			Details: err.Error(),
+		})
+	}
 
 	if err := saveSettings(&settings); err != nil {
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -613,6 +1031,10 @@ func handleUpdateSettings(c echo.Context) error {
 			Details: err.Error(),
 		})
 	}
+	events.publish(Event{Type: eventSettingsSave})
+	// The source or custom paths may have changed; don't report stale
+	// health until the next check
+	go runSourceHealth(context.Background())
 
 	return c.JSON(http.StatusOK, settings)
 }
@@ -625,29 +1047,33 @@ func handleGetProfiles(c echo.Context) error {
 			Details: err.Error(),
 		})
 	}
+	if c.QueryParam("accounts") == "true" {
+		profiles = withProfileAccounts(c.Request().Context(), profiles)
+	}
 	return c.JSON(http.StatusOK, profiles)
 }
 
 func handleGetStatus(c echo.Context) error {
-	profile := c.QueryParam("profile")
-	if profile == "" {
-		profile = "default"
-	}
+	profile := requestProfile(c, c.QueryParam("profile"))
+	resolution := c.Response().Header().Get(profileResolutionHeader)
 
 	creds, err := loadCachedCredentials(profile)
 	if err != nil || !isCredentialsValid(creds) {
-		return c.JSON(http.StatusOK, StatusResponse{
+		status := withPollHint(withThrottleStatus(StatusResponse{
 			Profile:       profile,
 			Authenticated: false,
-		})
-	}
-
-	return c.JSON(http.StatusOK, StatusResponse{
-		Profile:       profile,
-		Authenticated: true,
-		Expiration:    &creds.Expiration,
-		TimeRemaining: formatTimeRemaining(creds.Expiration),
-	})
+			Resolution:    resolution,
+		}))
+		setPollHeaders(c, status)
+		return c.JSON(http.StatusOK, status)
+	}
+
+	status := newStatusResponse(c, creds)
+	status.Profile = profile
+	status.Resolution = resolution
+	status = withPollHint(withAccountCheck(withThrottleStatus(status)))
+	setPollHeaders(c, status)
+	return c.JSON(http.StatusOK, status)
 }
 
 func handleGetAllStatus(c echo.Context) error {
@@ -659,21 +1085,27 @@ func handleGetAllStatus(c echo.Context) error {
 		})
 	}
 
+	statuses := profileStatuses(c, profiles)
+	setPollHeaders(c, statuses...)
+	return c.JSON(http.StatusOK, statuses)
+}
+
+// profileStatuses returns the session status of each profile
+func profileStatuses(c echo.Context, profiles []ProfileInfo) []StatusResponse {
 	var statuses []StatusResponse
 	for _, p := range profiles {
 		creds, err := loadCachedCredentials(p.Name)
 		status := StatusResponse{
 			Profile:       p.Name,
-			Authenticated: err == nil && isCredentialsValid(creds),
+			Authenticated: false,
 		}
-		if status.Authenticated {
-			status.Expiration = &creds.Expiration
-			status.TimeRemaining = formatTimeRemaining(creds.Expiration)
+		if err == nil && isCredentialsValid(creds) {
+			status = newStatusResponse(c, creds)
+			status.Profile = p.Name
 		}
-		statuses = append(statuses, status)
+		statuses = append(statuses, withPollHint(withAccountCheck(withThrottleStatus(status))))
 	}
-
-	return c.JSON(http.StatusOK, statuses)
+	return statuses
 }
 
 func handleLogin(c echo.Context) error {
@@ -684,77 +1116,229 @@ func handleLogin(c echo.Context) error {
 		})
 	}
 
-	if req.Profile == "" {
-		req.Profile = "default"
+	status, code, errBody := loginProfile(c, &req)
+	if errBody != nil {
+		return c.JSON(code, errBody)
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+// loginProfile runs a login request through policies and hooks. On failure
+// it returns the HTTP status and body a handler should respond with.
+func loginProfile(c echo.Context, req *LoginRequest) (*StatusResponse, int, interface{}) {
+	req.Profile = requestProfile(c, req.Profile)
+	if req.TokenCode == "" && tokenCodeSource(req.Profile) == "" {
+		return nil, http.StatusBadRequest, ErrorResponse{
+			Error: "Token code is required",
+		}
+	}
+	if req.ExternalID != "" && profileRoleARN(req.Profile) == "" {
+		return nil, http.StatusBadRequest, ErrorResponse{
+			Error:   "External ID needs a role",
+			Details: "profile " + req.Profile + " has no role_arn to pass externalId to",
+		}
+	}
+	if len(req.Tags) > 0 || req.TransitiveTags != nil {
+		if profileRoleARN(req.Profile) == "" {
+			return nil, http.StatusBadRequest, ErrorResponse{
+				Error:   "Session tags need a role",
+				Details: "profile " + req.Profile + " has no role_arn to tag the session of",
+			}
+		}
+		if _, _, err := hopSessionTags(req.Profile, req.roleOverrides(), true); err != nil {
+			return nil, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid session tags",
+				Details: err.Error(),
+			}
+		}
+	}
+	if policy, arns := sessionPolicy(req.Profile, req.roleOverrides()); policy != "" || len(arns) > 0 {
+		if profileRoleARN(req.Profile) == "" {
+			return nil, http.StatusBadRequest, ErrorResponse{
+				Error:   "Session policy needs a role",
+				Details: "profile " + req.Profile + " has no role_arn; GetSessionToken sessions can't be scoped by a policy",
+			}
+		}
+		if err := validateSessionPolicy(policy, arns); err != nil {
+			return nil, http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid session policy",
+				Details: err.Error(),
+			}
+		}
 	}
 	if req.Duration == 0 {
-		req.Duration = defaultDuration
+		req.Duration = defaultLoginDuration(req.Profile)
+	} else if err := loginDurationLimits(req.Profile).check(req.Duration); err != nil {
+		return nil, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid duration",
+			Details: err.Error(),
+		}
 	}
-	if req.TokenCode == "" {
-		return c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error: "Token code is required",
+
+	if duration, rule := policyMaxDuration(req.Profile, int32(req.Duration)); rule != nil {
+		recordAudit(AuditEntry{
+			Action:  "policy." + rule.Type,
+			Profile: req.Profile,
+			Result:  "clamped",
+			Details: fmt.Sprintf("requested duration %ds reduced to %ds", req.Duration, duration),
+			Fields:  map[string]string{"rule": rule.Name},
 		})
+		req.Duration = SessionDuration(duration)
+	}
+
+	if resp := requireConfirmation(c, confirmLogin, req.Profile); resp != nil {
+		return nil, http.StatusPreconditionRequired, resp
 	}
 
-	creds, err := performMFALogin(c.Request().Context(), req.Profile, req.TokenCode, req.Duration)
+	ctx := c.Request().Context()
+	hooks, ok := runLoginHooks(ctx, hookPreLogin, req.Profile, nil)
+	if !ok {
+		recordAudit(AuditEntry{Action: "login", Profile: req.Profile, Result: "error", Details: "pre-login hook failed"})
+		return nil, http.StatusPreconditionFailed, map[string]interface{}{
+			"error": "Pre-login hook failed",
+			"hooks": hooks,
+		}
+	}
+
+	// Read the code last so it is as fresh as possible when STS sees it
+	tokenSource := tokenSourceManual
+	if req.TokenCode == "" {
+		code, source, err := readTokenCode(ctx, req.Profile)
+		if errors.Is(err, errTokenCodeRequired) {
+			return nil, http.StatusBadRequest, ErrorResponse{
+				Error: "Token code is required",
+			}
+		}
+		if err != nil {
+			recordAudit(AuditEntry{Action: "login", Profile: req.Profile, Result: "error", Details: err.Error()})
+			return nil, http.StatusBadGateway, ErrorResponse{
+				Error:   "Failed to read token code",
+				Details: err.Error(),
+			}
+		}
+		req.TokenCode, tokenSource = code, source
+	}
+
+	creds, err := performMFALogin(ctx, req.Profile, req.TokenCode, req.roleOverrides(), int32(req.Duration))
 	if err != nil {
-		return c.JSON(http.StatusUnauthorized, ErrorResponse{
+		recordAudit(AuditEntry{Action: "login", Profile: req.Profile, Result: "error", Details: err.Error(),
+			Fields: map[string]string{"tokenSource": tokenSource}})
+		if errors.Is(err, errFIDOUnsupported) {
+			return nil, http.StatusUnprocessableEntity, ErrorResponse{
+				Error:   "MFA device not supported",
+				Details: err.Error(),
+			}
+		}
+		return nil, http.StatusUnauthorized, ErrorResponse{
 			Error:   "Authentication failed",
 			Details: err.Error(),
-		})
+		}
 	}
+	status, err := finishLogin(c, creds, hooks, map[string]string{"tokenSource": tokenSource})
+	if err != nil {
+		return nil, http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to cache credentials",
+			Details: err.Error(),
+		}
+	}
+	status.DurationSeconds = int32(req.Duration)
 
-	return c.JSON(http.StatusOK, StatusResponse{
-		Profile:       creds.Profile,
-		Authenticated: true,
-		Expiration:    &creds.Expiration,
-		TimeRemaining: formatTimeRemaining(creds.Expiration),
-	})
-}
-
-func handleGetCredentials(c echo.Context) error {
-	profile := c.QueryParam("profile")
-	if profile == "" {
-		profile = "default"
+	// The viewer session is a convenience; failing to mint one must not
+	// fail the login itself
+	if loadSettings().ViewerSessions {
+		if _, err := mintViewerSession(ctx, creds, int32(req.Duration)); err != nil {
+			fmt.Fprintf(os.Stderr, "viewer session for %s: %v\n", req.Profile, err)
+		}
 	}
+	return status, http.StatusOK, nil
+}
 
+// loadUsableCredentials loads a valid session for profile, or the HTTP status
+// and error body a handler should return instead
+func loadUsableCredentials(profile string) (*CachedCredentials, int, *ErrorResponse) {
 	creds, err := loadCachedCredentials(profile)
+	if errors.Is(err, errDeviceMismatch) {
+		return nil, http.StatusForbidden, &ErrorResponse{
+			Error:   "Session bound to another device",
+			Details: err.Error(),
+		}
+	}
 	if err != nil {
-		return c.JSON(http.StatusNotFound, ErrorResponse{
+		return nil, http.StatusNotFound, &ErrorResponse{
 			Error: "No cached credentials found",
-		})
+		}
 	}
 
 	if !isCredentialsValid(creds) {
-		return c.JSON(http.StatusUnauthorized, ErrorResponse{
+		return nil, http.StatusUnauthorized, &ErrorResponse{
 			Error: "Credentials expired",
+		}
+	}
+
+	return creds, http.StatusOK, nil
+}
+
+func formatEnvContent(creds *CachedCredentials) string {
+	return fmt.Sprintf("AWS_ACCESS_KEY_ID=%s\nAWS_SECRET_ACCESS_KEY=%s\nAWS_SESSION_TOKEN=%s\n",
+		creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)
+}
+
+// buildEnvContent renders the session as an env file, merging in any SSM
+// parameters or Secrets Manager secrets requested via ssmPath/secretPrefix
+func buildEnvContent(c echo.Context, creds *CachedCredentials) (string, error) {
+	secrets, err := fetchAppSecrets(c.Request().Context(), creds.Profile,
+		c.QueryParam("ssmPath"), c.QueryParam("secretPrefix"))
+	if err != nil {
+		return "", err
+	}
+	return appendSecretsEnv(formatEnvContent(creds), secrets), nil
+}
+
+// handleGetCredentials returns the profile's session. Consumers can pass
+// ?minTtl= (seconds) to require that much remaining lifetime.
+func handleGetCredentials(c echo.Context) error {
+	profile := requestProfile(c, c.QueryParam("profile"))
+	minTTL, err := parseMinTTL(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid minTtl",
+			Details: err.Error(),
 		})
 	}
 
+	creds, status, errResp := credentialsForTTL(c.Request().Context(), profile, minTTL)
+	if errResp != nil {
+		return c.JSON(status, errResp)
+	}
+
 	return c.JSON(http.StatusOK, creds)
 }
 
 func handleGetEnvFile(c echo.Context) error {
-	profile := c.QueryParam("profile")
-	if profile == "" {
-		profile = "default"
+	profile := requestProfile(c, c.QueryParam("profile"))
+	if resp := requireConfirmation(c, confirmExport, exportProfiles(profile, c.QueryParam("profiles"))...); resp != nil {
+		return c.JSON(http.StatusPreconditionRequired, resp)
+	}
+	if c.QueryParam("profiles") != "" {
+		return handleGetMultiProfileEnv(c)
 	}
 
-	creds, err := loadCachedCredentials(profile)
-	if err != nil {
-		return c.JSON(http.StatusNotFound, ErrorResponse{
-			Error: "No cached credentials found",
-		})
+	creds, status, errResp := loadUsableCredentials(profile)
+	if errResp != nil {
+		return c.JSON(status, errResp)
 	}
 
-	if !isCredentialsValid(creds) {
-		return c.JSON(http.StatusUnauthorized, ErrorResponse{
-			Error: "Credentials expired",
+	envContent, err := buildEnvContent(c, creds)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to fetch secrets",
+			Details: err.Error(),
 		})
 	}
 
-	envContent := fmt.Sprintf("AWS_ACCESS_KEY_ID=%s\nAWS_SECRET_ACCESS_KEY=%s\nAWS_SESSION_TOKEN=%s\n",
-		creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)
+	if c.QueryParam("mode") == "regions" {
+		return handleGetRegionEnvFiles(c, envContent)
+	}
 
 	return c.String(http.StatusOK, envContent)
 }
@@ -764,30 +1348,30 @@ func handleClearCredentials(c echo.Context) error {
 
 	if profile == "" {
 		// Clear all
-		files, _ := filepath.Glob(filepath.Join(getCacheDir(), "*.json"))
-		for _, f := range files {
-			if !strings.HasSuffix(f, "settings.json") {
-				os.Remove(f)
-			}
+		// Every source's sessions, not only the active one's
+		for _, cached := range cachedProfiles() {
+			removeSessionCopies(cached)
 		}
+		os.RemoveAll(getSessionsDir())
+		os.RemoveAll(filepath.Join(getCacheDir(), viewerCacheSubdir))
+		clearRoleSessions("")
+		events.publish(Event{Type: eventCleared})
+		recordAudit(AuditEntry{Action: "clear", Result: "ok", Details: "all sessions"})
 		return c.JSON(http.StatusOK, map[string]string{"message": "All credentials cleared"})
 	}
 
-	cacheFile := getCacheFile(profile)
-	if err := os.Remove(cacheFile); err != nil && !os.IsNotExist(err) {
+	if err := clearSession(profile); err != nil {
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
 			Error: "Failed to clear credentials",
 		})
 	}
+	recordAudit(AuditEntry{Action: "clear", Profile: profile, Result: "ok"})
 
 	return c.JSON(http.StatusOK, map[string]string{"message": "Credentials cleared for " + profile})
 }
 
 func handleExportEnvFile(c echo.Context) error {
-	profile := c.QueryParam("profile")
-	if profile == "" {
-		profile = "default"
-	}
+	profile := requestProfile(c, c.QueryParam("profile"))
 
 	outputPath := c.QueryParam("path")
 	if outputPath == "" {
@@ -796,23 +1380,32 @@ func handleExportEnvFile(c echo.Context) error {
 		})
 	}
 
-	creds, err := loadCachedCredentials(profile)
-	if err != nil {
-		return c.JSON(http.StatusNotFound, ErrorResponse{
-			Error: "No cached credentials found",
-		})
+	if resp := requireConfirmation(c, confirmExport, exportProfiles(profile, c.QueryParam("profiles"))...); resp != nil {
+		return c.JSON(http.StatusPreconditionRequired, resp)
+	}
+	if c.QueryParam("profiles") != "" {
+		return handleExportMultiProfileEnv(c, outputPath)
 	}
 
-	if !isCredentialsValid(creds) {
-		return c.JSON(http.StatusUnauthorized, ErrorResponse{
-			Error: "Credentials expired",
+	creds, status, errResp := loadUsableCredentials(profile)
+	if errResp != nil {
+		return c.JSON(status, errResp)
+	}
+
+	envContent, err := buildEnvContent(c, creds)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to fetch secrets",
+			Details: err.Error(),
 		})
 	}
 
-	envContent := fmt.Sprintf("AWS_ACCESS_KEY_ID=%s\nAWS_SECRET_ACCESS_KEY=%s\nAWS_SESSION_TOKEN=%s\n",
-		creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)
+	if c.QueryParam("mode") == "regions" {
+		return handleExportRegionEnvFiles(c, envContent, outputPath)
+	}
 
-	if err := os.WriteFile(outputPath, []byte(envContent), 0600); err != nil {
+	payload := &ExportPayload{Content: envContent, Sessions: []*CachedCredentials{creds}}
+	if _, err := deliverExport(c.Request().Context(), "file", ExportTarget{Path: outputPath}, payload); err != nil {
 		return c.JSON(http.StatusInternalServerError, ErrorResponse{
 			Error:   "Failed to write env file",
 			Details: err.Error(),
@@ -826,15 +1419,58 @@ func handleExportEnvFile(c echo.Context) error {
 }
 
 func main() {
-	var socketPath string
+	var socketPath, remoteSocket, listen string
+	var multiUser bool
 	flag.StringVar(&socketPath, "socket", "/run/guest-services/backend.sock", "Unix socket path")
+	flag.BoolVar(&multiUser, "multi-user", false, "Serve every OS user that connects, each with their own settings and cache")
+	flag.StringVar(&listen, "listen", "", "TCP address for remote read-only access in multi-user mode")
+	flag.StringVar(&remoteSocket, "remote-socket", "", "Serve remote read-only access on this Unix socket (set by the multi-user router)")
 	flag.Parse()
 
+	if multiUser {
+		if err := runTenantRouter(socketPath, listen); err != nil {
+			fmt.Fprintf(os.Stderr, "Multi-user router error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Ensure cache directory exists
 	os.MkdirAll(getCacheDir(), 0700)
+	// Move aside files a crash left truncated before anything reads them
+	_, quarantined := scanCacheIntegrity()
+	if len(quarantined) > 0 {
+		fmt.Fprintf(os.Stderr, "Quarantined %d corrupt cache files in %s\n", len(quarantined), getQuarantineDir())
+	}
+	migrated, err := migrateLegacySessions()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to migrate cached sessions: %v\n", err)
+	}
 
 	// Load settings on startup
-	loadSettings()
+	settings := loadSettings()
+	var started []ListenerChange
+	if remoteSocket != "" {
+		// Behind the multi-user router, which owns the TCP port and
+		// proxies every connection, so peer PIDs are the router's
+		processScope.unavailable = errBehindRouter
+		listeners.behindRouter = true
+		os.Remove(remoteSocket)
+		change := ListenerChange{Name: listenerRemoteAccess, Action: "started", Address: remoteSocket}
+		if listener, err := net.Listen("unix", remoteSocket); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to start remote access: %v\n", err)
+			change.Action, change.Error = "failed", err.Error()
+		} else {
+			serveRemote(listener)
+		}
+		started = append(started, change)
+	}
+	started = append(started, applyListenerSettings(settings)...)
+	for _, change := range started {
+		if change.Error != "" {
+			fmt.Fprintf(os.Stderr, "Failed to start %s: %s\n", change.Name, change.Error)
+		}
+	}
 
 	e := echo.New()
 	e.HideBanner = true
@@ -844,41 +1480,196 @@ func main() {
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORS())
 
+	// Routes that return or deliver credential material are only open to
+	// callers in the process scope while scoping is on
+	scoped := e.Group("", processScopeGate(false))
+
 	// Environment and settings routes
 	e.GET("/environment", handleGetEnvironment)
+	e.GET("/version", handleGetVersion)
+	e.GET("/startup-info", handleGetStartupInfo)
 	e.GET("/settings", handleGetSettings)
-	e.PUT("/settings", handleUpdateSettings)
+	e.PUT("/settings", handleUpdateSettings, processScopeGate(true))
+	e.POST("/reload", handleReload, processScopeGate(true))
 
 	// Profile and credential routes
 	e.GET("/profiles", handleGetProfiles)
+	e.GET("/profiles/backups", handleListBackups)
+	e.POST("/profiles/mfa-import", handleImportMFASerials)
+	e.POST("/profiles/backups/:id/restore", handleRestoreBackup)
+	e.POST("/profiles/:name/clone", handleCloneProfile)
+	e.POST("/profiles/:name/validate-keys", handleValidateKeys)
+	e.GET("/profiles/:name/discover-mfa", handleDiscoverMFA)
+	e.POST("/profiles/:name/discover-mfa", handleWriteDiscoveredMFA)
+	e.GET("/profiles/:name/raw", handleGetRawProfile)
+	e.PUT("/profiles/:name/raw", handlePutRawProfile)
+	e.GET("/identity", handleGetIdentity)
+	e.POST("/identity/refresh", handleRefreshIdentity)
 	e.GET("/status", handleGetStatus)
 	e.GET("/status/all", handleGetAllStatus)
+	e.GET("/snapshot", handleGetSnapshot)
 	e.POST("/login", handleLogin)
-	e.GET("/credentials", handleGetCredentials)
-	e.GET("/env", handleGetEnvFile)
-	e.POST("/env/export", handleExportEnvFile)
+	scoped.POST("/login-and-export", handleLoginAndExport)
+	e.GET("/mfa/devices", handleListMFADevices)
+	e.GET("/mfa/providers/status", handleMFAProvidersStatus)
+	e.GET("/token-sources", handleGetTokenSources)
+	e.PUT("/token-sources/totp/:profile", handleStoreTOTP, processScopeGate(true))
+	e.DELETE("/token-sources/totp/:profile", handleDeleteTOTP, processScopeGate(true))
+	e.GET("/sso/accounts", handleListSSOAccounts)
+	e.GET("/sso/roles", handleListSSORoles)
+	e.POST("/sso/start", handleSSOStart)
+	e.POST("/sso/poll", handleSSOPoll)
+	e.GET("/sso/sessions", handleListSSOSessions)
+	scoped.POST("/cli", handleRunCLI)
+	e.PUT("/s3/object", handlePutS3Object)
+	e.GET("/s3/object", handleGetS3Object)
+	scoped.GET("/credentials", handleGetCredentials)
+	scoped.GET("/console-url", handleConsoleURL)
+	e.POST("/credentials/validate", handleValidateCredentials)
+	scoped.GET("/env", handleGetEnvFile)
+	scoped.POST("/env/export", handleExportEnvFile)
 	e.DELETE("/credentials", handleClearCredentials)
+	e.GET("/cache/integrity", handleGetCacheIntegrity)
+	e.POST("/cache/repair", handleRepairCache)
+
+	// Role session routes
+	scoped.POST("/roles/assume", handleAssumeRole)
+	e.POST("/assume-role", handleAssumeRoleLogin)
+	e.GET("/organizations/accounts", handleListOrganizationAccounts)
+	e.GET("/roles/cache", handleRoleCacheStats)
+	e.GET("/roles/:arn/trust", handleRoleTrust)
+	e.GET("/sessions/:profile/lineage", handleGetLineage)
+	scoped.POST("/fanout", handleFanout)
+	e.GET("/export/sinks", handleListExportSinks)
+	e.GET("/export/jobs", handleListExportJobs)
+	e.GET("/export/jobs/:id", handleGetExportJob)
+	e.DELETE("/export/jobs/:id", handleDeleteExportJob)
+
+	// SAML federation routes
+	e.GET("/saml/roles", handleSAMLRoles)
+	e.POST("/saml/login", handleSAMLLogin)
+
+	// Confirmation routes
+	e.GET("/confirm", handleConfirm)
+
+	// Prompt routes
+	e.GET("/prompts", handleListPrompts)
+	e.GET("/prompts/:id", handleGetPrompt)
+	e.POST("/prompts/:id/complete", handleCompletePrompt)
+	e.DELETE("/prompts/:id", handleDismissPrompt)
+
+	// Process scoping routes
+	e.GET("/process-scope", handleGetProcessScope)
+	e.POST("/process-scope/parents", handleRegisterParent, processScopeGate(true))
+	e.DELETE("/process-scope/parents/:pid", handleUnregisterParent, processScopeGate(true))
+	scoped.POST("/export", handleExport)
+	scoped.GET("/export/claim/:token", handleClaimExport)
+	scoped.GET("/export/vault", handleGetVaultPayload)
+	e.GET("/capability-links", handleListCapabilityOps)
+	scoped.POST("/capability-links", handleCreateCapabilityLink)
+	scoped.GET("/capability-links/:token", handleRedeemCapabilityLink)
+
+	// Session tooling routes
+	e.POST("/simulate", handleSimulate)
+	e.POST("/ecr/repositories", handleCreateRepository)
+	e.GET("/ecr/repositories", handleListECRRepositories)
+	e.GET("/ecr/images", handleListECRImages)
+	e.GET("/ecr/manifest", handleGetECRManifest)
+	e.GET("/ecr/cache", handleECRCacheStats)
+	e.DELETE("/ecr/cache", handleFlushECRCache)
+
+	// Messaging smoke test routes
+	e.GET("/sqs/queues", handleListQueues)
+	e.POST("/sqs/send", handleSendTestMessage)
+	e.GET("/sns/topics", handleListTopics)
+	e.POST("/sns/publish", handlePublishTestMessage)
+
+	// Team metadata sync routes
+	e.GET("/team/manifest", handleGetTeamManifest)
+	e.POST("/team/push", handlePushTeamManifest)
+	e.POST("/team/pull", handlePullTeamManifest)
+
+	// Application secrets routes
+	scoped.GET("/secrets", handleGetAppSecrets)
+
+	// DynamoDB browser routes
+	e.GET("/dynamodb/local", handleListDynamoLocal)
+	e.GET("/dynamodb/tables", handleListDynamoTables)
+	e.GET("/dynamodb/tables/:name", handleDescribeDynamoTable)
+	e.GET("/dynamodb/tables/:name/scan", handleScanDynamoTable)
+
+	// Container credential routes
+	scoped.POST("/inject", handleInjectCredentials)
+	e.GET("/inventory", handleGetInventory)
+	e.POST("/inventory/revoke", handleRevokeInventory)
+	e.PUT("/inventory/renewal", handleSetRenewalPolicy)
+	e.GET("/compose/projects", handleGetComposeProjects)
+	e.GET("/sandboxes", handleListSandboxes)
+	e.POST("/sandboxes", handleCreateSandbox)
+	e.DELETE("/sandboxes/:name", handleDestroySandbox)
+
+	// Event stream and remote access routes
+	e.GET("/events", handleEvents)
+	e.GET("/notifications/channels", handleListNotificationChannels)
+	e.POST("/notifications/:name/test", handleTestNotification)
+	e.POST("/remote/tokens", handleIssueRemoteToken)
+	e.DELETE("/remote/tokens", handleRotateRemoteKey)
+
+	// Audit and policy routes
+	e.GET("/audit", handleGetAudit)
+	e.GET("/history/timeline", handleHistoryTimeline)
+	e.GET("/scheduler", handleGetScheduler)
+	e.POST("/jobs/validate", handleValidateJobs)
+	e.POST("/policies/evaluate", handleEvaluatePolicies)
+	e.GET("/reports/hygiene", handleGetHygieneReport)
+	e.POST("/reports/hygiene/refresh", handleRefreshHygieneReport)
+
+	// Broker API for other extensions
+	scoped.POST("/broker/consumers/:name/token", handleIssueBrokerToken)
+	scoped.DELETE("/broker/consumers/:name/token", handleRevokeBrokerToken)
+	broker := e.Group("/broker/v1", brokerAuth)
+	broker.GET("/status", handleBrokerStatus)
+	broker.GET("/credential-process", handleBrokerCredentialProcess, processScopeGate(false))
+	broker.GET("/events", handleBrokerEvents)
+
+	// Diagnostics routes
+	e.GET("/network/probe", handleNetworkProbe)
+	e.GET("/regions/latency", handleRegionLatency)
 
 	// Health check
 	e.GET("/health", func(c echo.Context) error {
 		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
 	})
 
-	// Remove existing socket file
-	os.Remove(socketPath)
-
-	// Listen on Unix socket
-	listener, err := net.Listen("unix", socketPath)
+	// Background jobs
+	scheduler.every("policy", policyInterval, runPolicyEvaluation)
+	scheduler.every("renew-exports", renewalInterval, runExportRenewal)
+	scheduler.every("validate-keys", keyCheckTick, runKeyValidation)
+	scheduler.every("hygiene-report", hygieneTick, runHygieneReport)
+	scheduler.every("source-health", sourceHealthInterval, runSourceHealth)
+	scheduler.every("export-queue", exportQueueTick, processExportQueue)
+	scheduler.start(context.Background())
+	go runSourceHealth(context.Background())
+	// Resume exports queued before a restart
+	go processExportQueue(context.Background())
+	go watchLabeledContainers(context.Background())
+
+	// Listen on Unix socket, replacing any stale socket file
+	listener, err := bindSocket(socketPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to listen on socket: %v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("Backend listening on %s\n", socketPath)
-	fmt.Printf("Environment: WSL2=%v, Windows=%v, Linux=%v\n", isWSL2(), runtime.GOOS == "windows", runtime.GOOS == "linux")
+	listeners.mu.Lock()
+	listeners.socketPath, listeners.socket = socketPath, listener
+	listeners.main = &http.Server{Handler: e, ConnContext: withPeerPID}
+	listeners.mu.Unlock()
+	serveSocket(listener)
+	watchReloadSignal()
+	recordStartup(socketPath, started, len(quarantined), migrated)
 
-	if err := http.Serve(listener, e); err != nil && err != io.EOF {
-		fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
-		os.Exit(1)
-	}
+	// Serve until the process is stopped; a reload may move the socket to
+	// a new listener
+	select {}
 }
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	testMessageGroupID = "aws-mfa-extension-test"
+	fifoSuffix         = ".fifo"
+)
+
+type TestPublishRequest struct {
+	Profile string `json:"profile"`
+	Region  string `json:"region,omitempty"`
+	Target  string `json:"target"` // queue URL or topic ARN
+	Message string `json:"message,omitempty"`
+	Subject string `json:"subject,omitempty"`
+}
+
+type TestPublishResponse struct {
+	Target    string `json:"target"`
+	Region    string `json:"region"`
+	MessageID string `json:"messageId"`
+}
+
+// messagingConfig returns the session config, optionally pointed at another
+// region so users can check whether the right one is configured
+func messagingConfig(ctx context.Context, profile, region string) (aws.Config, error) {
+	cfg, _, err := sessionAWSConfig(ctx, profile)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	if region != "" {
+		cfg.Region = region
+	}
+	return cfg, nil
+}
+
+func defaultTestMessage() string {
+	return fmt.Sprintf("Test message from the AWS MFA Docker extension at %s", time.Now().UTC().Format(time.RFC3339))
+}
+
+// fifoParams returns the group and deduplication IDs FIFO targets require
+func fifoParams(target string) (*string, *string) {
+	if !strings.HasSuffix(target, fifoSuffix) {
+		return nil, nil
+	}
+	return aws.String(testMessageGroupID), aws.String(strconv.FormatInt(time.Now().UnixNano(), 10))
+}
+
+func bindTestPublish(c echo.Context) (*TestPublishRequest, error) {
+	var req TestPublishRequest
+	if err := c.Bind(&req); err != nil {
+		return nil, fmt.Errorf("invalid request body")
+	}
+	if req.Target == "" {
+		return nil, fmt.Errorf("target is required")
+	}
+	if req.Message == "" {
+		req.Message = defaultTestMessage()
+	}
+	return &req, nil
+}
+
+func handleListQueues(c echo.Context) error {
+	ctx := c.Request().Context()
+	cfg, err := messagingConfig(ctx, requestProfile(c, c.QueryParam("profile")), c.QueryParam("region"))
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error:   "No usable session",
+			Details: err.Error(),
+		})
+	}
+
+	queues := []string{}
+	paginator := sqs.NewListQueuesPaginator(sqs.NewFromConfig(cfg), &sqs.ListQueuesInput{
+		QueueNamePrefix: optionalString(c.QueryParam("prefix")),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return c.JSON(http.StatusBadGateway, ErrorResponse{
+				Error:   "Failed to list queues",
+				Details: err.Error(),
+			})
+		}
+		queues = append(queues, page.QueueUrls...)
+	}
+
+	return c.JSON(http.StatusOK, queues)
+}
+
+func handleSendTestMessage(c echo.Context) error {
+	req, err := bindTestPublish(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	ctx := c.Request().Context()
+	cfg, err := messagingConfig(ctx, requestProfile(c, req.Profile), req.Region)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error:   "No usable session",
+			Details: err.Error(),
+		})
+	}
+
+	groupID, dedupID := fifoParams(req.Target)
+	out, err := sqs.NewFromConfig(cfg).SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:               aws.String(req.Target),
+		MessageBody:            aws.String(req.Message),
+		MessageGroupId:         groupID,
+		MessageDeduplicationId: dedupID,
+	})
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to send message",
+			Details: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, TestPublishResponse{
+		Target:    req.Target,
+		Region:    cfg.Region,
+		MessageID: aws.ToString(out.MessageId),
+	})
+}
+
+func handleListTopics(c echo.Context) error {
+	ctx := c.Request().Context()
+	cfg, err := messagingConfig(ctx, requestProfile(c, c.QueryParam("profile")), c.QueryParam("region"))
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error:   "No usable session",
+			Details: err.Error(),
+		})
+	}
+
+	topics := []string{}
+	paginator := sns.NewListTopicsPaginator(sns.NewFromConfig(cfg), &sns.ListTopicsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return c.JSON(http.StatusBadGateway, ErrorResponse{
+				Error:   "Failed to list topics",
+				Details: err.Error(),
+			})
+		}
+		for _, t := range page.Topics {
+			topics = append(topics, aws.ToString(t.TopicArn))
+		}
+	}
+
+	return c.JSON(http.StatusOK, topics)
+}
+
+func handlePublishTestMessage(c echo.Context) error {
+	req, err := bindTestPublish(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	ctx := c.Request().Context()
+	cfg, err := messagingConfig(ctx, requestProfile(c, req.Profile), req.Region)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error:   "No usable session",
+			Details: err.Error(),
+		})
+	}
+
+	groupID, dedupID := fifoParams(req.Target)
+	out, err := sns.NewFromConfig(cfg).Publish(ctx, &sns.PublishInput{
+		TopicArn:               aws.String(req.Target),
+		Message:                aws.String(req.Message),
+		Subject:                optionalString(req.Subject),
+		MessageGroupId:         groupID,
+		MessageDeduplicationId: dedupID,
+	})
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to publish message",
+			Details: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, TestPublishResponse{
+		Target:    req.Target,
+		Region:    cfg.Region,
+		MessageID: aws.ToString(out.MessageId),
+	})
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
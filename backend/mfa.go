@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/labstack/echo/v4"
+)
+
+// STS only accepts six-digit codes from virtual or hardware TOTP devices.
+// FIDO security keys work for console sign-in, but GetSessionToken and
+// AssumeRole have no way to take a WebAuthn assertion, so a host helper
+// can't hand one off either. Profiles pointing at a FIDO key are detected
+// and steered towards a TOTP device registered on the same user.
+const (
+	MFATypeTOTP = "totp"
+	MFATypeFIDO = "fido"
+)
+
+var errFIDOUnsupported = errors.New("FIDO security keys can't be used for API sessions")
+
+// Token code helpers are local tools that print the current TOTP code for
+// a profile, so a login can leave the code out
+const (
+	// codeHelperTimeout leaves time for a helper waiting on the user, such
+	// as a key to be touched or a password manager to be unlocked
+	codeHelperTimeout = 30 * time.Second
+	// maxCodeHelperOutput bounds what is read from a helper; a code or an
+	// account list is a few lines
+	maxCodeHelperOutput = 64 * 1024
+)
+
+var (
+	totpCodePattern      = regexp.MustCompile(`^\d{6}$`)
+	errCodeHelperTimeout = errors.New("timed out")
+)
+
+type MFADevice struct {
+	SerialNumber string `json:"serialNumber"`
+	Type         string `json:"type"`
+	UsableForCLI bool   `json:"usableForCli"`
+}
+
+// mfaType classifies an mfa_serial. FIDO keys are registered under the
+// u2f resource type; everything else is a TOTP device (virtual MFA ARNs
+// or hardware token serial numbers).
+func mfaType(serial string) string {
+	if strings.Contains(serial, ":u2f/") {
+		return MFATypeFIDO
+	}
+	return MFATypeTOTP
+}
+
+// listMFADevices returns the devices registered on the profile's IAM user,
+// using its long-term keys
+func listMFADevices(ctx context.Context, profile string) ([]MFADevice, error) {
+	cfg, err := baseKeysConfig(ctx, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := iam.NewFromConfig(cfg).ListMFADevices(ctx, &iam.ListMFADevicesInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	return toMFADevices(out.MFADevices), nil
+}
+
+func toMFADevices(registered []iamtypes.MFADevice) []MFADevice {
+	devices := make([]MFADevice, 0, len(registered))
+	for _, d := range registered {
+		serial := aws.ToString(d.SerialNumber)
+		kind := mfaType(serial)
+		devices = append(devices, MFADevice{SerialNumber: serial, Type: kind, UsableForCLI: kind == MFATypeTOTP})
+	}
+	return devices
+}
+
+// checkMFASerial rejects FIDO serials before a token code is wasted on
+// them, naming any TOTP device the user could switch mfa_serial to
+func checkMFASerial(ctx context.Context, profile, serial string) error {
+	if mfaType(serial) != MFATypeFIDO {
+		return nil
+	}
+
+	hint := "register a virtual MFA device on the IAM user and set it as mfa_serial, or use IAM Identity Center (SSO)"
+	if devices, err := listMFADevices(ctx, profile); err == nil {
+		for _, d := range devices {
+			if d.UsableForCLI {
+				hint = "set mfa_serial = " + d.SerialNumber + " for profile " + profile
+				break
+			}
+		}
+	}
+	return fmt.Errorf("%w: %s is a security key; %s", errFIDOUnsupported, serial, hint)
+}
+
+// handleListMFADevices lists the profile's IAM MFA devices, or with
+// ?source=yubikey the OATH accounts on the local YubiKey
+func handleListMFADevices(c echo.Context) error {
+	if c.QueryParam("source") == mfaSourceYubiKey {
+		return handleListYubiKeyAccounts(c)
+	}
+	profile := requestProfile(c, c.QueryParam("profile"))
+	devices, err := listMFADevices(c.Request().Context(), profile)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to list MFA devices",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, devices)
+}
+
+// runCodeHelper runs a token code helper and returns its output. Stdout
+// holds codes, so only stderr goes into errors.
+func runCodeHelper(ctx context.Context, tool, command string, args ...string) (string, error) {
+	return runCodeHelperEnv(ctx, nil, tool, command, args...)
+}
+
+// runCodeHelperEnv is runCodeHelper with env in place of the backend's
+// environment, or the backend's when env is nil
+func runCodeHelperEnv(ctx context.Context, env []string, tool, command string, args ...string) (string, error) {
+	return runCodeHelperCmd(ctx, tool, func(ctx context.Context) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, command, args...)
+		cmd.Env = env
+		return cmd
+	})
+}
+
+// runCodeHelperCmd runs the command build makes with the helper timeout
+func runCodeHelperCmd(ctx context.Context, tool string, build func(ctx context.Context) *exec.Cmd) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, codeHelperTimeout)
+	defer cancel()
+
+	stdout := &cappedBuffer{max: maxCodeHelperOutput}
+	stderr := &cappedBuffer{max: maxHookOutput}
+	cmd := build(ctx)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = hookWaitDelay
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return "", fmt.Errorf("%s %w after %s", tool, errCodeHelperTimeout, codeHelperTimeout)
+	case errors.As(err, &exitErr):
+		return "", fmt.Errorf("%s exited with %d: %s", tool, exitErr.ExitCode(), strings.TrimSpace(truncateOutput(stderr.buf.String())))
+	case err != nil:
+		return "", fmt.Errorf("%s: %w", tool, err)
+	}
+	return stdout.buf.String(), nil
+}
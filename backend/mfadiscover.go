@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"gopkg.in/ini.v1"
+)
+
+// Many users never set mfa_serial, and profiles without one can't log in
+// with MFA. GET /profiles/:name/discover-mfa lists the devices registered
+// on the IAM user behind the profile's long-term keys, and POST writes the
+// chosen one into the profile's config section.
+
+var (
+	errNoUsableMFADevice = errors.New("no virtual or hardware TOTP device is registered on the IAM user")
+	errMFASerialSet      = errors.New("profile already has a different mfa_serial")
+	errUnknownMFADevice  = errors.New("device isn't registered on the IAM user")
+)
+
+// MFADiscovery is what discovery found for Profile. Suggested is the device
+// to write, empty when Current is already a usable one.
+type MFADiscovery struct {
+	Profile   string      `json:"profile"`
+	Current   string      `json:"current,omitempty"`
+	Devices   []MFADevice `json:"devices"`
+	Suggested string      `json:"suggested,omitempty"`
+	Written   bool        `json:"written,omitempty"`
+}
+
+type WriteMFASerialRequest struct {
+	// SerialNumber picks a device; by default the suggested one is written
+	SerialNumber string `json:"serialNumber,omitempty"`
+	// Replace allows overwriting a different mfa_serial
+	Replace bool `json:"replace,omitempty"`
+}
+
+// profileMFASerial reads profile's own mfa_serial, "" when it has none or
+// no config section
+func profileMFASerial(profile string) string {
+	section, err := getProfileSection(profile)
+	if err != nil || !section.HasKey("mfa_serial") {
+		return ""
+	}
+	return section.Key("mfa_serial").String()
+}
+
+func suggestMFADevice(current string, devices []MFADevice) string {
+	for _, d := range devices {
+		if d.SerialNumber == current && d.UsableForCLI {
+			return ""
+		}
+	}
+	for _, d := range devices {
+		if d.UsableForCLI {
+			return d.SerialNumber
+		}
+	}
+	return ""
+}
+
+func discoverMFA(c echo.Context, profile string) (*MFADiscovery, error) {
+	devices, err := listMFADevices(c.Request().Context(), profile)
+	if err != nil {
+		return nil, err
+	}
+	current := profileMFASerial(profile)
+	return &MFADiscovery{
+		Profile:   profile,
+		Current:   current,
+		Devices:   devices,
+		Suggested: suggestMFADevice(current, devices),
+	}, nil
+}
+
+// writeMFASerial sets mfa_serial on profile, adding a config section for a
+// profile that only has keys in the credentials file
+func writeMFASerial(profile, serial string, replace bool) error {
+	return modifyIniFile(getAWSConfigPath(), func(cfg *ini.File) error {
+		name := profileSectionName(profile)
+		section, err := cfg.GetSection(name)
+		if err != nil {
+			if section, err = cfg.NewSection(name); err != nil {
+				return err
+			}
+		}
+		if section.HasKey("mfa_serial") {
+			current := section.Key("mfa_serial").String()
+			if current != "" && current != serial && !replace {
+				return fmt.Errorf("%w: %s", errMFASerialSet, current)
+			}
+		}
+		section.Key("mfa_serial").SetValue(serial)
+		return nil
+	})
+}
+
+func handleDiscoverMFA(c echo.Context) error {
+	profile := c.Param("name")
+	discovery, err := discoverMFA(c, profile)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to list MFA devices",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, discovery)
+}
+
+// handleWriteDiscoveredMFA writes a discovered device as the profile's
+// mfa_serial. Only devices registered on the user can be written.
+func handleWriteDiscoveredMFA(c echo.Context) error {
+	var req WriteMFASerialRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	profile := c.Param("name")
+	discovery, err := discoverMFA(c, profile)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to list MFA devices",
+			Details: err.Error(),
+		})
+	}
+
+	serial := req.SerialNumber
+	if serial == "" {
+		serial = discovery.Suggested
+	}
+	if serial == "" && discovery.Current != "" {
+		// The current device is already the one to use
+		return c.JSON(http.StatusOK, discovery)
+	}
+	if serial == "" {
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "No usable MFA device",
+			Details: errNoUsableMFADevice.Error(),
+		})
+	}
+
+	var device *MFADevice
+	for i := range discovery.Devices {
+		if discovery.Devices[i].SerialNumber == serial {
+			device = &discovery.Devices[i]
+		}
+	}
+	switch {
+	case device == nil:
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Unknown MFA device",
+			Details: fmt.Sprintf("%s: %v", serial, errUnknownMFADevice),
+		})
+	case !device.UsableForCLI:
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "MFA device can't be used for API sessions",
+			Details: errFIDOUnsupported.Error(),
+		})
+	}
+
+	if err := writeMFASerial(profile, serial, req.Replace); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errMFASerialSet) {
+			status = http.StatusConflict
+		}
+		return c.JSON(status, ErrorResponse{
+			Error:   "Failed to write mfa_serial",
+			Details: err.Error(),
+		})
+	}
+	recordAudit(AuditEntry{
+		Action:  "discover-mfa",
+		Profile: profile,
+		Result:  "written",
+		Fields:  map[string]string{"mfaSerial": serial, "previous": discovery.Current},
+	})
+
+	discovery.Current = serial
+	discovery.Suggested = ""
+	discovery.Written = true
+	return c.JSON(http.StatusOK, discovery)
+}
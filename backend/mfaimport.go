@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/ini.v1"
+)
+
+// POST /profiles/mfa-import fills in mfa_serial for many profiles at once,
+// for an admin setting up a machine with developers' keys. It reads the
+// IAM credential report with the admin's session to find the users with
+// MFA and active keys. The report doesn't include key IDs, so those users'
+// keys are listed to match them to the profiles' access key IDs, and each
+// matched user's MFA devices are listed to pick the serial.
+
+const (
+	mfaImportTimeout     = 2 * time.Minute
+	credentialReportWait = 2 * time.Second
+
+	MFAImportWritten = "written"
+	MFAImportPlanned = "planned"
+	MFAImportSkipped = "skipped"
+)
+
+var errCredentialReportPending = errors.New("the IAM credential report is still being generated; try again shortly")
+
+type MFAImportRequest struct {
+	// Profile is the session that reads the report; it needs
+	// iam:GenerateCredentialReport, iam:GetCredentialReport,
+	// iam:ListAccessKeys and iam:ListMFADevices
+	Profile string `json:"profile,omitempty"`
+	// Profiles limits the import to these profiles
+	Profiles []string `json:"profiles,omitempty"`
+	DryRun   bool     `json:"dryRun,omitempty"`
+}
+
+// MFAImportEntry is what happened to one profile with keys. Status is
+// "written", "planned" on a dry run, or "skipped" with the reason.
+type MFAImportEntry struct {
+	Profile      string `json:"profile"`
+	User         string `json:"user,omitempty"`
+	AccessKeyID  string `json:"accessKeyId,omitempty"`
+	SerialNumber string `json:"serialNumber,omitempty"`
+	Status       string `json:"status"`
+	Reason       string `json:"reason,omitempty"`
+}
+
+type MFAImportResult struct {
+	Profile           string           `json:"profile"`
+	DryRun            bool             `json:"dryRun,omitempty"`
+	ReportGeneratedAt *time.Time       `json:"reportGeneratedAt,omitempty"`
+	Entries           []MFAImportEntry `json:"entries"`
+	Written           int              `json:"written"`
+}
+
+// reportUser is a row of the credential report
+type reportUser struct {
+	Name       string
+	MFAActive  bool
+	ActiveKeys bool
+}
+
+// fetchCredentialReport has IAM generate the report, or reuse one from the
+// last four hours, and reads it
+func fetchCredentialReport(ctx context.Context, client *iam.Client) ([]byte, time.Time, error) {
+	for {
+		gen, err := client.GenerateCredentialReport(ctx, &iam.GenerateCredentialReportInput{})
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if gen.State == iamtypes.ReportStateTypeComplete {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, time.Time{}, errCredentialReportPending
+		case <-time.After(credentialReportWait):
+		}
+	}
+	out, err := client.GetCredentialReport(ctx, &iam.GetCredentialReportInput{})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return out.Content, aws.ToTime(out.GeneratedTime), nil
+}
+
+// parseCredentialReport reads the users out of the report's CSV
+func parseCredentialReport(content []byte) ([]reportUser, error) {
+	r := csv.NewReader(bytes.NewReader(content))
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading the credential report: %w", err)
+	}
+	column := map[string]int{}
+	for i, name := range header {
+		column[name] = i
+	}
+	for _, name := range []string{"user", "mfa_active", "access_key_1_active", "access_key_2_active"} {
+		if _, ok := column[name]; !ok {
+			return nil, fmt.Errorf("the credential report has no %s column", name)
+		}
+	}
+
+	var users []reportUser
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading the credential report: %w", err)
+		}
+		users = append(users, reportUser{
+			Name:       row[column["user"]],
+			MFAActive:  row[column["mfa_active"]] == "true",
+			ActiveKeys: row[column["access_key_1_active"]] == "true" || row[column["access_key_2_active"]] == "true",
+		})
+	}
+	return users, nil
+}
+
+// profileAccessKeys maps the access key IDs in the credentials file to the
+// profiles using them that have no mfa_serial, leaving out derived session
+// sections; only profiles in names count, when given
+func profileAccessKeys(names []string) (map[string][]string, error) {
+	credsPath := getAWSCredentialsPath()
+	creds, err := ini.Load(credsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS credentials from %s: %w", credsPath, err)
+	}
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+	byKey := map[string][]string{}
+	for _, section := range creds.Sections() {
+		profile := section.Name()
+		if (len(wanted) > 0 && !wanted[profile]) || profileMFASerial(profile) != "" || derivedFrom(section) != "" {
+			continue
+		}
+		if accessKey := sectionValue(section, "aws_access_key_id"); accessKey != "" {
+			byKey[accessKey] = append(byKey[accessKey], profile)
+		}
+	}
+	return byKey, nil
+}
+
+// planMFAImport matches the profiles' keys to report users and picks each
+// user's first TOTP device
+func planMFAImport(ctx context.Context, client *iam.Client, users []reportUser, byKey map[string][]string) ([]MFAImportEntry, error) {
+	var entries []MFAImportEntry
+	matched := map[string]bool{}
+	for _, user := range users {
+		if !user.ActiveKeys || len(matched) == len(byKey) {
+			continue
+		}
+		keys, err := client.ListAccessKeys(ctx, &iam.ListAccessKeysInput{UserName: aws.String(user.Name)})
+		if err != nil {
+			return nil, fmt.Errorf("listing the access keys of %s: %w", user.Name, err)
+		}
+
+		var devices []MFADevice
+		for _, key := range keys.AccessKeyMetadata {
+			keyID := aws.ToString(key.AccessKeyId)
+			profiles := byKey[keyID]
+			if len(profiles) == 0 {
+				continue
+			}
+			matched[keyID] = true
+
+			entry := MFAImportEntry{User: user.Name, AccessKeyID: keyID, Status: MFAImportSkipped}
+			if !user.MFAActive {
+				entry.Reason = "the user has no MFA device"
+			} else {
+				if devices == nil {
+					out, err := client.ListMFADevices(ctx, &iam.ListMFADevicesInput{UserName: aws.String(user.Name)})
+					if err != nil {
+						return nil, fmt.Errorf("listing the MFA devices of %s: %w", user.Name, err)
+					}
+					devices = toMFADevices(out.MFADevices)
+				}
+				if entry.SerialNumber = suggestMFADevice("", devices); entry.SerialNumber != "" {
+					entry.Status = MFAImportPlanned
+				} else {
+					entry.Reason = "the user only has security keys, which STS doesn't accept"
+				}
+			}
+			for _, profile := range profiles {
+				entry.Profile = profile
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	for keyID, profiles := range byKey {
+		if matched[keyID] {
+			continue
+		}
+		for _, profile := range profiles {
+			entries = append(entries, MFAImportEntry{
+				Profile:     profile,
+				AccessKeyID: keyID,
+				Status:      MFAImportSkipped,
+				Reason:      "no user in the session's account with active keys has this key",
+			})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Profile < entries[j].Profile })
+	return entries, nil
+}
+
+func hasPlannedImport(entries []MFAImportEntry) bool {
+	for _, e := range entries {
+		if e.Status == MFAImportPlanned {
+			return true
+		}
+	}
+	return false
+}
+
+// applyMFAImport writes the planned serials in one edit of the config
+// file, leaving profiles that have an mfa_serial by then alone
+func applyMFAImport(entries []MFAImportEntry) error {
+	return modifyIniFile(getAWSConfigPath(), func(cfg *ini.File) error {
+		for i := range entries {
+			e := &entries[i]
+			if e.Status != MFAImportPlanned {
+				continue
+			}
+			name := profileSectionName(e.Profile)
+			section, err := cfg.GetSection(name)
+			if err != nil {
+				if section, err = cfg.NewSection(name); err != nil {
+					return err
+				}
+			}
+			if current := sectionValue(section, "mfa_serial"); current != "" {
+				e.Status = MFAImportSkipped
+				e.Reason = "the profile already has mfa_serial " + current
+				continue
+			}
+			section.Key("mfa_serial").SetValue(e.SerialNumber)
+			e.Status = MFAImportWritten
+		}
+		return nil
+	})
+}
+
+// handleImportMFASerials is POST /profiles/mfa-import. Only profiles with
+// keys and no mfa_serial are looked at; with dryRun nothing is written.
+func handleImportMFASerials(c echo.Context) error {
+	var req MFAImportRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	req.Profile = requestProfile(c, req.Profile)
+
+	byKey, err := profileAccessKeys(req.Profiles)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read profiles",
+			Details: err.Error(),
+		})
+	}
+	result := &MFAImportResult{Profile: req.Profile, DryRun: req.DryRun, Entries: []MFAImportEntry{}}
+	if len(byKey) == 0 {
+		return c.JSON(http.StatusOK, result)
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), mfaImportTimeout)
+	defer cancel()
+	cfg, _, err := sessionAWSConfig(ctx, req.Profile)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error:   "No valid session",
+			Details: err.Error(),
+		})
+	}
+	client := iam.NewFromConfig(cfg)
+
+	content, generatedAt, err := fetchCredentialReport(ctx, client)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to get the IAM credential report",
+			Details: err.Error(),
+		})
+	}
+	users, err := parseCredentialReport(content)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to get the IAM credential report",
+			Details: err.Error(),
+		})
+	}
+	entries, err := planMFAImport(ctx, client, users, byKey)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to match users to profiles",
+			Details: err.Error(),
+		})
+	}
+
+	if !generatedAt.IsZero() {
+		result.ReportGeneratedAt = &generatedAt
+	}
+	result.Entries = append(result.Entries, entries...)
+	if req.DryRun || !hasPlannedImport(entries) {
+		return c.JSON(http.StatusOK, result)
+	}
+
+	if err := applyMFAImport(result.Entries); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to write mfa_serial",
+			Details: err.Error(),
+		})
+	}
+	var written []string
+	for _, e := range result.Entries {
+		if e.Status == MFAImportWritten {
+			written = append(written, e.Profile)
+		}
+	}
+	result.Written = len(written)
+	recordAudit(AuditEntry{
+		Action:  "mfa-import",
+		Profile: req.Profile,
+		Result:  "written",
+		Fields:  map[string]string{"profiles": strings.Join(written, ",")},
+	})
+	return c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// providerCheckTimeout bounds each provider's status check, which should
+// never wait on the user the way reading a code can
+const providerCheckTimeout = 5 * time.Second
+
+// MFAProviderStatus is whether a token code helper could produce a code
+// now. State is "disabled", "not-installed" or "timeout" for any provider,
+// otherwise provider-specific: "connected" or "absent" for a YubiKey,
+// "signed-in" or "signed-out" for 1Password, Bitwarden's vault status,
+// "unlocked", "locked" or "unauthenticated", and "ready" or "no-store" for
+// pass.
+type MFAProviderStatus struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Command string `json:"command,omitempty"`
+	State   string `json:"state"`
+	Details string `json:"details,omitempty"`
+	// Profiles read their codes from this provider
+	Profiles []string `json:"profiles"`
+}
+
+// providerCheck is one provider's status check. check returns the state,
+// with an error to report as details, or just an error when it failed.
+type providerCheck struct {
+	name    string
+	enabled bool
+	command string
+	check   func(ctx context.Context) (string, error)
+}
+
+func mfaProviderChecks(settings *Settings) []providerCheck {
+	var checks []providerCheck
+
+	yk := settings.YubiKey
+	ykCheck := providerCheck{name: mfaSourceYubiKey, command: defaultYkmanCommand}
+	if yk != nil && yk.Enabled {
+		ykCheck.enabled = true
+		if yk.Command != "" {
+			ykCheck.command = yk.Command
+		}
+		ykCheck.check = func(ctx context.Context) (string, error) {
+			out, err := runCodeHelper(ctx, "ykman", ykCheck.command, "list", "--serials")
+			if err != nil {
+				return "", err
+			}
+			for _, serial := range strings.Fields(out) {
+				if yk.Device == "" || serial == yk.Device {
+					return "connected", nil
+				}
+			}
+			return "absent", nil
+		}
+	}
+	checks = append(checks, ykCheck)
+
+	op := settings.OnePassword
+	opCheck := providerCheck{name: mfaSourceOnePassword, command: defaultOpCommand}
+	if op != nil && op.Enabled {
+		opCheck.enabled = true
+		if op.Command != "" {
+			opCheck.command = op.Command
+		}
+		opCheck.check = func(ctx context.Context) (string, error) {
+			args := []string{"whoami"}
+			if op.Account != "" {
+				args = append(args, "--account", op.Account)
+			}
+			if _, err := runCodeHelper(ctx, "op", opCheck.command, args...); err != nil {
+				if errors.Is(err, errCodeHelperTimeout) {
+					return "", err
+				}
+				return "signed-out", err
+			}
+			return "signed-in", nil
+		}
+	}
+	checks = append(checks, opCheck)
+
+	bw := settings.Bitwarden
+	bwCheck := providerCheck{name: mfaSourceBitwarden, command: defaultBwCommand}
+	if bw != nil && bw.Enabled {
+		bwCheck.enabled = true
+		if bw.Command != "" {
+			bwCheck.command = bw.Command
+		}
+		bwCheck.check = func(ctx context.Context) (string, error) {
+			return bitwardenStatus(ctx, bw)
+		}
+	}
+	checks = append(checks, bwCheck)
+
+	pass := settings.Pass
+	passCheck := providerCheck{name: mfaSourcePass, command: defaultPassCommand}
+	if pass != nil && pass.Enabled {
+		passCheck.enabled = true
+		if pass.Command != "" {
+			passCheck.command = pass.Command
+		}
+		// Decrypting an entry could bring up pinentry, so only the store
+		// is checked: an initialized one has a .gpg-id
+		passCheck.check = func(context.Context) (string, error) {
+			if _, err := os.Stat(filepath.Join(passStoreDir(pass), ".gpg-id")); err != nil {
+				return "no-store", err
+			}
+			return "ready", nil
+		}
+	}
+	return append(checks, passCheck)
+}
+
+// checkMFAProvider runs a provider's status check with its own timeout
+func checkMFAProvider(ctx context.Context, p providerCheck) MFAProviderStatus {
+	status := MFAProviderStatus{Name: p.name, Enabled: p.enabled, Command: p.command, Profiles: []string{}}
+	if !p.enabled {
+		status.State = "disabled"
+		return status
+	}
+	if _, err := exec.LookPath(p.command); err != nil {
+		status.State, status.Details = "not-installed", err.Error()
+		return status
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, providerCheckTimeout)
+	defer cancel()
+	state, err := p.check(ctx)
+	switch {
+	case errors.Is(err, errCodeHelperTimeout):
+		status.State, status.Details = "timeout", "no answer within "+providerCheckTimeout.String()
+	case err != nil && state == "":
+		status.State, status.Details = "error", err.Error()
+	default:
+		status.State = state
+		if err != nil {
+			status.Details = err.Error()
+		}
+	}
+	return status
+}
+
+// getMFAProvidersStatus checks every token code helper at once
+func getMFAProvidersStatus(ctx context.Context) []MFAProviderStatus {
+	settings := loadSettings()
+	checks := mfaProviderChecks(settings)
+	statuses := make([]MFAProviderStatus, len(checks))
+
+	var wg sync.WaitGroup
+	for i, p := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = checkMFAProvider(ctx, p)
+		}()
+	}
+	wg.Wait()
+
+	for profile := range settings.Profiles {
+		source := tokenCodeSource(profile)
+		for i := range statuses {
+			if statuses[i].Name == source {
+				statuses[i].Profiles = append(statuses[i].Profiles, profile)
+			}
+		}
+	}
+	for i := range statuses {
+		sort.Strings(statuses[i].Profiles)
+	}
+	return statuses
+}
+
+// handleMFAProvidersStatus is GET /mfa/providers/status
+func handleMFAProvidersStatus(c echo.Context) error {
+	return c.JSON(http.StatusOK, getMFAProvidersStatus(c.Request().Context()))
+}
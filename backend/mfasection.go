@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// With the credentialsFileSessions setting, or a profile's
+// credentialsFileSession, each login also writes the session into the
+// shared credentials file as [<profile>-mfa], for tools that only read
+// ~/.aws/credentials. The section is marked as derived, so a hand-written
+// section of the same name, which may hold long-term keys, is never
+// overwritten.
+
+const (
+	mfaSectionSuffix = "-mfa"
+	// mfaSectionMarkerKey names the profile a derived section was written
+	// for; the SDKs ignore keys they don't know
+	mfaSectionMarkerKey = "mfa_derived_from"
+	mfaSectionExpiryKey = "expiration"
+	mfaSectionComment   = "# Written by the AWS MFA extension after each login; changes are overwritten"
+)
+
+var errMFASectionTaken = errors.New("the section exists and wasn't written by the extension")
+
+func mfaSectionName(profile string) string {
+	return profile + mfaSectionSuffix
+}
+
+// writesMFASection reports whether logins to profile write its derived
+// section; the profile's setting takes precedence over the global one
+func writesMFASection(profile string) bool {
+	if setting := getProfileSettings(profile).CredentialsFileSession; setting != nil {
+		return *setting
+	}
+	return loadSettings().CredentialsFileSessions
+}
+
+// derivedFrom returns the profile a derived section was written for, or ""
+// for a section the extension didn't write
+func derivedFrom(section *ini.Section) string {
+	return sectionValue(section, mfaSectionMarkerKey)
+}
+
+// writeMFASection puts creds into [<profile>-mfa] in the credentials file
+func writeMFASection(creds *CachedCredentials) error {
+	if !writesMFASection(creds.Profile) {
+		return nil
+	}
+	name := mfaSectionName(creds.Profile)
+	err := modifyIniFile(getAWSCredentialsPath(), func(cfg *ini.File) error {
+		section, err := cfg.GetSection(name)
+		if err != nil {
+			if section, err = cfg.NewSection(name); err != nil {
+				return err
+			}
+		} else if len(section.Keys()) > 0 && derivedFrom(section) != creds.Profile {
+			return fmt.Errorf("[%s]: %w", name, errMFASectionTaken)
+		}
+		section.Comment = mfaSectionComment
+		section.Key("aws_access_key_id").SetValue(creds.AccessKeyID)
+		section.Key("aws_secret_access_key").SetValue(creds.SecretAccessKey)
+		section.Key("aws_session_token").SetValue(creds.SessionToken)
+		section.Key(mfaSectionExpiryKey).SetValue(creds.Expiration.UTC().Format(time.RFC3339))
+		section.Key(mfaSectionMarkerKey).SetValue(creds.Profile)
+		return nil
+	})
+
+	entry := AuditEntry{
+		Action:  "export",
+		Profile: creds.Profile,
+		Result:  "ok",
+		Fields:  map[string]string{"sink": "credentials-file", "section": name},
+	}
+	if err != nil {
+		entry.Result = "error"
+		entry.Details = err.Error()
+	}
+	recordAudit(entry)
+	return err
+}
+
+// removeMFASection deletes profile's derived section if it still holds the
+// session with accessKeyID
+func removeMFASection(profile, accessKeyID string) error {
+	path := getAWSCredentialsPath()
+	cfg, err := ini.Load(path)
+	if err != nil {
+		return nil
+	}
+	name := mfaSectionName(profile)
+	section, err := cfg.GetSection(name)
+	if err != nil || derivedFrom(section) != profile || sectionValue(section, "aws_access_key_id") != accessKeyID {
+		return nil
+	}
+	return modifyIniFile(path, func(cfg *ini.File) error {
+		if section, err := cfg.GetSection(name); err == nil && derivedFrom(section) == profile {
+			cfg.DeleteSection(name)
+		}
+		return nil
+	})
+}
+
+// removeSessionCopies removes the copies of profile's cached session that
+// were written outside the cache: the AWS CLI's cache entry and the
+// derived credentials file section
+func removeSessionCopies(profile string) {
+	creds, err := loadCachedCredentials(profile)
+	if err != nil {
+		return
+	}
+	removeCLICache(profile, creds.AccessKeyID)
+	if err := removeMFASection(profile, creds.AccessKeyID); err != nil {
+		fmt.Fprintf(os.Stderr, "credentials file section for %s: %v\n", profile, err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+var envPrefixInvalid = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// ProfilePrefix pairs a profile with the prefix its variables get in a
+// merged env file, e.g. prod -> PROD_AWS_ACCESS_KEY_ID
+type ProfilePrefix struct {
+	Profile string `json:"profile"`
+	Prefix  string `json:"prefix"`
+}
+
+// defaultEnvPrefix derives a prefix from the profile name: "prod-eu" -> "PROD_EU"
+func defaultEnvPrefix(profile string) string {
+	return envPrefixInvalid.ReplaceAllString(strings.ToUpper(profile), "_")
+}
+
+// parseProfilePrefixes reads a list like "prod:PROD,staging". Profiles
+// without an explicit prefix use the configured one, then the derived one.
+func parseProfilePrefixes(raw string) ([]ProfilePrefix, error) {
+	configured := loadSettings().EnvPrefixes
+	seen := map[string]string{}
+
+	var out []ProfilePrefix
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		profile, prefix, _ := strings.Cut(item, ":")
+		if prefix == "" {
+			prefix = configured[profile]
+		}
+		if prefix == "" {
+			prefix = defaultEnvPrefix(profile)
+		}
+		prefix = strings.TrimSuffix(strings.ToUpper(prefix), "_")
+		if envPrefixInvalid.MatchString(prefix) {
+			return nil, fmt.Errorf("invalid prefix %q for profile %s", prefix, profile)
+		}
+		if other, ok := seen[prefix]; ok {
+			return nil, fmt.Errorf("profiles %s and %s would both use prefix %s", other, profile, prefix)
+		}
+		seen[prefix] = profile
+		out = append(out, ProfilePrefix{Profile: profile, Prefix: prefix})
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no profiles given")
+	}
+	return out, nil
+}
+
+// prefixEnvContent prefixes every assignment in an env file, leaving
+// comments and blank lines alone
+func prefixEnvContent(envContent, prefix string) string {
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(envContent, "\n") {
+		if line != "" && line != "\n" && !strings.HasPrefix(line, "#") {
+			b.WriteString(prefix + "_")
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// buildMultiProfileEnv merges the sessions of several profiles into one env
+// file. Every profile must have a usable session, otherwise the failing
+// profile's status and error are returned.
+func buildMultiProfileEnv(prefixes []ProfilePrefix) (string, []*CachedCredentials, int, *ErrorResponse) {
+	var b strings.Builder
+	var all []*CachedCredentials
+	for _, pp := range prefixes {
+		creds, status, errResp := loadUsableCredentials(pp.Profile)
+		if errResp != nil {
+			if errResp.Details == "" {
+				errResp.Details = "profile " + pp.Profile
+			} else {
+				errResp.Details += " (profile " + pp.Profile + ")"
+			}
+			return "", nil, status, errResp
+		}
+		all = append(all, creds)
+		fmt.Fprintf(&b, "# profile %s\n", pp.Profile)
+		b.WriteString(prefixEnvContent(formatEnvContent(creds), pp.Prefix))
+	}
+	return b.String(), all, http.StatusOK, nil
+}
+
+// handleGetMultiProfileEnv is called from handleGetEnvFile when ?profiles= is set
+func handleGetMultiProfileEnv(c echo.Context) error {
+	prefixes, err := parseProfilePrefixes(c.QueryParam("profiles"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid profiles",
+			Details: err.Error(),
+		})
+	}
+
+	envContent, _, status, errResp := buildMultiProfileEnv(prefixes)
+	if errResp != nil {
+		return c.JSON(status, errResp)
+	}
+	return c.String(http.StatusOK, envContent)
+}
+
+// handleExportMultiProfileEnv is called from handleExportEnvFile when
+// ?profiles= is set
+func handleExportMultiProfileEnv(c echo.Context, outputPath string) error {
+	prefixes, err := parseProfilePrefixes(c.QueryParam("profiles"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid profiles",
+			Details: err.Error(),
+		})
+	}
+
+	envContent, sessions, status, errResp := buildMultiProfileEnv(prefixes)
+	if errResp != nil {
+		return c.JSON(status, errResp)
+	}
+
+	payload := &ExportPayload{Content: envContent, Sessions: sessions}
+	if _, err := deliverExport(c.Request().Context(), "file", ExportTarget{Path: outputPath}, payload); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to write env file",
+			Details: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":  "Env file written to " + outputPath,
+		"path":     outputPath,
+		"profiles": prefixes,
+	})
+}
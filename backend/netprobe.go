@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	probeTimeout     = 5 * time.Second
+	globalSTSHost    = "sts.amazonaws.com"
+	standardEthernet = 1500
+)
+
+type ProbeStep struct {
+	OK         bool   `json:"ok"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+type EndpointProbe struct {
+	Host        string    `json:"host"`
+	Region      string    `json:"region,omitempty"`
+	Addresses   []string  `json:"addresses,omitempty"`
+	DNS         ProbeStep `json:"dns"`
+	TCP         ProbeStep `json:"tcp"`
+	TLS         ProbeStep `json:"tls"`
+	TLSVersion  string    `json:"tlsVersion,omitempty"`
+	CertIssuer  string    `json:"certIssuer,omitempty"`
+	ProxyURL    string    `json:"proxyUrl,omitempty"`
+	HTTPS       ProbeStep `json:"https"`
+	HTTPSStatus int       `json:"httpsStatus,omitempty"`
+	Diagnosis   []string  `json:"diagnosis,omitempty"`
+}
+
+type InterfaceMTU struct {
+	Name string `json:"name"`
+	MTU  int    `json:"mtu"`
+}
+
+type NetworkProbeResponse struct {
+	ProxyEnv   map[string]string `json:"proxyEnv,omitempty"`
+	Interfaces []InterfaceMTU    `json:"interfaces"`
+	Endpoints  []EndpointProbe   `json:"endpoints"`
+	Healthy    bool              `json:"healthy"`
+}
+
+func stsHostForRegion(region string) string {
+	if region == "" {
+		return globalSTSHost
+	}
+	host := fmt.Sprintf("sts.%s.amazonaws.com", region)
+	if strings.HasPrefix(region, "cn-") {
+		host += ".cn"
+	}
+	return host
+}
+
+func timed(fn func() error) ProbeStep {
+	start := time.Now()
+	err := fn()
+	step := ProbeStep{OK: err == nil, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	return step
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// probeEndpoint walks DNS -> TCP -> TLS directly, then repeats the request
+// the way the SDK would (honoring proxy env), so the two can be compared
+func probeEndpoint(ctx context.Context, host, region string) EndpointProbe {
+	probe := EndpointProbe{Host: host, Region: region}
+
+	var addrs []net.IPAddr
+	probe.DNS = timed(func() error {
+		ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+		defer cancel()
+		var err error
+		addrs, err = net.DefaultResolver.LookupIPAddr(ctx, host)
+		return err
+	})
+	for _, a := range addrs {
+		probe.Addresses = append(probe.Addresses, a.String())
+	}
+
+	var tlsErr error
+	if probe.DNS.OK {
+		var conn net.Conn
+		probe.TCP = timed(func() error {
+			d := net.Dialer{Timeout: probeTimeout}
+			var err error
+			conn, err = d.DialContext(ctx, "tcp", net.JoinHostPort(host, "443"))
+			return err
+		})
+
+		if probe.TCP.OK {
+			tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+			probe.TLS = timed(func() error {
+				tlsConn.SetDeadline(time.Now().Add(probeTimeout))
+				tlsErr = tlsConn.HandshakeContext(ctx)
+				return tlsErr
+			})
+			if probe.TLS.OK {
+				state := tlsConn.ConnectionState()
+				probe.TLSVersion = tls.VersionName(state.Version)
+				if len(state.PeerCertificates) > 0 {
+					probe.CertIssuer = state.PeerCertificates[0].Issuer.String()
+				}
+			}
+			tlsConn.Close()
+		}
+	}
+
+	target := &url.URL{Scheme: "https", Host: host, Path: "/"}
+	if proxy, err := http.ProxyFromEnvironment(&http.Request{URL: target}); err == nil && proxy != nil {
+		probe.ProxyURL = proxy.Redacted()
+	}
+	probe.HTTPS = timed(func() error {
+		client := &http.Client{
+			Timeout:   probeTimeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		probe.HTTPSStatus = resp.StatusCode
+		return nil
+	})
+
+	probe.Diagnosis = diagnoseProbe(&probe, tlsErr)
+	return probe
+}
+
+// diagnoseProbe turns probe results into human-readable likely causes
+func diagnoseProbe(p *EndpointProbe, tlsErr error) []string {
+	var notes []string
+
+	if !p.DNS.OK {
+		notes = append(notes, "DNS resolution failed: check the VM's resolver or VPN split-DNS configuration")
+	}
+	if p.DNS.OK && !p.TCP.OK {
+		if p.ProxyURL != "" {
+			notes = append(notes, "direct connections are blocked; traffic must go through the configured proxy")
+		} else {
+			notes = append(notes, "TCP connection to port 443 failed: a firewall may be blocking outbound HTTPS")
+		}
+	}
+	if p.TCP.OK && !p.TLS.OK && isTimeout(tlsErr) {
+		notes = append(notes, "TCP connects but the TLS handshake times out: likely an MTU / path-MTU blackhole (common on VPNs); try lowering the VM or VPN MTU")
+	}
+	if p.TLS.OK && p.CertIssuer != "" && !strings.Contains(p.CertIssuer, "Amazon") {
+		notes = append(notes, fmt.Sprintf("certificate issued by %q rather than Amazon: a proxy is intercepting TLS, so its CA must be trusted inside the extension", p.CertIssuer))
+	}
+	if p.TLS.OK && !p.HTTPS.OK && p.ProxyURL != "" {
+		notes = append(notes, "direct path works but the configured proxy fails: check HTTPS_PROXY / NO_PROXY")
+	}
+	if !p.TLS.OK && p.HTTPS.OK && p.ProxyURL != "" {
+		notes = append(notes, "only the proxied path works: tools inside the extension must honor HTTPS_PROXY")
+	}
+	return notes
+}
+
+func proxyEnvironment() map[string]string {
+	env := map[string]string{}
+	for _, key := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy", "NO_PROXY", "no_proxy"} {
+		if v := os.Getenv(key); v != "" {
+			if u, err := url.Parse(v); err == nil && u.User != nil {
+				v = u.Redacted()
+			}
+			env[key] = v
+		}
+	}
+	return env
+}
+
+func interfaceMTUs() []InterfaceMTU {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var out []InterfaceMTU
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		out = append(out, InterfaceMTU{Name: iface.Name, MTU: iface.MTU})
+	}
+	return out
+}
+
+// handleNetworkProbe tests the network path to STS from inside the
+// extension VM. ?regions= is a comma-separated list; the global endpoint and
+// the profile's region are always included.
+func handleNetworkProbe(c echo.Context) error {
+	profile := requestProfile(c, c.QueryParam("profile"))
+
+	regions := []string{"", getProfileRegion(profile)}
+	for _, r := range strings.Split(c.QueryParam("regions"), ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			regions = append(regions, r)
+		}
+	}
+
+	seen := map[string]bool{}
+	var hosts []string
+	var hostRegions []string
+	for _, r := range regions {
+		host := stsHostForRegion(r)
+		if !seen[host] {
+			seen[host] = true
+			hosts = append(hosts, host)
+			hostRegions = append(hostRegions, r)
+		}
+	}
+
+	// A configured STS endpoint (e.g. a VPC endpoint) is what the SDK
+	// actually talks to, so it is probed as well
+	for service, endpoint := range loadSettings().Endpoints {
+		if normalizeServiceName(service) != "sts" {
+			continue
+		}
+		if u, err := url.Parse(endpoint); err == nil && !seen[u.Hostname()] {
+			seen[u.Hostname()] = true
+			hosts = append(hosts, u.Hostname())
+			hostRegions = append(hostRegions, "")
+		}
+	}
+
+	resp := NetworkProbeResponse{
+		ProxyEnv:   proxyEnvironment(),
+		Interfaces: interfaceMTUs(),
+		Endpoints:  make([]EndpointProbe, len(hosts)),
+		Healthy:    true,
+	}
+
+	var wg sync.WaitGroup
+	for i := range hosts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp.Endpoints[i] = probeEndpoint(c.Request().Context(), hosts[i], hostRegions[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for _, p := range resp.Endpoints {
+		if !p.HTTPS.OK {
+			resp.Healthy = false
+		}
+	}
+	for _, iface := range resp.Interfaces {
+		if iface.MTU < standardEthernet && !resp.Healthy {
+			for i := range resp.Endpoints {
+				resp.Endpoints[i].Diagnosis = append(resp.Endpoints[i].Diagnosis,
+					fmt.Sprintf("interface %s has MTU %d (< %d), which can cause fragmentation issues", iface.Name, iface.MTU, standardEthernet))
+			}
+		}
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
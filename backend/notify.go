@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	notifyTimeout = 10 * time.Second
+	// sseChannel is the built-in event stream. It receives every event
+	// regardless of routing, since the UI and broker clients depend on it.
+	sseChannel  = "sse"
+	routeAll    = "*"
+	eventTest   = "test"
+	notifyTitle = "AWS MFA"
+)
+
+var errInvalidChannel = errors.New("invalid notification channel")
+
+// Notifier delivers an event somewhere a person (or a log) will see it. New
+// channel types are added by implementing it and listing a constructor in
+// notifierKinds.
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// NotificationChannel is one configured destination. Each type reads only
+// the fields it needs: webhook and slack use URL, log uses Path.
+type NotificationChannel struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	URL  string `json:"url,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+// NotificationSettings routes event types to channels by name. An event
+// type without its own route uses the "*" route, if any.
+type NotificationSettings struct {
+	Channels []NotificationChannel `json:"channels,omitempty"`
+	Routes   map[string][]string   `json:"routes,omitempty"`
+}
+
+type notifierKind struct {
+	description string
+	build       func(NotificationChannel) (Notifier, error)
+}
+
+var notifierKinds = map[string]notifierKind{
+	sseChannel: {"The extension's event stream (always on)", func(NotificationChannel) (Notifier, error) {
+		return sseNotifier{events}, nil
+	}},
+	"desktop": {"A desktop notification on the machine running the backend", func(NotificationChannel) (Notifier, error) {
+		return desktopNotifier{}, nil
+	}},
+	"webhook": {"POST the event as JSON to a URL", newWebhookNotifier},
+	"slack":   {"Post a message to a Slack incoming webhook", newSlackNotifier},
+	"log":     {"Append the event as a JSON line to a file, or stdout", newLogNotifier},
+}
+
+type NotificationChannelInfo struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+// eventSummary renders an event as one line of text for channels meant to
+// be read by people
+func eventSummary(e Event) string {
+	data, _ := e.Data.(map[string]string)
+	switch e.Type {
+	case eventLogin:
+		return fmt.Sprintf("Logged in to %s; session expires %s", e.Profile, data["expiresAt"])
+	case eventCleared:
+		if e.Profile == "" {
+			return "All sessions cleared"
+		}
+		return "Session cleared for " + e.Profile
+	case eventExpiring:
+		return fmt.Sprintf("Credentials for %s in %s expire %s", e.Profile, data["container"], data["expiresAt"])
+	case eventRenewed:
+		return fmt.Sprintf("Renewed credentials for %s in %s", e.Profile, data["container"])
+	case eventMFARequired:
+		return fmt.Sprintf("MFA code needed to renew %s before %s", e.Profile, data["deadline"])
+	case eventSettingsSave:
+		return "Settings saved"
+	case eventTest:
+		return "Test notification"
+	}
+	if e.Profile != "" {
+		return fmt.Sprintf("%s (%s)", e.Type, e.Profile)
+	}
+	return e.Type
+}
+
+type sseNotifier struct{ bus *eventBus }
+
+func (n sseNotifier) Notify(_ context.Context, e Event) error {
+	n.bus.broadcast(e)
+	return nil
+}
+
+// desktopNotifier uses the host's notification tool. Inside the Docker
+// Desktop VM there is none, so it only works when the backend runs on the
+// desktop itself.
+type desktopNotifier struct{}
+
+func (desktopNotifier) Notify(ctx context.Context, e Event) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux":
+		cmd = exec.CommandContext(ctx, "notify-send", notifyTitle, eventSummary(e))
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s",
+			appleScriptString(eventSummary(e)), appleScriptString(notifyTitle))
+		cmd = exec.CommandContext(ctx, "osascript", "-e", script)
+	default:
+		return fmt.Errorf("desktop notifications are not supported on %s", runtime.GOOS)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%v: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// postJSON sends body to u and treats anything but 2xx as a failure
+func postJSON(ctx context.Context, u string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+// notifyURL applies the webhook sink's rule: https, or http to localhost
+func notifyURL(ch NotificationChannel) error {
+	u, err := url.Parse(ch.URL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("%w: %s needs an absolute url", errInvalidChannel, ch.Name)
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && isLoopbackHost(u.Hostname())) {
+		return fmt.Errorf("%w: %s must use https unless it points at localhost", errInvalidChannel, ch.Name)
+	}
+	return nil
+}
+
+type webhookNotifier struct{ url string }
+
+func newWebhookNotifier(ch NotificationChannel) (Notifier, error) {
+	if err := notifyURL(ch); err != nil {
+		return nil, err
+	}
+	return webhookNotifier{ch.URL}, nil
+}
+
+func (n webhookNotifier) Notify(ctx context.Context, e Event) error {
+	return postJSON(ctx, n.url, e)
+}
+
+type slackNotifier struct{ url string }
+
+func newSlackNotifier(ch NotificationChannel) (Notifier, error) {
+	if err := notifyURL(ch); err != nil {
+		return nil, err
+	}
+	return slackNotifier{ch.URL}, nil
+}
+
+func (n slackNotifier) Notify(ctx context.Context, e Event) error {
+	return postJSON(ctx, n.url, map[string]string{"text": eventSummary(e)})
+}
+
+type logNotifier struct{ path string }
+
+var logNotifierMu sync.Mutex
+
+func newLogNotifier(ch NotificationChannel) (Notifier, error) {
+	if ch.Path != "" && !filepath.IsAbs(ch.Path) {
+		return nil, fmt.Errorf("%w: %s needs an absolute path", errInvalidChannel, ch.Name)
+	}
+	return logNotifier{ch.Path}, nil
+}
+
+func (n logNotifier) Notify(_ context.Context, e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	logNotifierMu.Lock()
+	defer logNotifierMu.Unlock()
+	if n.path == "" {
+		_, err = os.Stdout.Write(line)
+		return err
+	}
+	f, err := os.OpenFile(remapPath(n.path), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(line)
+	return err
+}
+
+// validateNotifications checks every channel can be built and every route
+// names a configured channel
+func validateNotifications(ns *NotificationSettings) error {
+	if ns == nil {
+		return nil
+	}
+	names := map[string]bool{sseChannel: true}
+	for _, ch := range ns.Channels {
+		if ch.Name == "" {
+			return fmt.Errorf("%w: every channel needs a name", errInvalidChannel)
+		}
+		if names[ch.Name] {
+			return fmt.Errorf("%w: duplicate channel name %q", errInvalidChannel, ch.Name)
+		}
+		names[ch.Name] = true
+		kind, ok := notifierKinds[ch.Type]
+		if !ok || ch.Type == sseChannel {
+			return fmt.Errorf("%w: %s has unknown type %q", errInvalidChannel, ch.Name, ch.Type)
+		}
+		if _, err := kind.build(ch); err != nil {
+			return err
+		}
+	}
+	for eventType, channels := range ns.Routes {
+		for _, name := range channels {
+			if !names[name] {
+				return fmt.Errorf("%w: route %q names unknown channel %q", errInvalidChannel, eventType, name)
+			}
+		}
+	}
+	return nil
+}
+
+func findChannel(ns *NotificationSettings, name string) (NotificationChannel, bool) {
+	if name == sseChannel {
+		return NotificationChannel{Name: sseChannel, Type: sseChannel}, true
+	}
+	if ns != nil {
+		for _, ch := range ns.Channels {
+			if ch.Name == name {
+				return ch, true
+			}
+		}
+	}
+	return NotificationChannel{}, false
+}
+
+func sendNotification(ctx context.Context, ch NotificationChannel, e Event) error {
+	n, err := notifierKinds[ch.Type].build(ch)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	return n.Notify(ctx, e)
+}
+
+// routeNotification delivers the event to the channels routed for its type
+// in the background. Failures are logged; a broken channel must never hold
+// up whatever published the event.
+func routeNotification(e Event) {
+	ns := loadSettings().Notifications
+	if ns == nil {
+		return
+	}
+	names, ok := ns.Routes[e.Type]
+	if !ok {
+		names = ns.Routes[routeAll]
+	}
+
+	for _, name := range names {
+		ch, ok := findChannel(ns, name)
+		if !ok || ch.Type == sseChannel {
+			continue
+		}
+		go func() {
+			if err := sendNotification(context.Background(), ch, e); err != nil {
+				fmt.Fprintf(os.Stderr, "notify %s: %v\n", ch.Name, err)
+			}
+		}()
+	}
+}
+
+func handleListNotificationChannels(c echo.Context) error {
+	kinds := make([]NotificationChannelInfo, 0, len(notifierKinds))
+	for name, kind := range notifierKinds {
+		kinds = append(kinds, NotificationChannelInfo{Type: name, Description: kind.description})
+	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i].Type < kinds[j].Type })
+	return c.JSON(http.StatusOK, kinds)
+}
+
+// handleTestNotification sends a test event to one channel and reports the
+// outcome synchronously, unlike routed delivery
+func handleTestNotification(c echo.Context) error {
+	name := c.Param("name")
+	ch, ok := findChannel(loadSettings().Notifications, name)
+	if !ok {
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "Unknown notification channel: " + name,
+		})
+	}
+
+	e := Event{Type: eventTest, Time: time.Now().UTC()}
+	if err := sendNotification(c.Request().Context(), ch, e); err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Notification failed",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"channel": name, "status": "sent"})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The 1Password integration reads TOTP codes with `op item get --otp`, for
+// users who keep their virtual MFA seed in a 1Password item. op must be
+// signed in for the user the backend runs as, either through the desktop
+// app integration, which may ask to unlock, or a service account token.
+// The extension image ships op, but inside the Docker Desktop VM there is
+// no desktop app to talk to, so there it needs a service account token.
+
+const (
+	defaultOpCommand     = "op"
+	mfaSourceOnePassword = "1password"
+)
+
+// OnePasswordSettings enables the integration. Profiles name their item in
+// profiles.<name>.onePasswordItem.
+type OnePasswordSettings struct {
+	Enabled bool `json:"enabled"`
+	// Command is the op executable, by default found on PATH
+	Command string `json:"command,omitempty"`
+	// Account picks the 1Password account when op is signed in to several
+	Account string `json:"account,omitempty"`
+	// ServiceAccountTokenFile holds a service account token, read on
+	// every call and passed to op as OP_SERVICE_ACCOUNT_TOKEN
+	ServiceAccountTokenFile string `json:"serviceAccountTokenFile,omitempty"`
+}
+
+func onePasswordSettings() *OnePasswordSettings {
+	op := loadSettings().OnePassword
+	if op == nil || !op.Enabled {
+		return nil
+	}
+	return op
+}
+
+func validateOnePassword(op *OnePasswordSettings, profiles map[string]ProfileSettings) error {
+	if op != nil && op.ServiceAccountTokenFile != "" && !filepath.IsAbs(op.ServiceAccountTokenFile) {
+		return fmt.Errorf("onePassword.serviceAccountTokenFile must be an absolute path")
+	}
+	for profile, ps := range profiles {
+		for field, value := range map[string]string{"onePasswordItem": ps.OnePasswordItem, "onePasswordVault": ps.OnePasswordVault} {
+			// op would read a leading dash as a flag
+			if strings.HasPrefix(value, "-") || strings.ContainsAny(value, "\r\n") {
+				return fmt.Errorf("profiles.%s.%s is not a valid item or vault name", profile, field)
+			}
+		}
+		if ps.OnePasswordVault != "" && ps.OnePasswordItem == "" {
+			return fmt.Errorf("profiles.%s.onePasswordVault needs onePasswordItem", profile)
+		}
+	}
+	return nil
+}
+
+// onePasswordItem returns the 1Password item for profile when the
+// integration is enabled, or ""
+func onePasswordItem(profile string) string {
+	if onePasswordSettings() == nil {
+		return ""
+	}
+	return getProfileSettings(profile).OnePasswordItem
+}
+
+// runOp runs op against the configured account, as the service account
+// when a token file is set
+func runOp(ctx context.Context, op *OnePasswordSettings, args ...string) (string, error) {
+	command := op.Command
+	if command == "" {
+		command = defaultOpCommand
+	}
+	if op.Account != "" {
+		args = append(args, "--account", op.Account)
+	}
+	var env []string
+	if op.ServiceAccountTokenFile != "" {
+		token, err := os.ReadFile(remapPath(op.ServiceAccountTokenFile))
+		if err != nil {
+			return "", fmt.Errorf("reading the 1Password service account token: %w", err)
+		}
+		env = append(os.Environ(), "OP_SERVICE_ACCOUNT_TOKEN="+strings.TrimSpace(string(token)))
+	}
+	return runCodeHelperEnv(ctx, env, "op", command, args...)
+}
+
+// onePasswordCode reads the current TOTP code from the profile's item. The
+// code is never included in errors.
+func onePasswordCode(ctx context.Context, profile string) (string, error) {
+	op := onePasswordSettings()
+	ps := getProfileSettings(profile)
+	if op == nil || ps.OnePasswordItem == "" {
+		return "", fmt.Errorf("profile %s has no 1Password item", profile)
+	}
+
+	args := []string{"item", "get", ps.OnePasswordItem, "--otp"}
+	if ps.OnePasswordVault != "" {
+		args = append(args, "--vault", ps.OnePasswordVault)
+	}
+	out, err := runOp(ctx, op, args...)
+	if err != nil {
+		return "", fmt.Errorf("reading the one-time password of %s from 1Password: %w", ps.OnePasswordItem, err)
+	}
+	code := strings.TrimSpace(out)
+	if !totpCodePattern.MatchString(code) {
+		return "", fmt.Errorf("op did not print a six-digit code for %s; check the item has a one-time password field", ps.OnePasswordItem)
+	}
+	return code, nil
+}
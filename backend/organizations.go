@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/labstack/echo/v4"
+)
+
+// GET /organizations/accounts lists the member accounts of an organization
+// from a session in its management account (or a delegated administrator)
+// and builds the role ARNs to switch into each one, so accounts that
+// aren't in the config yet can be assumed with POST /roles/assume or added
+// as profiles. Only ListAccounts and DescribeOrganization are needed, so
+// the backend calls the Organizations JSON API itself rather than pulling
+// in another SDK client.
+
+const (
+	organizationsTargetPrefix = "AWSOrganizationsV20161128."
+	organizationsTimeout      = 30 * time.Second
+	maxOrganizationPages      = 100
+	// defaultOrgAccessRoleName is the role Organizations creates in
+	// accounts it creates
+	defaultOrgAccessRoleName = "OrganizationAccountAccessRole"
+)
+
+var (
+	errOrgAccessDenied = errors.New("profile can't list the organization's accounts")
+	roleNamePattern    = regexp.MustCompile(`^[\w+=,.@-]+(/[\w+=,.@-]+)*$`)
+)
+
+// organizationsEndpoint is where a partition's Organizations API lives; the
+// service is global, signed for one region per partition
+type organizationsEndpoint struct {
+	URL    string
+	Region string
+}
+
+var organizationsEndpoints = map[string]organizationsEndpoint{
+	"aws":        {"https://organizations.us-east-1.amazonaws.com", "us-east-1"},
+	"aws-cn":     {"https://organizations.cn-northwest-1.amazonaws.com.cn", "cn-northwest-1"},
+	"aws-us-gov": {"https://organizations.us-gov-west-1.amazonaws.com", "us-gov-west-1"},
+}
+
+// OrgAccount is one member account. Profiles lists the config profiles that
+// already point at it, and Roles the ARNs of the roles asked for.
+type OrgAccount struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Email      string     `json:"email,omitempty"`
+	Status     string     `json:"status"`
+	JoinedAt   *time.Time `json:"joinedAt,omitempty"`
+	Management bool       `json:"management,omitempty"`
+	Profiles   []string   `json:"profiles,omitempty"`
+	Roles      []string   `json:"roles"`
+}
+
+type OrgAccountList struct {
+	Profile             string       `json:"profile"`
+	OrganizationID      string       `json:"organizationId,omitempty"`
+	ManagementAccountID string       `json:"managementAccountId,omitempty"`
+	RoleNames           []string     `json:"roleNames"`
+	Accounts            []OrgAccount `json:"accounts"`
+}
+
+// orgAPIError is an error response from the Organizations API
+type orgAPIError struct {
+	Code    string
+	Message string
+}
+
+func (e *orgAPIError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// organizationsClient signs JSON requests to the Organizations API with
+// the session in cfg
+type organizationsClient struct {
+	cfg      aws.Config
+	endpoint organizationsEndpoint
+}
+
+// newOrganizationsClient picks the partition's endpoint, unless the
+// settings route Organizations elsewhere
+func newOrganizationsClient(cfg aws.Config, partition string) *organizationsClient {
+	endpoint, ok := organizationsEndpoints[partition]
+	if !ok {
+		endpoint = organizationsEndpoints["aws"]
+	}
+	if cfg.EndpointResolverWithOptions != nil {
+		if e, err := cfg.EndpointResolverWithOptions.ResolveEndpoint("Organizations", endpoint.Region); err == nil {
+			endpoint.URL = e.URL
+		}
+	}
+	return &organizationsClient{cfg: cfg, endpoint: endpoint}
+}
+
+func (c *organizationsClient) call(ctx context.Context, action string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(echo.HeaderContentType, "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", organizationsTargetPrefix+action)
+
+	creds, err := c.cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), "organizations", c.endpoint.Region, time.Now()); err != nil {
+		return err
+	}
+
+	client := c.cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("organizations unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &apiErr)
+		code := apiErr.Type[strings.LastIndex(apiErr.Type, "#")+1:]
+		if code == "" {
+			code = resp.Status
+		}
+		return &orgAPIError{Code: code, Message: apiErr.Message}
+	}
+	return json.Unmarshal(data, out)
+}
+
+// listOrganizationAccounts returns the organization and all its accounts
+func listOrganizationAccounts(ctx context.Context, client *organizationsClient) (string, string, []OrgAccount, error) {
+	var org struct {
+		Organization struct {
+			ID              string `json:"Id"`
+			MasterAccountID string `json:"MasterAccountId"`
+		}
+	}
+	if err := client.call(ctx, "DescribeOrganization", struct{}{}, &org); err != nil {
+		return "", "", nil, err
+	}
+
+	accounts := []OrgAccount{}
+	token := ""
+	for page := 0; page < maxOrganizationPages; page++ {
+		in := map[string]string{}
+		if token != "" {
+			in["NextToken"] = token
+		}
+		var out struct {
+			Accounts []struct {
+				ID              string  `json:"Id"`
+				Name            string  `json:"Name"`
+				Email           string  `json:"Email"`
+				Status          string  `json:"Status"`
+				JoinedTimestamp float64 `json:"JoinedTimestamp"`
+			}
+			NextToken string
+		}
+		if err := client.call(ctx, "ListAccounts", in, &out); err != nil {
+			return "", "", nil, err
+		}
+		for _, a := range out.Accounts {
+			account := OrgAccount{
+				ID:         a.ID,
+				Name:       a.Name,
+				Email:      a.Email,
+				Status:     a.Status,
+				Management: a.ID == org.Organization.MasterAccountID,
+			}
+			if a.JoinedTimestamp > 0 {
+				joined := time.UnixMilli(int64(a.JoinedTimestamp * 1000)).UTC()
+				account.JoinedAt = &joined
+			}
+			accounts = append(accounts, account)
+		}
+		if token = out.NextToken; token == "" {
+			break
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
+	return org.Organization.ID, org.Organization.MasterAccountID, accounts, nil
+}
+
+// configProfilesByAccount maps account IDs to the profiles whose config
+// names them
+func configProfilesByAccount() map[string][]string {
+	byAccount := make(map[string][]string)
+	profiles, err := getProfiles()
+	if err != nil {
+		return byAccount
+	}
+	for _, p := range profiles {
+		if _, account := profileConfigAccount(p.Name); account != "" {
+			byAccount[account] = append(byAccount[account], p.Name)
+		}
+	}
+	return byAccount
+}
+
+// parseRoleNames reads ?roleName=, a comma-separated list
+func parseRoleNames(raw string) ([]string, error) {
+	if raw == "" {
+		return []string{defaultOrgAccessRoleName}, nil
+	}
+	var names []string
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.Trim(strings.TrimSpace(name), "/")
+		if !roleNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("%q is not a valid role name", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// orgProfileConfig renders config entries for the accounts that have no
+// profile yet, named <account>-<role> and sourced from profile
+func orgProfileConfig(profile, region string, list *OrgAccountList) string {
+	var b strings.Builder
+	for _, a := range list.Accounts {
+		if a.Management || len(a.Profiles) > 0 || a.Status != "ACTIVE" {
+			continue
+		}
+		for i, roleName := range list.RoleNames {
+			account := a.Name
+			if account == "" {
+				account = a.ID
+			}
+			roleBase := roleName[strings.LastIndex(roleName, "/")+1:]
+			name := strings.Trim(ssoProfileNameInvalid.ReplaceAllString(strings.ToLower(account+"-"+roleBase), "-"), "-")
+
+			fmt.Fprintf(&b, "[profile %s]\n", name)
+			fmt.Fprintf(&b, "role_arn = %s\nsource_profile = %s\n", a.Roles[i], profile)
+			if region != "" {
+				fmt.Fprintf(&b, "region = %s\n", region)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// handleListOrganizationAccounts is GET /organizations/accounts. It needs a
+// session for ?profile= in the management account or a delegated
+// administrator; ?roleName= picks the roles to build ARNs for, and
+// ?format=config renders profiles for the accounts not in the config.
+func handleListOrganizationAccounts(c echo.Context) error {
+	profile := requestProfile(c, c.QueryParam("profile"))
+	roleNames, err := parseRoleNames(c.QueryParam("roleName"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid roleName",
+			Details: err.Error(),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), organizationsTimeout)
+	defer cancel()
+	cfg, err := viewerAWSConfig(ctx, profile)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error:   "No valid session",
+			Details: err.Error(),
+		})
+	}
+	partition, _ := profileConfigAccount(profile)
+	if partition == "" {
+		partition = "aws"
+	}
+
+	orgID, managementID, accounts, err := listOrganizationAccounts(ctx, newOrganizationsClient(cfg, partition))
+	if err != nil {
+		var apiErr *orgAPIError
+		if errors.As(err, &apiErr) {
+			switch apiErr.Code {
+			case "AccessDeniedException", "AWSOrganizationsNotInUseException":
+				return c.JSON(http.StatusForbidden, ErrorResponse{
+					Error:   "Cannot list organization accounts",
+					Details: fmt.Sprintf("%v: %v; use a profile in the management account or a delegated administrator", errOrgAccessDenied, err),
+				})
+			}
+		}
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to list organization accounts",
+			Details: err.Error(),
+		})
+	}
+
+	inConfig := configProfilesByAccount()
+	for i := range accounts {
+		accounts[i].Profiles = inConfig[accounts[i].ID]
+		accounts[i].Roles = make([]string, 0, len(roleNames))
+		for _, roleName := range roleNames {
+			accounts[i].Roles = append(accounts[i].Roles, fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, accounts[i].ID, roleName))
+		}
+	}
+	list := &OrgAccountList{
+		Profile:             profile,
+		OrganizationID:      orgID,
+		ManagementAccountID: managementID,
+		RoleNames:           roleNames,
+		Accounts:            accounts,
+	}
+
+	if c.QueryParam("format") == "config" {
+		return c.String(http.StatusOK, orgProfileConfig(profile, getProfileRegion(profile), list))
+	}
+	return c.JSON(http.StatusOK, list)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The pass integration reads TOTP codes with `pass otp` from the pass-otp
+// extension, for users who keep their MFA seed in password-store. Entries
+// are decrypted with gpg, so gpg-agent must hold the key or be able to
+// ask for its passphrase through pinentry within the helper timeout. The
+// extension image ships pass, pass-otp and gpg, but inside the Docker
+// Desktop VM no pinentry can reach the user, so there the key in GnupgHome
+// must be usable without a passphrase.
+
+const (
+	defaultPassCommand = "pass"
+	mfaSourcePass      = "pass"
+)
+
+// PassSettings enables the integration. Profiles name their entry in
+// profiles.<name>.passEntry.
+type PassSettings struct {
+	Enabled bool `json:"enabled"`
+	// Command is the pass executable, by default found on PATH
+	Command string `json:"command,omitempty"`
+	// StoreDir is the password store, by default PASSWORD_STORE_DIR or
+	// ~/.password-store
+	StoreDir string `json:"storeDir,omitempty"`
+	// GnupgHome is the gpg home holding the store's key, by default
+	// GNUPGHOME or ~/.gnupg
+	GnupgHome string `json:"gnupgHome,omitempty"`
+}
+
+func passSettings() *PassSettings {
+	ps := loadSettings().Pass
+	if ps == nil || !ps.Enabled {
+		return nil
+	}
+	return ps
+}
+
+func validatePass(pass *PassSettings, profiles map[string]ProfileSettings) error {
+	if pass != nil && pass.StoreDir != "" && !filepath.IsAbs(pass.StoreDir) {
+		return fmt.Errorf("pass.storeDir must be an absolute path")
+	}
+	if pass != nil && pass.GnupgHome != "" && !filepath.IsAbs(pass.GnupgHome) {
+		return fmt.Errorf("pass.gnupgHome must be an absolute path")
+	}
+	for profile, ps := range profiles {
+		entry := ps.PassEntry
+		if entry == "" {
+			continue
+		}
+		// pass would read a leading dash as a flag, and entries are paths
+		// inside the store
+		if strings.HasPrefix(entry, "-") || strings.HasPrefix(entry, "/") || strings.ContainsAny(entry, "\r\n") ||
+			strings.Contains("/"+entry+"/", "/../") {
+			return fmt.Errorf("profiles.%s.passEntry is not a valid entry name", profile)
+		}
+	}
+	return nil
+}
+
+// passEntry returns the pass entry for profile when the integration is
+// enabled, or ""
+func passEntry(profile string) string {
+	if passSettings() == nil {
+		return ""
+	}
+	return getProfileSettings(profile).PassEntry
+}
+
+// passStoreDir is the password store pass will read
+func passStoreDir(pass *PassSettings) string {
+	if pass.StoreDir != "" {
+		return remapPath(pass.StoreDir)
+	}
+	if dir := os.Getenv("PASSWORD_STORE_DIR"); dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".password-store")
+}
+
+// runPass runs pass against the configured store
+func runPass(ctx context.Context, pass *PassSettings, args ...string) (string, error) {
+	command := pass.Command
+	if command == "" {
+		command = defaultPassCommand
+	}
+	var env []string
+	if pass.StoreDir != "" {
+		env = append(env, "PASSWORD_STORE_DIR="+passStoreDir(pass))
+	}
+	if pass.GnupgHome != "" {
+		env = append(env, "GNUPGHOME="+remapPath(pass.GnupgHome))
+	}
+	if env != nil {
+		env = append(os.Environ(), env...)
+	}
+	return runCodeHelperEnv(ctx, env, "pass", command, args...)
+}
+
+// passCode reads the current TOTP code from the profile's entry. The code
+// is never included in errors.
+func passCode(ctx context.Context, profile string) (string, error) {
+	pass := passSettings()
+	entry := passEntry(profile)
+	if pass == nil || entry == "" {
+		return "", fmt.Errorf("profile %s has no pass entry", profile)
+	}
+	out, err := runPass(ctx, pass, "otp", entry)
+	if err != nil {
+		return "", fmt.Errorf("reading the code for %s from pass: %w", entry, err)
+	}
+	code := strings.TrimSpace(out)
+	if !totpCodePattern.MatchString(code) {
+		return "", fmt.Errorf("pass otp did not print a six-digit code for %s; check the entry has an otpauth:// line", entry)
+	}
+	return code, nil
+}
+
+type passSource struct{}
+
+func (passSource) Description() string {
+	return "Read the code from a password-store entry with pass otp"
+}
+func (passSource) Configured(profile string) bool { return passEntry(profile) != "" }
+func (passSource) Code(ctx context.Context, profile string) (string, error) {
+	return passCode(ctx, profile)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PathRemap maps paths as the host sees them to where the host directory is
+// mounted for the backend, e.g. "/Users/me" to "/host-home" when the host
+// home is bind-mounted into the extension container. Host may be a Windows
+// path such as "C:\Users\me".
+type PathRemap struct {
+	Host    string `json:"host"`
+	Mounted string `json:"mounted"`
+}
+
+// isWindowsPath reports whether p is a drive or UNC path, which are
+// matched without regard to case or slash direction
+func isWindowsPath(p string) bool {
+	return strings.Contains(p, `\`) || (len(p) >= 2 && p[1] == ':')
+}
+
+// trimHostPrefix returns the part of p after prefix, when prefix is a whole
+// leading part of p
+func trimHostPrefix(p, prefix string) (string, bool) {
+	match := strings.HasPrefix
+	if isWindowsPath(prefix) {
+		p = strings.ReplaceAll(p, `\`, "/")
+		prefix = strings.ReplaceAll(prefix, `\`, "/")
+		match = func(s, prefix string) bool {
+			return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+		}
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	switch {
+	case len(p) == len(prefix) && match(p, prefix):
+		return "", true
+	case match(p, prefix+"/"):
+		return p[len(prefix)+1:], true
+	}
+	return "", false
+}
+
+// remapPath translates a host path into its mounted location using the
+// longest matching prefix. Paths no remap covers are returned unchanged.
+func remapPath(p string) string {
+	if p == "" {
+		return p
+	}
+	var match *PathRemap
+	var rest string
+	remaps := loadSettings().PathRemaps
+	for i := range remaps {
+		tail, ok := trimHostPrefix(p, remaps[i].Host)
+		if ok && (match == nil || len(remaps[i].Host) > len(match.Host)) {
+			match, rest = &remaps[i], tail
+		}
+	}
+	if match == nil {
+		return p
+	}
+	return filepath.Join(match.Mounted, filepath.FromSlash(rest))
+}
+
+// mountedHomes lists the remapped directories that hold an AWS config
+// directory, which auto-detection treats like any other home
+func mountedHomes() []AWSPathInfo {
+	var paths []AWSPathInfo
+	for _, r := range loadSettings().PathRemaps {
+		dir := filepath.Join(r.Mounted, ".aws")
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		p := AWSPathInfo{
+			Source:      SourceMounted,
+			ConfigPath:  filepath.Join(dir, "config"),
+			CredsPath:   filepath.Join(dir, "credentials"),
+			Description: fmt.Sprintf("Mounted host directory %s", r.Host),
+		}
+		_, err := os.Stat(p.ConfigPath)
+		p.Exists = err == nil
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+func validatePathRemaps(remaps []PathRemap) error {
+	seen := make(map[string]bool, len(remaps))
+	for i, r := range remaps {
+		if r.Host == "" || r.Mounted == "" {
+			return fmt.Errorf("pathRemaps[%d]: host and mounted are required", i)
+		}
+		if !isWindowsPath(r.Host) && !filepath.IsAbs(r.Host) {
+			return fmt.Errorf("pathRemaps[%d]: host %q must be absolute", i, r.Host)
+		}
+		if !filepath.IsAbs(r.Mounted) {
+			return fmt.Errorf("pathRemaps[%d]: mounted %q must be absolute", i, r.Mounted)
+		}
+		key := r.Host
+		if isWindowsPath(key) {
+			key = strings.ToLower(strings.ReplaceAll(key, `\`, "/"))
+		}
+		key = strings.TrimSuffix(key, "/")
+		if seen[key] {
+			return fmt.Errorf("pathRemaps[%d]: host %q is mapped twice", i, r.Host)
+		}
+		seen[key] = true
+	}
+	return nil
+}
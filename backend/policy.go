@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // the extension VM ships without a zoneinfo database
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	policyClearAt     = "clearAt"
+	policyMaxLifetime = "maxLifetime"
+
+	policyInterval = time.Minute
+	// GetSessionToken rejects anything shorter
+	minSessionDuration = 900
+)
+
+// PolicyRule bounds how long sessions may live locally. clearAt rules drop
+// matching sessions at a time of day ("19:00") or on a cron schedule
+// ("0 19 * * 1-5"); maxLifetime rules drop them once older than MaxHours
+// and also cap the duration requested at login.
+type PolicyRule struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Profiles []string `json:"profiles,omitempty"`
+	At       string   `json:"at,omitempty"`
+	Cron     string   `json:"cron,omitempty"`
+	Timezone string   `json:"timezone,omitempty"`
+	MaxHours float64  `json:"maxHours,omitempty"`
+}
+
+type PolicyResult struct {
+	Rule    string `json:"rule"`
+	Profile string `json:"profile"`
+	Action  string `json:"action"`
+	Reason  string `json:"reason,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// appliesTo matches the profile against the rule's glob patterns; a rule
+// without patterns applies to every profile
+func (r PolicyRule) appliesTo(profile string) bool {
+	if len(r.Profiles) == 0 {
+		return true
+	}
+	for _, pattern := range r.Profiles {
+		if ok, _ := path.Match(pattern, profile); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r PolicyRule) location() (*time.Location, error) {
+	if r.Timezone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(r.Timezone)
+}
+
+// cronExpr returns the rule's clear times as a cron expression, turning a
+// time of day into a daily one
+func (r PolicyRule) cronExpr() (string, error) {
+	if r.Cron != "" {
+		if r.At != "" {
+			return "", fmt.Errorf("set either at or cron, not both")
+		}
+		return r.Cron, nil
+	}
+
+	hh, mm, ok := strings.Cut(r.At, ":")
+	hour, errH := strconv.Atoi(hh)
+	minute, errM := strconv.Atoi(mm)
+	if !ok || errH != nil || errM != nil || hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return "", fmt.Errorf("invalid time of day %q, expected HH:MM", r.At)
+	}
+	return fmt.Sprintf("%d %d * * *", minute, hour), nil
+}
+
+func (r PolicyRule) schedule() (*cronSchedule, error) {
+	expr, err := r.cronExpr()
+	if err != nil {
+		return nil, err
+	}
+	return parseCron(expr)
+}
+
+// lastClearTime returns the most recent scheduled clear at or before now
+func (r PolicyRule) lastClearTime(now time.Time) (time.Time, error) {
+	loc, err := r.location()
+	if err != nil {
+		return time.Time{}, err
+	}
+	sched, err := r.schedule()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return sched.prev(now.In(loc))
+}
+
+// validatePolicies rejects clearAt schedules and timezones that would only
+// fail later, when the policy job runs
+func validatePolicies(rules []PolicyRule) error {
+	for _, rule := range rules {
+		if rule.Type != policyClearAt {
+			continue
+		}
+		if _, err := rule.location(); err != nil {
+			return fmt.Errorf("policy %q: invalid timezone %q", rule.Name, rule.Timezone)
+		}
+		if _, err := rule.schedule(); err != nil {
+			return fmt.Errorf("policy %q: %w", rule.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r PolicyRule) maxLifetime() time.Duration {
+	return time.Duration(r.MaxHours * float64(time.Hour))
+}
+
+// sessionIssuedAt falls back to the cache file's mtime for sessions cached
+// before the issue time was recorded
+func sessionIssuedAt(creds *CachedCredentials) time.Time {
+	if !creds.IssuedAt.IsZero() {
+		return creds.IssuedAt
+	}
+	if info, err := os.Stat(getCacheFile(creds.Profile)); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+// evaluateRule decides whether a session violates a rule; an empty reason
+// means it complies
+func evaluateRule(rule PolicyRule, creds *CachedCredentials, now time.Time) (string, error) {
+	issuedAt := sessionIssuedAt(creds)
+
+	switch rule.Type {
+	case policyClearAt:
+		at, err := rule.lastClearTime(now)
+		if err != nil {
+			return "", err
+		}
+		if issuedAt.Before(at) {
+			return fmt.Sprintf("session issued before scheduled clear at %s", at.Format(time.RFC3339)), nil
+		}
+	case policyMaxLifetime:
+		if rule.MaxHours <= 0 {
+			return "", fmt.Errorf("maxHours must be positive")
+		}
+		if age := now.Sub(issuedAt); age > rule.maxLifetime() {
+			return fmt.Sprintf("session age %s exceeds %gh limit", age.Round(time.Minute), rule.MaxHours), nil
+		}
+	default:
+		return "", fmt.Errorf("unknown policy type %q", rule.Type)
+	}
+	return "", nil
+}
+
+// evaluatePolicies clears every cached session that violates a rule.
+// Violations and rule errors are written to the audit log.
+func evaluatePolicies(now time.Time) []PolicyResult {
+	rules := loadSettings().Policies
+	results := []PolicyResult{}
+	if len(rules) == 0 {
+		return results
+	}
+
+	for _, profile := range cachedProfiles() {
+		creds, err := loadCachedCredentials(profile)
+		if err != nil {
+			continue
+		}
+
+		for _, rule := range rules {
+			if !rule.appliesTo(profile) {
+				continue
+			}
+
+			result := PolicyResult{Rule: rule.Name, Profile: profile, Action: "none"}
+			reason, err := evaluateRule(rule, creds, now)
+			if err != nil {
+				result.Error = err.Error()
+			} else if reason != "" {
+				result.Reason = reason
+				result.Action = "cleared"
+				if err := clearSession(profile); err != nil {
+					result.Error = err.Error()
+				}
+			}
+
+			if result.Action != "none" || result.Error != "" {
+				entry := AuditEntry{
+					Action:  "policy." + rule.Type,
+					Profile: profile,
+					Result:  result.Action,
+					Details: result.Reason,
+					Fields:  map[string]string{"rule": rule.Name},
+				}
+				if result.Error != "" {
+					entry.Result = "error"
+					entry.Details = result.Error
+				}
+				recordAudit(entry)
+			}
+			results = append(results, result)
+
+			if result.Action == "cleared" {
+				break
+			}
+		}
+	}
+	return results
+}
+
+// policyMaxDuration caps a requested login duration by the strictest
+// matching maxLifetime rule, returning the rule that applied, if any
+func policyMaxDuration(profile string, requested int32) (int32, *PolicyRule) {
+	var applied *PolicyRule
+	for _, rule := range loadSettings().Policies {
+		if rule.Type != policyMaxLifetime || rule.MaxHours <= 0 || !rule.appliesTo(profile) {
+			continue
+		}
+		limit := int32(rule.maxLifetime().Seconds())
+		if limit < minSessionDuration {
+			limit = minSessionDuration
+		}
+		if limit < requested {
+			requested = limit
+			r := rule
+			applied = &r
+		}
+	}
+	return requested, applied
+}
+
+func runPolicyEvaluation(context.Context) {
+	evaluatePolicies(time.Now())
+}
+
+func handleEvaluatePolicies(c echo.Context) error {
+	return c.JSON(http.StatusOK, evaluatePolicies(time.Now()))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// /status and /status/all tell the UI how soon to ask again: rarely while
+// sessions have hours left, more often as one nears expiry so the countdown
+// and the switch to logged out are on time, and no faster than the
+// throttle budget allows for a profile STS is throttling. Logins and
+// clears are announced on the event stream, so a slow poll never hides
+// them for long.
+
+const (
+	pollIntervalHeader = "X-Poll-Interval"
+
+	// pollIntervalIdle applies to profiles without a session, which only
+	// change through a login
+	pollIntervalIdle = time.Minute
+	pollIntervalMin  = 5 * time.Second
+)
+
+// pollTiers maps time left on a session to how often to poll, longest first
+var pollTiers = []struct {
+	remaining time.Duration
+	interval  time.Duration
+}{
+	{time.Hour, 5 * time.Minute},
+	{15 * time.Minute, time.Minute},
+	{5 * time.Minute, 30 * time.Second},
+	{0, 10 * time.Second},
+}
+
+// pollInterval is how soon to poll a profile's status again
+func pollInterval(status StatusResponse) time.Duration {
+	interval := pollIntervalIdle
+	if status.Authenticated {
+		remaining := time.Duration(status.SecondsRemaining) * time.Second
+		for _, tier := range pollTiers {
+			if remaining > tier.remaining {
+				interval = tier.interval
+				break
+			}
+		}
+		// Poll again just after it expires rather than well past it
+		interval = max(min(interval, remaining+time.Second), pollIntervalMin)
+	}
+	if status.Throttle != nil && status.Throttle.Throttled {
+		interval = max(interval, time.Duration(status.Throttle.RefreshInterval)*time.Second)
+	}
+	return interval
+}
+
+// withPollHint annotates a status response with its poll interval
+func withPollHint(status StatusResponse) StatusResponse {
+	status.PollInterval = int64(pollInterval(status).Seconds())
+	return status
+}
+
+// setPollHeaders sets the poll interval as a header, with Retry-After as
+// well when throttling raised it. Statuses are never cached, since a login
+// changes them at once.
+func setPollHeaders(c echo.Context, statuses ...StatusResponse) {
+	var interval int64
+	throttled := false
+	for i, status := range statuses {
+		if i == 0 || status.PollInterval < interval {
+			interval = status.PollInterval
+		}
+		if status.Throttle != nil && status.Throttle.Throttled {
+			throttled = true
+		}
+	}
+	if len(statuses) == 0 {
+		interval = int64(pollIntervalIdle.Seconds())
+	}
+
+	h := c.Response().Header()
+	h.Set(echo.HeaderCacheControl, "no-cache")
+	h.Set(pollIntervalHeader, strconv.FormatInt(interval, 10))
+	if throttled {
+		h.Set(echo.HeaderRetryAfter, strconv.FormatInt(interval, 10))
+	}
+}
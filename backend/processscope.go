@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Process scoping restricts the routes that return or deliver credentials
+// to processes started under a registered parent, such as the shell a user works in, so other local
+// programs running as the same user can't read sessions off the socket.
+// Callers are identified by the PID in their connection's peer
+// credentials and traced up through /proc.
+
+// maxProcessDepth bounds the walk up the process tree
+const maxProcessDepth = 64
+
+type ProcessScopeSettings struct {
+	Enabled bool `json:"enabled"`
+}
+
+// ScopeParent is a registered parent process. StartTime guards against the
+// PID being reused by an unrelated process once the parent exits.
+type ScopeParent struct {
+	PID          int       `json:"pid"`
+	Command      string    `json:"command,omitempty"`
+	RegisteredAt time.Time `json:"registeredAt"`
+	startTime    uint64
+}
+
+type ProcessScopeStatus struct {
+	Enabled   bool          `json:"enabled"`
+	Available bool          `json:"available"`
+	Reason    string        `json:"reason,omitempty"`
+	Parents   []ScopeParent `json:"parents"`
+}
+
+type RegisterParentRequest struct {
+	// PID defaults to the caller's parent, e.g. the shell running curl
+	PID int `json:"pid,omitempty"`
+}
+
+type peerPIDContextKey struct{}
+
+var processScope = struct {
+	mu      sync.Mutex
+	parents map[int]*ScopeParent
+	// unavailable is set at startup when peer PIDs don't identify callers
+	unavailable error
+}{parents: make(map[int]*ScopeParent)}
+
+var errBehindRouter = errors.New("process scoping is not available behind the multi-user router, whose connections hide the calling process")
+
+// processScopeAvailable reports why scoping can't work here, or nil
+func processScopeAvailable() error {
+	if processScope.unavailable != nil {
+		return processScope.unavailable
+	}
+	return processScopeSupported()
+}
+
+func processScopeEnabled() bool {
+	ps := loadSettings().ProcessScope
+	return ps != nil && ps.Enabled
+}
+
+func validateProcessScope(ps *ProcessScopeSettings) error {
+	if ps == nil || !ps.Enabled {
+		return nil
+	}
+	if err := processScopeAvailable(); err != nil {
+		return fmt.Errorf("processScope: %w", err)
+	}
+	return nil
+}
+
+// withPeerPID stores the connecting process's PID in the request context
+func withPeerPID(ctx context.Context, c net.Conn) context.Context {
+	pid, err := peerPID(c)
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, peerPIDContextKey{}, pid)
+}
+
+func callerPID(c echo.Context) (int, bool) {
+	pid, ok := c.Request().Context().Value(peerPIDContextKey{}).(int)
+	return pid, ok
+}
+
+// liveScopeParents drops parents that have exited and returns the rest.
+// The caller holds processScope.mu.
+func liveScopeParents() map[int]*ScopeParent {
+	for pid, parent := range processScope.parents {
+		if _, start, err := processInfo(pid); err != nil || start != parent.startTime {
+			delete(processScope.parents, pid)
+		}
+	}
+	return processScope.parents
+}
+
+// descendsFromParent reports whether pid was started, directly or not, by
+// a registered parent. The parent itself is not in scope.
+func descendsFromParent(pid int) (bool, error) {
+	processScope.mu.Lock()
+	defer processScope.mu.Unlock()
+
+	parents := liveScopeParents()
+	current := pid
+	for depth := 0; depth < maxProcessDepth; depth++ {
+		ppid, _, err := processInfo(current)
+		if err != nil {
+			return false, err
+		}
+		if ppid <= 1 {
+			return false, nil
+		}
+		if _, ok := parents[ppid]; ok {
+			return true, nil
+		}
+		current = ppid
+	}
+	return false, nil
+}
+
+// isAncestor reports whether ancestor is pid or one of its ancestors
+func isAncestor(ancestor, pid int) bool {
+	current := pid
+	for depth := 0; depth < maxProcessDepth && current > 1; depth++ {
+		if current == ancestor {
+			return true
+		}
+		ppid, _, err := processInfo(current)
+		if err != nil {
+			return false
+		}
+		current = ppid
+	}
+	return false
+}
+
+// processScopeGate refuses callers outside the scope while it is enabled.
+// With bootstrap, callers are let through until the first parent is
+// registered, so that one can be; otherwise enabling scoping with no
+// parent shuts everyone out until one is.
+func processScopeGate(bootstrap bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !processScopeEnabled() {
+				return next(c)
+			}
+			if err := processScopeAvailable(); err != nil {
+				return c.JSON(http.StatusForbidden, ErrorResponse{
+					Error:   "Process scoping unavailable",
+					Details: err.Error(),
+				})
+			}
+			if bootstrap {
+				processScope.mu.Lock()
+				none := len(liveScopeParents()) == 0
+				processScope.mu.Unlock()
+				if none {
+					return next(c)
+				}
+			}
+
+			pid, ok := callerPID(c)
+			if !ok {
+				return c.JSON(http.StatusForbidden, ErrorResponse{
+					Error: "Could not identify the calling process",
+				})
+			}
+			allowed, err := descendsFromParent(pid)
+			if err == nil && allowed {
+				return next(c)
+			}
+
+			entry := AuditEntry{
+				Action: "process-scope",
+				Result: "denied",
+				Details: fmt.Sprintf("%s %s from pid %d, which is not started by a registered parent",
+					c.Request().Method, c.Path(), pid),
+				Fields: map[string]string{"pid": strconv.Itoa(pid), "command": processCommand(pid)},
+			}
+			recordAudit(entry)
+			return c.JSON(http.StatusForbidden, ErrorResponse{
+				Error:   "Caller is outside the process scope",
+				Details: entry.Details,
+			})
+		}
+	}
+}
+
+func handleGetProcessScope(c echo.Context) error {
+	status := ProcessScopeStatus{Enabled: processScopeEnabled(), Available: true, Parents: []ScopeParent{}}
+	if err := processScopeAvailable(); err != nil {
+		status.Available = false
+		status.Reason = err.Error()
+	}
+
+	processScope.mu.Lock()
+	for _, parent := range liveScopeParents() {
+		status.Parents = append(status.Parents, *parent)
+	}
+	processScope.mu.Unlock()
+
+	sort.Slice(status.Parents, func(i, j int) bool { return status.Parents[i].PID < status.Parents[j].PID })
+	return c.JSON(http.StatusOK, status)
+}
+
+// handleRegisterParent registers the caller, or one of its ancestors, as a
+// parent whose descendants may read credentials
+func handleRegisterParent(c echo.Context) error {
+	if err := processScopeAvailable(); err != nil {
+		return c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "Process scoping unavailable",
+			Details: err.Error(),
+		})
+	}
+	var req RegisterParentRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	caller, ok := callerPID(c)
+	if !ok {
+		return c.JSON(http.StatusForbidden, ErrorResponse{
+			Error: "Could not identify the calling process",
+		})
+	}
+
+	pid := req.PID
+	if pid == 0 {
+		ppid, _, err := processInfo(caller)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Failed to read the calling process",
+				Details: err.Error(),
+			})
+		}
+		pid = ppid
+	}
+	// Registering init would put every process in scope
+	if pid <= 1 || !isAncestor(pid, caller) {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid parent",
+			Details: fmt.Sprintf("pid %d must be the caller or one of its ancestors, other than init", pid),
+		})
+	}
+	_, start, err := processInfo(pid)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid parent",
+			Details: err.Error(),
+		})
+	}
+
+	parent := &ScopeParent{PID: pid, Command: processCommand(pid), RegisteredAt: time.Now().UTC(), startTime: start}
+	processScope.mu.Lock()
+	processScope.parents[pid] = parent
+	processScope.mu.Unlock()
+
+	recordAudit(AuditEntry{
+		Action: "process-scope",
+		Result: "registered",
+		Fields: map[string]string{"pid": strconv.Itoa(pid), "command": parent.Command, "by": strconv.Itoa(caller)},
+	})
+	return c.JSON(http.StatusOK, parent)
+}
+
+func handleUnregisterParent(c echo.Context) error {
+	pid, err := strconv.Atoi(c.Param("pid"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid pid",
+		})
+	}
+
+	processScope.mu.Lock()
+	_, ok := processScope.parents[pid]
+	delete(processScope.parents, pid)
+	processScope.mu.Unlock()
+	if !ok {
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "Parent not registered",
+		})
+	}
+
+	recordAudit(AuditEntry{
+		Action: "process-scope",
+		Result: "unregistered",
+		Fields: map[string]string{"pid": strconv.Itoa(pid)},
+	})
+	return c.NoContent(http.StatusNoContent)
+}
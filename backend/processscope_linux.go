@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// processInfo reads a process's parent and start time from /proc/<pid>/stat.
+// The start time (in clock ticks since boot) tells a process apart from a
+// later one that reuses its PID.
+func processInfo(pid int) (ppid int, start uint64, err error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+	// The command name is in parentheses and may itself contain spaces or
+	// parentheses, so the fields start after the last ')'
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return 0, 0, fmt.Errorf("unexpected /proc/%d/stat format", pid)
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	// fields[0] is the state (field 3), so field n is fields[n-3]
+	if len(fields) < 20 {
+		return 0, 0, fmt.Errorf("unexpected /proc/%d/stat format", pid)
+	}
+	if ppid, err = strconv.Atoi(fields[1]); err != nil {
+		return 0, 0, err
+	}
+	if start, err = strconv.ParseUint(fields[19], 10, 64); err != nil {
+		return 0, 0, err
+	}
+	return ppid, start, nil
+}
+
+// processCommand is the process's name, for audit entries
+func processCommand(pid int) string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func processScopeSupported() error {
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+func processInfo(int) (int, uint64, error) {
+	return 0, 0, errProcessScopeUnsupported
+}
+
+func processCommand(int) string {
+	return ""
+}
+
+func processScopeSupported() error {
+	return errProcessScopeUnsupported
+}
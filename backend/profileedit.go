@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/labstack/echo/v4"
+	"gopkg.in/ini.v1"
+)
+
+var (
+	errProfileNotFound = errors.New("profile not found")
+	errProfileExists   = errors.New("profile already exists")
+
+	profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.@+-]+$`)
+
+	// secretKeys must never be written to the config file
+	secretKeys = map[string]bool{
+		"aws_access_key_id":     true,
+		"aws_secret_access_key": true,
+		"aws_session_token":     true,
+	}
+)
+
+type CloneProfileRequest struct {
+	NewName         string            `json:"newName"`
+	Region          string            `json:"region,omitempty"`
+	RoleARN         string            `json:"roleArn,omitempty"`
+	Values          map[string]string `json:"values,omitempty"`
+	CopyCredentials bool              `json:"copyCredentials,omitempty"`
+}
+
+type CloneProfileResponse struct {
+	Profile             string            `json:"profile"`
+	ClonedFrom          string            `json:"clonedFrom"`
+	Values              map[string]string `json:"values"`
+	CredentialsCopied   bool              `json:"credentialsCopied"`
+	SourceProfileLinked bool              `json:"sourceProfileLinked"`
+}
+
+// cloneProfile duplicates a config section under a new name with overrides.
+// Long-term keys are only duplicated on request; a cloned role profile
+// instead points source_profile back at the original so keys live in one
+// place.
+func cloneProfile(source string, req *CloneProfileRequest) (*CloneProfileResponse, error) {
+	resp := &CloneProfileResponse{Profile: req.NewName, ClonedFrom: source}
+
+	err := modifyIniFile(getAWSConfigPath(), func(cfg *ini.File) error {
+		src, err := cfg.GetSection(profileSectionName(source))
+		if err != nil {
+			return fmt.Errorf("%w: %s", errProfileNotFound, source)
+		}
+		if _, err := cfg.GetSection(profileSectionName(req.NewName)); err == nil {
+			return fmt.Errorf("%w: %s", errProfileExists, req.NewName)
+		}
+
+		dst, err := cfg.NewSection(profileSectionName(req.NewName))
+		if err != nil {
+			return err
+		}
+		for _, key := range src.Keys() {
+			dst.Key(key.Name()).SetValue(key.Value())
+		}
+		// A clone is the user's own; left marked as generated, Granted
+		// could prune it
+		dst.DeleteKey(grantedGeneratedFromKey)
+
+		if req.Region != "" {
+			dst.Key("region").SetValue(req.Region)
+		}
+		if req.RoleARN != "" {
+			dst.Key("role_arn").SetValue(req.RoleARN)
+			if !req.CopyCredentials && !dst.HasKey("source_profile") {
+				dst.Key("source_profile").SetValue(source)
+				resp.SourceProfileLinked = true
+			}
+		}
+		for k, v := range req.Values {
+			dst.Key(k).SetValue(v)
+		}
+
+		resp.Values = dst.KeysHash()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !req.CopyCredentials {
+		return resp, nil
+	}
+
+	err = modifyIniFile(getAWSCredentialsPath(), func(cfg *ini.File) error {
+		src, err := cfg.GetSection(source)
+		if err != nil {
+			return nil // nothing to copy, e.g. SSO or role-only profiles
+		}
+		if _, err := cfg.GetSection(req.NewName); err == nil {
+			return fmt.Errorf("%w in credentials file: %s", errProfileExists, req.NewName)
+		}
+		dst, err := cfg.NewSection(req.NewName)
+		if err != nil {
+			return err
+		}
+		for _, key := range src.Keys() {
+			dst.Key(key.Name()).SetValue(key.Value())
+		}
+		resp.CredentialsCopied = true
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("profile cloned in config but credentials copy failed: %w", err)
+	}
+
+	return resp, nil
+}
+
+func handleCloneProfile(c echo.Context) error {
+	var req CloneProfileRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	if !profileNamePattern.MatchString(req.NewName) {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "A valid newName is required",
+		})
+	}
+
+	for k := range req.Values {
+		if secretKeys[k] {
+			return c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: "Credentials cannot be set through profile values",
+			})
+		}
+	}
+
+	resp, err := cloneProfile(c.Param("name"), &req)
+	switch {
+	case errors.Is(err, errProfileNotFound):
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "Profile not found",
+			Details: err.Error(),
+		})
+	case errors.Is(err, errProfileExists):
+		return c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "Profile already exists",
+			Details: err.Error(),
+		})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to clone profile",
+			Details: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusCreated, resp)
+}
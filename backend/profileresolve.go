@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	fallbackProfile         = "default"
+	profileResolutionHeader = "X-Profile-Resolution"
+
+	// Profile resolution rules, in order of precedence
+	ResolutionExplicit = "explicit"
+	ResolutionSettings = "settings"
+	ResolutionEnv      = "AWS_PROFILE"
+	ResolutionFallback = "fallback"
+)
+
+// resolveProfile picks the profile to use when a request may omit it: the
+// requested one, then the configured default, then AWS_PROFILE, then
+// "default". It also returns the rule that applied.
+func resolveProfile(requested string) (string, string) {
+	if requested != "" {
+		return requested, ResolutionExplicit
+	}
+	if p := loadSettings().DefaultProfile; p != "" {
+		return p, ResolutionSettings
+	}
+	if p := os.Getenv("AWS_PROFILE"); p != "" {
+		return p, ResolutionEnv
+	}
+	return fallbackProfile, ResolutionFallback
+}
+
+// defaultProfile is the profile used when none is given
+func defaultProfile() string {
+	profile, _ := resolveProfile("")
+	return profile
+}
+
+// requestProfile resolves the profile for a handler and reports the rule
+// that applied in the X-Profile-Resolution response header
+func requestProfile(c echo.Context, requested string) string {
+	profile, rule := resolveProfile(requested)
+	c.Response().Header().Set(profileResolutionHeader, rule)
+	return profile
+}
@@ -0,0 +1,101 @@
+package main
+
+// ProfileSettings holds per-profile preferences kept in the extension's
+// settings rather than in the AWS config file
+type ProfileSettings struct {
+	// FIPS and DualStack select FIPS 140 and IPv6-capable endpoints. When
+	// unset, use_fips_endpoint / use_dualstack_endpoint from the AWS config
+	// profile apply.
+	FIPS      *bool `json:"fips,omitempty"`
+	DualStack *bool `json:"dualStack,omitempty"`
+
+	// PreLogin and PostLogin run around a successful login, e.g. to switch
+	// the kubectl context
+	PreLogin  []LoginHook `json:"preLogin,omitempty"`
+	PostLogin []LoginHook `json:"postLogin,omitempty"`
+
+	// ExpectedAccount is the account ID the profile's keys should belong
+	// to; sessions for any other account are flagged on /status
+	ExpectedAccount string `json:"expectedAccount,omitempty"`
+
+	// Duration is the session length for this profile's logins when the
+	// request doesn't ask for one, e.g. "8h"
+	Duration SessionDuration `json:"duration,omitempty"`
+
+	// Sensitive makes logins and exports for the profile wait for a
+	// confirmation token from GET /confirm. When unset, `sensitive` in the
+	// AWS config profile applies.
+	Sensitive *bool `json:"sensitive,omitempty"`
+
+	// SessionPolicy and SessionPolicyARNs scope the profile's role sessions
+	// to less than the role allows: an inline policy document and managed
+	// policy ARNs passed to AssumeRole
+	SessionPolicy     string   `json:"sessionPolicy,omitempty"`
+	SessionPolicyARNs []string `json:"sessionPolicyArns,omitempty"`
+
+	// YubiKeyAccount is the OATH account on the YubiKey that generates the
+	// profile's codes, so logins can leave out the token code
+	YubiKeyAccount string `json:"yubikeyAccount,omitempty"`
+
+	// OnePasswordItem is the 1Password item, by name or ID, holding the
+	// profile's one-time password; OnePasswordVault narrows the lookup
+	OnePasswordItem  string `json:"onePasswordItem,omitempty"`
+	OnePasswordVault string `json:"onePasswordVault,omitempty"`
+
+	// BitwardenItem is the Bitwarden item, by name or ID, holding the
+	// profile's TOTP seed
+	BitwardenItem string `json:"bitwardenItem,omitempty"`
+
+	// PassEntry is the password-store entry holding the profile's
+	// otpauth:// URI, read with pass otp
+	PassEntry string `json:"passEntry,omitempty"`
+
+	// TokenSources is the order token sources are tried in when a login
+	// leaves out the code. Sources after "manual" are never tried, so
+	// ["manual"] always asks for the code.
+	TokenSources []string `json:"tokenSources,omitempty"`
+
+	// STSEndpoint is "global" to send the profile's STS calls, its
+	// GetSessionToken included, to sts.amazonaws.com from any region, or
+	// "regional" to keep them in the region. When unset,
+	// sts_regional_endpoints and the stsRegionalEndpoints setting apply.
+	STSEndpoint string `json:"stsEndpoint,omitempty"`
+
+	// EndpointURL sends all of the profile's calls to one endpoint, e.g.
+	// LocalStack or moto; when unset, endpoint_url from the AWS config
+	// profile applies. Endpoints sends single services elsewhere, keyed
+	// like the global endpoints setting. Both take precedence over it.
+	EndpointURL string            `json:"endpointUrl,omitempty"`
+	Endpoints   map[string]string `json:"endpoints,omitempty"`
+
+	// Canaries are checked with the new session after every login, e.g.
+	// s3:ListBucket on a bucket the profile's workflow reads
+	Canaries []Canary `json:"canaries,omitempty"`
+
+	// CredentialsFileSession writes the profile's sessions to the
+	// credentials file as [<profile>-mfa]; when unset, the
+	// credentialsFileSessions setting applies
+	CredentialsFileSession *bool `json:"credentialsFileSession,omitempty"`
+
+	// ViewerRoleARN is the role viewer sessions are assumed into from the
+	// profile's MFA session; when unset, the profile's own role is. Profiles
+	// without a role_arn need one for viewer sessions.
+	ViewerRoleARN string `json:"viewerRoleArn,omitempty"`
+}
+
+// getProfileSettings returns the settings for profile, or zero values
+func getProfileSettings(profile string) ProfileSettings {
+	return loadSettings().Profiles[profile]
+}
+
+// profileFlag resolves a toggle from the extension settings first, then
+// from the named key in the AWS config profile
+func profileFlag(profile string, setting *bool, configKey string) bool {
+	if setting != nil {
+		return *setting
+	}
+	if section, err := getProfileSection(profile); err == nil {
+		return section.Key(configKey).MustBool(false)
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	eventMFARequired    = "mfa-required"
+	eventPromptResolved = "prompt-resolved"
+
+	promptReasonRenewal = "renewal"
+
+	promptCompleted = "completed"
+	promptDismissed = "dismissed"
+)
+
+// PromptConsumer is a delivery waiting on the login a prompt asks for
+type PromptConsumer struct {
+	Container string    `json:"container,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Prompt asks the user for an MFA code the backend needs to keep a session
+// going on its own, such as renewing credentials delivered to a container.
+// There is at most one pending prompt per profile; consumers that need the
+// same login are added to it.
+type Prompt struct {
+	ID        string           `json:"id"`
+	Profile   string           `json:"profile"`
+	Reason    string           `json:"reason"`
+	MFASerial string           `json:"mfaSerial,omitempty"`
+	Consumers []PromptConsumer `json:"consumers"`
+	CreatedAt time.Time        `json:"createdAt"`
+	// Deadline is when the first waiting consumer's session lapses
+	Deadline time.Time `json:"deadline"`
+}
+
+// CompletePromptRequest answers a prompt with a token code. The login is
+// the same as POST /login for the prompt's profile.
+type CompletePromptRequest struct {
+	TokenCode string          `json:"tokenCode"`
+	Duration  SessionDuration `json:"duration,omitempty"`
+}
+
+var prompts = struct {
+	mu      sync.Mutex
+	pending map[string]*Prompt
+}{pending: make(map[string]*Prompt)}
+
+// requestMFA queues a prompt for profile, or adds the consumer to the one
+// already pending, and announces new prompts on the event stream
+func requestMFA(profile, reason string, consumer PromptConsumer) {
+	prompts.mu.Lock()
+	for _, p := range prompts.pending {
+		if p.Profile != profile {
+			continue
+		}
+		p.Consumers = append(p.Consumers, consumer)
+		if consumer.ExpiresAt.Before(p.Deadline) {
+			p.Deadline = consumer.ExpiresAt
+		}
+		prompts.mu.Unlock()
+		return
+	}
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		prompts.mu.Unlock()
+		return
+	}
+	p := &Prompt{
+		ID:        hex.EncodeToString(buf),
+		Profile:   profile,
+		Reason:    reason,
+		Consumers: []PromptConsumer{consumer},
+		CreatedAt: time.Now().UTC(),
+		Deadline:  consumer.ExpiresAt,
+	}
+	if serial, err := getMFASerial(profile); err == nil {
+		p.MFASerial = serial
+	}
+	prompts.pending[p.ID] = p
+	prompts.mu.Unlock()
+
+	events.publish(Event{
+		Type:    eventMFARequired,
+		Profile: profile,
+		Data: map[string]string{
+			"prompt":    p.ID,
+			"reason":    reason,
+			"container": consumer.Container,
+			"deadline":  p.Deadline.UTC().Format(time.RFC3339),
+		},
+	})
+}
+
+// resolvePrompts closes the pending prompt for profile once it has a new
+// session, however the user logged in, and renews what was waiting on it
+func resolvePrompts(profile string) {
+	prompts.mu.Lock()
+	var resolved *Prompt
+	for id, p := range prompts.pending {
+		if p.Profile == profile {
+			resolved = p
+			delete(prompts.pending, id)
+		}
+	}
+	prompts.mu.Unlock()
+	if resolved == nil {
+		return
+	}
+
+	publishPromptResolved(resolved, promptCompleted)
+	if resolved.Reason == promptReasonRenewal {
+		go runExportRenewal(context.Background())
+	}
+}
+
+func publishPromptResolved(p *Prompt, resolution string) {
+	events.publish(Event{
+		Type:    eventPromptResolved,
+		Profile: p.Profile,
+		Data:    map[string]string{"prompt": p.ID, "resolution": resolution},
+	})
+}
+
+func findPrompt(id string) (Prompt, bool) {
+	prompts.mu.Lock()
+	defer prompts.mu.Unlock()
+	p, ok := prompts.pending[id]
+	if !ok {
+		return Prompt{}, false
+	}
+	return *p, true
+}
+
+// handleListPrompts returns pending prompts, the most urgent first
+func handleListPrompts(c echo.Context) error {
+	prompts.mu.Lock()
+	list := make([]Prompt, 0, len(prompts.pending))
+	for _, p := range prompts.pending {
+		list = append(list, *p)
+	}
+	prompts.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Deadline.Before(list[j].Deadline) })
+	return c.JSON(http.StatusOK, list)
+}
+
+func handleGetPrompt(c echo.Context) error {
+	p, ok := findPrompt(c.Param("id"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "Prompt not found",
+		})
+	}
+	return c.JSON(http.StatusOK, p)
+}
+
+// handleCompletePrompt logs the prompt's profile in with the code given.
+// The prompt is resolved by the login itself.
+func handleCompletePrompt(c echo.Context) error {
+	var req CompletePromptRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	p, ok := findPrompt(c.Param("id"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "Prompt not found",
+		})
+	}
+
+	login := LoginRequest{Profile: p.Profile, TokenCode: req.TokenCode, Duration: req.Duration}
+	status, code, errBody := loginProfile(c, &login)
+	if errBody != nil {
+		return c.JSON(code, errBody)
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+// handleDismissPrompt drops a prompt the user doesn't want to answer. The
+// consumers keep their current session until it expires.
+func handleDismissPrompt(c echo.Context) error {
+	prompts.mu.Lock()
+	p, ok := prompts.pending[c.Param("id")]
+	delete(prompts.pending, c.Param("id"))
+	prompts.mu.Unlock()
+	if !ok {
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "Prompt not found",
+		})
+	}
+
+	publishPromptResolved(p, promptDismissed)
+	return c.NoContent(http.StatusNoContent)
+}
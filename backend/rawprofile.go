@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gopkg.in/ini.v1"
+)
+
+var (
+	errRawSectionInvalid = errors.New("invalid section")
+	errRawSectionChanged = errors.New("config file changed since it was read")
+	errRawETagRequired   = errors.New("etag is required to replace an existing section")
+
+	iniSectionHeader = regexp.MustCompile(`^\s*\[\s*([^\]]*?)\s*\]`)
+)
+
+// RawProfileSection is one profile's config section exactly as it appears
+// in the file, comments included. ETag covers the whole file so a save can
+// be refused if anything changed in between.
+type RawProfileSection struct {
+	Profile string `json:"profile"`
+	File    string `json:"file"`
+	Content string `json:"content"`
+	ETag    string `json:"etag"`
+}
+
+// RawProfileUpdate is a section to save. ETag is the one the section was
+// read with; it may only be left out when creating the section.
+type RawProfileUpdate struct {
+	Content string `json:"content"`
+	ETag    string `json:"etag,omitempty"`
+}
+
+func iniETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+func isIniComment(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";")
+}
+
+// matchesProfileHeader accepts both spellings of the default profile
+func matchesProfileHeader(header, profile string) bool {
+	return header == profileSectionName(profile) || (profile == "default" && header == "profile default")
+}
+
+// rawSectionRange finds the lines belonging to a profile's section: the
+// header with any comment block directly above it, down to the next
+// section, minus the blank lines and comments that lead into that one
+func rawSectionRange(lines []string, profile string) (start, end int, ok bool) {
+	start = -1
+	for i, line := range lines {
+		m := iniSectionHeader.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		if start >= 0 {
+			end = i
+			break
+		}
+		if matchesProfileHeader(m[1], profile) {
+			start = i
+			end = len(lines)
+		}
+	}
+	if start < 0 {
+		return 0, 0, false
+	}
+
+	for start > 0 && isIniComment(lines[start-1]) {
+		start--
+	}
+	for end > start+1 && (strings.TrimSpace(lines[end-1]) == "" || isIniComment(lines[end-1])) {
+		end--
+	}
+	return start, end, true
+}
+
+func splitIniLines(data []byte) []string {
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// validateRawSection checks edited content before it goes anywhere near
+// the file: exactly one section, for this profile, with no duplicate or
+// secret keys
+func validateRawSection(profile, content string) error {
+	var headers []string
+	for _, line := range strings.Split(content, "\n") {
+		if m := iniSectionHeader.FindStringSubmatch(line); m != nil {
+			headers = append(headers, m[1])
+		}
+	}
+	if len(headers) != 1 || !matchesProfileHeader(headers[0], profile) {
+		return fmt.Errorf("%w: content must contain exactly one [%s] header", errRawSectionInvalid, profileSectionName(profile))
+	}
+
+	cfg, err := ini.LoadSources(ini.LoadOptions{AllowShadows: true}, []byte(content))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errRawSectionInvalid, err)
+	}
+	if len(cfg.Section(ini.DefaultSection).Keys()) > 0 {
+		return fmt.Errorf("%w: keys must come after the section header", errRawSectionInvalid)
+	}
+	section := cfg.Section(headers[0])
+	for _, key := range section.Keys() {
+		if secretKeys[key.Name()] {
+			return fmt.Errorf("%w: %s belongs in the credentials file", errRawSectionInvalid, key.Name())
+		}
+		if len(key.ValueWithShadows()) > 1 {
+			return fmt.Errorf("%w: %s is set more than once", errRawSectionInvalid, key.Name())
+		}
+	}
+	return nil
+}
+
+// spliceRawSection replaces (or appends) the profile's section, leaving
+// every other byte of the file as it was
+func spliceRawSection(data []byte, profile, content string) []byte {
+	lines := splitIniLines(data)
+	section := splitIniLines([]byte(strings.TrimRight(content, "\n") + "\n"))
+
+	start, end, ok := rawSectionRange(lines, profile)
+	if !ok {
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		start, end = len(lines), len(lines)
+	}
+
+	out := append(append(append([]string{}, lines[:start]...), section...), lines[end:]...)
+	return []byte(strings.Join(out, "\n") + "\n")
+}
+
+func handleGetRawProfile(c echo.Context) error {
+	profile := c.Param("name")
+	path := getAWSConfigPath()
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read AWS config",
+			Details: err.Error(),
+		})
+	}
+
+	lines := splitIniLines(data)
+	start, end, ok := rawSectionRange(lines, profile)
+	if !ok {
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "Profile not found: " + profile,
+		})
+	}
+	return c.JSON(http.StatusOK, RawProfileSection{
+		Profile: profile,
+		File:    absPath(path),
+		Content: strings.Join(lines[start:end], "\n") + "\n",
+		ETag:    iniETag(data),
+	})
+}
+
+// handlePutRawProfile saves an edited section, creating it if the profile
+// doesn't exist yet. The rest of the file is untouched, it is backed up
+// like any other edit, and replacing a section without an etag, or with a
+// stale one, is refused rather than overwriting someone else's change.
+func handlePutRawProfile(c echo.Context) error {
+	profile := c.Param("name")
+	var req RawProfileUpdate
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	if !profileNamePattern.MatchString(profile) {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid profile name",
+		})
+	}
+	if err := validateRawSection(profile, req.Content); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid profile section",
+			Details: err.Error(),
+		})
+	}
+
+	path := getAWSConfigPath()
+	raw := RawProfileSection{Profile: profile, File: absPath(path)}
+	err := modifyIniText(path, func(data []byte) ([]byte, error) {
+		if req.ETag == "" {
+			if _, _, exists := rawSectionRange(splitIniLines(data), profile); exists {
+				return nil, errRawETagRequired
+			}
+		} else if req.ETag != iniETag(data) {
+			return nil, errRawSectionChanged
+		}
+		updated := spliceRawSection(data, profile, req.Content)
+		if _, err := ini.Load(updated); err != nil {
+			return nil, fmt.Errorf("%w: file would no longer parse: %v", errRawSectionInvalid, err)
+		}
+
+		lines := splitIniLines(updated)
+		start, end, _ := rawSectionRange(lines, profile)
+		raw.Content = strings.Join(lines[start:end], "\n") + "\n"
+		raw.ETag = iniETag(updated)
+		return updated, nil
+	})
+	switch {
+	case errors.Is(err, errRawETagRequired):
+		return c.JSON(http.StatusPreconditionRequired, ErrorResponse{
+			Error:   "ETag required",
+			Details: "read the section with GET /profiles/" + profile + "/raw and send its etag",
+		})
+	case errors.Is(err, errRawSectionChanged):
+		return c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "Config file changed",
+			Details: "reload the section and reapply your edits",
+		})
+	case errors.Is(err, errRawSectionInvalid):
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid profile section",
+			Details: err.Error(),
+		})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to save profile",
+			Details: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, raw)
+}
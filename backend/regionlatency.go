@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultLatencySamples = 3
+	maxLatencySamples     = 10
+)
+
+// defaultLatencyRegions is probed when neither ?regions= nor
+// Settings.LatencyRegions is set: the commercial regions most teams pick
+// from for dev resources
+var defaultLatencyRegions = []string{
+	"us-east-1", "us-east-2", "us-west-2", "ca-central-1", "sa-east-1",
+	"eu-west-1", "eu-west-2", "eu-central-1", "eu-north-1",
+	"ap-south-1", "ap-southeast-1", "ap-southeast-2", "ap-northeast-1",
+}
+
+type RegionLatency struct {
+	Region  string `json:"region"`
+	Host    string `json:"host"`
+	RTTMs   *int64 `json:"rttMs,omitempty"` // median TCP connect time
+	MinMs   *int64 `json:"minMs,omitempty"`
+	Samples int    `json:"samples"`
+	Current bool   `json:"current,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type RegionLatencyResponse struct {
+	Profile     string          `json:"profile"`
+	Recommended string          `json:"recommended,omitempty"`
+	Regions     []RegionLatency `json:"regions"`
+}
+
+func resolveLatencyRegions(c echo.Context) []string {
+	var regions []string
+	for _, r := range strings.Split(c.QueryParam("regions"), ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			regions = append(regions, r)
+		}
+	}
+	if len(regions) == 0 {
+		regions = loadSettings().LatencyRegions
+	}
+	if len(regions) == 0 {
+		regions = defaultLatencyRegions
+	}
+	return regions
+}
+
+// measureRegion times TCP connects to the region's STS endpoint. A connect
+// is one round trip, so it approximates RTT without TLS or server time; DNS
+// is resolved once up front so it doesn't skew the first sample.
+func measureRegion(ctx context.Context, region string, samples int) RegionLatency {
+	result := RegionLatency{Region: region, Host: stsHostForRegion(region)}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+	addrs, err := net.DefaultResolver.LookupIPAddr(lookupCtx, result.Host)
+	cancel()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	addr := net.JoinHostPort(addrs[0].IP.String(), "443")
+
+	var durations []time.Duration
+	for i := 0; i < samples; i++ {
+		d := net.Dialer{Timeout: probeTimeout}
+		start := time.Now()
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			result.Error = err.Error()
+			continue
+		}
+		durations = append(durations, time.Since(start))
+		conn.Close()
+	}
+
+	result.Samples = len(durations)
+	if len(durations) == 0 {
+		return result
+	}
+	result.Error = ""
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	median := durations[len(durations)/2].Milliseconds()
+	fastest := durations[0].Milliseconds()
+	result.RTTMs, result.MinMs = &median, &fastest
+	return result
+}
+
+// handleRegionLatency ranks regions by round-trip time from the extension
+// VM, which is the network the user's containers share. ?regions= overrides
+// the configured set and ?samples= the number of connects per region.
+func handleRegionLatency(c echo.Context) error {
+	profile := requestProfile(c, c.QueryParam("profile"))
+	current := getProfileRegion(profile)
+
+	samples := defaultLatencySamples
+	if s := c.QueryParam("samples"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxLatencySamples {
+			return c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: "samples must be between 1 and " + strconv.Itoa(maxLatencySamples),
+			})
+		}
+		samples = n
+	}
+
+	regions := resolveLatencyRegions(c)
+	results := make([]RegionLatency, len(regions))
+	var wg sync.WaitGroup
+	for i, region := range regions {
+		wg.Add(1)
+		go func(i int, region string) {
+			defer wg.Done()
+			results[i] = measureRegion(c.Request().Context(), region, samples)
+			results[i].Current = region == current
+		}(i, region)
+	}
+	wg.Wait()
+
+	// Reachable regions first, fastest first; unreachable ones keep their order
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i].RTTMs, results[j].RTTMs
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return *a < *b
+	})
+
+	resp := RegionLatencyResponse{Profile: profile, Regions: results}
+	if len(results) > 0 && results[0].RTTMs != nil {
+		resp.Recommended = results[0].Region
+	}
+	return c.JSON(http.StatusOK, resp)
+}
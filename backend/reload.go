@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// A reload re-reads the settings file, as after editing it by hand, and
+// brings the TCP listeners in line with it without a restart. A listener
+// whose address is unchanged keeps its socket and only swaps its routes, so
+// nothing is refused in between; one that moves binds its new address
+// before the old one is shut down, and requests already being served on
+// the old one are allowed to finish. Service clients are built per request
+// from the current settings, so endpoint changes apply from the next call.
+
+const (
+	listenerRemoteAccess      = "remoteAccess"
+	listenerContainerEndpoint = "containerEndpoint"
+	listenerSocket            = "socket"
+
+	// listenerDrainTimeout bounds how long a replaced listener waits for
+	// requests in flight, such as event streams, before closing them
+	listenerDrainTimeout = 30 * time.Second
+)
+
+// ReloadResult reports what a reload changed
+type ReloadResult struct {
+	ReloadedAt time.Time `json:"reloadedAt"`
+	// Changed lists the top-level settings that differ from before
+	Changed   []string         `json:"changed"`
+	Listeners []ListenerChange `json:"listeners"`
+}
+
+// ListenerChange is what a reload did to one listener: started, stopped,
+// moved to a new address, reconfigured in place, rebound (the socket file
+// had gone), unchanged, or failed, in which case the old one keeps serving
+type ListenerChange struct {
+	Name    string `json:"name"`
+	Action  string `json:"action"`
+	Address string `json:"address,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// managedListener is a TCP listener started from the settings. Its routes
+// sit behind handler so they can be replaced while it keeps serving.
+type managedListener struct {
+	addr    string
+	config  []byte
+	server  *http.Server
+	handler atomic.Pointer[echo.Echo]
+}
+
+func (l *managedListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.handler.Load().ServeHTTP(w, r)
+}
+
+var listeners = struct {
+	mu      sync.Mutex
+	servers map[string]*managedListener
+	// behindRouter is set when the multi-user router owns remote access
+	behindRouter bool
+	// socket is the main Unix socket, rebound by a reload if its file is gone
+	socketPath string
+	socket     net.Listener
+	main       *http.Server
+}{servers: make(map[string]*managedListener)}
+
+var reloadMu sync.Mutex
+
+// applyListener makes the named listener match its settings: started when
+// newly enabled, stopped when disabled, given fresh routes when its
+// settings changed and moved when its address did. If binding a new
+// address fails, the old listener is left running.
+func applyListener(name string, enabled bool, addr string, config interface{}, routes func() *echo.Echo) ListenerChange {
+	listeners.mu.Lock()
+	defer listeners.mu.Unlock()
+
+	change := ListenerChange{Name: name, Address: addr}
+	current := listeners.servers[name]
+	if !enabled {
+		if current == nil {
+			change.Action = "disabled"
+			return change
+		}
+		delete(listeners.servers, name)
+		go drainListener(current)
+		change.Action, change.Address = "stopped", current.addr
+		return change
+	}
+
+	data, _ := json.Marshal(config)
+	if current != nil && current.addr == addr {
+		if bytes.Equal(current.config, data) {
+			change.Action = "unchanged"
+			return change
+		}
+		current.handler.Store(routes())
+		current.config = data
+		change.Action = "reconfigured"
+		return change
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		change.Action, change.Error = "failed", err.Error()
+		return change
+	}
+	l := &managedListener{addr: addr, config: data}
+	l.handler.Store(routes())
+	l.server = &http.Server{Handler: l}
+	go func() {
+		if err := l.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(os.Stderr, "%s listener error: %v\n", name, err)
+		}
+	}()
+	listeners.servers[name] = l
+
+	change.Action = "started"
+	if current != nil {
+		go drainListener(current)
+		change.Action = "moved"
+	}
+	fmt.Printf("%s listening on %s\n", name, addr)
+	return change
+}
+
+// drainListener stops accepting on a replaced listener and lets requests
+// in flight finish, closing whatever is still open after the timeout
+func drainListener(l *managedListener) {
+	ctx, cancel := context.WithTimeout(context.Background(), listenerDrainTimeout)
+	defer cancel()
+	if err := l.server.Shutdown(ctx); err != nil {
+		l.server.Close()
+	}
+}
+
+// applyListenerSettings brings the remote access and container endpoint
+// listeners in line with settings
+func applyListenerSettings(settings *Settings) []ListenerChange {
+	var changes []ListenerChange
+
+	listeners.mu.Lock()
+	behindRouter := listeners.behindRouter
+	listeners.mu.Unlock()
+	if !behindRouter {
+		ra := settings.RemoteAccess
+		enabled := ra != nil && ra.Enabled
+		addr := defaultRemoteListen
+		if enabled && ra.Listen != "" {
+			addr = ra.Listen
+		}
+		changes = append(changes, applyListener(listenerRemoteAccess, enabled, addr, ra, newRemoteRouter))
+	}
+
+	ce := settings.ContainerEndpoint
+	enabled := ce != nil && ce.Enabled
+	addr := defaultContainerListen
+	if enabled && ce.Listen != "" {
+		addr = ce.Listen
+	}
+	change := applyListener(listenerContainerEndpoint, enabled, addr, ce, func() *echo.Echo {
+		return newContainerRouter(ce)
+	})
+	switch change.Action {
+	case "started", "moved", "reconfigured":
+		startContainerTokens(ce)
+	case "stopped":
+		stopContainerTokens()
+	}
+	return append(changes, change)
+}
+
+// serveSocket serves the main routes on the Unix socket in the background.
+// A listener closed by a rebind ends quietly; any other failure is fatal,
+// as the extension can't be reached without it.
+func serveSocket(listener net.Listener) {
+	go func() {
+		err := listeners.main.Serve(listener)
+		if err == nil || err == io.EOF || errors.Is(err, net.ErrClosed) || errors.Is(err, http.ErrServerClosed) {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
+		os.Exit(1)
+	}()
+}
+
+// bindSocket listens on the main socket path, replacing whatever is there
+func bindSocket(path string) (net.Listener, error) {
+	os.Remove(path)
+	return net.Listen("unix", path)
+}
+
+// rebindSocket listens on the main socket again if its file was removed,
+// which otherwise leaves the backend running but unreachable. Connections
+// accepted on the old listener are unaffected.
+func rebindSocket() ListenerChange {
+	listeners.mu.Lock()
+	defer listeners.mu.Unlock()
+
+	change := ListenerChange{Name: listenerSocket, Address: listeners.socketPath, Action: "unchanged"}
+	if listeners.main == nil {
+		return change
+	}
+	if _, err := os.Stat(listeners.socketPath); err == nil {
+		return change
+	}
+	ln, err := bindSocket(listeners.socketPath)
+	if err != nil {
+		change.Action, change.Error = "failed", err.Error()
+		return change
+	}
+	old := listeners.socket
+	listeners.socket = ln
+	serveSocket(ln)
+	if unix, ok := old.(*net.UnixListener); ok {
+		// The path now belongs to the new listener
+		unix.SetUnlinkOnClose(false)
+	}
+	old.Close()
+	change.Action = "rebound"
+	return change
+}
+
+// changedSettings lists the top-level settings keys that differ
+func changedSettings(before, after *Settings) []string {
+	var a, b map[string]json.RawMessage
+	dataA, _ := json.Marshal(before)
+	dataB, _ := json.Marshal(after)
+	json.Unmarshal(dataA, &a)
+	json.Unmarshal(dataB, &b)
+
+	changed := []string{}
+	for key, value := range b {
+		if !bytes.Equal(a[key], value) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range a {
+		if _, ok := b[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// reloadSettings re-reads and validates the settings file, swaps it in and
+// applies it. Invalid settings are refused and the current ones kept.
+func reloadSettings(trigger string) (*ReloadResult, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	// Unlike at startup, a file that doesn't parse is reported rather than
+	// quarantined: it was most likely just edited by hand
+	settings := &Settings{CredentialSource: SourceAuto}
+	if data, err := os.ReadFile(getSettingsPath()); err == nil {
+		if err := json.Unmarshal(data, settings); err != nil {
+			return nil, fmt.Errorf("settings file: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := validateSettings(settings); err != nil {
+		return nil, err
+	}
+
+	before := loadSettings()
+	setCurrentSettings(settings)
+	result := &ReloadResult{
+		ReloadedAt: time.Now().UTC(),
+		Changed:    changedSettings(before, settings),
+		Listeners:  append(applyListenerSettings(settings), rebindSocket()),
+	}
+
+	for _, key := range result.Changed {
+		if key == "endpoints" || key == "stsRegionalEndpoints" {
+			// Cached responses may have come from the old endpoints
+			ecrCache.flush()
+		}
+	}
+	events.publish(Event{Type: eventSettingsSave, Data: map[string]string{"trigger": trigger}})
+	go runSourceHealth(context.Background())
+
+	fields := map[string]string{"trigger": trigger}
+	for _, l := range result.Listeners {
+		fields[l.Name] = l.Action
+	}
+	recordAudit(AuditEntry{Action: "reload", Result: "ok", Fields: fields})
+	return result, nil
+}
+
+// watchReloadSignal reloads on SIGHUP. Platforms without it never deliver
+// one, leaving POST /reload.
+func watchReloadSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			result, err := reloadSettings("signal")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Reload failed, keeping current settings: %v\n", err)
+				recordAudit(AuditEntry{Action: "reload", Result: "error", Details: err.Error(),
+					Fields: map[string]string{"trigger": "signal"}})
+				continue
+			}
+			for _, l := range result.Listeners {
+				if l.Error != "" {
+					fmt.Fprintf(os.Stderr, "Reload: %s %s: %s\n", l.Name, l.Action, l.Error)
+				}
+			}
+			fmt.Printf("Settings reloaded; changed: %v\n", result.Changed)
+		}
+	}()
+}
+
+func handleReload(c echo.Context) error {
+	result, err := reloadSettings("api")
+	if err != nil {
+		recordAudit(AuditEntry{Action: "reload", Result: "error", Details: err.Error(),
+			Fields: map[string]string{"trigger": "api"}})
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Reload failed; current settings kept",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+const (
+	defaultRemoteListen = "0.0.0.0:9417"
+	remoteKeyFile       = "remote/jwt.key"
+	remoteAudience      = "aws-mfa-remote"
+	remoteScopeRead     = "read"
+	defaultTokenTTL     = 30 * 24 * time.Hour
+)
+
+// RemoteAccessSettings configures the read-only TCP listener. Changes take
+// effect on the next reload or backend start.
+type RemoteAccessSettings struct {
+	Enabled bool   `json:"enabled"`
+	Listen  string `json:"listen,omitempty"`
+}
+
+type RemoteTokenRequest struct {
+	Name     string `json:"name"`
+	TTLHours int    `json:"ttlHours,omitempty"`
+}
+
+type RemoteTokenResponse struct {
+	Token     string    `json:"token"`
+	Name      string    `json:"name"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+var remoteKeyMu sync.Mutex
+
+type remoteClaims struct {
+	Scope string `json:"scope"`
+	// UID is the OS user the issuing backend runs as, so a multi-user
+	// router knows whose key to verify the token with
+	UID string `json:"uid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func getRemoteKeyPath() string {
+	return filepath.Join(getCacheDir(), remoteKeyFile)
+}
+
+// remoteSigningKey loads the HMAC key, generating one on first use. Deleting
+// the key file (see handleRotateRemoteKey) invalidates every issued token.
+func remoteSigningKey() ([]byte, error) {
+	remoteKeyMu.Lock()
+	defer remoteKeyMu.Unlock()
+
+	path := getRemoteKeyPath()
+	key, err := os.ReadFile(path)
+	if err == nil && len(key) >= 32 {
+		return key, nil
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(path, key, 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func issueRemoteToken(name string, ttl time.Duration) (*RemoteTokenResponse, error) {
+	key, err := remoteSigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, remoteClaims{
+		Scope: remoteScopeRead,
+		UID:   strconv.Itoa(os.Getuid()),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   name,
+			Audience:  jwt.ClaimStrings{remoteAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	})
+	signed, err := token.SignedString(key)
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteTokenResponse{Token: signed, Name: name, ExpiresAt: expiresAt.UTC()}, nil
+}
+
+// remoteAuth requires a valid read-scoped token, either as a bearer header
+// or as ?access_token= for EventSource clients that cannot set headers
+func remoteAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		raw := strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+		if raw == "" {
+			raw = c.QueryParam("access_token")
+		}
+		if raw == "" {
+			return c.JSON(http.StatusUnauthorized, ErrorResponse{
+				Error: "Missing bearer token",
+			})
+		}
+
+		key, err := remoteSigningKey()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error: "Remote access key unavailable",
+			})
+		}
+
+		if _, err := verifyRemoteToken(raw, key); err != nil {
+			return c.JSON(http.StatusUnauthorized, ErrorResponse{
+				Error:   "Invalid token",
+				Details: err.Error(),
+			})
+		}
+
+		return next(c)
+	}
+}
+
+// verifyRemoteToken checks raw's signature against key, and that it is an
+// unexpired read-scoped token for remote access
+func verifyRemoteToken(raw string, key []byte) (*remoteClaims, error) {
+	var claims remoteClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, func(*jwt.Token) (interface{}, error) {
+		return key, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithAudience(remoteAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Scope != remoteScopeRead {
+		return nil, errors.New("token scope does not allow read access")
+	}
+	return &claims, nil
+}
+
+// serveRemote serves the remote routes on listener in the background, for
+// the multi-user router's tenant socket
+func serveRemote(listener net.Listener) {
+	e := newRemoteRouter()
+	go func() {
+		if err := http.Serve(listener, e); err != nil {
+			fmt.Fprintf(os.Stderr, "Remote server error: %v\n", err)
+		}
+	}()
+}
+
+// newRemoteRouter builds the remote read-only routes. Credential, export
+// and mutation routes are intentionally never registered here.
+func newRemoteRouter() *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
+	e.Use(middleware.Recover())
+	e.Use(middleware.CORS())
+
+	e.GET("/health", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	r := e.Group("", remoteAuth)
+	r.GET("/status", handleGetStatus)
+	r.GET("/status/all", handleGetAllStatus)
+	r.GET("/profiles", handleGetProfiles)
+	r.GET("/events", handleEvents)
+	return e
+}
+
+// handleIssueRemoteToken is only registered on the local socket
+func handleIssueRemoteToken(c echo.Context) error {
+	var req RemoteTokenRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	if req.Name == "" {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Token name is required",
+		})
+	}
+
+	ttl := defaultTokenTTL
+	if req.TTLHours > 0 {
+		ttl = time.Duration(req.TTLHours) * time.Hour
+	}
+
+	resp, err := issueRemoteToken(req.Name, ttl)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to issue token",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusCreated, resp)
+}
+
+// handleRotateRemoteKey discards the signing key, revoking all tokens
+func handleRotateRemoteKey(c echo.Context) error {
+	remoteKeyMu.Lock()
+	defer remoteKeyMu.Unlock()
+
+	if err := os.Remove(getRemoteKeyPath()); err != nil && !os.IsNotExist(err) {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to rotate key",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "All remote tokens revoked"})
+}
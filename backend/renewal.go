@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	renewalInterval = time.Minute
+	// Injected containers are typically long-running, so by default they get
+	// a newer session well before the old one hits the expiry buffer
+	defaultRenewAt = 0.8
+
+	eventRenewed  = "renewed"
+	eventExpiring = "expiring"
+)
+
+type RenewalPolicyRequest struct {
+	ContainerID string  `json:"containerId"`
+	Path        string  `json:"path,omitempty"`
+	RenewAt     float64 `json:"renewAt"`
+}
+
+// renewalNotified remembers which deliveries were already reported, so the
+// UI is prompted and failures are audited once per session rather than on
+// every run
+var (
+	renewalMu       sync.Mutex
+	renewalNotified = map[string]bool{}
+)
+
+func firstRenewalNotice(key string) bool {
+	renewalMu.Lock()
+	defer renewalMu.Unlock()
+	if renewalNotified[key] {
+		return false
+	}
+	renewalNotified[key] = true
+	return true
+}
+
+func effectiveRenewAt(e *InventoryEntry) float64 {
+	if e.RenewAt != nil {
+		return *e.RenewAt
+	}
+	if e.Mechanism == mechanismInject {
+		return defaultRenewAt
+	}
+	return 0
+}
+
+// renewalDue returns when the entry's session should be replaced, if it has
+// proactive renewal enabled
+func renewalDue(e *InventoryEntry) (time.Time, bool) {
+	renewAt := effectiveRenewAt(e)
+	if renewAt <= 0 || e.Revoked {
+		return time.Time{}, false
+	}
+	issued := e.IssuedAt
+	if issued.IsZero() {
+		issued = e.DeliveredAt
+	}
+	lifetime := e.ExpiresAt.Sub(issued)
+	return issued.Add(time.Duration(float64(lifetime) * renewAt)), true
+}
+
+// renewalGroup is every inventory entry written to the same file; a
+// multi-profile env file has to be rebuilt as a whole
+type renewalGroup struct {
+	container string
+	path      string
+	entries   []InventoryEntry
+}
+
+func groupForRenewal(entries []InventoryEntry) []*renewalGroup {
+	var groups []*renewalGroup
+	index := map[string]*renewalGroup{}
+	for _, e := range entries {
+		if e.Mechanism != mechanismInject || e.Revoked {
+			continue
+		}
+		key := e.ContainerID + "\x00" + e.Path
+		g, ok := index[key]
+		if !ok {
+			g = &renewalGroup{container: e.ContainerID, path: e.Path}
+			index[key] = g
+			groups = append(groups, g)
+		}
+		g.entries = append(g.entries, e)
+	}
+	return groups
+}
+
+// renewGroup pushes newer sessions to a container once any of its entries
+// is due. MFA sessions can't be minted without a token code, so when there
+// is nothing newer the UI is told the delivered session is about to lapse
+// and prompted for a login, which renews the container once it's done.
+func renewGroup(ctx context.Context, g *renewalGroup, now time.Time) {
+	due := false
+	stale := false
+	for i := range g.entries {
+		e := &g.entries[i]
+		if at, ok := renewalDue(e); ok && !now.Before(at) {
+			due = true
+		}
+		if creds, err := loadCachedCredentials(e.Profile); err == nil && isCredentialsValid(creds) &&
+			sessionGeneration(creds) != e.Generation {
+			stale = true
+		}
+	}
+	if !due {
+		return
+	}
+
+	first := g.entries[0]
+	key := g.container + "\x00" + g.path + "\x00" + first.Generation
+	if !stale {
+		if firstRenewalNotice(key + "\x00" + eventExpiring) {
+			events.publish(Event{
+				Type:    eventExpiring,
+				Profile: first.Profile,
+				Data: map[string]string{
+					"container": first.ContainerName,
+					"path":      g.path,
+					"expiresAt": first.ExpiresAt.UTC().Format(time.RFC3339),
+				},
+			})
+			seen := map[string]bool{}
+			for _, e := range g.entries {
+				if seen[e.Profile] {
+					continue
+				}
+				seen[e.Profile] = true
+				requestMFA(e.Profile, promptReasonRenewal, PromptConsumer{
+					Container: e.ContainerName,
+					Path:      g.path,
+					ExpiresAt: e.ExpiresAt,
+				})
+			}
+		}
+		return
+	}
+
+	var payload *ExportPayload
+	var errResp *ErrorResponse
+	if len(g.entries) == 1 && first.Prefix == "" {
+		payload, _, errResp = buildExportPayload(first.Profile, "")
+	} else {
+		prefixes := make([]ProfilePrefix, 0, len(g.entries))
+		for _, e := range g.entries {
+			prefixes = append(prefixes, ProfilePrefix{Profile: e.Profile, Prefix: e.Prefix})
+		}
+		content, sessions, _, resp := buildMultiProfileEnv(prefixes)
+		payload, errResp = &ExportPayload{Content: content, Sessions: sessions, Prefixes: prefixes}, resp
+	}
+	if errResp != nil {
+		// Some profile in the file has no usable session yet
+		return
+	}
+
+	entry := AuditEntry{
+		Action:  "export.renew",
+		Profile: first.Profile,
+		Result:  "ok",
+		Fields:  map[string]string{"container": first.ContainerName, "path": g.path},
+	}
+
+	target := ExportTarget{Container: g.container, Path: g.path}
+	_, err := deliverExport(ctx, "container", target, payload)
+	if errors.Is(err, errDockerNotFound) {
+		// The container is gone; revoking the inventory cleans it up
+		return
+	}
+	if err != nil {
+		entry.Result = "error"
+		entry.Details = err.Error()
+		if firstRenewalNotice(key + "\x00error") {
+			recordAudit(entry)
+		}
+		fmt.Fprintf(os.Stderr, "renew %s:%s: %v\n", first.ContainerName, g.path, err)
+		return
+	}
+	recordAudit(entry)
+	events.publish(Event{
+		Type:    eventRenewed,
+		Profile: first.Profile,
+		Data:    map[string]string{"container": first.ContainerName, "path": g.path},
+	})
+}
+
+// runExportRenewal is the scheduler job that keeps long-running consumers
+// supplied with fresh sessions
+func runExportRenewal(ctx context.Context) {
+	inventoryMu.Lock()
+	entries := loadInventory()
+	inventoryMu.Unlock()
+
+	now := time.Now()
+	for _, g := range groupForRenewal(entries) {
+		renewGroup(ctx, g, now)
+	}
+}
+
+// handleSetRenewalPolicy changes the renewal fraction for a container's
+// deliveries; 0 disables proactive renewal
+func handleSetRenewalPolicy(c echo.Context) error {
+	var req RenewalPolicyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	if req.ContainerID == "" {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "containerId is required",
+		})
+	}
+	if req.RenewAt < 0 || req.RenewAt >= 1 {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "renewAt must be between 0 and 1",
+		})
+	}
+
+	inventoryMu.Lock()
+	defer inventoryMu.Unlock()
+
+	entries := loadInventory()
+	updated := 0
+	for i := range entries {
+		e := &entries[i]
+		if (e.ContainerID != req.ContainerID && e.ContainerName != req.ContainerID) ||
+			(req.Path != "" && e.Path != req.Path) {
+			continue
+		}
+		renewAt := req.RenewAt
+		e.RenewAt = &renewAt
+		updated++
+	}
+	if updated == 0 {
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "No deliveries recorded for that container",
+		})
+	}
+	if err := saveInventory(entries); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to update inventory",
+			Details: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]int{"updated": updated})
+}
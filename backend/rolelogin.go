@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/ini.v1"
+)
+
+// profileRoleARN returns the profile's role_arn, if it has one
+func profileRoleARN(profile string) string {
+	section, err := getProfileSection(profile)
+	if err != nil {
+		return ""
+	}
+	return section.Key("role_arn").String()
+}
+
+// RoleInfo is what a role profile switches to, for a role picker
+type RoleInfo struct {
+	ARN string `json:"arn"`
+	// AccountID and Name are parsed from the ARN
+	AccountID string `json:"accountId,omitempty"`
+	Name      string `json:"name,omitempty"`
+	// SourceProfile signs the AssumeRole call; CredentialSource is set
+	// instead for roles assumed with the environment's credentials
+	SourceProfile    string `json:"sourceProfile,omitempty"`
+	CredentialSource string `json:"credentialSource,omitempty"`
+	// BaseProfile holds the long-term keys at the start of the chain, and
+	// Chain the role profiles assumed in order from it
+	BaseProfile     string   `json:"baseProfile,omitempty"`
+	Chain           []string `json:"chain,omitempty"`
+	RoleSessionName string   `json:"roleSessionName,omitempty"`
+	HasExternalID   bool     `json:"hasExternalId,omitempty"`
+	// MFARequired is set when the profile or one of its source profiles
+	// has an mfa_serial, which is then the MFASerial used
+	MFARequired bool   `json:"mfaRequired"`
+	MFASerial   string `json:"mfaSerial,omitempty"`
+	// Error explains a chain that can't be followed, such as a loop
+	Error string `json:"error,omitempty"`
+}
+
+// profileRoleInfo describes the role profile in section
+func profileRoleInfo(profile string, section *ini.Section) *RoleInfo {
+	info := &RoleInfo{
+		ARN:              section.Key("role_arn").String(),
+		SourceProfile:    section.Key("source_profile").String(),
+		CredentialSource: section.Key("credential_source").String(),
+		RoleSessionName:  section.Key("role_session_name").String(),
+		HasExternalID:    section.Key("external_id").String() != "",
+	}
+	info.AccountID = accountFromARN(info.ARN)
+	if i := strings.LastIndex(info.ARN, "/"); i >= 0 {
+		info.Name = info.ARN[i+1:]
+	}
+	if info.CredentialSource != "" && info.SourceProfile == "" {
+		// Nothing in the config files to follow
+		return info
+	}
+
+	base, hops, err := roleChain(profile)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.BaseProfile, info.Chain = base, hops
+	if serial, err := roleMFASerial(profile); err == nil {
+		info.MFARequired, info.MFASerial = true, serial
+	}
+	return info
+}
+
+// Role chaining (assuming a role with another role's session) caps the new
+// session at one hour whatever the role allows
+const maxChainedRoleDuration = 3600
+
+// maxRoleSessionNameLen is STS's limit on RoleSessionName
+const maxRoleSessionNameLen = 64
+
+// defaultRoleSessionName names the role sessions of profiles without a
+// role_session_name, within STS's 64-character limit
+func defaultRoleSessionName(profile string) string {
+	name := federationNameInvalid.ReplaceAllString("aws-mfa-"+profile, "-")
+	if len(name) > maxRoleSessionNameLen {
+		name = name[:maxRoleSessionNameLen]
+	}
+	return name
+}
+
+// roleChain follows source_profile links down to the profile whose
+// long-term keys start the chain. hops are the role profiles to assume in
+// order: hops[0] is signed by the base's keys and the last is profile
+// itself. A role profile without source_profile, or naming itself, signs
+// with its own keys and is both the base and the first hop.
+func roleChain(profile string) (base string, hops []string, err error) {
+	visited := map[string]bool{}
+	for current := profile; ; {
+		if visited[current] {
+			return "", nil, fmt.Errorf("source_profile loop at profile %s", current)
+		}
+		visited[current] = true
+
+		if profileRoleARN(current) == "" {
+			return current, hops, nil
+		}
+		hops = append([]string{current}, hops...)
+
+		section, err := getProfileSection(current)
+		if err != nil {
+			return "", nil, err
+		}
+		source := section.Key("source_profile").String()
+		if source == "" || source == current {
+			return current, hops, nil
+		}
+		current = source
+	}
+}
+
+// roleBaseProfile is the profile whose long-term keys start the profile's
+// role chain, or the profile itself when the chain can't be resolved
+func roleBaseProfile(profile string) string {
+	base, _, err := roleChain(profile)
+	if err != nil {
+		return profile
+	}
+	return base
+}
+
+// roleMFASerial follows the CLI: mfa_serial on the role profile, falling
+// back along the chain to the base profile's
+func roleMFASerial(profile string) (string, error) {
+	base, hops, err := roleChain(profile)
+	if err != nil {
+		return "", err
+	}
+	candidates := []string{base}
+	for _, hop := range hops {
+		if hop != base {
+			candidates = append([]string{hop}, candidates...)
+		}
+	}
+	for _, candidate := range candidates {
+		if serial, err := getMFASerial(candidate); err == nil {
+			return serial, nil
+		}
+	}
+	return "", fmt.Errorf("no mfa_serial configured for profile %s or its source profiles", profile)
+}
+
+// roleLoginDuration caps the requested duration at what the role allows:
+// the role's maximum when the identity cache knows it. Otherwise a profile
+// with duration_seconds is trusted to know its role's maximum, and any
+// other is held to the one hour every role permits. Asking for more than
+// the role allows fails the call and wastes the MFA code.
+func roleLoginDuration(profile string, requested int32) int32 {
+	if info := loadIdentity(profile); info != nil && info.RoleMaxDuration > 0 {
+		return min(requested, info.RoleMaxDuration)
+	}
+	if profileDurationSeconds(profile) != 0 {
+		return requested
+	}
+	return min(requested, defaultRoleDuration)
+}
+
+// assumeRoleChain assumes each hop's role with the previous hop's session,
+// starting from cfg. The MFA code, if given, goes with the first hop, which
+// is the one signed by long-term keys. The overrides apply to the last hop.
+func assumeRoleChain(ctx context.Context, cfg aws.Config, hops []string, duration int32, mfaSerial, tokenCode string, o roleOverrides) (*ststypes.Credentials, error) {
+	// Check every hop's tags first so a bad tag doesn't waste the MFA code
+	tags := make([]map[string]string, len(hops))
+	transitive := make([][]string, len(hops))
+	for i, hop := range hops {
+		var err error
+		if tags[i], transitive[i], err = hopSessionTags(hop, o, i == len(hops)-1); err != nil {
+			return nil, err
+		}
+	}
+
+	var creds *ststypes.Credentials
+	for i, hop := range hops {
+		if i > 0 {
+			var err error
+			cfg, err = staticAWSConfig(ctx, hop, &CachedCredentials{
+				AccessKeyID:     aws.ToString(creds.AccessKeyId),
+				SecretAccessKey: aws.ToString(creds.SecretAccessKey),
+				SessionToken:    aws.ToString(creds.SessionToken),
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		section, _ := getProfileSection(hop)
+		sessionName := section.Key("role_session_name").String()
+		if sessionName == "" {
+			sessionName = defaultRoleSessionName(hop)
+		}
+		hopDuration := roleLoginDuration(hop, duration)
+		if i > 0 {
+			hopDuration = min(hopDuration, maxChainedRoleDuration)
+		}
+
+		hopExternalID := section.Key("external_id").String()
+		if o.ExternalID != "" && i == len(hops)-1 {
+			hopExternalID = o.ExternalID
+		}
+		// Only the last hop is scoped; a policy on an earlier hop could
+		// deny the sts:AssumeRole the next hop needs
+		var policy string
+		var policyARNs []string
+		if i == len(hops)-1 {
+			policy, policyARNs = sessionPolicy(hop, o)
+		}
+
+		input := &sts.AssumeRoleInput{
+			RoleArn:           aws.String(profileRoleARN(hop)),
+			RoleSessionName:   aws.String(sessionName),
+			DurationSeconds:   aws.Int32(hopDuration),
+			ExternalId:        optionalString(hopExternalID),
+			Policy:            optionalString(policy),
+			PolicyArns:        stsPolicyARNs(policyARNs),
+			Tags:              stsSessionTags(tags[i]),
+			TransitiveTagKeys: transitive[i],
+		}
+		// The source identity is set once, on the first hop, and carries
+		// down the chain
+		if i == 0 && loadSettings().DeviceBinding {
+			input.SourceIdentity = aws.String(deviceSourceIdentity())
+		}
+		mfa := i == 0 && tokenCode != ""
+		if mfa {
+			input.SerialNumber = aws.String(mfaSerial)
+			input.TokenCode = aws.String(tokenCode)
+		}
+
+		result, err := sts.NewFromConfig(cfg).AssumeRole(ctx, input)
+		stsThrottles.observe(hop, err)
+		if err != nil {
+			if mfa {
+				return nil, fmt.Errorf("MFA authentication failed: %w", err)
+			}
+			return nil, fmt.Errorf("failed to assume role for profile %s: %w", hop, err)
+		}
+		creds = result.Credentials
+	}
+	return creds, nil
+}
+
+// performRoleLogin assumes the profile's role with the MFA code, signing
+// with the long-term keys at the base of its source_profile chain
+func performRoleLogin(ctx context.Context, profile, roleARN, tokenCode string, o roleOverrides, duration int32) (*CachedCredentials, error) {
+	base, hops, err := roleChain(profile)
+	if err != nil {
+		return nil, err
+	}
+	mfaSerial, err := roleMFASerial(profile)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMFASerial(ctx, base, mfaSerial); err != nil {
+		return nil, err
+	}
+
+	cfg, err := baseKeysConfig(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := assumeRoleChain(ctx, cfg, hops, duration, mfaSerial, tokenCode, o)
+	if err != nil {
+		return nil, err
+	}
+	tags, transitive, _ := hopSessionTags(profile, o, true)
+	policy, policyARNs := sessionPolicy(profile, o)
+
+	return &CachedCredentials{
+		AccessKeyID:       *result.AccessKeyId,
+		SecretAccessKey:   *result.SecretAccessKey,
+		SessionToken:      *result.SessionToken,
+		Expiration:        *result.Expiration,
+		Profile:           profile,
+		DeviceID:          getDeviceID(),
+		IssuedAt:          time.Now().UTC(),
+		RoleARN:           roleARN,
+		ExternalID:        o.ExternalID,
+		SessionTags:       tags,
+		TransitiveTags:    transitive,
+		SessionPolicy:     policy,
+		SessionPolicyARNs: policyARNs,
+	}, nil
+}
+
+// handleAssumeRoleLogin is /login for profiles that must assume a role, so
+// a profile without role_arn is refused instead of getting a session token
+func handleAssumeRoleLogin(c echo.Context) error {
+	var req LoginRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	req.Profile = requestProfile(c, req.Profile)
+	if profileRoleARN(req.Profile) == "" {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Profile has no role",
+			Details: "profile " + req.Profile + " has no role_arn",
+		})
+	}
+
+	status, code, errBody := loginProfile(c, &req)
+	if errBody != nil {
+		return c.JSON(code, errBody)
+	}
+	return c.JSON(http.StatusOK, status)
+}
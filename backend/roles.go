@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	rolesSubdir         = "roles"
+	defaultRoleDuration = 3600
+)
+
+// AssumeRoleParams identifies a role session. Everything that changes what
+// the resulting credentials may do is part of the cache key.
+type AssumeRoleParams struct {
+	Profile    string          `json:"profile"`
+	RoleARN    string          `json:"roleArn,omitempty"`
+	Policy     string          `json:"policy,omitempty"`
+	PolicyARNs []string        `json:"policyArns,omitempty"`
+	Duration   SessionDuration `json:"duration,omitempty"`
+	ExternalID string          `json:"externalId,omitempty"`
+	// Tags are merged over the profile's tags when the role comes from the
+	// profile; TransitiveTags then replaces its transitive_session_tags
+	Tags           map[string]string `json:"tags,omitempty"`
+	TransitiveTags []string          `json:"transitiveTags,omitempty"`
+	// MinRemaining skips cached sessions expiring sooner; it doesn't change
+	// what the session may do, so it isn't part of the key
+	MinRemaining time.Duration `json:"-"`
+}
+
+type AssumeRoleResponse struct {
+	RoleARN     string             `json:"roleArn"`
+	Reused      bool               `json:"reused"`
+	Credentials *CachedCredentials `json:"credentials"`
+}
+
+type RoleCacheStats struct {
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	ReuseRate float64 `json:"reuseRate"`
+	Entries   int     `json:"entries"`
+}
+
+type roleSessionCache struct {
+	mu      sync.Mutex
+	hits    int64
+	misses  int64
+	flights flightGroup[roleFlightResult]
+}
+
+var roleCache = &roleSessionCache{}
+
+// key hashes the composite (profile, role, policy, duration, external ID,
+// tags, policy ARNs)
+func (p AssumeRoleParams) key() string {
+	h := sha256.New()
+	parts := []string{p.Profile, p.RoleARN, p.Policy, fmt.Sprint(int32(p.Duration)), p.ExternalID}
+	// Untagged sessions keep the keys they were cached under before tags
+	if len(p.Tags) > 0 || len(p.TransitiveTags) > 0 {
+		parts = append(parts, sessionTagsKey(p.Tags, p.TransitiveTags))
+	}
+	if len(p.PolicyARNs) > 0 {
+		parts = append(parts, "arns:"+strings.Join(p.PolicyARNs, ","))
+	}
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+func getRoleCacheFile(key string) string {
+	return filepath.Join(getCacheDir(), rolesSubdir, key+".json")
+}
+
+// resolveRoleParams fills the role ARN from the profile's role_arn and
+// applies defaults; the profile must already be resolved. The duration
+// defaults to the profile's duration_seconds when its own role is assumed.
+func resolveRoleParams(p *AssumeRoleParams) error {
+	if p.RoleARN == "" {
+		if section, err := getProfileSection(p.Profile); err == nil {
+			p.RoleARN = section.Key("role_arn").String()
+			if secs := profileDurationSeconds(p.Profile); p.Duration == 0 && p.RoleARN != "" && secs != 0 {
+				p.Duration = min(max(secs, SessionDuration(assumeRoleLimits.Min)), SessionDuration(assumeRoleLimits.Max))
+			}
+			if p.ExternalID == "" {
+				p.ExternalID = section.Key("external_id").String()
+			}
+			tags, transitive, err := parseSessionTags(section)
+			if err != nil {
+				return err
+			}
+			p.Tags, p.TransitiveTags = mergeSessionTags(tags, transitive,
+				roleOverrides{Tags: p.Tags, TransitiveTags: p.TransitiveTags})
+			if p.RoleARN != "" {
+				p.Policy, p.PolicyARNs = sessionPolicy(p.Profile, roleOverrides{Policy: p.Policy, PolicyARNs: p.PolicyARNs})
+			}
+		}
+	}
+	if p.RoleARN == "" {
+		return fmt.Errorf("no roleArn given and profile %s has no role_arn", p.Profile)
+	}
+	if p.Duration == 0 {
+		p.Duration = defaultRoleDuration
+	}
+	return nil
+}
+
+// assumeRole returns a role session derived from the profile's MFA session,
+// reusing a cached one for identical parameters while it remains valid
+func assumeRole(ctx context.Context, p AssumeRoleParams) (*CachedCredentials, bool, error) {
+	key := p.key()
+	path := getRoleCacheFile(key)
+
+	// Identical requests share one AssumeRole; requests for other roles
+	// don't wait on it
+	result, err := roleCache.flights.do(key+"/"+p.MinRemaining.String(), func() (roleFlightResult, error) {
+		if creds := cachedRoleSession(path, p.MinRemaining); creds != nil {
+			roleCache.count(true)
+			return roleFlightResult{creds, true}, nil
+		}
+		roleCache.count(false)
+		creds, err := mintRoleSession(ctx, p, path)
+		return roleFlightResult{creds, false}, err
+	})
+	return result.creds, result.reused, err
+}
+
+type roleFlightResult struct {
+	creds  *CachedCredentials
+	reused bool
+}
+
+func (r *roleSessionCache) count(hit bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if hit {
+		r.hits++
+	} else {
+		r.misses++
+	}
+}
+
+// cachedRoleSession returns the session cached at path if it's still valid
+// for at least minRemaining
+func cachedRoleSession(path string, minRemaining time.Duration) *CachedCredentials {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var creds CachedCredentials
+	if decodeCacheFile(path, data, &creds) == nil && isCredentialsValid(&creds) && checkDeviceBinding(&creds) == nil &&
+		time.Until(creds.Expiration) >= minRemaining {
+		return &creds
+	}
+	return nil
+}
+
+// mintRoleSession assumes the role from the profile's MFA session and
+// caches the result at path
+func mintRoleSession(ctx context.Context, p AssumeRoleParams, path string) (*CachedCredentials, error) {
+	cfg, base, err := sessionAWSConfig(ctx, p.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &sts.AssumeRoleInput{
+		RoleArn:           aws.String(p.RoleARN),
+		RoleSessionName:   aws.String(defaultRoleSessionName(p.Profile)),
+		DurationSeconds:   aws.Int32(int32(p.Duration)),
+		Policy:            optionalString(p.Policy),
+		PolicyArns:        stsPolicyARNs(p.PolicyARNs),
+		ExternalId:        optionalString(p.ExternalID),
+		Tags:              stsSessionTags(p.Tags),
+		TransitiveTagKeys: p.TransitiveTags,
+	}
+	result, err := sts.NewFromConfig(cfg).AssumeRole(ctx, input)
+	stsThrottles.observe(p.Profile, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to assume role: %w", err)
+	}
+
+	creds := &CachedCredentials{
+		AccessKeyID:       *result.Credentials.AccessKeyId,
+		SecretAccessKey:   *result.Credentials.SecretAccessKey,
+		SessionToken:      *result.Credentials.SessionToken,
+		Expiration:        *result.Credentials.Expiration,
+		Profile:           p.Profile,
+		DeviceID:          base.DeviceID,
+		IssuedAt:          time.Now().UTC(),
+		RoleARN:           p.RoleARN,
+		SessionTags:       p.Tags,
+		TransitiveTags:    p.TransitiveTags,
+		SessionPolicy:     p.Policy,
+		SessionPolicyARNs: p.PolicyARNs,
+		SourceGeneration:  sessionGeneration(base),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return nil, err
+	}
+
+	return creds, nil
+}
+
+func (r *roleSessionCache) stats() RoleCacheStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	files, _ := filepath.Glob(filepath.Join(getCacheDir(), rolesSubdir, "*.json"))
+	stats := RoleCacheStats{Hits: r.hits, Misses: r.misses, Entries: len(files)}
+	if total := r.hits + r.misses; total > 0 {
+		stats.ReuseRate = float64(r.hits) / float64(total)
+	}
+	return stats
+}
+
+// clearRoleSessions drops cached role sessions derived from profile, or all
+// of them for an empty profile
+func clearRoleSessions(profile string) {
+	roleCache.mu.Lock()
+	defer roleCache.mu.Unlock()
+
+	files, _ := filepath.Glob(filepath.Join(getCacheDir(), rolesSubdir, "*.json"))
+	for _, f := range files {
+		if profile != "" {
+			data, err := os.ReadFile(f)
+			if err != nil {
+				continue
+			}
+			var creds CachedCredentials
+			if json.Unmarshal(data, &creds) != nil || creds.Profile != profile {
+				continue
+			}
+		}
+		os.Remove(f)
+	}
+}
+
+func handleAssumeRole(c echo.Context) error {
+	var params AssumeRoleParams
+	if err := c.Bind(&params); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	params.Profile = requestProfile(c, params.Profile)
+	if err := resolveRoleParams(&params); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Role not specified",
+			Details: err.Error(),
+		})
+	}
+
+	if err := validateSessionTags(params.Tags, params.TransitiveTags); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid session tags",
+			Details: err.Error(),
+		})
+	}
+
+	if err := validateSessionPolicy(params.Policy, params.PolicyARNs); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid session policy",
+			Details: err.Error(),
+		})
+	}
+
+	if err := assumeRoleLimits.check(params.Duration); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid duration",
+			Details: err.Error(),
+		})
+	}
+
+	if resp := requireConfirmation(c, confirmExport, params.Profile); resp != nil {
+		return c.JSON(http.StatusPreconditionRequired, resp)
+	}
+	creds, reused, err := assumeRole(c.Request().Context(), params)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to assume role",
+			Details: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, AssumeRoleResponse{
+		RoleARN:     params.RoleARN,
+		Reused:      reused,
+		Credentials: creds,
+	})
+}
+
+func handleRoleCacheStats(c echo.Context) error {
+	return c.JSON(http.StatusOK, roleCache.stats())
+}
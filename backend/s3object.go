@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/labstack/echo/v4"
+)
+
+// Uploads and downloads go through the backend socket, so they are capped
+// to keep them to build artifacts and config files
+const maxS3ObjectSize = 50 << 20
+
+var errObjectTooLarge = errors.New("object exceeds the size limit")
+
+type S3ObjectResponse struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Size   int64  `json:"size"`
+	ETag   string `json:"etag,omitempty"`
+}
+
+type s3ObjectRef struct {
+	profile string
+	bucket  string
+	key     string
+}
+
+func (r *s3ObjectRef) audit(action string, err error) {
+	entry := AuditEntry{
+		Action:  action,
+		Profile: r.profile,
+		Result:  "ok",
+		Fields:  map[string]string{"bucket": r.bucket, "key": r.key},
+	}
+	if err != nil {
+		entry.Result = "error"
+		entry.Details = err.Error()
+	}
+	recordAudit(entry)
+}
+
+// s3ObjectClient returns an S3 client for the request's session, pointed at
+// ?region= when the bucket lives outside the profile's region. On failure
+// it returns the HTTP status and body to respond with.
+func s3ObjectClient(c echo.Context) (*s3.Client, *s3ObjectRef, int, *ErrorResponse) {
+	ref := &s3ObjectRef{
+		profile: requestProfile(c, c.QueryParam("profile")),
+		bucket:  c.QueryParam("bucket"),
+		key:     c.QueryParam("key"),
+	}
+	if ref.bucket == "" || ref.key == "" {
+		return nil, nil, http.StatusBadRequest, &ErrorResponse{
+			Error:   "Invalid S3 request",
+			Details: "bucket and key are required",
+		}
+	}
+
+	cfg, _, err := sessionAWSConfig(c.Request().Context(), ref.profile)
+	if err != nil {
+		return nil, nil, http.StatusUnauthorized, &ErrorResponse{
+			Error:   "No valid session",
+			Details: err.Error(),
+		}
+	}
+	if region := c.QueryParam("region"); region != "" {
+		cfg.Region = region
+	}
+	return s3.NewFromConfig(cfg), ref, 0, nil
+}
+
+// handlePutS3Object streams the request body to S3. A Content-Length is
+// required so oversized uploads are refused before any data is sent.
+func handlePutS3Object(c echo.Context) error {
+	client, ref, status, errResp := s3ObjectClient(c)
+	if errResp != nil {
+		return c.JSON(status, errResp)
+	}
+
+	r := c.Request()
+	if r.ContentLength < 0 {
+		return c.JSON(http.StatusLengthRequired, ErrorResponse{
+			Error: "Content-Length is required",
+		})
+	}
+	if r.ContentLength > maxS3ObjectSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+			Error:   "Object too large",
+			Details: fmt.Sprintf("limit is %d bytes", maxS3ObjectSize),
+		})
+	}
+
+	contentType := r.Header.Get(echo.HeaderContentType)
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(ref.key))
+	}
+
+	out, err := client.PutObject(r.Context(), &s3.PutObjectInput{
+		Bucket:        aws.String(ref.bucket),
+		Key:           aws.String(ref.key),
+		Body:          http.MaxBytesReader(c.Response(), r.Body, maxS3ObjectSize),
+		ContentLength: aws.Int64(r.ContentLength),
+		ContentType:   optionalString(contentType),
+	})
+	ref.audit("s3.put", err)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to upload object",
+			Details: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, S3ObjectResponse{
+		Bucket: ref.bucket,
+		Key:    ref.key,
+		Size:   r.ContentLength,
+		ETag:   aws.ToString(out.ETag),
+	})
+}
+
+// openS3Object starts a download, refusing objects over the limit
+func openS3Object(ctx context.Context, client *s3.Client, bucket, key string) (*s3.GetObjectOutput, error) {
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if aws.ToInt64(out.ContentLength) > maxS3ObjectSize {
+		out.Body.Close()
+		return nil, errObjectTooLarge
+	}
+	return out, nil
+}
+
+func handleGetS3Object(c echo.Context) error {
+	client, ref, status, errResp := s3ObjectClient(c)
+	if errResp != nil {
+		return c.JSON(status, errResp)
+	}
+
+	out, err := openS3Object(c.Request().Context(), client, ref.bucket, ref.key)
+	ref.audit("s3.get", err)
+	if errors.Is(err, errObjectTooLarge) {
+		return c.JSON(http.StatusRequestEntityTooLarge, ErrorResponse{
+			Error:   "Object too large",
+			Details: fmt.Sprintf("limit is %d bytes", maxS3ObjectSize),
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to download object",
+			Details: err.Error(),
+		})
+	}
+	defer out.Body.Close()
+
+	contentType := aws.ToString(out.ContentType)
+	if contentType == "" {
+		contentType = echo.MIMEOctetStream
+	}
+	h := c.Response().Header()
+	h.Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(ref.key)}))
+	h.Set(echo.HeaderContentLength, strconv.FormatInt(aws.ToInt64(out.ContentLength), 10))
+	return c.Stream(http.StatusOK, contentType, out.Body)
+}
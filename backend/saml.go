@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	sourceTypeSAML = "saml"
+
+	samlCommandTimeout = 2 * time.Minute
+	// Assertions with many roles and an embedded certificate run to tens of
+	// kilobytes; anything much bigger isn't one
+	maxSAMLOutput = 512 * 1024
+	// samlAssertionReuse is how long an assertion is kept between listing
+	// its roles and logging in, so the IdP isn't asked twice
+	samlAssertionReuse = 5 * time.Minute
+
+	samlRoleAttribute     = "https://aws.amazon.com/SAML/Attributes/Role"
+	samlDurationAttribute = "https://aws.amazon.com/SAML/Attributes/SessionDuration"
+)
+
+var errSAMLRoleSelection = errors.New("the assertion grants several roles; choose one")
+
+// SAMLRolePair is a role the assertion allows and the identity provider
+// that vouches for it, as AssumeRoleWithSAML takes them
+type SAMLRolePair struct {
+	RoleARN      string `json:"roleArn"`
+	PrincipalARN string `json:"principalArn"`
+}
+
+type SAMLRolesResponse struct {
+	Profile string         `json:"profile"`
+	Roles   []SAMLRolePair `json:"roles"`
+	// Selected is the pair a login without a roleArn would use, if any
+	Selected *SAMLRolePair `json:"selected,omitempty"`
+	// MaxDuration is the SessionDuration the IdP asserted, in seconds
+	MaxDuration int32     `json:"maxDuration,omitempty"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+type SAMLLoginRequest struct {
+	Profile      string          `json:"profile"`
+	RoleARN      string          `json:"roleArn,omitempty"`
+	PrincipalARN string          `json:"principalArn,omitempty"`
+	Duration     SessionDuration `json:"duration,omitempty"`
+}
+
+// samlAssertion is an assertion printed by a profile's saml_command
+type samlAssertion struct {
+	encoded     string
+	roles       []SAMLRolePair
+	maxDuration int32
+	expiresAt   time.Time
+}
+
+var samlAssertions = struct {
+	mu        sync.Mutex
+	byProfile map[string]*samlAssertion
+}{byProfile: make(map[string]*samlAssertion)}
+
+// profileSAMLCommand returns the command that prints a SAML assertion for
+// the profile, such as a wrapper around an ADFS or Okta client
+func profileSAMLCommand(profile string) string {
+	section, err := getProfileSection(profile)
+	if err != nil {
+		return ""
+	}
+	return section.Key("saml_command").String()
+}
+
+// runSAMLCommand runs the profile's saml_command and reads the assertion it
+// prints, base64 encoded or as raw XML. The assertion is a credential, so
+// like credential_process output it never appears in errors.
+func runSAMLCommand(ctx context.Context, profile, command string) (*samlAssertion, error) {
+	out, err := runProfileCommand(ctx, profile, "saml_command", command, samlCommandTimeout, maxSAMLOutput)
+	if err != nil {
+		return nil, err
+	}
+
+	out = bytes.TrimSpace(out)
+	var encoded string
+	var doc []byte
+	if bytes.HasPrefix(out, []byte("<")) {
+		doc = out
+		encoded = base64.StdEncoding.EncodeToString(out)
+	} else {
+		encoded = string(bytes.Join(bytes.Fields(out), nil))
+		if doc, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return nil, fmt.Errorf("saml_command for %s did not print a base64 SAML assertion", profile)
+		}
+	}
+
+	a, err := parseSAMLAssertion(doc)
+	if err != nil {
+		return nil, fmt.Errorf("saml_command for %s: %w", profile, err)
+	}
+	a.encoded = encoded
+	return a, nil
+}
+
+// parseSAMLAssertion reads the AWS role attribute, the session duration and
+// the validity window from a SAML response. Namespace prefixes vary by IdP,
+// so elements are matched by local name.
+func parseSAMLAssertion(doc []byte) (*samlAssertion, error) {
+	a := &samlAssertion{expiresAt: time.Now().Add(samlAssertionReuse)}
+	dec := xml.NewDecoder(bytes.NewReader(doc))
+	var attribute string
+	var inValue bool
+	var value strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("assertion is not valid XML")
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "Attribute":
+				attribute = xmlAttr(t, "Name")
+			case "AttributeValue":
+				inValue = true
+				value.Reset()
+			case "Conditions", "SubjectConfirmationData":
+				if at, err := time.Parse(time.RFC3339, xmlAttr(t, "NotOnOrAfter")); err == nil && at.Before(a.expiresAt) {
+					a.expiresAt = at
+				}
+			}
+		case xml.CharData:
+			if inValue {
+				value.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "Attribute":
+				attribute = ""
+			case "AttributeValue":
+				inValue = false
+				v := strings.TrimSpace(value.String())
+				switch attribute {
+				case samlRoleAttribute:
+					if pair, ok := parseSAMLRolePair(v); ok {
+						a.roles = append(a.roles, pair)
+					}
+				case samlDurationAttribute:
+					if secs, err := strconv.ParseInt(v, 10, 32); err == nil {
+						a.maxDuration = int32(secs)
+					}
+				}
+			}
+		}
+	}
+	if len(a.roles) == 0 {
+		return nil, fmt.Errorf("assertion grants no AWS roles")
+	}
+	return a, nil
+}
+
+func xmlAttr(e xml.StartElement, name string) string {
+	for _, attr := range e.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// parseSAMLRolePair splits a role attribute value, "role ARN,provider ARN"
+// in either order
+func parseSAMLRolePair(v string) (SAMLRolePair, bool) {
+	first, second, ok := strings.Cut(v, ",")
+	if !ok {
+		return SAMLRolePair{}, false
+	}
+	first, second = strings.TrimSpace(first), strings.TrimSpace(second)
+	if strings.Contains(first, ":saml-provider/") {
+		first, second = second, first
+	}
+	if !strings.Contains(first, ":role/") || !strings.Contains(second, ":saml-provider/") {
+		return SAMLRolePair{}, false
+	}
+	return SAMLRolePair{RoleARN: first, PrincipalARN: second}, true
+}
+
+// profileSAMLAssertion returns a recent assertion for profile, running its
+// saml_command when there is none or refresh is set
+func profileSAMLAssertion(ctx context.Context, profile string, refresh bool) (*samlAssertion, error) {
+	command := profileSAMLCommand(profile)
+	if command == "" {
+		return nil, fmt.Errorf("profile %s has no saml_command", profile)
+	}
+
+	samlAssertions.mu.Lock()
+	a, ok := samlAssertions.byProfile[profile]
+	samlAssertions.mu.Unlock()
+	if ok && !refresh && time.Now().Before(a.expiresAt) {
+		return a, nil
+	}
+
+	a, err := runSAMLCommand(ctx, profile, command)
+	if err != nil {
+		return nil, err
+	}
+	samlAssertions.mu.Lock()
+	samlAssertions.byProfile[profile] = a
+	samlAssertions.mu.Unlock()
+	return a, nil
+}
+
+// selectSAMLRole picks the pair to assume: the one asked for, else the
+// profile's saml_role_arn (and saml_principal_arn), else the only one
+func selectSAMLRole(profile string, roles []SAMLRolePair, roleARN, principalARN string) (*SAMLRolePair, error) {
+	if roleARN == "" {
+		if section, err := getProfileSection(profile); err == nil {
+			roleARN = section.Key("saml_role_arn").String()
+			principalARN = section.Key("saml_principal_arn").String()
+		}
+	}
+	if roleARN == "" {
+		if len(roles) == 1 {
+			return &roles[0], nil
+		}
+		return nil, errSAMLRoleSelection
+	}
+	for i, pair := range roles {
+		if pair.RoleARN == roleARN && (principalARN == "" || pair.PrincipalARN == principalARN) {
+			return &roles[i], nil
+		}
+	}
+	return nil, fmt.Errorf("the assertion does not grant %s", roleARN)
+}
+
+// assumeRoleWithSAML exchanges the assertion for a session. The call is
+// authenticated by the assertion, so the client has no credentials.
+func assumeRoleWithSAML(ctx context.Context, profile string, a *samlAssertion, pair *SAMLRolePair, duration int32) (*CachedCredentials, error) {
+	opts := append([]func(*config.LoadOptions) error{
+		config.WithRegion(getProfileRegion(profile)),
+		config.WithCredentialsProvider(aws.AnonymousCredentials{}),
+	}, awsLoadOptions(profile)...)
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	result, err := sts.NewFromConfig(cfg).AssumeRoleWithSAML(ctx, &sts.AssumeRoleWithSAMLInput{
+		RoleArn:         aws.String(pair.RoleARN),
+		PrincipalArn:    aws.String(pair.PrincipalARN),
+		SAMLAssertion:   aws.String(a.encoded),
+		DurationSeconds: aws.Int32(duration),
+	})
+	stsThrottles.observe(profile, err)
+	if err != nil {
+		return nil, fmt.Errorf("SAML federation failed: %w", err)
+	}
+
+	return &CachedCredentials{
+		AccessKeyID:     *result.Credentials.AccessKeyId,
+		SecretAccessKey: *result.Credentials.SecretAccessKey,
+		SessionToken:    *result.Credentials.SessionToken,
+		Expiration:      *result.Credentials.Expiration,
+		Profile:         profile,
+		DeviceID:        getDeviceID(),
+		IssuedAt:        time.Now().UTC(),
+		RoleARN:         pair.RoleARN,
+	}, nil
+}
+
+// handleSAMLRoles lists the roles the profile's IdP grants. The assertion
+// is kept briefly so the login that follows doesn't run the command again.
+func handleSAMLRoles(c echo.Context) error {
+	profile := requestProfile(c, c.QueryParam("profile"))
+	if profileSAMLCommand(profile) == "" {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Profile is not a SAML profile",
+			Details: "profile " + profile + " has no saml_command",
+		})
+	}
+
+	a, err := profileSAMLAssertion(c.Request().Context(), profile, c.QueryParam("refresh") == "true")
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to get SAML assertion",
+			Details: err.Error(),
+		})
+	}
+
+	resp := SAMLRolesResponse{Profile: profile, Roles: a.roles, MaxDuration: a.maxDuration, ExpiresAt: a.expiresAt.UTC()}
+	if pair, err := selectSAMLRole(profile, a.roles, "", ""); err == nil {
+		resp.Selected = pair
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// handleSAMLLogin federates into the chosen role and caches the session
+func handleSAMLLogin(c echo.Context) error {
+	var req SAMLLoginRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	profile := requestProfile(c, req.Profile)
+	if profileSAMLCommand(profile) == "" {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Profile is not a SAML profile",
+			Details: "profile " + profile + " has no saml_command",
+		})
+	}
+	if req.Duration != 0 {
+		if err := loginDurationLimits(profile).check(req.Duration); err != nil {
+			return c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid duration",
+				Details: err.Error(),
+			})
+		}
+	}
+
+	if resp := requireConfirmation(c, confirmLogin, profile); resp != nil {
+		return c.JSON(http.StatusPreconditionRequired, resp)
+	}
+
+	ctx := c.Request().Context()
+	fields := map[string]string{"method": "saml"}
+	hooks, ok := runLoginHooks(ctx, hookPreLogin, profile, nil)
+	if !ok {
+		recordAudit(AuditEntry{Action: "login", Profile: profile, Result: "error", Details: "pre-login hook failed", Fields: fields})
+		return c.JSON(http.StatusPreconditionFailed, map[string]interface{}{
+			"error": "Pre-login hook failed",
+			"hooks": hooks,
+		})
+	}
+
+	a, err := profileSAMLAssertion(ctx, profile, false)
+	if err != nil {
+		recordAudit(AuditEntry{Action: "login", Profile: profile, Result: "error", Details: err.Error(), Fields: fields})
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to get SAML assertion",
+			Details: err.Error(),
+		})
+	}
+	pair, err := selectSAMLRole(profile, a.roles, req.RoleARN, req.PrincipalARN)
+	if errors.Is(err, errSAMLRoleSelection) {
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"error": "Role selection required",
+			"roles": a.roles,
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Role not granted",
+			Details: err.Error(),
+		})
+	}
+
+	// Without a requested duration, stay within what the IdP asserted
+	duration := req.Duration
+	if duration == 0 {
+		duration = defaultLoginDuration(profile)
+		if a.maxDuration > 0 && int32(duration) > a.maxDuration {
+			duration = SessionDuration(a.maxDuration)
+		}
+	}
+	if clamped, rule := policyMaxDuration(profile, int32(duration)); rule != nil {
+		recordAudit(AuditEntry{
+			Action:  "policy." + rule.Type,
+			Profile: profile,
+			Result:  "clamped",
+			Details: fmt.Sprintf("requested duration %ds reduced to %ds", duration, clamped),
+			Fields:  map[string]string{"rule": rule.Name},
+		})
+		duration = SessionDuration(clamped)
+	}
+
+	fields["role"] = pair.RoleARN
+	creds, err := assumeRoleWithSAML(ctx, profile, a, pair, int32(duration))
+	if err != nil {
+		recordAudit(AuditEntry{Action: "login", Profile: profile, Result: "error", Details: err.Error(), Fields: fields})
+		return c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error:   "Authentication failed",
+			Details: err.Error(),
+		})
+	}
+
+	// An assertion is good for one session
+	samlAssertions.mu.Lock()
+	delete(samlAssertions.byProfile, profile)
+	samlAssertions.mu.Unlock()
+
+	status, err := finishLogin(c, creds, hooks, fields)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to cache credentials",
+			Details: err.Error(),
+		})
+	}
+	status.DurationSeconds = int32(duration)
+	return c.JSON(http.StatusOK, status)
+}
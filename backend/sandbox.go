@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/labstack/echo/v4"
+)
+
+// Sandboxes are throwaway resources created with a profile's session and
+// tagged with it. The backend records what it created, and only ever
+// destroys resources from that record.
+const (
+	sandboxSubdir   = "sandboxes"
+	sandboxFile     = "sandboxes.json"
+	sandboxPrefix   = "aws-mfa-sbx-"
+	sandboxKindS3   = "s3"
+	sandboxKindDDB  = "dynamodb"
+	sandboxTagKey   = "aws-mfa:sandbox"
+	sandboxKeyAttr  = "pk"
+	maxSandboxName  = 63
+	s3DeleteBatch   = 1000
+	sandboxIDLength = 4
+	// sandboxCleanupTimeout bounds deleting a bucket whose tagging failed
+	sandboxCleanupTimeout = 30 * time.Second
+)
+
+var sandboxNameInvalid = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// sandboxGoneCodes mean the resource was already deleted elsewhere
+var sandboxGoneCodes = map[string]bool{
+	"NoSuchBucket":              true,
+	"ResourceNotFoundException": true,
+}
+
+type Sandbox struct {
+	Name      string    `json:"name"`
+	Kind      string    `json:"kind"`
+	Profile   string    `json:"profile"`
+	Region    string    `json:"region"`
+	Session   string    `json:"session"`
+	CreatedAt time.Time `json:"createdAt"`
+	// SessionActive says whether the session that created it is still the
+	// profile's current one
+	SessionActive bool `json:"sessionActive"`
+}
+
+type CreateSandboxRequest struct {
+	Profile string `json:"profile"`
+	Kind    string `json:"kind"`
+	Region  string `json:"region,omitempty"`
+}
+
+var sandboxMu sync.Mutex
+
+func getSandboxesPath() string {
+	return filepath.Join(getCacheDir(), sandboxSubdir, sandboxFile)
+}
+
+func loadSandboxes() []Sandbox {
+	data, err := os.ReadFile(getSandboxesPath())
+	if err != nil {
+		return []Sandbox{}
+	}
+	var sandboxes []Sandbox
+	if err := json.Unmarshal(data, &sandboxes); err != nil {
+		return []Sandbox{}
+	}
+	return sandboxes
+}
+
+func saveSandboxes(sandboxes []Sandbox) error {
+	path := getSandboxesPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sandboxes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// sandboxName builds a name valid for both buckets and tables
+func sandboxName(profile string) (string, error) {
+	buf := make([]byte, sandboxIDLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	suffix := "-" + hex.EncodeToString(buf)
+	middle := strings.Trim(sandboxNameInvalid.ReplaceAllString(strings.ToLower(profile), "-"), "-")
+	if room := maxSandboxName - len(sandboxPrefix) - len(suffix); len(middle) > room {
+		middle = middle[:room]
+	}
+	return sandboxPrefix + middle + suffix, nil
+}
+
+func sandboxTags(sb Sandbox) map[string]string {
+	return map[string]string{
+		sandboxTagKey:      "true",
+		"aws-mfa:profile":  sb.Profile,
+		"aws-mfa:session":  sb.Session,
+		"aws-mfa:created":  sb.CreatedAt.Format(time.RFC3339),
+		"aws-mfa:deviceId": getDeviceID(),
+	}
+}
+
+func createS3Sandbox(ctx context.Context, cfg aws.Config, sb Sandbox) error {
+	client := s3.NewFromConfig(cfg)
+	input := &s3.CreateBucketInput{Bucket: aws.String(sb.Name)}
+	// us-east-1 is the one region that rejects its own location constraint
+	if sb.Region != "us-east-1" {
+		input.CreateBucketConfiguration = &s3types.CreateBucketConfiguration{
+			LocationConstraint: s3types.BucketLocationConstraint(sb.Region),
+		}
+	}
+	if _, err := client.CreateBucket(ctx, input); err != nil {
+		return err
+	}
+
+	var tags []s3types.Tag
+	for k, v := range sandboxTags(sb) {
+		tags = append(tags, s3types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	_, err := client.PutBucketTagging(ctx, &s3.PutBucketTaggingInput{
+		Bucket:  aws.String(sb.Name),
+		Tagging: &s3types.Tagging{TagSet: tags},
+	})
+	if err != nil {
+		// An untagged bucket is never recorded, so nothing would clean it
+		// up; delete it, even if ctx is what ran out
+		cleanup, cancel := context.WithTimeout(context.WithoutCancel(ctx), sandboxCleanupTimeout)
+		defer cancel()
+		if _, delErr := client.DeleteBucket(cleanup, &s3.DeleteBucketInput{Bucket: aws.String(sb.Name)}); delErr != nil {
+			return fmt.Errorf("tagging bucket: %w; deleting it: %v", err, delErr)
+		}
+		return fmt.Errorf("tagging bucket: %w", err)
+	}
+	return nil
+}
+
+func createDynamoSandbox(ctx context.Context, cfg aws.Config, sb Sandbox) error {
+	var tags []ddbtypes.Tag
+	for k, v := range sandboxTags(sb) {
+		tags = append(tags, ddbtypes.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	_, err := dynamodb.NewFromConfig(cfg).CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName:   aws.String(sb.Name),
+		BillingMode: ddbtypes.BillingModePayPerRequest,
+		AttributeDefinitions: []ddbtypes.AttributeDefinition{
+			{AttributeName: aws.String(sandboxKeyAttr), AttributeType: ddbtypes.ScalarAttributeTypeS},
+		},
+		KeySchema: []ddbtypes.KeySchemaElement{
+			{AttributeName: aws.String(sandboxKeyAttr), KeyType: ddbtypes.KeyTypeHash},
+		},
+		Tags: tags,
+	})
+	return err
+}
+
+// emptyBucket deletes every object version and delete marker, which a
+// bucket must be rid of before it can be deleted
+func emptyBucket(ctx context.Context, client *s3.Client, bucket string) error {
+	p := s3.NewListObjectVersionsPaginator(client, &s3.ListObjectVersionsInput{Bucket: aws.String(bucket)})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+
+		var ids []s3types.ObjectIdentifier
+		for _, v := range page.Versions {
+			ids = append(ids, s3types.ObjectIdentifier{Key: v.Key, VersionId: v.VersionId})
+		}
+		for _, m := range page.DeleteMarkers {
+			ids = append(ids, s3types.ObjectIdentifier{Key: m.Key, VersionId: m.VersionId})
+		}
+		for len(ids) > 0 {
+			batch := ids[:min(len(ids), s3DeleteBatch)]
+			ids = ids[len(batch):]
+			if _, err := client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+				Bucket: aws.String(bucket),
+				Delete: &s3types.Delete{Objects: batch, Quiet: aws.Bool(true)},
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func destroySandbox(ctx context.Context, cfg aws.Config, sb Sandbox) error {
+	switch sb.Kind {
+	case sandboxKindS3:
+		client := s3.NewFromConfig(cfg)
+		if err := emptyBucket(ctx, client, sb.Name); err != nil {
+			return err
+		}
+		_, err := client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(sb.Name)})
+		return err
+	case sandboxKindDDB:
+		_, err := dynamodb.NewFromConfig(cfg).DeleteTable(ctx, &dynamodb.DeleteTableInput{TableName: aws.String(sb.Name)})
+		return err
+	}
+	return fmt.Errorf("unknown sandbox kind %q", sb.Kind)
+}
+
+func auditSandbox(action string, sb Sandbox, err error) {
+	entry := AuditEntry{
+		Action:  action,
+		Profile: sb.Profile,
+		Result:  "ok",
+		Fields:  map[string]string{"kind": sb.Kind, "name": sb.Name, "region": sb.Region},
+	}
+	if err != nil {
+		entry.Result = "error"
+		entry.Details = err.Error()
+	}
+	recordAudit(entry)
+}
+
+func handleListSandboxes(c echo.Context) error {
+	profile := c.QueryParam("profile")
+
+	sandboxMu.Lock()
+	sandboxes := loadSandboxes()
+	sandboxMu.Unlock()
+
+	generations := map[string]string{}
+	out := []Sandbox{}
+	for _, sb := range sandboxes {
+		if profile != "" && sb.Profile != profile {
+			continue
+		}
+		gen, ok := generations[sb.Profile]
+		if !ok {
+			if creds, err := loadCachedCredentials(sb.Profile); err == nil && isCredentialsValid(creds) {
+				gen = sessionGeneration(creds)
+			}
+			generations[sb.Profile] = gen
+		}
+		sb.SessionActive = gen != "" && gen == sb.Session
+		out = append(out, sb)
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+func handleCreateSandbox(c echo.Context) error {
+	var req CreateSandboxRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	if req.Kind != sandboxKindS3 && req.Kind != sandboxKindDDB {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Kind must be s3 or dynamodb",
+		})
+	}
+	req.Profile = requestProfile(c, req.Profile)
+
+	ctx := c.Request().Context()
+	cfg, creds, err := sessionAWSConfig(ctx, req.Profile)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error:   "No valid session",
+			Details: err.Error(),
+		})
+	}
+	if req.Region != "" {
+		cfg.Region = req.Region
+	}
+
+	name, err := sandboxName(req.Profile)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to name sandbox",
+			Details: err.Error(),
+		})
+	}
+	sb := Sandbox{
+		Name:      name,
+		Kind:      req.Kind,
+		Profile:   req.Profile,
+		Region:    cfg.Region,
+		Session:   sessionGeneration(creds),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if sb.Kind == sandboxKindS3 {
+		err = createS3Sandbox(ctx, cfg, sb)
+	} else {
+		err = createDynamoSandbox(ctx, cfg, sb)
+	}
+	auditSandbox("sandbox.create", sb, err)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to create sandbox",
+			Details: err.Error(),
+		})
+	}
+
+	sandboxMu.Lock()
+	err = saveSandboxes(append(loadSandboxes(), sb))
+	sandboxMu.Unlock()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Sandbox created but not recorded",
+			Details: err.Error(),
+		})
+	}
+
+	sb.SessionActive = true
+	return c.JSON(http.StatusCreated, sb)
+}
+
+// handleDestroySandbox deletes a recorded sandbox with its profile's
+// current session, which need not be the one that created it
+func handleDestroySandbox(c echo.Context) error {
+	name := c.Param("name")
+
+	sandboxMu.Lock()
+	defer sandboxMu.Unlock()
+
+	sandboxes := loadSandboxes()
+	idx := -1
+	for i, sb := range sandboxes {
+		if sb.Name == name {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "Unknown sandbox: " + name,
+		})
+	}
+	sb := sandboxes[idx]
+
+	ctx := c.Request().Context()
+	cfg, _, err := sessionAWSConfig(ctx, sb.Profile)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error:   "No valid session",
+			Details: err.Error(),
+		})
+	}
+	cfg.Region = sb.Region
+
+	err = destroySandbox(ctx, cfg, sb)
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && sandboxGoneCodes[apiErr.ErrorCode()] {
+		err = nil
+	}
+	auditSandbox("sandbox.destroy", sb, err)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to destroy sandbox",
+			Details: err.Error(),
+		})
+	}
+
+	if err := saveSandboxes(append(sandboxes[:idx], sandboxes[idx+1:]...)); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Sandbox destroyed but record not updated",
+			Details: err.Error(),
+		})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
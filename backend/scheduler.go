@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// JobStatus reports when a background job last ran and will run next
+type JobStatus struct {
+	Name     string     `json:"name"`
+	Interval string     `json:"interval"`
+	LastRun  *time.Time `json:"lastRun,omitempty"`
+	NextRun  time.Time  `json:"nextRun"`
+}
+
+type scheduledJob struct {
+	name     string
+	interval time.Duration
+	run      func(context.Context)
+	cancel   context.CancelFunc
+
+	lastRun time.Time
+	nextRun time.Time
+}
+
+// jobScheduler runs registered jobs on fixed intervals, each in its own
+// goroutine, until the context passed to start is cancelled
+type jobScheduler struct {
+	mu   sync.Mutex
+	jobs []*scheduledJob
+	// ctx is set by start; jobs registered later begin right away
+	ctx context.Context
+}
+
+var scheduler = &jobScheduler{}
+
+// every registers a job, replacing any job of the same name. Before start
+// it waits for start; afterwards, as when a reload enables a listener with
+// its own jobs, it begins right away.
+func (s *jobScheduler) every(name string, interval time.Duration, fn func(context.Context)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(name)
+	job := &scheduledJob{name: name, interval: interval, run: fn}
+	s.jobs = append(s.jobs, job)
+	if s.ctx != nil {
+		s.launchLocked(job)
+	}
+}
+
+// stop cancels and forgets the named job
+func (s *jobScheduler) stop(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(name)
+}
+
+func (s *jobScheduler) removeLocked(name string) {
+	for i, job := range s.jobs {
+		if job.name != name {
+			continue
+		}
+		if job.cancel != nil {
+			job.cancel()
+		}
+		s.jobs = append(s.jobs[:i], s.jobs[i+1:]...)
+		return
+	}
+}
+
+func (s *jobScheduler) start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctx = ctx
+	for _, job := range s.jobs {
+		s.launchLocked(job)
+	}
+}
+
+func (s *jobScheduler) launchLocked(job *scheduledJob) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	job.cancel = cancel
+	job.nextRun = time.Now().Add(job.interval)
+	go s.loop(ctx, job)
+}
+
+func (s *jobScheduler) loop(ctx context.Context, job *scheduledJob) {
+	ticker := time.NewTicker(job.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			job.run(ctx)
+
+			s.mu.Lock()
+			job.lastRun = now
+			job.nextRun = now.Add(job.interval)
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *jobScheduler) status() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]JobStatus, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		st := JobStatus{Name: job.name, Interval: job.interval.String(), NextRun: job.nextRun}
+		if !job.lastRun.IsZero() {
+			last := job.lastRun
+			st.LastRun = &last
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func handleGetScheduler(c echo.Context) error {
+	return c.JSON(http.StatusOK, scheduler.status())
+}
+
+const (
+	defaultPreviewRuns = 5
+	maxPreviewRuns     = 50
+)
+
+// JobSpec is a user-defined schedule to check before it is saved
+type JobSpec struct {
+	Name     string   `json:"name"`
+	Cron     string   `json:"cron"`
+	Timezone string   `json:"timezone,omitempty"`
+	Profiles []string `json:"profiles,omitempty"`
+}
+
+// JobValidateRequest takes schedules as jobs, as clearAt policy rules, or
+// both. Timezone applies to entries without their own.
+type JobValidateRequest struct {
+	Jobs     []JobSpec    `json:"jobs,omitempty"`
+	Policies []PolicyRule `json:"policies,omitempty"`
+	Timezone string       `json:"timezone,omitempty"`
+	Count    int          `json:"count,omitempty"`
+}
+
+type JobPreview struct {
+	Name     string      `json:"name"`
+	Valid    bool        `json:"valid"`
+	Error    string      `json:"error,omitempty"`
+	Timezone string      `json:"timezone,omitempty"`
+	Next     []time.Time `json:"next,omitempty"`
+}
+
+type JobConflict struct {
+	Jobs   []string `json:"jobs"`
+	Reason string   `json:"reason"`
+}
+
+type JobValidateResponse struct {
+	Valid     bool          `json:"valid"`
+	Jobs      []JobPreview  `json:"jobs"`
+	Conflicts []JobConflict `json:"conflicts"`
+}
+
+// profilesOverlap reports whether two profile pattern lists can match the
+// same profile; an empty list matches every profile
+func profilesOverlap(a, b []string) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return true
+	}
+	for _, pa := range a {
+		for _, pb := range b {
+			if pa == pb {
+				return true
+			}
+			if ok, _ := path.Match(pa, pb); ok {
+				return true
+			}
+			if ok, _ := path.Match(pb, pa); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// previewJob parses the job's schedule and lists its next count runs in
+// its timezone
+func previewJob(job JobSpec, defaultTZ string, count int, now time.Time) JobPreview {
+	preview := JobPreview{Name: job.Name, Timezone: job.Timezone}
+	if preview.Timezone == "" {
+		preview.Timezone = defaultTZ
+	}
+
+	loc := time.Local
+	if preview.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(preview.Timezone); err != nil {
+			preview.Error = fmt.Sprintf("invalid timezone %q", preview.Timezone)
+			return preview
+		}
+	}
+	sched, err := parseCron(job.Cron)
+	if err != nil {
+		preview.Error = err.Error()
+		return preview
+	}
+
+	at := now.In(loc)
+	for len(preview.Next) < count {
+		if at, err = sched.next(at); err != nil {
+			preview.Error = err.Error()
+			return preview
+		}
+		preview.Next = append(preview.Next, at)
+	}
+	preview.Valid = true
+	return preview
+}
+
+// jobConflicts finds missing or repeated names, and schedules that fire at
+// the same moment for overlapping profiles, where one of them does nothing
+func jobConflicts(jobs []JobSpec, previews []JobPreview) []JobConflict {
+	conflicts := []JobConflict{}
+
+	seen := map[string]bool{}
+	for _, job := range jobs {
+		switch {
+		case job.Name == "":
+			conflicts = append(conflicts, JobConflict{Jobs: []string{job.Name}, Reason: "job has no name"})
+		case seen[job.Name]:
+			conflicts = append(conflicts, JobConflict{Jobs: []string{job.Name}, Reason: "name is used more than once"})
+		}
+		seen[job.Name] = true
+	}
+
+	for i := range jobs {
+		for j := i + 1; j < len(jobs); j++ {
+			if !previews[i].Valid || !previews[j].Valid || !profilesOverlap(jobs[i].Profiles, jobs[j].Profiles) {
+				continue
+			}
+			if at, ok := firstCommonRun(previews[i].Next, previews[j].Next); ok {
+				conflicts = append(conflicts, JobConflict{
+					Jobs:   []string{jobs[i].Name, jobs[j].Name},
+					Reason: fmt.Sprintf("both run at %s for overlapping profiles", at.UTC().Format(time.RFC3339)),
+				})
+			}
+		}
+	}
+	return conflicts
+}
+
+func firstCommonRun(a, b []time.Time) (time.Time, bool) {
+	for _, x := range a {
+		for _, y := range b {
+			if x.Equal(y) {
+				return x, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// handleValidateJobs checks schedules before they are saved: each one must
+// parse, and the preview shows when it would run
+func handleValidateJobs(c echo.Context) error {
+	var req JobValidateRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	count := req.Count
+	if count <= 0 {
+		count = defaultPreviewRuns
+	}
+	count = min(count, maxPreviewRuns)
+
+	jobs := append([]JobSpec{}, req.Jobs...)
+	previews := make([]JobPreview, 0, len(jobs)+len(req.Policies))
+	now := time.Now()
+	for _, job := range req.Jobs {
+		previews = append(previews, previewJob(job, req.Timezone, count, now))
+	}
+	for _, rule := range req.Policies {
+		if rule.Type != policyClearAt {
+			continue
+		}
+		// A time of day is previewed as the daily schedule the policy job
+		// reads it as. Without a timezone the job uses the backend's, so
+		// the runs are only shown in the requested one.
+		expr, err := rule.cronExpr()
+		job := JobSpec{Name: rule.Name, Cron: expr, Timezone: rule.Timezone, Profiles: rule.Profiles}
+		jobs = append(jobs, job)
+		if err != nil {
+			previews = append(previews, JobPreview{Name: rule.Name, Timezone: rule.Timezone, Error: err.Error()})
+			continue
+		}
+		preview := previewJob(job, "", count, now)
+		if display, err := time.LoadLocation(req.Timezone); req.Timezone != "" && err == nil {
+			for i, at := range preview.Next {
+				preview.Next[i] = at.In(display)
+			}
+		}
+		previews = append(previews, preview)
+	}
+
+	resp := JobValidateResponse{Valid: true, Jobs: previews, Conflicts: jobConflicts(jobs, previews)}
+	for _, p := range previews {
+		resp.Valid = resp.Valid && p.Valid
+	}
+	resp.Valid = resp.Valid && len(resp.Conflicts) == 0
+	return c.JSON(http.StatusOK, resp)
+}
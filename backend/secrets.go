@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/labstack/echo/v4"
+)
+
+// AppSecret is a single value fetched from Parameter Store or Secrets
+// Manager, along with the environment variable name it maps to
+type AppSecret struct {
+	Source  string `json:"source"` // "ssm" or "secretsmanager"
+	Name    string `json:"name"`
+	EnvName string `json:"envName"`
+	Value   string `json:"value"`
+}
+
+var envNameInvalidChars = regexp.MustCompile(`[^A-Z0-9_]+`)
+
+// envNameFor turns a parameter or secret name into an env var name relative
+// to the requested path, e.g. /app/dev/db-password -> DB_PASSWORD
+func envNameFor(name, prefix string) string {
+	rel := strings.TrimPrefix(name, prefix)
+	rel = strings.Trim(rel, "/")
+	env := envNameInvalidChars.ReplaceAllString(strings.ToUpper(rel), "_")
+	env = strings.Trim(env, "_")
+	if env != "" && env[0] >= '0' && env[0] <= '9' {
+		env = "_" + env
+	}
+	return env
+}
+
+func fetchParameters(ctx context.Context, cfg aws.Config, path string) ([]AppSecret, error) {
+	client := ssm.NewFromConfig(cfg)
+	paginator := ssm.NewGetParametersByPathPaginator(client, &ssm.GetParametersByPathInput{
+		Path:           aws.String(path),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(true),
+	})
+
+	var secrets []AppSecret
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read parameters under %s: %w", path, err)
+		}
+		for _, p := range page.Parameters {
+			name := aws.ToString(p.Name)
+			secrets = append(secrets, AppSecret{
+				Source:  "ssm",
+				Name:    name,
+				EnvName: envNameFor(name, path),
+				Value:   aws.ToString(p.Value),
+			})
+		}
+	}
+	return secrets, nil
+}
+
+// fetchSecrets reads every secret whose name starts with prefix. JSON object
+// secrets are expanded so each key becomes its own variable.
+func fetchSecrets(ctx context.Context, cfg aws.Config, prefix string) ([]AppSecret, error) {
+	client := secretsmanager.NewFromConfig(cfg)
+	paginator := secretsmanager.NewListSecretsPaginator(client, &secretsmanager.ListSecretsInput{
+		Filters: []smtypes.Filter{{
+			Key:    smtypes.FilterNameStringTypeName,
+			Values: []string{prefix},
+		}},
+	})
+
+	var secrets []AppSecret
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list secrets with prefix %s: %w", prefix, err)
+		}
+		for _, entry := range page.SecretList {
+			name := aws.ToString(entry.Name)
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+
+			out, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+				SecretId: entry.ARN,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to read secret %s: %w", name, err)
+			}
+			value := aws.ToString(out.SecretString)
+
+			var fields map[string]interface{}
+			if json.Unmarshal([]byte(value), &fields) == nil && len(fields) > 0 {
+				for key, v := range fields {
+					secrets = append(secrets, AppSecret{
+						Source:  "secretsmanager",
+						Name:    name + "#" + key,
+						EnvName: envNameFor(key, ""),
+						Value:   fmt.Sprint(v),
+					})
+				}
+				continue
+			}
+
+			secrets = append(secrets, AppSecret{
+				Source:  "secretsmanager",
+				Name:    name,
+				EnvName: envNameFor(name, prefix),
+				Value:   value,
+			})
+		}
+	}
+	return secrets, nil
+}
+
+// fetchAppSecrets collects parameters under ssmPath and secrets under
+// secretPrefix using the cached session; either may be empty
+func fetchAppSecrets(ctx context.Context, profile, ssmPath, secretPrefix string) ([]AppSecret, error) {
+	if ssmPath == "" && secretPrefix == "" {
+		return nil, nil
+	}
+
+	cfg, _, err := sessionAWSConfig(ctx, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets []AppSecret
+	if ssmPath != "" {
+		params, err := fetchParameters(ctx, cfg, ssmPath)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, params...)
+	}
+	if secretPrefix != "" {
+		values, err := fetchSecrets(ctx, cfg, secretPrefix)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, values...)
+	}
+
+	sort.SliceStable(secrets, func(i, j int) bool {
+		return secrets[i].EnvName < secrets[j].EnvName
+	})
+	return secrets, nil
+}
+
+// appendSecretsEnv adds secrets to env file content. Env files have no
+// quoting, so multi-line values cannot be represented and are skipped with
+// a comment instead of silently corrupting the file.
+func appendSecretsEnv(envContent string, secrets []AppSecret) string {
+	var b strings.Builder
+	b.WriteString(envContent)
+	for _, s := range secrets {
+		if s.EnvName == "" {
+			continue
+		}
+		if strings.ContainsAny(s.Value, "\r\n") {
+			fmt.Fprintf(&b, "# skipped %s: multi-line value\n", s.EnvName)
+			continue
+		}
+		fmt.Fprintf(&b, "%s=%s\n", s.EnvName, s.Value)
+	}
+	return b.String()
+}
+
+func handleGetAppSecrets(c echo.Context) error {
+	profile := requestProfile(c, c.QueryParam("profile"))
+
+	ssmPath := c.QueryParam("ssmPath")
+	secretPrefix := c.QueryParam("secretPrefix")
+	if ssmPath == "" && secretPrefix == "" {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "ssmPath or secretPrefix is required",
+		})
+	}
+
+	secrets, err := fetchAppSecrets(c.Request().Context(), profile, ssmPath, secretPrefix)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to fetch secrets",
+			Details: err.Error(),
+		})
+	}
+	if secrets == nil {
+		secrets = []AppSecret{}
+	}
+
+	return c.JSON(http.StatusOK, secrets)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Sessions are cached under sessions/<source>/<partition>-<account>/, so
+// two config sources with a profile of the same name, or a profile edited
+// to point at another account, never read each other's session. The source
+// part names the credential source and hashes the config file it resolved
+// to; the account comes from the profile's role_arn, sso_account_id or
+// mfa_serial, which are known before a login. A profile whose account
+// can't be told from its config, such as one with a hardware token serial,
+// is cached under "unknown".
+
+const (
+	sessionsSubdir      = "sessions"
+	unknownAccountShard = "unknown"
+)
+
+func getSessionsDir() string {
+	return filepath.Join(getCacheDir(), sessionsSubdir)
+}
+
+// sessionSourceShard names the active config source: the source setting
+// and a hash of the config file it resolves to
+func sessionSourceShard() string {
+	configPath := getAWSConfigPath()
+	sum := sha256.Sum256([]byte(absPath(configPath)))
+	return string(sourceForPath(configPath)) + "-" + hex.EncodeToString(sum[:4])
+}
+
+// arnPartitionAccount returns the partition and account of an ARN, or ""
+func arnPartitionAccount(arn string) (string, string) {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 || parts[0] != "arn" || parts[1] == "" || !accountIDPattern.MatchString(parts[4]) {
+		return "", ""
+	}
+	return parts[1], parts[4]
+}
+
+// regionPartition is the partition a region belongs to
+func regionPartition(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	}
+	return "aws"
+}
+
+// profileConfigAccount returns the partition and account profile's config
+// names, from its role_arn, mfa_serial or sso_account_id, or Granted's
+// granted_sso_account_id, or ""
+func profileConfigAccount(profile string) (string, string) {
+	section, err := getProfileSection(profile)
+	if err != nil {
+		return "", ""
+	}
+	for _, key := range []string{"role_arn", "mfa_serial"} {
+		if partition, account := arnPartitionAccount(sectionValue(section, key)); account != "" {
+			return partition, account
+		}
+	}
+	if account := ssoProfileKey(section, "sso_account_id"); accountIDPattern.MatchString(account) {
+		return regionPartition(ssoProfileKey(section, "sso_region")), account
+	}
+	return "", ""
+}
+
+// sessionAccountShard names the partition and account profile's session
+// belongs to, from its config
+func sessionAccountShard(profile string) string {
+	if partition, account := profileConfigAccount(profile); account != "" {
+		return partition + "-" + account
+	}
+	return unknownAccountShard
+}
+
+func getCacheFile(profile string) string {
+	if profile == "" {
+		profile = defaultProfile()
+	}
+	return filepath.Join(getSessionsDir(), sessionSourceShard(), sessionAccountShard(profile), filepath.Base(profile)+".json")
+}
+
+// profileSessionFiles lists every cached session of profile: those of each
+// source and account it was ever cached under, and one cached by an
+// earlier version
+func profileSessionFiles(profile string) []string {
+	name := filepath.Base(profile) + ".json"
+	var files []string
+	shards, _ := filepath.Glob(filepath.Join(getSessionsDir(), "*", "*"))
+	candidates := make([]string, 0, len(shards)+1)
+	for _, shard := range shards {
+		candidates = append(candidates, filepath.Join(shard, name))
+	}
+	if name != filepath.Base(settingsFile) {
+		candidates = append(candidates, filepath.Join(getCacheDir(), name))
+	}
+	for _, f := range candidates {
+		if _, err := os.Stat(f); err == nil {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// cachedProfiles lists profiles with a session cached for the active
+// source and their current account
+func cachedProfiles() []string {
+	files, _ := filepath.Glob(filepath.Join(getSessionsDir(), sessionSourceShard(), "*", "*.json"))
+	var profiles []string
+	for _, f := range files {
+		profile := strings.TrimSuffix(filepath.Base(f), ".json")
+		if getCacheFile(profile) == f {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles
+}
+
+// legacySessionFiles lists sessions cached directly in the cache directory
+// by earlier versions, keyed by profile name alone
+func legacySessionFiles() []string {
+	files, _ := filepath.Glob(filepath.Join(getCacheDir(), "*.json"))
+	var sessions []string
+	for _, f := range files {
+		if filepath.Base(f) != filepath.Base(settingsFile) {
+			sessions = append(sessions, f)
+		}
+	}
+	return sessions
+}
+
+// migrateLegacySessions moves sessions cached by profile name alone into
+// the active source's partitions. A session is only moved if its profile
+// exists in the active config and, for a role session, the role is still
+// the profile's; the rest are left where they are and never read.
+func migrateLegacySessions() (int, error) {
+	migrated := 0
+	for _, path := range legacySessionFiles() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var creds CachedCredentials
+		if err := decodeCacheFile(path, data, &creds); err != nil {
+			continue
+		}
+		profile := strings.TrimSuffix(filepath.Base(path), ".json")
+		if creds.Profile != "" {
+			profile = creds.Profile
+		}
+		if _, err := getProfileSection(profile); err != nil {
+			continue
+		}
+		if creds.RoleARN != "" && creds.RoleARN != profileRoleARN(profile) {
+			continue
+		}
+
+		target := getCacheFile(profile)
+		if _, err := os.Stat(target); err == nil {
+			// A newer session is already in place
+			os.Remove(path)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return migrated, err
+		}
+		if err := os.Rename(path, target); err != nil {
+			return migrated, fmt.Errorf("moving %s: %w", filepath.Base(path), err)
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// sessionFileProfile returns the profile a session cache file belongs to,
+// or "" if path isn't one
+func sessionFileProfile(path string) string {
+	rel, err := filepath.Rel(getSessionsDir(), path)
+	if err != nil || len(strings.Split(rel, string(filepath.Separator))) != 3 {
+		return ""
+	}
+	return strings.TrimSuffix(filepath.Base(path), ".json")
+}
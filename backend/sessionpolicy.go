@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+// STS limits on session policies passed to AssumeRole. The size limit is on
+// the plaintext; STS also rejects policies whose packed form is too large,
+// which can only be known from the call.
+const (
+	maxSessionPolicyARNs = 10
+	maxSessionPolicySize = 2048
+)
+
+var sessionPolicyARNPattern = regexp.MustCompile(`^arn:aws[a-z-]*:iam::(\d{12}|aws):policy/.+$`)
+
+// compactPolicy strips the whitespace from a policy document, which counts
+// towards the size limit. A document that isn't JSON is returned as is.
+func compactPolicy(policy string) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(policy)); err != nil {
+		return policy
+	}
+	return buf.String()
+}
+
+// validateSessionPolicy checks an inline policy and managed policy ARNs
+// against what AssumeRole accepts
+func validateSessionPolicy(policy string, arns []string) error {
+	if policy != "" {
+		var doc map[string]interface{}
+		if err := json.Unmarshal([]byte(policy), &doc); err != nil {
+			return fmt.Errorf("policy is not a JSON object: %w", err)
+		}
+		if size := len(compactPolicy(policy)); size > maxSessionPolicySize {
+			return fmt.Errorf("policy is %d characters, more than the %d STS allows", size, maxSessionPolicySize)
+		}
+	}
+	if len(arns) > maxSessionPolicyARNs {
+		return fmt.Errorf("%d policy ARNs given; STS allows at most %d", len(arns), maxSessionPolicyARNs)
+	}
+	for _, arn := range arns {
+		if !sessionPolicyARNPattern.MatchString(arn) {
+			return fmt.Errorf("%q is not an IAM policy ARN", arn)
+		}
+	}
+	return nil
+}
+
+// validateSessionPolicies checks the session policies in the profile
+// settings
+func validateSessionPolicies(profiles map[string]ProfileSettings) error {
+	for profile, ps := range profiles {
+		if err := validateSessionPolicy(ps.SessionPolicy, ps.SessionPolicyARNs); err != nil {
+			return fmt.Errorf("profiles.%s: %w", profile, err)
+		}
+	}
+	return nil
+}
+
+// sessionPolicy returns the policy and policy ARNs that scope a profile's
+// role session: the profile's settings, each replaced by the override when
+// one is given
+func sessionPolicy(profile string, o roleOverrides) (string, []string) {
+	ps := getProfileSettings(profile)
+	policy, arns := ps.SessionPolicy, ps.SessionPolicyARNs
+	if o.Policy != "" {
+		policy = o.Policy
+	}
+	if o.PolicyARNs != nil {
+		arns = o.PolicyARNs
+	}
+	if policy != "" {
+		policy = compactPolicy(policy)
+	}
+	return policy, arns
+}
+
+func stsPolicyARNs(arns []string) []ststypes.PolicyDescriptorType {
+	if len(arns) == 0 {
+		return nil
+	}
+	out := make([]ststypes.PolicyDescriptorType, len(arns))
+	for i, arn := range arns {
+		out[i] = ststypes.PolicyDescriptorType{Arn: aws.String(arn)}
+	}
+	return out
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"gopkg.in/ini.v1"
+)
+
+// STS limits on session tags passed to AssumeRole
+const (
+	maxSessionTags        = 50
+	maxSessionTagKeyLen   = 128
+	maxSessionTagValueLen = 256
+)
+
+var sessionTagPattern = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+
+// roleOverrides replace what a role profile configures, for one login.
+// They apply to the profile's own role, the last hop of a chain.
+type roleOverrides struct {
+	ExternalID string
+	// Tags are merged over the profile's tags key by key
+	Tags map[string]string
+	// TransitiveTags, when not nil, replaces the profile's list
+	TransitiveTags []string
+	// Policy and PolicyARNs, when given, replace the profile's session
+	// policy settings
+	Policy     string
+	PolicyARNs []string
+}
+
+// parseSessionTags reads the profile's tags ("Key=Value, Key2=Value2") and
+// transitive_session_tags ("Key, Key2")
+func parseSessionTags(section *ini.Section) (map[string]string, []string, error) {
+	var tags map[string]string
+	for _, pair := range strings.Split(section.Key("tags").String(), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, nil, fmt.Errorf("profile %s: tags entry %q is not Key=Value", section.Name(), pair)
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	var transitive []string
+	for _, key := range strings.Split(section.Key("transitive_session_tags").String(), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			transitive = append(transitive, key)
+		}
+	}
+	return tags, transitive, nil
+}
+
+// mergeSessionTags applies overrides to the tags and transitive keys a
+// profile configures. STS compares tag keys without case, so an override
+// replaces a profile tag whose key differs only in case.
+func mergeSessionTags(tags map[string]string, transitive []string, o roleOverrides) (map[string]string, []string) {
+	if len(o.Tags) > 0 {
+		merged := make(map[string]string, len(tags)+len(o.Tags))
+		for k, v := range tags {
+			merged[k] = v
+		}
+		for k, v := range o.Tags {
+			for existing := range merged {
+				if strings.EqualFold(existing, k) {
+					delete(merged, existing)
+				}
+			}
+			merged[k] = v
+		}
+		tags = merged
+	}
+	if o.TransitiveTags != nil {
+		transitive = o.TransitiveTags
+	}
+	return tags, transitive
+}
+
+// validateSessionTags checks tags against the limits STS enforces, so a bad
+// tag fails before an MFA code is spent on it. Tag keys are case
+// insensitive to STS, and transitive keys must name a tag being passed.
+func validateSessionTags(tags map[string]string, transitive []string) error {
+	if len(tags) > maxSessionTags {
+		return fmt.Errorf("%d session tags is more than the %d STS allows", len(tags), maxSessionTags)
+	}
+	seen := make(map[string]string, len(tags))
+	for key, value := range tags {
+		switch {
+		case key == "" || len(key) > maxSessionTagKeyLen:
+			return fmt.Errorf("session tag key %q must be 1-%d characters", key, maxSessionTagKeyLen)
+		case len(value) > maxSessionTagValueLen:
+			return fmt.Errorf("session tag %s: value is longer than %d characters", key, maxSessionTagValueLen)
+		case !sessionTagPattern.MatchString(key) || !sessionTagPattern.MatchString(value):
+			return fmt.Errorf("session tag %s contains characters STS doesn't allow", key)
+		}
+		if other, ok := seen[strings.ToLower(key)]; ok {
+			return fmt.Errorf("session tags %s and %s differ only in case", other, key)
+		}
+		seen[strings.ToLower(key)] = key
+	}
+	for _, key := range transitive {
+		if _, ok := seen[strings.ToLower(key)]; !ok {
+			return fmt.Errorf("transitive session tag %s is not one of the session tags", key)
+		}
+	}
+	return nil
+}
+
+// stsSessionTags converts tags for AssumeRoleInput, in key order so the
+// request is the same every time
+func stsSessionTags(tags map[string]string) []ststypes.Tag {
+	if len(tags) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]ststypes.Tag, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, ststypes.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	return out
+}
+
+// sessionTagsKey renders tags and transitive keys canonically for cache keys
+func sessionTagsKey(tags map[string]string, transitive []string) string {
+	parts := make([]string, 0, len(tags))
+	for k, v := range tags {
+		parts = append(parts, k+"="+v)
+	}
+	sort.Strings(parts)
+	keys := append([]string(nil), transitive...)
+	sort.Strings(keys)
+	return strings.Join(parts, ",") + ";" + strings.Join(keys, ",")
+}
+
+// hopSessionTags returns the tags and transitive keys a hop of a role chain
+// passes: its profile's, with the overrides applied on the last hop
+func hopSessionTags(hop string, o roleOverrides, last bool) (map[string]string, []string, error) {
+	section, err := getProfileSection(hop)
+	if err != nil {
+		return nil, nil, err
+	}
+	tags, transitive, err := parseSessionTags(section)
+	if err != nil {
+		return nil, nil, err
+	}
+	if last {
+		tags, transitive = mergeSessionTags(tags, transitive, o)
+	}
+	if err := validateSessionTags(tags, transitive); err != nil {
+		return nil, nil, fmt.Errorf("profile %s: %w", hop, err)
+	}
+	return tags, transitive, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/labstack/echo/v4"
+)
+
+type SimulateCheck struct {
+	Action   string `json:"action"`
+	Resource string `json:"resource,omitempty"`
+}
+
+type SimulateRequest struct {
+	Profile string          `json:"profile"`
+	Checks  []SimulateCheck `json:"checks"`
+}
+
+type SimulateResult struct {
+	Action               string   `json:"action"`
+	Resource             string   `json:"resource"`
+	Decision             string   `json:"decision"`
+	Allowed              bool     `json:"allowed"`
+	MatchedStatements    []string `json:"matchedStatements,omitempty"`
+	MissingContextValues []string `json:"missingContextValues,omitempty"`
+	Error                string   `json:"error,omitempty"`
+}
+
+type SimulateResponse struct {
+	Profile   string           `json:"profile"`
+	Principal string           `json:"principal"`
+	AllowAll  bool             `json:"allowAll"`
+	Results   []SimulateResult `json:"results"`
+}
+
+// principalPolicySourceARN converts a caller identity ARN into an ARN that
+// SimulatePrincipalPolicy accepts. Assumed-role sessions are mapped back to
+// their role; role paths are not recoverable from the session ARN.
+func principalPolicySourceARN(callerARN string) string {
+	if !strings.Contains(callerARN, ":assumed-role/") {
+		return callerARN
+	}
+	parts := strings.SplitN(callerARN, ":", 6)
+	if len(parts) != 6 {
+		return callerARN
+	}
+	roleParts := strings.Split(strings.TrimPrefix(parts[5], "assumed-role/"), "/")
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", parts[1], parts[4], roleParts[0])
+}
+
+// simulateChecks evaluates each action/resource pair against the session's
+// principal. MFA context is supplied since every session minted here is
+// MFA-authenticated.
+func simulateChecks(ctx context.Context, profile string, checks []SimulateCheck) (*SimulateResponse, error) {
+	cfg, _, err := sessionAWSConfig(ctx, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	stsThrottles.observe(profile, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve caller identity: %w", err)
+	}
+
+	resp := &SimulateResponse{
+		Profile:   profile,
+		Principal: principalPolicySourceARN(aws.ToString(identity.Arn)),
+		AllowAll:  true,
+	}
+
+	client := iam.NewFromConfig(cfg)
+	mfaContext := []iamtypes.ContextEntry{{
+		ContextKeyName:   aws.String("aws:MultiFactorAuthPresent"),
+		ContextKeyType:   iamtypes.ContextKeyTypeEnumBoolean,
+		ContextKeyValues: []string{"true"},
+	}}
+
+	for _, check := range checks {
+		resource := check.Resource
+		if resource == "" {
+			resource = "*"
+		}
+		result := SimulateResult{Action: check.Action, Resource: resource}
+
+		out, err := client.SimulatePrincipalPolicy(ctx, &iam.SimulatePrincipalPolicyInput{
+			PolicySourceArn: aws.String(resp.Principal),
+			ActionNames:     []string{check.Action},
+			ResourceArns:    []string{resource},
+			ContextEntries:  mfaContext,
+		})
+		if err != nil {
+			result.Error = err.Error()
+			resp.AllowAll = false
+			resp.Results = append(resp.Results, result)
+			continue
+		}
+
+		for _, eval := range out.EvaluationResults {
+			result.Decision = string(eval.EvalDecision)
+			result.Allowed = eval.EvalDecision == iamtypes.PolicyEvaluationDecisionTypeAllowed
+			result.MissingContextValues = eval.MissingContextValues
+			for _, stmt := range eval.MatchedStatements {
+				result.MatchedStatements = append(result.MatchedStatements, aws.ToString(stmt.SourcePolicyId))
+			}
+		}
+		if !result.Allowed {
+			resp.AllowAll = false
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	return resp, nil
+}
+
+func handleSimulate(c echo.Context) error {
+	var req SimulateRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+
+	req.Profile = requestProfile(c, req.Profile)
+	if len(req.Checks) == 0 {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "At least one check is required",
+		})
+	}
+	for _, check := range req.Checks {
+		if check.Action == "" {
+			return c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: "Each check requires an action",
+			})
+		}
+	}
+
+	resp, err := simulateChecks(c.Request().Context(), req.Profile, req.Checks)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Policy simulation failed",
+			Details: err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GET /snapshot is everything a dashboard or status bar shows, in one
+// document: the environment, a summary of the settings, the profiles,
+// their statuses, recent exports and recent events. It holds no credential
+// material or settings secrets. A built snapshot is reused for a few
+// seconds, or until the next event, so many pollers cost one build.
+
+const (
+	snapshotTTL        = 5 * time.Second
+	maxSnapshotExports = 20
+)
+
+// SettingsSummary is the part of the settings a dashboard can show;
+// tokens, webhook URLs and the like are left out. Features lists the
+// integrations that are turned on.
+type SettingsSummary struct {
+	CredentialSource     CredentialSource `json:"credentialSource"`
+	DefaultProfile       string           `json:"defaultProfile,omitempty"`
+	Timezone             string           `json:"timezone,omitempty"`
+	STSRegionalEndpoints string           `json:"stsRegionalEndpoints,omitempty"`
+	DeviceBinding        bool             `json:"deviceBinding,omitempty"`
+	ViewerSessions       bool             `json:"viewerSessions,omitempty"`
+	CLICache             bool             `json:"cliCache,omitempty"`
+	ConfiguredProfiles   int              `json:"configuredProfiles"`
+	Policies             int              `json:"policies"`
+	Features             []string         `json:"features"`
+}
+
+type Snapshot struct {
+	GeneratedAt time.Time        `json:"generatedAt"`
+	Version     *VersionInfo     `json:"version"`
+	Environment *EnvironmentInfo `json:"environment"`
+	Settings    SettingsSummary  `json:"settings"`
+	Profiles    []ProfileInfo    `json:"profiles"`
+	Statuses    []StatusResponse `json:"statuses"`
+	Exports     []ExportJob      `json:"exports"`
+	Events      []Event          `json:"events"`
+	// Errors says which parts couldn't be read, e.g. "profiles"
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// builtSnapshot is the last snapshot, for the language and timezone it was
+// built in and the events it has seen
+type builtSnapshot struct {
+	lang     string
+	timezone string
+	seq      uint64
+	builtAt  time.Time
+	body     []byte
+	etag     string
+	interval int64
+}
+
+var snapshotCache struct {
+	mu   sync.Mutex
+	last *builtSnapshot
+}
+
+func summarizeSettings(s *Settings) SettingsSummary {
+	summary := SettingsSummary{
+		CredentialSource:     s.CredentialSource,
+		DefaultProfile:       s.DefaultProfile,
+		Timezone:             s.Timezone,
+		STSRegionalEndpoints: s.STSRegionalEndpoints,
+		DeviceBinding:        s.DeviceBinding,
+		ViewerSessions:       s.ViewerSessions,
+		CLICache:             s.CLICache,
+		ConfiguredProfiles:   len(s.Profiles),
+		Policies:             len(s.Policies),
+		Features:             []string{},
+	}
+	for _, f := range []struct {
+		name string
+		on   bool
+	}{
+		{"teamSync", s.TeamSync != nil},
+		{"keyValidation", s.KeyValidation != nil},
+		{"remoteAccess", s.RemoteAccess != nil && s.RemoteAccess.Enabled},
+		{"broker", s.Broker != nil && s.Broker.Enabled},
+		{"notifications", s.Notifications != nil},
+		{"autoProvision", s.AutoProvision != nil && s.AutoProvision.Enabled},
+		{"containerEndpoint", s.ContainerEndpoint != nil && s.ContainerEndpoint.Enabled},
+		{"hygiene", s.Hygiene != nil},
+		{"ecrCache", s.ECRCache != nil && s.ECRCache.Enabled},
+		{"processScope", s.ProcessScope != nil && s.ProcessScope.Enabled},
+		{"yubikey", s.YubiKey != nil && s.YubiKey.Enabled},
+		{"onePassword", s.OnePassword != nil && s.OnePassword.Enabled},
+		{"bitwarden", s.Bitwarden != nil && s.Bitwarden.Enabled},
+		{"pass", s.Pass != nil && s.Pass.Enabled},
+	} {
+		if f.on {
+			summary.Features = append(summary.Features, f.name)
+		}
+	}
+	return summary
+}
+
+// buildSnapshot gathers the snapshot; a part that fails is left empty and
+// named in Errors rather than failing the whole document
+func buildSnapshot(c echo.Context, recent []Event) *Snapshot {
+	snap := &Snapshot{
+		GeneratedAt: time.Now().UTC(),
+		Version:     getVersionInfo(),
+		Environment: getEnvironmentInfo(),
+		Settings:    summarizeSettings(loadSettings()),
+		Profiles:    []ProfileInfo{},
+		Statuses:    []StatusResponse{},
+		Exports:     listExportJobs(""),
+		Events:      recent,
+	}
+	if len(snap.Exports) > maxSnapshotExports {
+		snap.Exports = snap.Exports[:maxSnapshotExports]
+	}
+
+	profiles, err := getProfiles()
+	if err != nil {
+		snap.Errors = map[string]string{"profiles": err.Error()}
+		return snap
+	}
+	snap.Profiles = append(snap.Profiles, profiles...)
+	snap.Statuses = append(snap.Statuses, profileStatuses(c, profiles)...)
+	return snap
+}
+
+// currentSnapshot returns the cached snapshot while it's fresh, or builds
+// a new one
+func currentSnapshot(c echo.Context) (*builtSnapshot, error) {
+	lang := c.Request().Header.Get("Accept-Language")
+	timezone := c.Request().Header.Get(timezoneHeader)
+	recent, seq := events.history()
+
+	snapshotCache.mu.Lock()
+	defer snapshotCache.mu.Unlock()
+	if last := snapshotCache.last; last != nil && last.lang == lang && last.timezone == timezone && last.seq == seq && time.Since(last.builtAt) < snapshotTTL {
+		return last, nil
+	}
+
+	snap := buildSnapshot(c, recent)
+	body, err := json.Marshal(snap)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	built := &builtSnapshot{
+		lang:     lang,
+		timezone: timezone,
+		seq:      seq,
+		builtAt:  time.Now(),
+		body:     body,
+		etag:     `"` + hex.EncodeToString(sum[:8]) + `"`,
+	}
+	if len(snap.Statuses) > 0 {
+		built.interval = snap.Statuses[0].PollInterval
+		for _, s := range snap.Statuses[1:] {
+			if s.PollInterval < built.interval {
+				built.interval = s.PollInterval
+			}
+		}
+	} else {
+		built.interval = int64(pollIntervalIdle.Seconds())
+	}
+	snapshotCache.last = built
+	return built, nil
+}
+
+// handleGetSnapshot is GET /snapshot. Clients can send If-None-Match with
+// the last ETag, and may reuse a response for its max-age.
+func handleGetSnapshot(c echo.Context) error {
+	built, err := currentSnapshot(c)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to build snapshot",
+			Details: err.Error(),
+		})
+	}
+
+	maxAge := int((snapshotTTL - time.Since(built.builtAt)).Seconds())
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	h := c.Response().Header()
+	h.Set(echo.HeaderCacheControl, "private, max-age="+strconv.Itoa(maxAge))
+	h.Set("ETag", built.etag)
+	h.Set(pollIntervalHeader, strconv.FormatInt(built.interval, 10))
+	if c.Request().Header.Get("If-None-Match") == built.etag {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSONBlob(http.StatusOK, built.body)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+const (
+	sourceHealthInterval = 5 * time.Minute
+	// A hung network share (a WSL2 \\wsl$ path, an unmounted SMB home) can
+	// block a stat for minutes; give up well before the next check
+	sourceCheckTimeout = 5 * time.Second
+
+	eventSourceHealth = "source-health"
+
+	healthOK          = "ok"
+	healthMissing     = "missing"
+	healthUnreachable = "unreachable"
+	healthUnreadable  = "unreadable"
+	healthInvalid     = "invalid"
+	healthTimeout     = "timeout"
+)
+
+// SourceHealth is the last check of one credential source file. Missing
+// means the file isn't there although its directory is; unreachable means
+// the directory itself is gone, which is what an unmounted share looks
+// like.
+type SourceHealth struct {
+	Source      CredentialSource `json:"source"`
+	Description string           `json:"description"`
+	Kind        string           `json:"kind"`
+	Path        string           `json:"path"`
+	Active      bool             `json:"active"`
+	Status      string           `json:"status"`
+	Error       string           `json:"error,omitempty"`
+	LastChecked time.Time        `json:"lastChecked"`
+	LastHealthy *time.Time       `json:"lastHealthy,omitempty"`
+	// Since is when the source entered its current status
+	Since time.Time `json:"since"`
+}
+
+type healthTarget struct {
+	source      CredentialSource
+	description string
+	kind        string
+	path        string
+	active      bool
+}
+
+var sourceHealth = struct {
+	mu      sync.Mutex
+	entries map[string]*SourceHealth
+}{entries: make(map[string]*SourceHealth)}
+
+// healthTargets lists every source the backend could read from: the
+// detected homes, the custom paths when set, and whichever files are in
+// use now
+func healthTargets() []healthTarget {
+	settings := loadSettings()
+	activeConfig, activeCreds := getAWSConfigPath(), getAWSCredentialsPath()
+
+	var targets []healthTarget
+	add := func(source CredentialSource, description, kind, path string) {
+		if path == "" {
+			return
+		}
+		targets = append(targets, healthTarget{
+			source:      source,
+			description: description,
+			kind:        kind,
+			path:        path,
+			active:      path == activeConfig || path == activeCreds,
+		})
+	}
+
+	for _, p := range discoverAWSPaths() {
+		if p.ConfigPath == p.CredsPath {
+			// WSL2 distros are listed by their home directory
+			add(p.Source, p.Description, "directory", p.ConfigPath)
+			continue
+		}
+		add(p.Source, p.Description, "config", p.ConfigPath)
+		add(p.Source, p.Description, "credentials", p.CredsPath)
+	}
+	if settings.CustomConfigPath != "" || settings.CustomCredsPath != "" {
+		add(SourceCustom, "Custom paths", "config", remapPath(settings.CustomConfigPath))
+		add(SourceCustom, "Custom paths", "credentials", remapPath(settings.CustomCredsPath))
+	}
+	return targets
+}
+
+// checkSourcePath reports the status of one file or directory, with an
+// error describing what failed
+func checkSourcePath(kind, path string) (string, error) {
+	dir := path
+	if kind != "directory" {
+		dir = filepath.Dir(path)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return healthUnreachable, err
+	}
+	if kind == "directory" {
+		if _, err := os.ReadDir(path); err != nil {
+			return healthUnreadable, err
+		}
+		return healthOK, nil
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return healthMissing, err
+	case err != nil:
+		return healthUnreadable, err
+	}
+	if _, err := ini.Load(data); err != nil {
+		return healthInvalid, err
+	}
+	return healthOK, nil
+}
+
+// checkSourceWithTimeout runs the check in its own goroutine so a hung
+// mount only costs the timeout. The goroutine is left to finish on its own.
+func checkSourceWithTimeout(ctx context.Context, kind, path string) (string, string) {
+	type result struct {
+		status string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		status, err := checkSourcePath(kind, path)
+		done <- result{status, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return r.status, r.err.Error()
+		}
+		return r.status, ""
+	case <-time.After(sourceCheckTimeout):
+		return healthTimeout, fmt.Sprintf("no response after %s", sourceCheckTimeout)
+	case <-ctx.Done():
+		return healthTimeout, ctx.Err().Error()
+	}
+}
+
+// runSourceHealth checks every source concurrently and publishes an event
+// for each one whose status changed
+func runSourceHealth(ctx context.Context) {
+	targets := healthTargets()
+	results := make([]SourceHealth, len(targets))
+
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, errText := checkSourceWithTimeout(ctx, t.kind, t.path)
+			results[i] = SourceHealth{
+				Source:      t.source,
+				Description: t.description,
+				Kind:        t.kind,
+				Path:        t.path,
+				Active:      t.active,
+				Status:      status,
+				Error:       errText,
+				LastChecked: time.Now().UTC(),
+			}
+		}()
+	}
+	wg.Wait()
+
+	var changes []Event
+	sourceHealth.mu.Lock()
+	seen := make(map[string]bool, len(results))
+	for _, r := range results {
+		key := r.Kind + "\x00" + r.Path
+		seen[key] = true
+		prev := sourceHealth.entries[key]
+
+		r.Since = r.LastChecked
+		if prev != nil {
+			r.LastHealthy = prev.LastHealthy
+			if prev.Status == r.Status {
+				r.Since = prev.Since
+			}
+		}
+		if r.Status == healthOK {
+			at := r.LastChecked
+			r.LastHealthy = &at
+		}
+		if prev != nil && prev.Status != r.Status {
+			changes = append(changes, Event{
+				Type: eventSourceHealth,
+				Data: map[string]string{"path": r.Path, "status": r.Status, "previous": prev.Status},
+			})
+		}
+		entry := r
+		sourceHealth.entries[key] = &entry
+	}
+	// Forget sources that are no longer configured or detected
+	for key := range sourceHealth.entries {
+		if !seen[key] {
+			delete(sourceHealth.entries, key)
+		}
+	}
+	sourceHealth.mu.Unlock()
+
+	for _, e := range changes {
+		events.publish(e)
+	}
+}
+
+// currentSourceHealth returns the latest results, active sources first
+func currentSourceHealth() []SourceHealth {
+	sourceHealth.mu.Lock()
+	defer sourceHealth.mu.Unlock()
+
+	out := make([]SourceHealth, 0, len(sourceHealth.entries))
+	for _, entry := range sourceHealth.entries {
+		out = append(out, *entry)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Active != out[j].Active {
+			return out[i].Active
+		}
+		if out[i].Path != out[j].Path {
+			return out[i].Path < out[j].Path
+		}
+		return out[i].Kind < out[j].Kind
+	})
+	return out
+}
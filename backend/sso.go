@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sso"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/ini.v1"
+)
+
+var ssoProfileNameInvalid = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// ssoSettings is where a profile's SSO portal lives, either inline or via an
+// [sso-session] section
+type ssoSettings struct {
+	Session  string
+	StartURL string
+	Region   string
+	// Scopes are the sso-session's sso_registration_scopes
+	Scopes []string
+}
+
+// SSOSessionInfo describes the [sso-session] section a profile logs in
+// through
+type SSOSessionInfo struct {
+	Name               string   `json:"name"`
+	StartURL           string   `json:"startUrl,omitempty"`
+	Region             string   `json:"region,omitempty"`
+	RegistrationScopes []string `json:"registrationScopes,omitempty"`
+	Error              string   `json:"error,omitempty"`
+}
+
+// ssoToken is the portal access token the AWS CLI caches after
+// `aws sso login`
+type ssoToken struct {
+	AccessToken string
+	ExpiresAt   time.Time
+}
+
+// SSOCachedSession is a portal login found in the SSO token cache. The
+// token itself never leaves the backend.
+type SSOCachedSession struct {
+	File      string    `json:"file"`
+	Session   string    `json:"session,omitempty"`
+	StartURL  string    `json:"startUrl"`
+	Region    string    `json:"region"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Expired   bool      `json:"expired"`
+	// Refreshable tokens were issued with a refresh token the CLI can use
+	// to extend the login without the browser
+	Refreshable bool     `json:"refreshable"`
+	Profiles    []string `json:"profiles"`
+}
+
+// parseSSOExpiry accepts RFC 3339 and the "2006-01-02T15:04:05UTC" form
+// older CLI versions wrote
+func parseSSOExpiry(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02T15:04:05UTC", v)
+}
+
+type SSOAccount struct {
+	AccountID    string `json:"accountId"`
+	AccountName  string `json:"accountName"`
+	EmailAddress string `json:"emailAddress,omitempty"`
+}
+
+type SSORole struct {
+	AccountID   string `json:"accountId"`
+	AccountName string `json:"accountName,omitempty"`
+	RoleName    string `json:"roleName"`
+}
+
+func getSSOSettings(profile string) (*ssoSettings, error) {
+	section, err := getProfileSection(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if session := section.Key("sso_session").String(); session != "" {
+		cfg, err := ini.Load(getAWSConfigPath())
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS config: %w", err)
+		}
+		return ssoSessionSettings(cfg, session)
+	}
+
+	if startURL := ssoProfileKey(section, "sso_start_url"); startURL != "" {
+		return &ssoSettings{StartURL: startURL, Region: ssoProfileKey(section, "sso_region")}, nil
+	}
+	return nil, fmt.Errorf("profile %s is not configured for SSO", profile)
+}
+
+// ssoSessionSettings reads an [sso-session name] section
+func ssoSessionSettings(cfg *ini.File, session string) (*ssoSettings, error) {
+	section, err := cfg.GetSection("sso-session " + session)
+	if err != nil {
+		return nil, fmt.Errorf("sso-session %s not found", session)
+	}
+	s := &ssoSettings{
+		Session:  session,
+		StartURL: section.Key("sso_start_url").String(),
+		Region:   section.Key("sso_region").String(),
+	}
+	for _, scope := range strings.Split(section.Key("sso_registration_scopes").String(), ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			s.Scopes = append(s.Scopes, scope)
+		}
+	}
+	return s, nil
+}
+
+func ssoCacheDir() string {
+	return filepath.Join(filepath.Dir(getAWSConfigPath()), "sso", "cache")
+}
+
+// ssoCacheKey mirrors the CLI's naming: the SHA-1 of the session name, or
+// of the start URL for legacy profiles
+func ssoCacheKey(name string) string {
+	sum := sha1.Sum([]byte(name))
+	return hex.EncodeToString(sum[:])
+}
+
+func ssoCacheFile(s *ssoSettings) string {
+	key := s.Session
+	if key == "" {
+		key = s.StartURL
+	}
+	return filepath.Join(ssoCacheDir(), ssoCacheKey(key)+".json")
+}
+
+func readSSOCacheFile(path string) (*ssoCLIToken, time.Time, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var token ssoCLIToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid SSO token cache: %w", err)
+	}
+	expiresAt, err := parseSSOExpiry(token.ExpiresAt)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid SSO token expiry %q", token.ExpiresAt)
+	}
+	return &token, expiresAt, nil
+}
+
+func loadSSOToken(profile string, s *ssoSettings) (*ssoToken, error) {
+	token, expiresAt, err := readSSOCacheFile(ssoCacheFile(s))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no SSO token cached; run `aws sso login --profile %s`", profile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" || time.Now().After(expiresAt) {
+		return nil, fmt.Errorf("SSO token expired; run `aws sso login --profile %s`", profile)
+	}
+	return &ssoToken{AccessToken: token.AccessToken, ExpiresAt: expiresAt}, nil
+}
+
+// discoverSSOSessions lists the portal logins in the SSO token cache and
+// which profiles each one serves. The cache also holds tokens the CLI
+// writes for other tools (they have no accessToken); those are skipped.
+func discoverSSOSessions() ([]SSOCachedSession, error) {
+	entries, err := os.ReadDir(ssoCacheDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return []SSOCachedSession{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Map cache keys back to the sessions and start URLs in the config
+	type ssoOwner struct {
+		session  string
+		profiles []string
+	}
+	owners := make(map[string]*ssoOwner)
+	if cfg, err := ini.Load(getAWSConfigPath()); err == nil {
+		for _, section := range cfg.Sections() {
+			name := section.Name()
+			if session, ok := strings.CutPrefix(name, "sso-session "); ok {
+				key := ssoCacheKey(session)
+				if owners[key] == nil {
+					owners[key] = &ssoOwner{}
+				}
+				owners[key].session = session
+				continue
+			}
+			profile := strings.TrimPrefix(name, "profile ")
+			key := ""
+			if session := section.Key("sso_session").String(); session != "" {
+				key = ssoCacheKey(session)
+			} else if startURL := ssoProfileKey(section, "sso_start_url"); startURL != "" {
+				key = ssoCacheKey(startURL)
+			} else {
+				continue
+			}
+			if owners[key] == nil {
+				owners[key] = &ssoOwner{}
+			}
+			owners[key].profiles = append(owners[key].profiles, profile)
+		}
+	}
+
+	now := time.Now()
+	sessions := []SSOCachedSession{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		token, expiresAt, err := readSSOCacheFile(filepath.Join(ssoCacheDir(), entry.Name()))
+		if err != nil || token.AccessToken == "" {
+			continue
+		}
+		found := SSOCachedSession{
+			File:        entry.Name(),
+			StartURL:    token.StartURL,
+			Region:      token.Region,
+			ExpiresAt:   expiresAt.UTC(),
+			Expired:     now.After(expiresAt),
+			Refreshable: token.RefreshToken != "",
+			Profiles:    []string{},
+		}
+		if owner, ok := owners[strings.TrimSuffix(entry.Name(), ".json")]; ok {
+			found.Session = owner.session
+			found.Profiles = append(found.Profiles, owner.profiles...)
+			sort.Strings(found.Profiles)
+		}
+		sessions = append(sessions, found)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ExpiresAt.After(sessions[j].ExpiresAt)
+	})
+	return sessions, nil
+}
+
+func handleListSSOSessions(c echo.Context) error {
+	sessions, err := discoverSSOSessions()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read SSO token cache",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, sessions)
+}
+
+// ssoPortal returns a portal client and access token for the profile. The
+// portal API authenticates with the bearer token, not SigV4.
+func ssoPortal(ctx context.Context, profile string) (*sso.Client, *ssoToken, *ssoSettings, error) {
+	s, err := getSSOSettings(profile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	token, err := loadSSOToken(profile, s)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	opts := append([]func(*config.LoadOptions) error{
+		config.WithRegion(s.Region),
+		config.WithCredentialsProvider(aws.AnonymousCredentials{}),
+	}, awsLoadOptions(profile)...)
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return sso.NewFromConfig(cfg), token, s, nil
+}
+
+func listSSOAccounts(ctx context.Context, client *sso.Client, token *ssoToken) ([]SSOAccount, error) {
+	accounts := []SSOAccount{}
+	paginator := sso.NewListAccountsPaginator(client, &sso.ListAccountsInput{
+		AccessToken: aws.String(token.AccessToken),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range page.AccountList {
+			accounts = append(accounts, SSOAccount{
+				AccountID:    aws.ToString(a.AccountId),
+				AccountName:  aws.ToString(a.AccountName),
+				EmailAddress: aws.ToString(a.EmailAddress),
+			})
+		}
+	}
+	return accounts, nil
+}
+
+func listSSORoles(ctx context.Context, client *sso.Client, token *ssoToken, account SSOAccount) ([]SSORole, error) {
+	roles := []SSORole{}
+	paginator := sso.NewListAccountRolesPaginator(client, &sso.ListAccountRolesInput{
+		AccessToken: aws.String(token.AccessToken),
+		AccountId:   aws.String(account.AccountID),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range page.RoleList {
+			roles = append(roles, SSORole{
+				AccountID:   account.AccountID,
+				AccountName: account.AccountName,
+				RoleName:    aws.ToString(r.RoleName),
+			})
+		}
+	}
+	return roles, nil
+}
+
+// ssoProfileConfig renders config entries for roles, named
+// <account>-<role>, pointing at the same portal as the source profile
+func ssoProfileConfig(s *ssoSettings, region string, roles []SSORole) string {
+	var b strings.Builder
+	for _, r := range roles {
+		account := r.AccountName
+		if account == "" {
+			account = r.AccountID
+		}
+		name := strings.Trim(ssoProfileNameInvalid.ReplaceAllString(strings.ToLower(account+"-"+r.RoleName), "-"), "-")
+
+		fmt.Fprintf(&b, "[profile %s]\n", name)
+		if s.Session != "" {
+			fmt.Fprintf(&b, "sso_session = %s\n", s.Session)
+		} else {
+			fmt.Fprintf(&b, "sso_start_url = %s\nsso_region = %s\n", s.StartURL, s.Region)
+		}
+		fmt.Fprintf(&b, "sso_account_id = %s\nsso_role_name = %s\n", r.AccountID, r.RoleName)
+		if region != "" {
+			fmt.Fprintf(&b, "region = %s\n", region)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func handleListSSOAccounts(c echo.Context) error {
+	profile := requestProfile(c, c.QueryParam("profile"))
+	ctx := c.Request().Context()
+
+	client, token, _, err := ssoPortal(ctx, profile)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error:   "No usable SSO session",
+			Details: err.Error(),
+		})
+	}
+
+	accounts, err := listSSOAccounts(ctx, client, token)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to list SSO accounts",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, accounts)
+}
+
+// handleListSSORoles lists roles for ?accountId=, or for every account.
+// With ?format=config it returns ready-to-paste profile entries instead.
+func handleListSSORoles(c echo.Context) error {
+	profile := requestProfile(c, c.QueryParam("profile"))
+	ctx := c.Request().Context()
+
+	client, token, settings, err := ssoPortal(ctx, profile)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, ErrorResponse{
+			Error:   "No usable SSO session",
+			Details: err.Error(),
+		})
+	}
+
+	var accounts []SSOAccount
+	if id := c.QueryParam("accountId"); id != "" {
+		accounts = []SSOAccount{{AccountID: id}}
+	} else if accounts, err = listSSOAccounts(ctx, client, token); err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to list SSO accounts",
+			Details: err.Error(),
+		})
+	}
+
+	roles := []SSORole{}
+	for _, account := range accounts {
+		accountRoles, err := listSSORoles(ctx, client, token, account)
+		if err != nil {
+			return c.JSON(http.StatusBadGateway, ErrorResponse{
+				Error:   "Failed to list SSO roles",
+				Details: fmt.Sprintf("account %s: %v", account.AccountID, err),
+			})
+		}
+		roles = append(roles, accountRoles...)
+	}
+
+	if c.QueryParam("format") == "config" {
+		return c.String(http.StatusOK, ssoProfileConfig(settings, getProfileRegion(profile), roles))
+	}
+	return c.JSON(http.StatusOK, roles)
+}
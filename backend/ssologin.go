@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sso"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
+	"github.com/aws/smithy-go"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	ssoSubdir          = "sso"
+	ssoClientName      = "docker-aws-mfa"
+	ssoDeviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+	// SlowDownException asks the client to add this to its polling interval
+	ssoSlowDownStep = 5
+
+	SSOStatusPending    = "pending"
+	SSOStatusAuthorized = "authorized"
+	SSOStatusComplete   = "complete"
+)
+
+// ssoAccessScope is the CLI's default scope for sso-session profiles;
+// legacy profiles register without scopes
+const ssoAccessScope = "sso:account:access"
+
+// ssoRegistration is the OIDC client the backend registers per portal. It
+// lasts about 90 days, so it is cached rather than registered per login.
+type ssoRegistration struct {
+	ClientID     string    `json:"clientId"`
+	ClientSecret string    `json:"clientSecret"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+// ssoCLIToken is the CLI's token cache format, so a login here also serves
+// `aws` commands and the SSO account browser
+type ssoCLIToken struct {
+	StartURL              string `json:"startUrl"`
+	Region                string `json:"region"`
+	AccessToken           string `json:"accessToken"`
+	ExpiresAt             string `json:"expiresAt"`
+	ClientID              string `json:"clientId,omitempty"`
+	ClientSecret          string `json:"clientSecret,omitempty"`
+	RegistrationExpiresAt string `json:"registrationExpiresAt,omitempty"`
+	RefreshToken          string `json:"refreshToken,omitempty"`
+}
+
+// ssoAuthorization is a device authorization waiting for the user to
+// approve it in the browser
+type ssoAuthorization struct {
+	id           string
+	profile      string
+	settings     *ssoSettings
+	registration *ssoRegistration
+	deviceCode   string
+	interval     int32
+	expiresAt    time.Time
+}
+
+// SSOStartRequest starts an SSO login. Force skips reusing a cached
+// portal token and always goes through the browser.
+type SSOStartRequest struct {
+	Profile string `json:"profile"`
+	Force   bool   `json:"force,omitempty"`
+}
+
+// SSOStartResponse is what the frontend shows while the user approves the
+// login: the code to confirm and where to confirm it. When a cached portal
+// token was still good, the login is already complete and Session is set.
+type SSOStartResponse struct {
+	Status                  string          `json:"status"`
+	ID                      string          `json:"id,omitempty"`
+	Profile                 string          `json:"profile"`
+	UserCode                string          `json:"userCode,omitempty"`
+	VerificationURI         string          `json:"verificationUri,omitempty"`
+	VerificationURIComplete string          `json:"verificationUriComplete,omitempty"`
+	Interval                int32           `json:"interval,omitempty"`
+	ExpiresAt               *time.Time      `json:"expiresAt,omitempty"`
+	Session                 *StatusResponse `json:"session,omitempty"`
+}
+
+type SSOPollRequest struct {
+	ID string `json:"id"`
+}
+
+// SSOPollResponse reports a pending authorization, or its outcome. A profile
+// without sso_account_id/sso_role_name only gets the portal token
+// (authorized); otherwise its role credentials are cached (complete).
+type SSOPollResponse struct {
+	Status   string          `json:"status"`
+	Interval int32           `json:"interval,omitempty"`
+	Session  *StatusResponse `json:"session,omitempty"`
+}
+
+var (
+	ssoAuthMu      sync.Mutex
+	ssoAuthPending = map[string]*ssoAuthorization{}
+)
+
+func ssoOIDCClient(ctx context.Context, profile string, s *ssoSettings) (*ssooidc.Client, error) {
+	opts := append([]func(*config.LoadOptions) error{
+		config.WithRegion(s.Region),
+		config.WithCredentialsProvider(aws.AnonymousCredentials{}),
+	}, awsLoadOptions(profile)...)
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return ssooidc.NewFromConfig(cfg), nil
+}
+
+// getSSORegistrationFile keys the cached client by what it was registered
+// for, so changing sso_registration_scopes registers a new one
+func getSSORegistrationFile(s *ssoSettings) string {
+	key := s.Region + "\x00" + s.StartURL
+	if len(s.Scopes) > 0 {
+		key += "\x00" + strings.Join(s.Scopes, ",")
+	}
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(getCacheDir(), ssoSubdir, "client-"+hex.EncodeToString(sum[:8])+".json")
+}
+
+// ssoScopes follows the CLI: only sso-session profiles ask for scopes,
+// sso_registration_scopes when set and sso:account:access otherwise
+func ssoScopes(s *ssoSettings) []string {
+	if s.Session == "" {
+		return nil
+	}
+	if len(s.Scopes) > 0 {
+		return s.Scopes
+	}
+	return []string{ssoAccessScope}
+}
+
+// registerSSOClient returns the cached OIDC client for the portal,
+// registering a new one when there is none or it is about to expire
+func registerSSOClient(ctx context.Context, client *ssooidc.Client, s *ssoSettings) (*ssoRegistration, error) {
+	path := getSSORegistrationFile(s)
+	if data, err := os.ReadFile(path); err == nil {
+		var reg ssoRegistration
+		if json.Unmarshal(data, &reg) == nil && time.Until(reg.ExpiresAt) > time.Hour {
+			return &reg, nil
+		}
+	}
+
+	out, err := client.RegisterClient(ctx, &ssooidc.RegisterClientInput{
+		ClientName: aws.String(ssoClientName),
+		ClientType: aws.String("public"),
+		Scopes:     ssoScopes(s),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to register SSO client: %w", err)
+	}
+	reg := &ssoRegistration{
+		ClientID:     aws.ToString(out.ClientId),
+		ClientSecret: aws.ToString(out.ClientSecret),
+		ExpiresAt:    time.Unix(out.ClientSecretExpiresAt, 0).UTC(),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(reg, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
+
+func pruneSSOAuthorizations() {
+	now := time.Now()
+	for id, auth := range ssoAuthPending {
+		if now.After(auth.expiresAt) {
+			delete(ssoAuthPending, id)
+		}
+	}
+}
+
+// saveSSOToken writes the portal token where the CLI looks for it
+func saveSSOToken(s *ssoSettings, reg *ssoRegistration, out *ssooidc.CreateTokenOutput) error {
+	expiresAt := time.Now().UTC().Add(time.Duration(out.ExpiresIn) * time.Second).Truncate(time.Second)
+	token := ssoCLIToken{
+		StartURL:              s.StartURL,
+		Region:                s.Region,
+		AccessToken:           aws.ToString(out.AccessToken),
+		ExpiresAt:             expiresAt.Format(time.RFC3339),
+		ClientID:              reg.ClientID,
+		ClientSecret:          reg.ClientSecret,
+		RegistrationExpiresAt: reg.ExpiresAt.Format(time.RFC3339),
+		RefreshToken:          aws.ToString(out.RefreshToken),
+	}
+
+	path := ssoCacheFile(s)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// ssoRoleCredentials exchanges the cached portal token for the profile's
+// sso_account_id/sso_role_name credentials. It returns nil when the
+// profile names no role.
+func ssoRoleCredentials(ctx context.Context, profile string) (*CachedCredentials, error) {
+	section, err := getProfileSection(profile)
+	if err != nil {
+		return nil, err
+	}
+	accountID := ssoProfileKey(section, "sso_account_id")
+	roleName := ssoProfileKey(section, "sso_role_name")
+	if accountID == "" || roleName == "" {
+		return nil, nil
+	}
+
+	client, token, _, err := ssoPortal(ctx, profile)
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.GetRoleCredentials(ctx, &sso.GetRoleCredentialsInput{
+		AccessToken: aws.String(token.AccessToken),
+		AccountId:   aws.String(accountID),
+		RoleName:    aws.String(roleName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get role credentials for %s in %s: %w", roleName, accountID, err)
+	}
+	rc := out.RoleCredentials
+	return &CachedCredentials{
+		AccessKeyID:     aws.ToString(rc.AccessKeyId),
+		SecretAccessKey: aws.ToString(rc.SecretAccessKey),
+		SessionToken:    aws.ToString(rc.SessionToken),
+		Expiration:      time.UnixMilli(rc.Expiration).UTC(),
+		Profile:         profile,
+		DeviceID:        getDeviceID(),
+		IssuedAt:        time.Now().UTC(),
+		RoleARN:         fmt.Sprintf("arn:aws:iam::%s:role/%s", accountID, roleName),
+	}, nil
+}
+
+// handleSSOStart begins a device authorization for an SSO profile. The
+// frontend shows the user code and opens the verification URI, then polls.
+func handleSSOStart(c echo.Context) error {
+	var req SSOStartRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	profile := requestProfile(c, req.Profile)
+	ctx := c.Request().Context()
+
+	s, err := getSSOSettings(profile)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Profile is not an SSO profile",
+			Details: err.Error(),
+		})
+	}
+	if s.StartURL == "" || s.Region == "" {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Incomplete SSO configuration",
+			Details: "sso_start_url and sso_region are required",
+		})
+	}
+
+	if resp := requireConfirmation(c, confirmLogin, profile); resp != nil {
+		return c.JSON(http.StatusPreconditionRequired, resp)
+	}
+	hooks, ok := runLoginHooks(ctx, hookPreLogin, profile, nil)
+	if !ok {
+		recordAudit(AuditEntry{Action: "login", Profile: profile, Result: "error", Details: "pre-login hook failed",
+			Fields: map[string]string{"method": "sso"}})
+		return c.JSON(http.StatusPreconditionFailed, map[string]interface{}{
+			"error": "Pre-login hook failed",
+			"hooks": hooks,
+		})
+	}
+
+	// A portal token from an earlier login here or `aws sso login` is
+	// enough to mint role credentials; if it has been revoked, fall back to
+	// the browser flow
+	if !req.Force {
+		if creds, err := ssoRoleCredentials(ctx, profile); err == nil && creds != nil {
+			status, err := finishLogin(c, creds, hooks, map[string]string{"method": "sso", "via": "cached-token"})
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, ErrorResponse{
+					Error:   "Failed to cache credentials",
+					Details: err.Error(),
+				})
+			}
+			return c.JSON(http.StatusOK, SSOStartResponse{Status: SSOStatusComplete, Profile: profile, Session: status})
+		}
+	}
+
+	client, err := ssoOIDCClient(ctx, profile, s)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to start SSO login",
+			Details: err.Error(),
+		})
+	}
+	reg, err := registerSSOClient(ctx, client, s)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to start SSO login",
+			Details: err.Error(),
+		})
+	}
+	out, err := client.StartDeviceAuthorization(ctx, &ssooidc.StartDeviceAuthorizationInput{
+		ClientId:     aws.String(reg.ClientID),
+		ClientSecret: aws.String(reg.ClientSecret),
+		StartUrl:     aws.String(s.StartURL),
+	})
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to start SSO login",
+			Details: err.Error(),
+		})
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to start SSO login",
+			Details: err.Error(),
+		})
+	}
+	auth := &ssoAuthorization{
+		id:           hex.EncodeToString(buf),
+		profile:      profile,
+		settings:     s,
+		registration: reg,
+		deviceCode:   aws.ToString(out.DeviceCode),
+		interval:     max(out.Interval, 1),
+		expiresAt:    time.Now().Add(time.Duration(out.ExpiresIn) * time.Second),
+	}
+
+	ssoAuthMu.Lock()
+	pruneSSOAuthorizations()
+	ssoAuthPending[auth.id] = auth
+	ssoAuthMu.Unlock()
+
+	expiresAt := auth.expiresAt.UTC()
+	return c.JSON(http.StatusOK, SSOStartResponse{
+		Status:                  SSOStatusPending,
+		ID:                      auth.id,
+		Profile:                 profile,
+		UserCode:                aws.ToString(out.UserCode),
+		VerificationURI:         aws.ToString(out.VerificationUri),
+		VerificationURIComplete: aws.ToString(out.VerificationUriComplete),
+		Interval:                auth.interval,
+		ExpiresAt:               &expiresAt,
+	})
+}
+
+// handleSSOPoll checks whether the user has approved the authorization.
+// Until then it answers 202 with the interval to wait before asking again.
+func handleSSOPoll(c echo.Context) error {
+	var req SSOPollRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	ctx := c.Request().Context()
+
+	ssoAuthMu.Lock()
+	pruneSSOAuthorizations()
+	auth, ok := ssoAuthPending[req.ID]
+	ssoAuthMu.Unlock()
+	if !ok {
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: "Unknown or expired SSO login",
+		})
+	}
+
+	client, err := ssoOIDCClient(ctx, auth.profile, auth.settings)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to complete SSO login",
+			Details: err.Error(),
+		})
+	}
+	out, err := client.CreateToken(ctx, &ssooidc.CreateTokenInput{
+		ClientId:     aws.String(auth.registration.ClientID),
+		ClientSecret: aws.String(auth.registration.ClientSecret),
+		GrantType:    aws.String(ssoDeviceGrantType),
+		DeviceCode:   aws.String(auth.deviceCode),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		code := ""
+		if errors.As(err, &apiErr) {
+			code = apiErr.ErrorCode()
+		}
+		switch code {
+		case "AuthorizationPendingException":
+			return c.JSON(http.StatusAccepted, SSOPollResponse{Status: SSOStatusPending, Interval: auth.interval})
+		case "SlowDownException":
+			ssoAuthMu.Lock()
+			auth.interval += ssoSlowDownStep
+			ssoAuthMu.Unlock()
+			return c.JSON(http.StatusAccepted, SSOPollResponse{Status: SSOStatusPending, Interval: auth.interval})
+		}
+
+		ssoAuthMu.Lock()
+		delete(ssoAuthPending, auth.id)
+		ssoAuthMu.Unlock()
+		recordAudit(AuditEntry{Action: "login", Profile: auth.profile, Result: "error", Details: err.Error(),
+			Fields: map[string]string{"method": "sso"}})
+
+		switch code {
+		case "ExpiredTokenException":
+			return c.JSON(http.StatusGone, ErrorResponse{
+				Error:   "SSO login expired",
+				Details: "the code was not approved in time; start again",
+			})
+		case "AccessDeniedException":
+			return c.JSON(http.StatusForbidden, ErrorResponse{
+				Error:   "SSO login denied",
+				Details: err.Error(),
+			})
+		}
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to complete SSO login",
+			Details: err.Error(),
+		})
+	}
+
+	ssoAuthMu.Lock()
+	delete(ssoAuthPending, auth.id)
+	ssoAuthMu.Unlock()
+
+	if err := saveSSOToken(auth.settings, auth.registration, out); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to cache SSO token",
+			Details: err.Error(),
+		})
+	}
+
+	creds, err := ssoRoleCredentials(ctx, auth.profile)
+	if err != nil {
+		recordAudit(AuditEntry{Action: "login", Profile: auth.profile, Result: "error", Details: err.Error(),
+			Fields: map[string]string{"method": "sso"}})
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to get SSO role credentials",
+			Details: err.Error(),
+		})
+	}
+	if creds == nil {
+		recordAudit(AuditEntry{Action: "login", Profile: auth.profile, Result: "ok",
+			Fields: map[string]string{"method": "sso"}})
+		return c.JSON(http.StatusOK, SSOPollResponse{Status: SSOStatusAuthorized})
+	}
+
+	status, err := finishLogin(c, creds, nil, map[string]string{"method": "sso", "via": "device"})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to cache credentials",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, SSOPollResponse{Status: SSOStatusComplete, Session: status})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// StartupInfo records how the backend came up. It is written to the log as
+// a single JSON line once the socket is listening, in place of a free-form
+// banner, and served at GET /startup-info so the UI and diagnostics can see
+// exactly what this process started with. It is not updated by a reload.
+type StartupInfo struct {
+	Event       string             `json:"event"`
+	StartedAt   time.Time          `json:"startedAt"`
+	ReadyAt     time.Time          `json:"readyAt"`
+	PID         int                `json:"pid"`
+	Version     string             `json:"version"`
+	GoVersion   string             `json:"goVersion"`
+	Socket      string             `json:"socket"`
+	Listeners   []ListenerChange   `json:"listeners"`
+	Environment StartupEnvironment `json:"environment"`
+	Settings    StartupSettings    `json:"settings"`
+	Features    map[string]bool    `json:"features"`
+	// Quarantined counts the corrupt cache files moved aside at startup
+	Quarantined int `json:"quarantined"`
+	// MigratedSessions counts sessions moved from the old cache layout
+	MigratedSessions int `json:"migratedSessions"`
+}
+
+type StartupEnvironment struct {
+	OS     string `json:"os"`
+	Arch   string `json:"arch"`
+	IsWSL2 bool   `json:"isWsl2"`
+	// BehindRouter is set when a multi-user router started this backend
+	BehindRouter bool `json:"behindRouter"`
+}
+
+// StartupSettings summarizes the settings in effect at startup without
+// repeating them; GET /settings has the full values
+type StartupSettings struct {
+	Path string `json:"path"`
+	// Loaded is false when there was no settings file and defaults apply
+	Loaded           bool             `json:"loaded"`
+	CredentialSource CredentialSource `json:"credentialSource"`
+	DefaultProfile   string           `json:"defaultProfile,omitempty"`
+	DefaultDuration  SessionDuration  `json:"defaultDuration,omitempty"`
+	ProfileSettings  int              `json:"profileSettings"`
+	Policies         int              `json:"policies"`
+}
+
+var processStartedAt = time.Now().UTC()
+
+var startup struct {
+	mu   sync.Mutex
+	info *StartupInfo
+}
+
+// startupFeatures reports which optional features the settings turn on
+func startupFeatures(settings *Settings) map[string]bool {
+	return map[string]bool{
+		"remoteAccess":      settings.RemoteAccess != nil && settings.RemoteAccess.Enabled,
+		"containerEndpoint": settings.ContainerEndpoint != nil && settings.ContainerEndpoint.Enabled,
+		"broker":            settings.Broker != nil && settings.Broker.Enabled,
+		"processScope":      settings.ProcessScope != nil && settings.ProcessScope.Enabled,
+		"autoProvision":     settings.AutoProvision != nil && settings.AutoProvision.Enabled,
+		"yubikey":           settings.YubiKey != nil && settings.YubiKey.Enabled,
+		"onePassword":       settings.OnePassword != nil && settings.OnePassword.Enabled,
+		"ecrCache":          settings.ECRCache != nil && settings.ECRCache.Enabled,
+		"teamSync":          settings.TeamSync != nil && settings.TeamSync.Bucket != "",
+		"notifications":     settings.Notifications != nil && len(settings.Notifications.Channels) > 0,
+		"keyValidation":     settings.KeyValidation == nil || !settings.KeyValidation.Disabled,
+		"hygiene":           settings.Hygiene == nil || !settings.Hygiene.Disabled,
+		"deviceBinding":     settings.DeviceBinding,
+		"viewerSessions":    settings.ViewerSessions,
+	}
+}
+
+// recordStartup builds the readiness record, keeps it for /startup-info and
+// writes it to the log
+func recordStartup(socketPath string, changes []ListenerChange, quarantined, migrated int) *StartupInfo {
+	settings := loadSettings()
+	_, statErr := os.Stat(getSettingsPath())
+
+	listeners.mu.Lock()
+	behindRouter := listeners.behindRouter
+	listeners.mu.Unlock()
+
+	if changes == nil {
+		changes = []ListenerChange{}
+	}
+	info := &StartupInfo{
+		Event:     "ready",
+		StartedAt: processStartedAt,
+		ReadyAt:   time.Now().UTC(),
+		PID:       os.Getpid(),
+		Version:   version,
+		GoVersion: runtime.Version(),
+		Socket:    socketPath,
+		Listeners: changes,
+		Environment: StartupEnvironment{
+			OS:           runtime.GOOS,
+			Arch:         runtime.GOARCH,
+			IsWSL2:       isWSL2(),
+			BehindRouter: behindRouter,
+		},
+		Settings: StartupSettings{
+			Path:             getSettingsPath(),
+			Loaded:           statErr == nil,
+			CredentialSource: settings.CredentialSource,
+			DefaultProfile:   settings.DefaultProfile,
+			DefaultDuration:  settings.DefaultDuration,
+			ProfileSettings:  len(settings.Profiles),
+			Policies:         len(settings.Policies),
+		},
+		Features:         startupFeatures(settings),
+		Quarantined:      quarantined,
+		MigratedSessions: migrated,
+	}
+
+	startup.mu.Lock()
+	startup.info = info
+	startup.mu.Unlock()
+
+	data, _ := json.Marshal(info)
+	fmt.Println(string(data))
+	return info
+}
+
+func handleGetStartupInfo(c echo.Context) error {
+	startup.mu.Lock()
+	info := startup.info
+	startup.mu.Unlock()
+	if info == nil {
+		return c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error: "Backend is still starting",
+		})
+	}
+	return c.JSON(http.StatusOK, info)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/ini.v1"
+)
+
+const (
+	teamManifestVersion = 1
+	defaultTeamKey      = "docker-aws-mfa/team-manifest.json"
+)
+
+var errTeamSyncNotConfigured = errors.New("team sync is not configured")
+
+// TeamSyncSettings points at the S3 object holding the shared team manifest.
+// Profile names the local profile whose session is used for the transfer.
+type TeamSyncSettings struct {
+	Bucket  string `json:"bucket"`
+	Key     string `json:"key,omitempty"`
+	Region  string `json:"region,omitempty"`
+	Profile string `json:"profile,omitempty"`
+}
+
+// TeamProfile is the shareable part of a profile definition
+type TeamProfile struct {
+	Name   string            `json:"name"`
+	Values map[string]string `json:"values"`
+}
+
+// TeamManifest is the non-secret metadata shared through the team bucket
+type TeamManifest struct {
+	Version   int           `json:"version"`
+	UpdatedAt time.Time     `json:"updatedAt"`
+	UpdatedBy string        `json:"updatedBy,omitempty"`
+	Profiles  []TeamProfile `json:"profiles"`
+}
+
+type TeamPullResponse struct {
+	Manifest *TeamManifest `json:"manifest"`
+	Missing  []string      `json:"missing"`
+	Applied  []string      `json:"applied,omitempty"`
+}
+
+// shareableProfileKeys are config keys that describe how to reach an
+// account without carrying anything user-specific or secret. mfa_serial is
+// per-user and credentials never leave the credentials file.
+var shareableProfileKeys = []string{
+	"region",
+	"output",
+	"role_arn",
+	"source_profile",
+	"duration_seconds",
+	"sso_session",
+	"sso_start_url",
+	"sso_region",
+	"sso_account_id",
+	"sso_role_name",
+}
+
+func buildTeamManifest() (*TeamManifest, error) {
+	configPath := getAWSConfigPath()
+	cfg, err := ini.Load(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config from %s: %w", configPath, err)
+	}
+
+	manifest := &TeamManifest{
+		Version:   teamManifestVersion,
+		UpdatedAt: time.Now().UTC(),
+		Profiles:  []TeamProfile{},
+	}
+	manifest.UpdatedBy, _ = os.Hostname()
+
+	for _, section := range cfg.Sections() {
+		name := section.Name()
+		if name == "DEFAULT" {
+			continue
+		}
+		if len(name) > 8 && name[:8] == "profile " {
+			name = name[8:]
+		}
+
+		values := map[string]string{}
+		for _, key := range shareableProfileKeys {
+			if section.HasKey(key) {
+				values[key] = section.Key(key).String()
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		manifest.Profiles = append(manifest.Profiles, TeamProfile{Name: name, Values: values})
+	}
+
+	sort.Slice(manifest.Profiles, func(i, j int) bool {
+		return manifest.Profiles[i].Name < manifest.Profiles[j].Name
+	})
+	return manifest, nil
+}
+
+func teamSyncTarget() (*TeamSyncSettings, error) {
+	settings := loadSettings()
+	if settings.TeamSync == nil || settings.TeamSync.Bucket == "" {
+		return nil, errTeamSyncNotConfigured
+	}
+
+	target := *settings.TeamSync
+	if target.Key == "" {
+		target.Key = defaultTeamKey
+	}
+	if target.Profile == "" {
+		target.Profile = defaultProfile()
+	}
+	return &target, nil
+}
+
+func teamS3Client(ctx context.Context, target *TeamSyncSettings) (*s3.Client, error) {
+	cfg, _, err := sessionAWSConfig(ctx, target.Profile)
+	if err != nil {
+		return nil, err
+	}
+	if target.Region != "" {
+		cfg.Region = target.Region
+	}
+	return s3.NewFromConfig(cfg), nil
+}
+
+func pushTeamManifest(ctx context.Context) (*TeamManifest, error) {
+	target, err := teamSyncTarget()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := buildTeamManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := teamS3Client(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(target.Bucket),
+		Key:         aws.String(target.Key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to upload team manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+func fetchTeamManifest(ctx context.Context) (*TeamManifest, error) {
+	target, err := teamSyncTarget()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := teamS3Client(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(target.Bucket),
+		Key:    aws.String(target.Key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download team manifest: %w", err)
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest TeamManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid team manifest: %w", err)
+	}
+	if manifest.Version > teamManifestVersion {
+		return nil, fmt.Errorf("team manifest version %d is newer than supported version %d", manifest.Version, teamManifestVersion)
+	}
+	return &manifest, nil
+}
+
+// applyTeamProfiles adds manifest profiles that are missing locally. Existing
+// profiles are never overwritten; local edits always win.
+func applyTeamProfiles(manifest *TeamManifest, names []string) ([]string, error) {
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var applied []string
+	err := modifyIniFile(getAWSConfigPath(), func(cfg *ini.File) error {
+		for _, p := range manifest.Profiles {
+			if !wanted[p.Name] {
+				continue
+			}
+			sectionName := profileSectionName(p.Name)
+			if _, err := cfg.GetSection(sectionName); err == nil {
+				continue
+			}
+			section, err := cfg.NewSection(sectionName)
+			if err != nil {
+				return err
+			}
+			for _, key := range shareableProfileKeys {
+				if value, ok := p.Values[key]; ok {
+					section.Key(key).SetValue(value)
+				}
+			}
+			applied = append(applied, p.Name)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+func missingTeamProfiles(manifest *TeamManifest) ([]string, error) {
+	configPath := getAWSConfigPath()
+	cfg, err := ini.Load(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config from %s: %w", configPath, err)
+	}
+
+	missing := []string{}
+	for _, p := range manifest.Profiles {
+		if _, err := cfg.GetSection(profileSectionName(p.Name)); err != nil {
+			missing = append(missing, p.Name)
+		}
+	}
+	return missing, nil
+}
+
+func handleGetTeamManifest(c echo.Context) error {
+	manifest, err := buildTeamManifest()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to build team manifest",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, manifest)
+}
+
+func handlePushTeamManifest(c echo.Context) error {
+	manifest, err := pushTeamManifest(c.Request().Context())
+	if errors.Is(err, errTeamSyncNotConfigured) {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Team sync is not configured",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to push team manifest",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, manifest)
+}
+
+// handlePullTeamManifest fetches the shared manifest and reports which
+// profiles are missing locally; ?apply=true writes them to the config file
+func handlePullTeamManifest(c echo.Context) error {
+	manifest, err := fetchTeamManifest(c.Request().Context())
+	if errors.Is(err, errTeamSyncNotConfigured) {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Team sync is not configured",
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to pull team manifest",
+			Details: err.Error(),
+		})
+	}
+
+	missing, err := missingTeamProfiles(manifest)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to compare team manifest",
+			Details: err.Error(),
+		})
+	}
+
+	resp := TeamPullResponse{Manifest: manifest, Missing: missing}
+	if c.QueryParam("apply") == "true" && len(missing) > 0 {
+		resp.Applied, err = applyTeamProfiles(manifest, missing)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "Failed to apply team profiles",
+				Details: err.Error(),
+			})
+		}
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
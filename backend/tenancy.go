@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Multi-user mode lets one router process serve several OS users on a shared
+// dev box. Every user gets their own backend child, started with that user's
+// HOME (and, when the router runs as root, that user's uid), so settings,
+// caches and ~/.aws never mix. Local callers are identified by the peer
+// credentials of their socket connection; remote callers by the uid claim
+// in their token, once the token verifies against that user's key.
+const (
+	tenantDirName      = "tenants"
+	tenantStartTimeout = 10 * time.Second
+	// tenantPath is the PATH children run with, in place of the router's
+	tenantPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+)
+
+// tenantPassEnv are the router's variables a child inherits. Anything else,
+// such as the router's own AWS_* or VAULT_TOKEN, stays with the router.
+var tenantPassEnv = []string{"DOCKER_HOST", "LANG", "TZ"}
+
+var errTenantForbidden = errors.New("this router can't serve other users unless it runs as root")
+
+type tenantContextKey struct{}
+
+type tenant struct {
+	uid          int
+	socket       string
+	remoteSocket string
+	cmd          *exec.Cmd
+	local        *httputil.ReverseProxy
+	remote       *httputil.ReverseProxy
+}
+
+type tenantRouter struct {
+	dir string
+
+	mu      sync.Mutex
+	tenants map[int]*tenant
+	// starts makes concurrent first requests from one user wait for a
+	// single child, without holding up other users
+	starts flightGroup[*tenant]
+}
+
+func unixProxy(socket string) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.Out.URL.Scheme = "http"
+			r.Out.URL.Host = "tenant"
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+		// Event streams must reach the client as they are written
+		FlushInterval: -1,
+	}
+}
+
+// tenantEnv is the environment a child runs with: the user's identity and
+// the few router variables in tenantPassEnv
+func tenantEnv(u *user.User) []string {
+	env := []string{"HOME=" + u.HomeDir, "USER=" + u.Username, "LOGNAME=" + u.Username, "PATH=" + tenantPath}
+	for _, key := range tenantPassEnv {
+		if v, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+v)
+		}
+	}
+	return env
+}
+
+func (r *tenantRouter) running(uid int) (*tenant, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tenants[uid]
+	return t, ok
+}
+
+// get returns the user's backend, starting it on first use
+func (r *tenantRouter) get(uid int) (*tenant, error) {
+	if t, ok := r.running(uid); ok {
+		return t, nil
+	}
+	if os.Geteuid() != 0 && uid != os.Geteuid() {
+		return nil, errTenantForbidden
+	}
+	return r.starts.do(strconv.Itoa(uid), func() (*tenant, error) {
+		// A start that finished just before this one began
+		if t, ok := r.running(uid); ok {
+			return t, nil
+		}
+		return r.start(uid)
+	})
+}
+
+// start runs a backend child for uid and waits for its socket
+func (r *tenantRouter) start(uid int) (*tenant, error) {
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return nil, err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	// Each child gets a directory only it (and root) can enter
+	dir := filepath.Join(r.dir, strconv.Itoa(uid))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if os.Geteuid() == 0 {
+		gid, _ := strconv.Atoi(u.Gid)
+		if err := os.Chown(dir, uid, gid); err != nil {
+			return nil, err
+		}
+	}
+
+	t := &tenant{
+		uid:          uid,
+		socket:       filepath.Join(dir, "backend.sock"),
+		remoteSocket: filepath.Join(dir, "remote.sock"),
+	}
+	t.cmd = exec.Command(exe, "-socket", t.socket, "-remote-socket", t.remoteSocket)
+	t.cmd.Env = tenantEnv(u)
+	t.cmd.Stdout = os.Stdout
+	t.cmd.Stderr = os.Stderr
+	if err := setTenantCredential(t.cmd, u); err != nil {
+		return nil, err
+	}
+	os.Remove(t.socket)
+	os.Remove(t.remoteSocket)
+	if err := t.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start backend for %s: %w", u.Username, err)
+	}
+	if err := waitForSocket(t.socket, tenantStartTimeout); err != nil {
+		t.cmd.Process.Kill()
+		return nil, fmt.Errorf("backend for %s did not start: %w", u.Username, err)
+	}
+
+	t.local = unixProxy(t.socket)
+	t.remote = unixProxy(t.remoteSocket)
+	r.mu.Lock()
+	r.tenants[uid] = t
+	r.mu.Unlock()
+	fmt.Printf("Started backend for %s (uid %d)\n", u.Username, uid)
+
+	// A crashed child is started again on the user's next request
+	go func() {
+		t.cmd.Wait()
+		r.mu.Lock()
+		if r.tenants[uid] == t {
+			delete(r.tenants, uid)
+		}
+		r.mu.Unlock()
+	}()
+	return t, nil
+}
+
+func waitForSocket(path string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func tenantError(w http.ResponseWriter, status int, msg string, err error) {
+	resp := ErrorResponse{Error: msg}
+	if err != nil {
+		resp.Details = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (r *tenantRouter) serveTenant(w http.ResponseWriter, req *http.Request, uid int, remote bool) {
+	t, err := r.get(uid)
+	if errors.Is(err, errTenantForbidden) {
+		tenantError(w, http.StatusForbidden, "User not served by this router", err)
+		return
+	}
+	if err != nil {
+		tenantError(w, http.StatusBadGateway, "Backend unavailable", err)
+		return
+	}
+	if remote {
+		t.remote.ServeHTTP(w, req)
+		return
+	}
+	t.local.ServeHTTP(w, req)
+}
+
+// serveLocal routes socket callers by the uid captured in ConnContext
+func (r *tenantRouter) serveLocal(w http.ResponseWriter, req *http.Request) {
+	uid, ok := req.Context().Value(tenantContextKey{}).(int)
+	if !ok {
+		tenantError(w, http.StatusUnauthorized, "Could not identify the calling user", nil)
+		return
+	}
+	r.serveTenant(w, req, uid, false)
+}
+
+// verifyTenantToken checks raw against the remote access key of the user it
+// names, read from their cache directory. The router never creates the
+// key: a user who hasn't issued a token has none.
+func verifyTenantToken(raw string) (int, error) {
+	var claims remoteClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(raw, &claims); err != nil {
+		return 0, err
+	}
+	uid, err := strconv.Atoi(claims.UID)
+	if err != nil {
+		return 0, errors.New("token does not name a user")
+	}
+	u, err := user.LookupId(claims.UID)
+	if err != nil {
+		return 0, err
+	}
+	key, err := os.ReadFile(filepath.Join(u.HomeDir, cacheDir, remoteKeyFile))
+	if err != nil || len(key) < 32 {
+		return 0, errors.New("the user has no remote access key")
+	}
+	if _, err := verifyRemoteToken(raw, key); err != nil {
+		return 0, err
+	}
+	return uid, nil
+}
+
+// serveRemote routes TCP callers by their token's uid claim. The token is
+// verified before the user's backend is started or reached, so a forged
+// uid can't make the router spawn anything.
+func (r *tenantRouter) serveRemote(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/health" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+		return
+	}
+
+	raw := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if raw == "" {
+		raw = req.URL.Query().Get("access_token")
+	}
+	uid, err := verifyTenantToken(raw)
+	if err != nil {
+		tenantError(w, http.StatusUnauthorized, "Invalid token", err)
+		return
+	}
+	r.serveTenant(w, req, uid, true)
+}
+
+func (r *tenantRouter) shutdown() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.tenants {
+		t.cmd.Process.Signal(os.Interrupt)
+	}
+}
+
+// runTenantRouter serves socketPath (and listen, if set) on behalf of every
+// user that connects. It replaces the normal single-user server.
+func runTenantRouter(socketPath, listen string) error {
+	r := &tenantRouter{
+		dir:     filepath.Join(filepath.Dir(socketPath), tenantDirName),
+		tenants: map[int]*tenant{},
+	}
+	// Traversable but not listable; the per-user directories inside are
+	// private to their owner
+	if err := os.MkdirAll(r.dir, 0711); err != nil {
+		return err
+	}
+	defer r.shutdown()
+
+	if listen != "" {
+		tcp, err := net.Listen("tcp", listen)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Multi-user remote access listening on %s\n", listen)
+		go func() {
+			if err := http.Serve(tcp, http.HandlerFunc(r.serveRemote)); err != nil {
+				fmt.Fprintf(os.Stderr, "Remote server error: %v\n", err)
+			}
+		}()
+	}
+
+	os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	// Every user must be able to connect; peer credentials decide what
+	// they reach
+	if err := os.Chmod(socketPath, 0666); err != nil {
+		return err
+	}
+
+	fmt.Printf("Multi-user backend listening on %s\n", socketPath)
+	server := &http.Server{
+		Handler: http.HandlerFunc(r.serveLocal),
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			uid, err := peerUID(c)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "peer credentials: %v\n", err)
+				return ctx
+			}
+			return context.WithValue(ctx, tenantContextKey{}, uid)
+		},
+	}
+	return server.Serve(listener)
+}
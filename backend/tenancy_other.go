@@ -0,0 +1,27 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os/exec"
+	"os/user"
+)
+
+var (
+	errMultiUserUnsupported    = errors.New("multi-user mode needs SO_PEERCRED and is only supported on Linux")
+	errProcessScopeUnsupported = errors.New("process scoping needs SO_PEERCRED and /proc and is only supported on Linux")
+)
+
+func peerUID(net.Conn) (int, error) {
+	return 0, errMultiUserUnsupported
+}
+
+func peerPID(net.Conn) (int, error) {
+	return 0, errProcessScopeUnsupported
+}
+
+func setTenantCredential(*exec.Cmd, *user.User) error {
+	return errMultiUserUnsupported
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+const (
+	throttleWindow       = 15 * time.Minute
+	throttleBudget       = 3 // throttles per window before warning
+	baseRefreshInterval  = 30 * time.Second
+	maxRefreshInterval   = 30 * time.Minute
+	throttleWarningLabel = "STS is throttling requests for this profile; refreshes are slowed down"
+)
+
+var throttleCodes = map[string]bool{
+	"Throttling":                true,
+	"ThrottlingException":       true,
+	"ThrottledException":        true,
+	"RequestLimitExceeded":      true,
+	"RequestThrottled":          true,
+	"RequestThrottledException": true,
+	"TooManyRequestsException":  true,
+}
+
+// ThrottleStatus is the per-profile error budget as reported on /status
+type ThrottleStatus struct {
+	Throttled       bool  `json:"throttled"`
+	Events          int   `json:"events"`
+	Calls           int   `json:"calls"`
+	RefreshInterval int64 `json:"refreshIntervalSeconds"`
+}
+
+// throttleTracker keeps a sliding window of STS calls and throttles per
+// profile
+type throttleTracker struct {
+	mu        sync.Mutex
+	calls     map[string][]time.Time
+	throttles map[string][]time.Time
+}
+
+var stsThrottles = &throttleTracker{
+	calls:     map[string][]time.Time{},
+	throttles: map[string][]time.Time{},
+}
+
+func isThrottleError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && throttleCodes[apiErr.ErrorCode()]
+}
+
+func pruneWindow(times []time.Time, now time.Time) []time.Time {
+	cutoff := now.Add(-throttleWindow)
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+// observe records the outcome of an STS call made for profile
+func (t *throttleTracker) observe(profile string, err error) {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.calls[profile] = append(pruneWindow(t.calls[profile], now), now)
+	if isThrottleError(err) {
+		t.throttles[profile] = append(pruneWindow(t.throttles[profile], now), now)
+	}
+}
+
+// status reports the budget; the recommended refresh interval doubles with
+// each throttle in the window
+func (t *throttleTracker) status(profile string) ThrottleStatus {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.calls[profile] = pruneWindow(t.calls[profile], now)
+	t.throttles[profile] = pruneWindow(t.throttles[profile], now)
+
+	events := len(t.throttles[profile])
+	interval := baseRefreshInterval
+	for i := 0; i < events && interval < maxRefreshInterval; i++ {
+		interval *= 2
+	}
+	if interval > maxRefreshInterval {
+		interval = maxRefreshInterval
+	}
+
+	return ThrottleStatus{
+		Throttled:       events >= throttleBudget,
+		Events:          events,
+		Calls:           len(t.calls[profile]),
+		RefreshInterval: int64(interval.Seconds()),
+	}
+}
+
+// withThrottleStatus annotates a status response with the profile's budget
+func withThrottleStatus(status StatusResponse) StatusResponse {
+	ts := stsThrottles.status(status.Profile)
+	status.Throttle = &ts
+	if ts.Throttled {
+		status.Warning = throttleWarningLabel
+	}
+	return status
+}
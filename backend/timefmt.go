@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/text/language"
+)
+
+// durationLocale holds the patterns used to render a remaining lifetime
+// for one language. Machine-readable fields are always returned alongside
+// the rendered string, so clients are free to ignore it and format locally.
+type durationLocale struct {
+	Tag          language.Tag
+	HoursMinutes string
+	Minutes      string
+	Expired      string
+	// ExpiresToday, ExpiresTomorrow and ExpiresLater take the local time
+	// of expiry, the last one with its date
+	ExpiresToday    string
+	ExpiresTomorrow string
+	ExpiresLater    string
+}
+
+// durationLocales lists supported languages; the first entry is the fallback
+var durationLocales = []durationLocale{
+	{Tag: language.English, HoursMinutes: "%dh %dm", Minutes: "%dm", Expired: "expired",
+		ExpiresToday: "expires today at %s", ExpiresTomorrow: "expires tomorrow at %s", ExpiresLater: "expires %s"},
+	{Tag: language.German, HoursMinutes: "%d Std. %d Min.", Minutes: "%d Min.", Expired: "abgelaufen",
+		ExpiresToday: "läuft heute um %s ab", ExpiresTomorrow: "läuft morgen um %s ab", ExpiresLater: "läuft am %s ab"},
+	{Tag: language.French, HoursMinutes: "%d h %d min", Minutes: "%d min", Expired: "expiré",
+		ExpiresToday: "expire aujourd'hui à %s", ExpiresTomorrow: "expire demain à %s", ExpiresLater: "expire le %s"},
+	{Tag: language.Spanish, HoursMinutes: "%d h %d min", Minutes: "%d min", Expired: "caducado",
+		ExpiresToday: "caduca hoy a las %s", ExpiresTomorrow: "caduca mañana a las %s", ExpiresLater: "caduca el %s"},
+	{Tag: language.Portuguese, HoursMinutes: "%d h %d min", Minutes: "%d min", Expired: "expirado",
+		ExpiresToday: "expira hoje às %s", ExpiresTomorrow: "expira amanhã às %s", ExpiresLater: "expira em %s"},
+	{Tag: language.Japanese, HoursMinutes: "%d時間%d分", Minutes: "%d分", Expired: "期限切れ",
+		ExpiresToday: "今日 %s に期限切れ", ExpiresTomorrow: "明日 %s に期限切れ", ExpiresLater: "%s に期限切れ"},
+	{Tag: language.Chinese, HoursMinutes: "%d小时%d分钟", Minutes: "%d分钟", Expired: "已过期",
+		ExpiresToday: "今天 %s 过期", ExpiresTomorrow: "明天 %s 过期", ExpiresLater: "%s 过期"},
+}
+
+var durationLocaleMatcher = func() language.Matcher {
+	tags := make([]language.Tag, len(durationLocales))
+	for i, l := range durationLocales {
+		tags[i] = l.Tag
+	}
+	return language.NewMatcher(tags)
+}()
+
+// localeForRequest picks the best supported locale from Accept-Language
+func localeForRequest(c echo.Context) durationLocale {
+	tags, _, err := language.ParseAcceptLanguage(c.Request().Header.Get("Accept-Language"))
+	if err != nil || len(tags) == 0 {
+		return durationLocales[0]
+	}
+	_, idx, _ := durationLocaleMatcher.Match(tags...)
+	return durationLocales[idx]
+}
+
+// formatRemaining renders the time left until expiration in this locale
+func (l durationLocale) formatRemaining(expiration time.Time) string {
+	remaining := time.Until(expiration)
+	if remaining < 0 {
+		return l.Expired
+	}
+
+	hours := int(remaining.Hours())
+	minutes := int(remaining.Minutes()) % 60
+
+	if hours > 0 {
+		return fmt.Sprintf(l.HoursMinutes, hours, minutes)
+	}
+	return fmt.Sprintf(l.Minutes, minutes)
+}
+
+func secondsRemaining(expiration time.Time) int64 {
+	remaining := int64(time.Until(expiration).Seconds())
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// Expiry days, relative to the display timezone
+const (
+	expiresDayToday    = "today"
+	expiresDayTomorrow = "tomorrow"
+	expiresDayLater    = "later"
+	expiresDayExpired  = "expired"
+)
+
+// The backend runs in the Docker Desktop VM, whose clock is on UTC, so
+// expiry times are also given in the user's timezone: the timezone
+// setting, else the zone the client sends in X-Timezone (the UI sends the
+// browser's), else the backend's own, which is only right outside the VM.
+
+const timezoneHeader = "X-Timezone"
+
+var hostTimezone = sync.OnceValue(func() *time.Location {
+	// time.Local is named "Local" when it comes from /etc/localtime, so
+	// look for the zone's name first
+	if tz := strings.TrimPrefix(os.Getenv("TZ"), ":"); tz != "" {
+		if loc, err := time.LoadLocation(tz); err == nil {
+			return loc
+		}
+	}
+	if target, err := os.Readlink("/etc/localtime"); err == nil {
+		if _, name, ok := strings.Cut(target, "zoneinfo/"); ok {
+			if loc, err := time.LoadLocation(name); err == nil {
+				return loc
+			}
+		}
+	}
+	return time.Local
+})
+
+func validateTimezone(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, err := time.LoadLocation(name); err != nil {
+		return fmt.Errorf("timezone %q is not an IANA timezone name", name)
+	}
+	return nil
+}
+
+// displayTimezone is where expiry times are shown for this request. A
+// zone in the header that isn't an IANA name is ignored.
+func displayTimezone(c echo.Context) *time.Location {
+	for _, name := range []string{loadSettings().Timezone, c.Request().Header.Get(timezoneHeader)} {
+		if name == "" {
+			continue
+		}
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc
+		}
+	}
+	return hostTimezone()
+}
+
+// expiresDay says whether expiration falls today or tomorrow in loc
+func expiresDay(expiration time.Time, loc *time.Location) string {
+	now := time.Now().In(loc)
+	if !expiration.After(now) {
+		return expiresDayExpired
+	}
+	y, m, d := now.Date()
+	switch local := expiration.In(loc); {
+	case local.Before(time.Date(y, m, d+1, 0, 0, 0, 0, loc)):
+		return expiresDayToday
+	case local.Before(time.Date(y, m, d+2, 0, 0, 0, 0, loc)):
+		return expiresDayTomorrow
+	}
+	return expiresDayLater
+}
+
+// formatExpiry renders when expiration is in loc, e.g. "expires tomorrow
+// at 09:30 CET"
+func (l durationLocale) formatExpiry(expiration time.Time, loc *time.Location) string {
+	local := expiration.In(loc)
+	switch expiresDay(expiration, loc) {
+	case expiresDayExpired:
+		return l.Expired
+	case expiresDayToday:
+		return fmt.Sprintf(l.ExpiresToday, local.Format("15:04 MST"))
+	case expiresDayTomorrow:
+		return fmt.Sprintf(l.ExpiresTomorrow, local.Format("15:04 MST"))
+	}
+	return fmt.Sprintf(l.ExpiresLater, local.Format("2006-01-02 15:04 MST"))
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultTimelineDays = 7
+	maxTimelineDays     = 90
+	defaultTimelineStep = time.Hour
+	minTimelineStep     = 5 * time.Minute
+	maxTimelineBuckets  = 2000
+	// The audit log also holds broker and export entries, so scan well past
+	// what the logins alone would need
+	timelineAuditScan = 50000
+)
+
+// sessionSpan is one session's life: from login until it expired, was
+// cleared or was replaced by the next login
+type sessionSpan struct {
+	start, end time.Time
+	cleared    bool
+	active     bool
+}
+
+// ProfileTimeline is one profile's share of each bucket spent with a valid
+// session. Expired sessions ran their full duration; cleared ones were
+// dropped by the user or a policy first.
+type ProfileTimeline struct {
+	Profile        string    `json:"profile"`
+	Coverage       []float64 `json:"coverage"`
+	CoveredSeconds int64     `json:"coveredSeconds"`
+	CoverageRatio  float64   `json:"coverageRatio"`
+	Logins         int       `json:"logins"`
+	Expired        int       `json:"expired"`
+	Cleared        int       `json:"cleared"`
+}
+
+type TimelineResponse struct {
+	From          time.Time         `json:"from"`
+	To            time.Time         `json:"to"`
+	BucketSeconds int64             `json:"bucketSeconds"`
+	Buckets       []time.Time       `json:"buckets"`
+	Profiles      []ProfileTimeline `json:"profiles"`
+}
+
+// sessionSpans rebuilds each profile's sessions from the audit log. Logins
+// record when their session expires; logins audited before that was
+// recorded can't be placed and are skipped. Sessions still cached are
+// taken from the cache, which knows exactly when they were issued.
+func sessionSpans(now time.Time) (map[string][]sessionSpan, error) {
+	entries, err := readAudit("", timelineAuditScan)
+	if err != nil {
+		return nil, err
+	}
+
+	spans := make(map[string][]sessionSpan)
+	// end cuts a profile's open session short, or every profile's when
+	// profile is empty. A session replaced by a new login isn't counted as
+	// cleared.
+	end := func(profile string, at time.Time, cleared bool) {
+		for p, list := range spans {
+			if profile != "" && p != profile {
+				continue
+			}
+			if last := &list[len(list)-1]; last.end.After(at) {
+				last.end = at
+				last.cleared = cleared
+			}
+		}
+	}
+
+	// readAudit returns the newest first
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		switch {
+		case entry.Action == "login" && entry.Result == "ok":
+			expiresAt, err := time.Parse(time.RFC3339, entry.Fields["expiresAt"])
+			if err != nil {
+				continue
+			}
+			end(entry.Profile, entry.Time, false)
+			spans[entry.Profile] = append(spans[entry.Profile], sessionSpan{start: entry.Time, end: expiresAt})
+		case entry.Action == "clear" && entry.Result == "ok":
+			end(entry.Profile, entry.Time, true)
+		case entry.Result == "cleared" && entry.Profile != "":
+			end(entry.Profile, entry.Time, true)
+		}
+	}
+
+	for _, profile := range cachedProfiles() {
+		creds, err := loadCachedCredentials(profile)
+		if err != nil || creds.IssuedAt.IsZero() {
+			continue
+		}
+		list := spans[profile]
+		if n := len(list); n > 0 && !list[n-1].start.Before(creds.IssuedAt.Add(-time.Minute)) {
+			continue
+		}
+		spans[profile] = append(list, sessionSpan{start: creds.IssuedAt, end: creds.Expiration})
+	}
+
+	// Sessions can't outlive now
+	for _, list := range spans {
+		if last := &list[len(list)-1]; last.end.After(now) {
+			last.end = now
+			last.active = true
+		}
+	}
+	return spans, nil
+}
+
+// overlap is how long [start, end) and [from, to) share
+func overlap(start, end, from, to time.Time) time.Duration {
+	if start.Before(from) {
+		start = from
+	}
+	if end.After(to) {
+		end = to
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// nextBucket steps whole days by the calendar so day buckets stay on
+// midnight across DST changes
+func nextBucket(at time.Time, step time.Duration) time.Time {
+	if step%(24*time.Hour) == 0 {
+		return at.AddDate(0, 0, int(step/(24*time.Hour)))
+	}
+	return at.Add(step)
+}
+
+// buildTimeline buckets the spans between from and to. Spans of one profile
+// never overlap, so summing them per bucket can't exceed the bucket.
+func buildTimeline(spans map[string][]sessionSpan, profile string, from, to time.Time, step time.Duration) TimelineResponse {
+	resp := TimelineResponse{
+		From:          from,
+		To:            to,
+		BucketSeconds: int64(step / time.Second),
+		Buckets:       []time.Time{},
+		Profiles:      []ProfileTimeline{},
+	}
+	for at := from; at.Before(to); at = nextBucket(at, step) {
+		resp.Buckets = append(resp.Buckets, at)
+	}
+
+	names := make([]string, 0, len(spans))
+	for name := range spans {
+		if profile == "" || name == profile {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		tl := ProfileTimeline{Profile: name, Coverage: make([]float64, len(resp.Buckets))}
+		var covered time.Duration
+		for _, span := range spans[name] {
+			if !span.end.After(from) || !span.start.Before(to) {
+				continue
+			}
+			if !span.start.Before(from) {
+				tl.Logins++
+			}
+			switch {
+			case span.cleared:
+				tl.Cleared++
+			case !span.active:
+				tl.Expired++
+			}
+			for i, bucket := range resp.Buckets {
+				bucketEnd := nextBucket(bucket, step)
+				d := overlap(span.start, span.end, bucket, bucketEnd)
+				tl.Coverage[i] += d.Seconds() / bucketEnd.Sub(bucket).Seconds()
+				covered += d
+			}
+		}
+		for i, v := range tl.Coverage {
+			tl.Coverage[i] = roundRatio(min(v, 1))
+		}
+		tl.CoveredSeconds = int64(covered / time.Second)
+		tl.CoverageRatio = roundRatio(covered.Seconds() / to.Sub(from).Seconds())
+		resp.Profiles = append(resp.Profiles, tl)
+	}
+	return resp
+}
+
+func roundRatio(v float64) float64 {
+	return float64(int64(v*1000+0.5)) / 1000
+}
+
+// handleHistoryTimeline reports per-profile session coverage over the last
+// ?days= (7 by default) in ?bucket= steps ("1h" by default, "30m", "1d").
+// Day buckets start at midnight in ?timezone=, or the backend's local time.
+func handleHistoryTimeline(c echo.Context) error {
+	days := defaultTimelineDays
+	if raw := c.QueryParam("days"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxTimelineDays {
+			return c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid days",
+				Details: fmt.Sprintf("days must be between 1 and %d", maxTimelineDays),
+			})
+		}
+		days = n
+	}
+
+	step := defaultTimelineStep
+	if raw := c.QueryParam("bucket"); raw != "" {
+		d, err := parseSessionDuration(raw)
+		if err != nil || time.Duration(d)*time.Second < minTimelineStep {
+			return c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid bucket",
+				Details: fmt.Sprintf("bucket must be a duration of at least %s", minTimelineStep),
+			})
+		}
+		step = time.Duration(d) * time.Second
+	}
+	if time.Duration(days)*24*time.Hour/step > maxTimelineBuckets {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Too many buckets",
+			Details: fmt.Sprintf("%d days in %s buckets is more than %d; use a larger bucket", days, step, maxTimelineBuckets),
+		})
+	}
+
+	loc := time.Local
+	if tz := c.QueryParam("timezone"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "Invalid timezone",
+				Details: err.Error(),
+			})
+		}
+	}
+
+	// Align buckets so the same hour or day always lands in the same
+	// bucket, and end with the bucket holding now
+	now := time.Now().In(loc)
+	var to time.Time
+	if step%(24*time.Hour) == 0 {
+		to = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc)
+	} else {
+		to = now.Truncate(step).Add(step)
+	}
+	from := to.AddDate(0, 0, -days)
+
+	spans, err := sessionSpans(time.Now())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to read audit log",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, buildTimeline(spans, c.QueryParam("profile"), from, to, step))
+}
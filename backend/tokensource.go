@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// A login without a token code walks the profile's chain of token sources
+// and uses the first code one of them produces. Sources that aren't set up
+// for the profile are skipped, and one that fails hands over to the next,
+// so an unplugged key falls back to a password manager. A code in the
+// request is always used as given.
+
+const (
+	tokenSourceManual     = "manual"
+	tokenSourceMFAProcess = "mfa_process"
+	tokenSourceTOTP       = "totp"
+)
+
+// errTokenCodeRequired is returned when the chain reaches manual entry
+var errTokenCodeRequired = errors.New("token code is required")
+
+// TokenSource produces MFA token codes. New sources are added by
+// implementing it and listing it in tokenSources and, if it should be
+// tried by default, defaultTokenSourceChain.
+type TokenSource interface {
+	Description() string
+	// Configured reports whether the source is set up for profile, without
+	// running anything
+	Configured(profile string) bool
+	// Code returns the current code. It is never included in errors.
+	Code(ctx context.Context, profile string) (string, error)
+}
+
+var tokenSources = map[string]TokenSource{
+	tokenSourceManual:     manualSource{},
+	mfaSourceYubiKey:      yubikeySource{},
+	mfaSourceOnePassword:  onePasswordSource{},
+	mfaSourceBitwarden:    bitwardenSource{},
+	mfaSourcePass:         passSource{},
+	tokenSourceMFAProcess: mfaProcessSource{},
+	tokenSourceTOTP:       storedTOTPSource{},
+}
+
+// defaultTokenSourceChain keeps hardware keys ahead of password managers,
+// and manual entry last
+var defaultTokenSourceChain = []string{
+	mfaSourceYubiKey,
+	mfaSourceOnePassword,
+	mfaSourceBitwarden,
+	mfaSourcePass,
+	tokenSourceMFAProcess,
+	tokenSourceTOTP,
+	tokenSourceManual,
+}
+
+// manualSource is the user typing the code. It ends the chain: the login
+// has to be retried with the code.
+type manualSource struct{}
+
+func (manualSource) Description() string    { return "Ask for the code" }
+func (manualSource) Configured(string) bool { return true }
+func (manualSource) Code(context.Context, string) (string, error) {
+	return "", errTokenCodeRequired
+}
+
+type yubikeySource struct{}
+
+func (yubikeySource) Description() string {
+	return "Read the code from a YubiKey OATH account with ykman"
+}
+func (yubikeySource) Configured(profile string) bool { return yubikeyAccount(profile) != "" }
+func (yubikeySource) Code(ctx context.Context, profile string) (string, error) {
+	return yubikeyCode(ctx, profile)
+}
+
+type onePasswordSource struct{}
+
+func (onePasswordSource) Description() string {
+	return "Read the one-time password of a 1Password item with op"
+}
+func (onePasswordSource) Configured(profile string) bool { return onePasswordItem(profile) != "" }
+func (onePasswordSource) Code(ctx context.Context, profile string) (string, error) {
+	return onePasswordCode(ctx, profile)
+}
+
+type bitwardenSource struct{}
+
+func (bitwardenSource) Description() string {
+	return "Read the TOTP code of a Bitwarden item with bw"
+}
+func (bitwardenSource) Configured(profile string) bool { return bitwardenItem(profile) != "" }
+func (bitwardenSource) Code(ctx context.Context, profile string) (string, error) {
+	return bitwardenCode(ctx, profile)
+}
+
+// mfaProcessSource runs the profile's mfa_process, a command in the AWS
+// config that prints the code, found like mfa_serial along the role chain
+type mfaProcessSource struct{}
+
+func (mfaProcessSource) Description() string {
+	return "Run the profile's mfa_process command"
+}
+func (mfaProcessSource) Configured(profile string) bool { return profileMFAProcess(profile) != "" }
+
+func (mfaProcessSource) Code(ctx context.Context, profile string) (string, error) {
+	command := profileMFAProcess(profile)
+	if command == "" {
+		return "", fmt.Errorf("profile %s has no mfa_process", profile)
+	}
+	out, err := runCodeHelperCmd(ctx, "mfa_process", func(ctx context.Context) *exec.Cmd {
+		cmd := shellCommand(ctx, command)
+		cmd.Env = hookEnv(profile, nil)
+		return cmd
+	})
+	if err != nil {
+		return "", err
+	}
+	code := strings.TrimSpace(out)
+	if !totpCodePattern.MatchString(code) {
+		return "", fmt.Errorf("mfa_process did not print a six-digit code")
+	}
+	return code, nil
+}
+
+func profileMFAProcess(profile string) string {
+	for _, candidate := range []string{profile, roleBaseProfile(profile)} {
+		if section, err := getProfileSection(candidate); err == nil {
+			if command := section.Key("mfa_process").String(); command != "" {
+				return command
+			}
+		}
+	}
+	return ""
+}
+
+// tokenSourceChain is the order sources are tried in for profile
+func tokenSourceChain(profile string) []string {
+	if chain := getProfileSettings(profile).TokenSources; len(chain) > 0 {
+		return chain
+	}
+	return defaultTokenSourceChain
+}
+
+// tokenCodeSource names the source a login for profile would read its
+// code from when the request leaves it out, or "" when it must be given
+func tokenCodeSource(profile string) string {
+	for _, name := range tokenSourceChain(profile) {
+		if name == tokenSourceManual {
+			return ""
+		}
+		if tokenSources[name].Configured(profile) {
+			return name
+		}
+	}
+	return ""
+}
+
+// readTokenCode walks the profile's chain and returns the first code a
+// source produces, with the source's name. It returns errTokenCodeRequired
+// when no source is set up, and the failures when every one that is failed.
+func readTokenCode(ctx context.Context, profile string) (string, string, error) {
+	var failures []string
+	for _, name := range tokenSourceChain(profile) {
+		source := tokenSources[name]
+		if !source.Configured(profile) {
+			continue
+		}
+		code, err := source.Code(ctx, profile)
+		if errors.Is(err, errTokenCodeRequired) {
+			break
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		return code, name, nil
+	}
+	if len(failures) == 0 {
+		return "", "", errTokenCodeRequired
+	}
+	return "", "", fmt.Errorf("no token source produced a code (%s)", strings.Join(failures, "; "))
+}
+
+// validateTokenSources checks each profile's chain names known sources,
+// once each, with manual only at the end
+func validateTokenSources(profiles map[string]ProfileSettings) error {
+	for profile, ps := range profiles {
+		seen := make(map[string]bool)
+		for i, name := range ps.TokenSources {
+			if _, ok := tokenSources[name]; !ok {
+				return fmt.Errorf("profiles.%s.tokenSources: unknown source %q; known sources are %s",
+					profile, name, strings.Join(tokenSourceNames(), ", "))
+			}
+			if seen[name] {
+				return fmt.Errorf("profiles.%s.tokenSources lists %s twice", profile, name)
+			}
+			seen[name] = true
+			if name == tokenSourceManual && i != len(ps.TokenSources)-1 {
+				return fmt.Errorf("profiles.%s.tokenSources: manual must come last, as later sources are never tried", profile)
+			}
+		}
+	}
+	return nil
+}
+
+func tokenSourceNames() []string {
+	names := make([]string, 0, len(tokenSources))
+	for name := range tokenSources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TokenSourceInfo is one step of a profile's chain
+type TokenSourceInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Configured  bool   `json:"configured"`
+}
+
+// TokenSourceChain is the chain a login for Profile walks. Selected is the
+// source it would use, or "manual" when the code has to be given.
+type TokenSourceChain struct {
+	Profile  string            `json:"profile"`
+	Custom   bool              `json:"custom"`
+	Chain    []TokenSourceInfo `json:"chain"`
+	Selected string            `json:"selected"`
+}
+
+// handleGetTokenSources is GET /token-sources. It only reports which
+// sources are set up; GET /mfa/providers/status checks the helpers.
+func handleGetTokenSources(c echo.Context) error {
+	profile := requestProfile(c, c.QueryParam("profile"))
+	chain := tokenSourceChain(profile)
+	result := TokenSourceChain{
+		Profile:  profile,
+		Custom:   len(getProfileSettings(profile).TokenSources) > 0,
+		Chain:    make([]TokenSourceInfo, 0, len(chain)),
+		Selected: tokenCodeSource(profile),
+	}
+	if result.Selected == "" {
+		result.Selected = tokenSourceManual
+	}
+	for _, name := range chain {
+		source := tokenSources[name]
+		result.Chain = append(result.Chain, TokenSourceInfo{
+			Name:        name,
+			Description: source.Description(),
+			Configured:  source.Configured(profile),
+		})
+	}
+	return c.JSON(http.StatusOK, result)
+}
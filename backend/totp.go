@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// A stored TOTP seed lets the backend generate a virtual MFA device's codes
+// itself (RFC 6238: HMAC-SHA1, 30-second steps, six digits, which is all
+// IAM virtual devices use). The seed is kept in the cache directory with
+// the same 0600 protection as the long-term keys in ~/.aws/credentials,
+// and is never returned by the API.
+
+const (
+	totpSubdir = "totp"
+	totpStep   = 30 * time.Second
+)
+
+// StoredTOTP is a profile's seed as kept on disk
+type StoredTOTP struct {
+	Secret  string    `json:"secret"`
+	SavedAt time.Time `json:"savedAt"`
+}
+
+// StoreTOTPRequest takes the seed as base32, as shown when a virtual MFA
+// device is set up, or as the otpauth:// URI in its QR code
+type StoreTOTPRequest struct {
+	Secret string `json:"secret"`
+}
+
+type StoredTOTPInfo struct {
+	Profile string    `json:"profile"`
+	SavedAt time.Time `json:"savedAt"`
+}
+
+// totpLastStep is the last time step each profile's code was used for, as
+// STS refuses a code it has already seen
+var totpLastStep = struct {
+	sync.Mutex
+	steps map[string]int64
+}{steps: make(map[string]int64)}
+
+func getTOTPPath(profile string) string {
+	return filepath.Join(getCacheDir(), totpSubdir, filepath.Base(profile)+".json")
+}
+
+// parseTOTPSecret decodes a base32 seed or an otpauth URI's secret
+func parseTOTPSecret(raw string) ([]byte, error) {
+	raw = strings.TrimSpace(raw)
+	if strings.HasPrefix(raw, "otpauth://") {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid otpauth URI")
+		}
+		q := u.Query()
+		if u.Host != "totp" {
+			return nil, fmt.Errorf("otpauth URI is not for a TOTP device")
+		}
+		if alg := q.Get("algorithm"); alg != "" && !strings.EqualFold(alg, "SHA1") {
+			return nil, fmt.Errorf("only SHA1 seeds are supported")
+		}
+		if d := q.Get("digits"); d != "" && d != "6" {
+			return nil, fmt.Errorf("only six-digit codes are supported")
+		}
+		if p := q.Get("period"); p != "" && p != "30" {
+			return nil, fmt.Errorf("only 30-second periods are supported")
+		}
+		raw = q.Get("secret")
+	}
+	raw = strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(raw))
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(raw, "="))
+	if err != nil || len(secret) < 10 {
+		return nil, fmt.Errorf("secret must be a base32 seed of at least 16 characters")
+	}
+	return secret, nil
+}
+
+// totpCode computes the code for a time step
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+func loadStoredTOTP(profile string) (*StoredTOTP, error) {
+	path := getTOTPPath(profile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var stored StoredTOTP
+	if err := decodeCacheFile(path, data, &stored); err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+type storedTOTPSource struct{}
+
+func (storedTOTPSource) Description() string {
+	return "Generate the code from a stored TOTP seed"
+}
+
+func (storedTOTPSource) Configured(profile string) bool {
+	_, err := os.Stat(getTOTPPath(profile))
+	return err == nil
+}
+
+// Code waits for the next time step when this step's code was already
+// used, which keeps two logins in quick succession from both failing
+func (storedTOTPSource) Code(ctx context.Context, profile string) (string, error) {
+	stored, err := loadStoredTOTP(profile)
+	if err != nil {
+		return "", fmt.Errorf("reading the stored seed: %w", err)
+	}
+	secret, err := parseTOTPSecret(stored.Secret)
+	if err != nil {
+		return "", fmt.Errorf("stored seed: %w", err)
+	}
+
+	// Claim a step before waiting so a concurrent login claims the next
+	totpLastStep.Lock()
+	step := max(time.Now().Unix()/int64(totpStep.Seconds()), totpLastStep.steps[profile]+1)
+	totpLastStep.steps[profile] = step
+	totpLastStep.Unlock()
+
+	select {
+	case <-time.After(time.Until(time.Unix(step*int64(totpStep.Seconds()), 0))):
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	return totpCode(secret, step), nil
+}
+
+// handleStoreTOTP is PUT /token-sources/totp/:profile
+func handleStoreTOTP(c echo.Context) error {
+	profile := c.Param("profile")
+	if !profileNamePattern.MatchString(profile) {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid profile name",
+			Details: profile,
+		})
+	}
+	var req StoreTOTPRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid request body",
+		})
+	}
+	if _, err := parseTOTPSecret(req.Secret); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid TOTP seed",
+			Details: err.Error(),
+		})
+	}
+
+	stored := StoredTOTP{Secret: strings.TrimSpace(req.Secret), SavedAt: time.Now().UTC()}
+	data, _ := json.Marshal(stored)
+	if err := writeFileAtomic(getTOTPPath(profile), data, 0600); err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to store TOTP seed",
+			Details: err.Error(),
+		})
+	}
+	recordAudit(AuditEntry{Action: "totp", Profile: profile, Result: "stored"})
+	return c.JSON(http.StatusOK, StoredTOTPInfo{Profile: profile, SavedAt: stored.SavedAt})
+}
+
+// handleDeleteTOTP is DELETE /token-sources/totp/:profile
+func handleDeleteTOTP(c echo.Context) error {
+	profile := c.Param("profile")
+	if !profileNamePattern.MatchString(profile) {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid profile name",
+			Details: profile,
+		})
+	}
+	if err := os.Remove(getTOTPPath(profile)); err != nil {
+		if os.IsNotExist(err) {
+			return c.JSON(http.StatusNotFound, ErrorResponse{
+				Error: "No TOTP seed stored for " + profile,
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "Failed to remove TOTP seed",
+			Details: err.Error(),
+		})
+	}
+	recordAudit(AuditEntry{Action: "totp", Profile: profile, Result: "removed"})
+	return c.NoContent(http.StatusNoContent)
+}
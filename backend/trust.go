@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/labstack/echo/v4"
+)
+
+// Trust verdicts. unknown means an Allow statement would match apart from
+// conditions the backend can't evaluate locally.
+const (
+	trustAllowed = "allowed"
+	trustDenied  = "denied"
+	trustUnknown = "unknown"
+
+	conditionSatisfied   = "satisfied"
+	conditionUnsatisfied = "unsatisfied"
+	conditionUnknown     = "unknown"
+)
+
+// TrustCondition is one key of a statement's Condition block, evaluated
+// against the caller
+type TrustCondition struct {
+	Operator string   `json:"operator"`
+	Key      string   `json:"key"`
+	Values   []string `json:"values"`
+	Status   string   `json:"status"`
+	Actual   string   `json:"actual,omitempty"`
+}
+
+type TrustStatement struct {
+	Sid             string              `json:"sid,omitempty"`
+	Effect          string              `json:"effect"`
+	Actions         []string            `json:"actions"`
+	Principals      map[string][]string `json:"principals"`
+	MatchesCaller   bool                `json:"matchesCaller"`
+	MatchesAction   bool                `json:"matchesAction"`
+	Conditions      []TrustCondition    `json:"conditions,omitempty"`
+	ConditionStatus string              `json:"conditionStatus"`
+}
+
+// TrustInspection explains whether the caller may assume a role. The usual
+// reasons AssumeRole is denied are surfaced on their own: the caller isn't
+// a trusted principal, the trust policy wants MFA the session doesn't
+// carry, or it wants an external ID that isn't configured.
+type TrustInspection struct {
+	RoleARN            string           `json:"roleArn"`
+	Caller             string           `json:"caller"`
+	CallerAccount      string           `json:"callerAccount"`
+	Policy             string           `json:"policy"`
+	Statements         []TrustStatement `json:"statements"`
+	PrincipalTrusted   bool             `json:"principalTrusted"`
+	MFARequired        bool             `json:"mfaRequired"`
+	MFAPresent         bool             `json:"mfaPresent"`
+	ExternalIDRequired bool             `json:"externalIdRequired"`
+	Verdict            string           `json:"verdict"`
+	Reasons            []string         `json:"reasons,omitempty"`
+}
+
+// trustCaller is what the conditions are evaluated against
+type trustCaller struct {
+	arn, principalARN, account string
+	mfaPresent                 bool
+	mfaAge                     time.Duration
+	externalID                 string
+}
+
+// stringList reads a policy value that may be a single string or a list
+func stringList(raw json.RawMessage) []string {
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return []string{one}
+	}
+	var many []string
+	if json.Unmarshal(raw, &many) == nil {
+		return many
+	}
+	var b bool
+	if json.Unmarshal(raw, &b) == nil {
+		return []string{strconv.FormatBool(b)}
+	}
+	return nil
+}
+
+// policyWildcard matches IAM's * and ? wildcards, case-insensitively for
+// actions
+func policyWildcard(pattern, value string, foldCase bool) bool {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	if foldCase {
+		expr = "(?i)" + expr
+	}
+	ok, _ := regexp.MatchString("^"+expr+"$", value)
+	return ok
+}
+
+// principalMatches checks the statement's AWS principals. Naming the
+// account ("123456789012" or its :root ARN) trusts every identity in it
+// whose own policy allows the call.
+func principalMatches(principals map[string][]string, caller trustCaller) bool {
+	for _, p := range principals["AWS"] {
+		switch {
+		case p == "*":
+			return true
+		case p == caller.account, p == fmt.Sprintf("arn:aws:iam::%s:root", caller.account):
+			return true
+		case p == caller.arn, p == caller.principalARN:
+			return true
+		}
+	}
+	return false
+}
+
+func trackedConditionKey(key string) bool {
+	switch strings.ToLower(key) {
+	case "aws:multifactorauthpresent", "aws:multifactorauthage", "sts:externalid",
+		"aws:principalarn", "aws:principalaccount":
+		return true
+	}
+	return false
+}
+
+// conditionValue is the caller's value for a condition key, if known
+func conditionValue(key string, caller trustCaller) (string, bool) {
+	switch strings.ToLower(key) {
+	case "aws:multifactorauthpresent":
+		if !caller.mfaPresent {
+			return "", false
+		}
+		return "true", true
+	case "aws:multifactorauthage":
+		if !caller.mfaPresent {
+			return "", false
+		}
+		return strconv.Itoa(int(caller.mfaAge.Seconds())), true
+	case "sts:externalid":
+		return caller.externalID, caller.externalID != ""
+	case "aws:principalarn":
+		return caller.principalARN, true
+	case "aws:principalaccount":
+		return caller.account, true
+	}
+	return "", false
+}
+
+// evaluateCondition handles the operators trust policies use for MFA,
+// external IDs and principals. Set operators and other keys depend on
+// request context the backend doesn't have and are reported as unknown.
+func evaluateCondition(operator, key string, values []string, caller trustCaller) TrustCondition {
+	cond := TrustCondition{Operator: operator, Key: key, Values: values, Status: conditionUnknown}
+	if strings.HasPrefix(operator, "ForAnyValue:") || strings.HasPrefix(operator, "ForAllValues:") {
+		return cond
+	}
+	op, ifExists := strings.CutSuffix(operator, "IfExists")
+
+	actual, known := conditionValue(key, caller)
+	if !known {
+		// A key we track but the caller has no value for is absent from
+		// the request: Null:true and IfExists pass, everything else fails
+		if trackedConditionKey(key) {
+			switch {
+			case op == "Null":
+				cond.Status = boolStatus(len(values) > 0 && strings.EqualFold(values[0], "true"))
+			case ifExists, strings.HasPrefix(op, "StringNot"), strings.HasPrefix(op, "ArnNot"):
+				cond.Status = conditionSatisfied
+			default:
+				cond.Status = conditionUnsatisfied
+			}
+		}
+		return cond
+	}
+	cond.Actual = actual
+
+	match := func(f func(string) bool) {
+		ok := false
+		for _, v := range values {
+			if f(v) {
+				ok = true
+				break
+			}
+		}
+		if strings.HasPrefix(op, "StringNot") || strings.HasPrefix(op, "ArnNot") {
+			ok = !ok
+		}
+		cond.Status = boolStatus(ok)
+	}
+	switch op {
+	case "Bool":
+		match(func(v string) bool { return strings.EqualFold(v, actual) })
+	case "StringEquals", "StringNotEquals", "ArnEquals", "ArnNotEquals":
+		match(func(v string) bool { return v == actual })
+	case "StringEqualsIgnoreCase", "StringNotEqualsIgnoreCase":
+		match(func(v string) bool { return strings.EqualFold(v, actual) })
+	case "StringLike", "StringNotLike", "ArnLike", "ArnNotLike":
+		match(func(v string) bool { return policyWildcard(v, actual, false) })
+	case "NumericLessThan", "NumericLessThanEquals", "NumericGreaterThan", "NumericGreaterThanEquals", "NumericEquals":
+		n, err := strconv.ParseFloat(actual, 64)
+		if err != nil {
+			return cond
+		}
+		match(func(v string) bool {
+			limit, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return false
+			}
+			switch op {
+			case "NumericLessThan":
+				return n < limit
+			case "NumericLessThanEquals":
+				return n <= limit
+			case "NumericGreaterThan":
+				return n > limit
+			case "NumericGreaterThanEquals":
+				return n >= limit
+			}
+			return n == limit
+		})
+	case "Null":
+		cond.Status = boolStatus(len(values) > 0 && strings.EqualFold(values[0], "false"))
+	}
+	return cond
+}
+
+func boolStatus(ok bool) string {
+	if ok {
+		return conditionSatisfied
+	}
+	return conditionUnsatisfied
+}
+
+// inspectTrustPolicy evaluates a trust policy document for sts:AssumeRole
+// by caller
+func inspectTrustPolicy(document string, caller trustCaller) (*TrustInspection, error) {
+	var policy struct {
+		Statement json.RawMessage `json:"Statement"`
+	}
+	if err := json.Unmarshal([]byte(document), &policy); err != nil {
+		return nil, fmt.Errorf("invalid trust policy: %w", err)
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(policy.Statement, &raw); err != nil {
+		raw = []json.RawMessage{policy.Statement}
+	}
+
+	inspection := &TrustInspection{
+		Caller:        caller.arn,
+		CallerAccount: caller.account,
+		MFAPresent:    caller.mfaPresent,
+		Statements:    []TrustStatement{},
+		Verdict:       trustDenied,
+	}
+	var allowUnknown, denied bool
+	for _, r := range raw {
+		var stmt struct {
+			Sid       string                                `json:"Sid"`
+			Effect    string                                `json:"Effect"`
+			Principal json.RawMessage                       `json:"Principal"`
+			Action    json.RawMessage                       `json:"Action"`
+			Condition map[string]map[string]json.RawMessage `json:"Condition"`
+		}
+		if err := json.Unmarshal(r, &stmt); err != nil {
+			return nil, fmt.Errorf("invalid trust policy statement: %w", err)
+		}
+
+		ts := TrustStatement{
+			Sid:             stmt.Sid,
+			Effect:          stmt.Effect,
+			Actions:         stringList(stmt.Action),
+			Principals:      map[string][]string{},
+			ConditionStatus: conditionSatisfied,
+		}
+		var principalMap map[string]json.RawMessage
+		if json.Unmarshal(stmt.Principal, &principalMap) == nil {
+			for kind, v := range principalMap {
+				ts.Principals[kind] = stringList(v)
+			}
+		} else if p := stringList(stmt.Principal); len(p) > 0 {
+			ts.Principals["AWS"] = p
+		}
+		ts.MatchesCaller = principalMatches(ts.Principals, caller)
+		for _, action := range ts.Actions {
+			if policyWildcard(action, "sts:AssumeRole", true) {
+				ts.MatchesAction = true
+			}
+		}
+
+		operators := make([]string, 0, len(stmt.Condition))
+		for operator := range stmt.Condition {
+			operators = append(operators, operator)
+		}
+		sort.Strings(operators)
+		for _, operator := range operators {
+			keys := make([]string, 0, len(stmt.Condition[operator]))
+			for key := range stmt.Condition[operator] {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				cond := evaluateCondition(operator, key, stringList(stmt.Condition[operator][key]), caller)
+				ts.Conditions = append(ts.Conditions, cond)
+				switch {
+				case cond.Status == conditionUnsatisfied:
+					ts.ConditionStatus = conditionUnsatisfied
+				case cond.Status == conditionUnknown && ts.ConditionStatus == conditionSatisfied:
+					ts.ConditionStatus = conditionUnknown
+				}
+				if stmt.Effect == "Allow" && ts.MatchesCaller {
+					if strings.HasPrefix(strings.ToLower(key), "aws:multifactorauth") {
+						inspection.MFARequired = true
+					}
+					if strings.EqualFold(key, "sts:ExternalId") {
+						inspection.ExternalIDRequired = true
+					}
+				}
+			}
+		}
+		inspection.Statements = append(inspection.Statements, ts)
+
+		if !ts.MatchesCaller || !ts.MatchesAction {
+			continue
+		}
+		switch {
+		case stmt.Effect == "Deny" && ts.ConditionStatus != conditionUnsatisfied:
+			denied = true
+			inspection.Reasons = append(inspection.Reasons, fmt.Sprintf("statement %s denies the caller", statementName(ts, len(inspection.Statements))))
+		case stmt.Effect == "Allow":
+			inspection.PrincipalTrusted = true
+			switch ts.ConditionStatus {
+			case conditionSatisfied:
+				inspection.Verdict = trustAllowed
+			case conditionUnknown:
+				allowUnknown = true
+			case conditionUnsatisfied:
+				for _, cond := range ts.Conditions {
+					if cond.Status == conditionUnsatisfied {
+						inspection.Reasons = append(inspection.Reasons, fmt.Sprintf("statement %s needs %s %s %s",
+							statementName(ts, len(inspection.Statements)), cond.Key, cond.Operator, strings.Join(cond.Values, ",")))
+					}
+				}
+			}
+		}
+	}
+
+	switch {
+	case denied:
+		inspection.Verdict = trustDenied
+	case inspection.Verdict != trustAllowed && allowUnknown:
+		inspection.Verdict = trustUnknown
+		inspection.Reasons = append(inspection.Reasons, "a matching statement has conditions that can't be checked locally")
+	case !inspection.PrincipalTrusted:
+		inspection.Reasons = append(inspection.Reasons, "no Allow statement trusts "+caller.principalARN+" or its account")
+	}
+	pretty, err := json.MarshalIndent(json.RawMessage(document), "", "  ")
+	if err == nil {
+		inspection.Policy = string(pretty)
+	} else {
+		inspection.Policy = document
+	}
+	return inspection, nil
+}
+
+func statementName(ts TrustStatement, index int) string {
+	if ts.Sid != "" {
+		return strconv.Quote(ts.Sid)
+	}
+	return "#" + strconv.Itoa(index)
+}
+
+func accountFromARN(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 5 {
+		return ""
+	}
+	return parts[4]
+}
+
+// inspectRoleTrust fetches the role's trust policy with the profile's
+// session and evaluates it for that session's identity. IAM only returns
+// roles in the caller's own account.
+func inspectRoleTrust(ctx context.Context, profile, role, externalID string) (*TrustInspection, int, error) {
+	cfg, creds, err := sessionAWSConfig(ctx, profile)
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+	identity, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	stsThrottles.observe(profile, err)
+	if err != nil {
+		return nil, http.StatusBadGateway, fmt.Errorf("failed to resolve caller identity: %w", err)
+	}
+	account := aws.ToString(identity.Account)
+
+	roleName := role
+	if strings.HasPrefix(role, "arn:") {
+		if roleAccount := accountFromARN(role); roleAccount != account {
+			return nil, http.StatusUnprocessableEntity, fmt.Errorf(
+				"role is in account %s but the session is in %s; IAM only returns trust policies for roles in the caller's account", roleAccount, account)
+		}
+		roleName = role[strings.LastIndex(role, "/")+1:]
+	}
+
+	out, err := iam.NewFromConfig(cfg).GetRole(ctx, &iam.GetRoleInput{RoleName: aws.String(roleName)})
+	if err != nil {
+		return nil, http.StatusBadGateway, fmt.Errorf("failed to get role %s: %w", roleName, err)
+	}
+	document, err := url.QueryUnescape(aws.ToString(out.Role.AssumeRolePolicyDocument))
+	if err != nil {
+		return nil, http.StatusBadGateway, fmt.Errorf("invalid trust policy encoding: %w", err)
+	}
+	roleARN := aws.ToString(out.Role.Arn)
+
+	if externalID == "" && creds.RoleARN == roleARN {
+		externalID = creds.ExternalID
+	}
+	if externalID == "" {
+		if section, err := getProfileSection(profile); err == nil && section.Key("role_arn").String() == roleARN {
+			externalID = section.Key("external_id").String()
+		}
+	}
+	caller := trustCaller{
+		arn:          aws.ToString(identity.Arn),
+		principalARN: principalPolicySourceARN(aws.ToString(identity.Arn)),
+		account:      account,
+		externalID:   externalID,
+	}
+	// Sessions minted here carry MFA unless they came from SSO, which
+	// doesn't pass it on to STS
+	if _, err := getSSOSettings(profile); err != nil {
+		caller.mfaPresent = true
+		caller.mfaAge = time.Since(creds.IssuedAt)
+	}
+
+	inspection, err := inspectTrustPolicy(document, caller)
+	if err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+	inspection.RoleARN = roleARN
+	return inspection, http.StatusOK, nil
+}
+
+// handleRoleTrust is GET /roles/:arn/trust. The ARN's slashes must be
+// escaped (role%2FAdmin); a bare role name is looked up in the session's
+// account. ?externalId= overrides the profile's external_id.
+func handleRoleTrust(c echo.Context) error {
+	role, err := url.PathUnescape(c.Param("arn"))
+	if err != nil || role == "" {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: "Invalid role ARN",
+		})
+	}
+	profile := requestProfile(c, c.QueryParam("profile"))
+
+	inspection, code, err := inspectRoleTrust(c.Request().Context(), profile, role, c.QueryParam("externalId"))
+	if err != nil {
+		return c.JSON(code, ErrorResponse{
+			Error:   "Failed to inspect trust policy",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, inspection)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const viewerCacheSubdir = "viewer"
+
+// defaultViewerPolicy limits viewer sessions to the read-only calls the
+// dashboard makes. It is intersected with the viewer role's permissions, so
+// it can only ever narrow what the session may do.
+const defaultViewerPolicy = `{
+  "Version": "2012-10-17",
+  "Statement": [{
+    "Effect": "Allow",
+    "Action": [
+      "sts:GetCallerIdentity",
+      "iam:ListAccountAliases",
+      "iam:GetRole",
+      "ce:Get*",
+      "ce:Describe*",
+      "ecr:DescribeRepositories",
+      "ecr:DescribeImages",
+      "ecr:ListImages",
+      "ecr:ListTagsForResource",
+      "dynamodb:ListTables",
+      "dynamodb:DescribeTable",
+      "dynamodb:Scan"
+    ],
+    "Resource": "*"
+  }]
+}`
+
+var (
+	federationNameInvalid = regexp.MustCompile(`[^\w+=,.@-]`)
+	roleARNPattern        = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/.+$`)
+)
+
+func getViewerCacheFile(profile string) string {
+	return filepath.Join(getCacheDir(), viewerCacheSubdir, filepath.Base(profile)+".json")
+}
+
+func viewerPolicy() string {
+	if p := loadSettings().ViewerPolicy; p != "" {
+		return p
+	}
+	return defaultViewerPolicy
+}
+
+// viewerRoleARN is the role viewer sessions for profile assume: the
+// profile's viewerRoleArn setting, or else the role its own sessions are for
+func viewerRoleARN(profile string, session *CachedCredentials) string {
+	if arn := getProfileSettings(profile).ViewerRoleARN; arn != "" {
+		return arn
+	}
+	return session.RoleARN
+}
+
+// validateViewerRoles checks the viewerRoleArn of each profile's settings
+func validateViewerRoles(profiles map[string]ProfileSettings) error {
+	for profile, ps := range profiles {
+		if ps.ViewerRoleARN != "" && !roleARNPattern.MatchString(ps.ViewerRoleARN) {
+			return fmt.Errorf("profiles.%s: viewerRoleArn %q is not an IAM role ARN", profile, ps.ViewerRoleARN)
+		}
+	}
+	return nil
+}
+
+// mintViewerSession derives a read-only session from the MFA session a
+// login just cached, by assuming the viewer role with it under the viewer
+// policy. Long-term keys are never used, so a viewer session only exists
+// where an MFA login happened. It lives in its own cache directory and is
+// never served by the credential export routes.
+func mintViewerSession(ctx context.Context, session *CachedCredentials, duration int32) (*CachedCredentials, error) {
+	profile := session.Profile
+	roleARN := viewerRoleARN(profile, session)
+	if roleARN == "" {
+		return nil, fmt.Errorf("profile %s has no role to derive a viewer session from; set its viewerRoleArn", profile)
+	}
+
+	cfg, err := staticAWSConfig(ctx, profile, session)
+	if err != nil {
+		return nil, err
+	}
+	// A role session can only assume another role for up to an hour
+	if session.RoleARN != "" && duration > maxChainedRoleDuration {
+		duration = maxChainedRoleDuration
+	}
+
+	name := federationNameInvalid.ReplaceAllString("viewer-"+profile, "-")
+	if len(name) > maxRoleSessionNameLen {
+		name = name[:maxRoleSessionNameLen]
+	}
+
+	result, err := sts.NewFromConfig(cfg).AssumeRole(ctx, &sts.AssumeRoleInput{
+		RoleArn:         aws.String(roleARN),
+		RoleSessionName: aws.String(name),
+		Policy:          aws.String(compactPolicy(viewerPolicy())),
+		DurationSeconds: aws.Int32(duration),
+	})
+	stsThrottles.observe(profile, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mint viewer session: %w", err)
+	}
+
+	creds := &CachedCredentials{
+		AccessKeyID:     *result.Credentials.AccessKeyId,
+		SecretAccessKey: *result.Credentials.SecretAccessKey,
+		SessionToken:    *result.Credentials.SessionToken,
+		Expiration:      *result.Credentials.Expiration,
+		Profile:         profile,
+		DeviceID:        getDeviceID(),
+		IssuedAt:        time.Now().UTC(),
+		RoleARN:         roleARN,
+	}
+
+	path := getViewerCacheFile(profile)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return nil, err
+	}
+
+	return creds, nil
+}
+
+func loadViewerCredentials(profile string) (*CachedCredentials, error) {
+	path := getViewerCacheFile(profile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds CachedCredentials
+	if err := decodeCacheFile(path, data, &creds); err != nil {
+		return nil, err
+	}
+	if err := checkDeviceBinding(&creds); err != nil {
+		return nil, err
+	}
+	return &creds, nil
+}
+
+// viewerAWSConfig returns the config dashboard read features should use.
+// With viewer sessions enabled there is deliberately no fallback to the
+// full session, otherwise leaking these routes would expose it again.
+func viewerAWSConfig(ctx context.Context, profile string) (aws.Config, error) {
+	if !loadSettings().ViewerSessions {
+		cfg, _, err := sessionAWSConfig(ctx, profile)
+		return cfg, err
+	}
+
+	creds, err := loadViewerCredentials(profile)
+	if err != nil || !isCredentialsValid(creds) {
+		return aws.Config{}, fmt.Errorf("no valid viewer session for profile %s; log in again", profile)
+	}
+	return staticAWSConfig(ctx, profile, creds)
+}
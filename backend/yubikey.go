@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// The YubiKey integration reads TOTP codes from a key's OATH applet with
+// ykman, so a login for a profile mapped to an OATH account can go ahead
+// without a token code. Accounts that require touch make ykman wait until
+// the key is touched, which the helper timeout allows for. ykman needs the
+// key on USB, which the Docker Desktop VM can't reach, so the extension
+// image doesn't ship it: this only works when the backend runs on the
+// machine the key is plugged into, as on a shared dev box.
+
+const (
+	defaultYkmanCommand = "ykman"
+	mfaSourceYubiKey    = "yubikey"
+)
+
+var yubikeySerialPattern = regexp.MustCompile(`^\d+$`)
+
+// YubiKeySettings enables the integration. Profiles name the OATH account
+// to use in profiles.<name>.yubikeyAccount.
+type YubiKeySettings struct {
+	Enabled bool `json:"enabled"`
+	// Command is the ykman executable, by default found on PATH
+	Command string `json:"command,omitempty"`
+	// Device is the serial of the key to use when several are plugged in
+	Device string `json:"device,omitempty"`
+}
+
+// YubiKeyAccount is an OATH account on the key and the profiles using it
+type YubiKeyAccount struct {
+	Name     string   `json:"name"`
+	Profiles []string `json:"profiles"`
+}
+
+func yubikeySettings() *YubiKeySettings {
+	yk := loadSettings().YubiKey
+	if yk == nil || !yk.Enabled {
+		return nil
+	}
+	return yk
+}
+
+func validateYubiKey(yk *YubiKeySettings, profiles map[string]ProfileSettings) error {
+	if yk != nil && yk.Device != "" && !yubikeySerialPattern.MatchString(yk.Device) {
+		return fmt.Errorf("yubikey.device must be a serial number")
+	}
+	for profile, ps := range profiles {
+		if strings.ContainsAny(ps.YubiKeyAccount, "\r\n") {
+			return fmt.Errorf("profiles.%s.yubikeyAccount must be a single line", profile)
+		}
+	}
+	return nil
+}
+
+// yubikeyAccount returns the OATH account for profile when the integration
+// is enabled, or ""
+func yubikeyAccount(profile string) string {
+	if yubikeySettings() == nil {
+		return ""
+	}
+	return getProfileSettings(profile).YubiKeyAccount
+}
+
+// runYkman runs ykman against the configured key and returns its output
+func runYkman(ctx context.Context, yk *YubiKeySettings, args ...string) (string, error) {
+	command := yk.Command
+	if command == "" {
+		command = defaultYkmanCommand
+	}
+	if yk.Device != "" {
+		args = append([]string{"--device", yk.Device}, args...)
+	}
+	out, err := runCodeHelper(ctx, "ykman", command, args...)
+	if errors.Is(err, errCodeHelperTimeout) {
+		return "", fmt.Errorf("%w; touch the key if the account requires it", err)
+	}
+	return out, err
+}
+
+// yubikeyCode reads the current TOTP code for the profile's OATH account.
+// The code is never included in errors.
+func yubikeyCode(ctx context.Context, profile string) (string, error) {
+	yk := yubikeySettings()
+	account := yubikeyAccount(profile)
+	if yk == nil || account == "" {
+		return "", fmt.Errorf("profile %s has no YubiKey OATH account", profile)
+	}
+	out, err := runYkman(ctx, yk, "oath", "accounts", "code", "--single", account)
+	if err != nil {
+		return "", fmt.Errorf("reading the code for %s from the YubiKey: %w", account, err)
+	}
+	code := strings.TrimSpace(out)
+	if !totpCodePattern.MatchString(code) {
+		return "", fmt.Errorf("ykman did not print a six-digit code for %s", account)
+	}
+	return code, nil
+}
+
+// listYubiKeyAccounts lists the key's OATH accounts with the profiles
+// mapped to each
+func listYubiKeyAccounts(ctx context.Context, yk *YubiKeySettings) ([]YubiKeyAccount, error) {
+	out, err := runYkman(ctx, yk, "oath", "accounts", "list")
+	if err != nil {
+		return nil, err
+	}
+	byAccount := make(map[string][]string)
+	for profile, ps := range loadSettings().Profiles {
+		if ps.YubiKeyAccount != "" {
+			byAccount[ps.YubiKeyAccount] = append(byAccount[ps.YubiKeyAccount], profile)
+		}
+	}
+
+	accounts := []YubiKeyAccount{}
+	for _, line := range strings.Split(out, "\n") {
+		name := strings.TrimSpace(line)
+		if name == "" {
+			continue
+		}
+		profiles := byAccount[name]
+		sort.Strings(profiles)
+		if profiles == nil {
+			profiles = []string{}
+		}
+		accounts = append(accounts, YubiKeyAccount{Name: name, Profiles: profiles})
+	}
+	return accounts, nil
+}
+
+// handleListYubiKeyAccounts is GET /mfa/devices?source=yubikey
+func handleListYubiKeyAccounts(c echo.Context) error {
+	yk := yubikeySettings()
+	if yk == nil {
+		return c.JSON(http.StatusConflict, ErrorResponse{
+			Error: "YubiKey integration is disabled",
+		})
+	}
+	accounts, err := listYubiKeyAccounts(c.Request().Context(), yk)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "Failed to list YubiKey OATH accounts",
+			Details: err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, accounts)
+}
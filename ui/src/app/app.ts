@@ -341,6 +341,8 @@ export class App implements OnInit, OnDestroy {
         return 'Windows';
       case 'custom':
         return 'Custom Path';
+      case 'mounted':
+        return 'Mounted host home';
       default:
         return source;
     }
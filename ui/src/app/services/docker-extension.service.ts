@@ -1,7 +1,7 @@
 import { Injectable } from '@angular/core';
 import { createDockerDesktopClient } from '@docker/extension-api-client';
 
-export type CredentialSource = 'auto' | 'linux' | 'wsl2' | 'windows' | 'custom';
+export type CredentialSource = 'auto' | 'linux' | 'wsl2' | 'windows' | 'custom' | 'mounted';
 
 export interface AWSPathInfo {
   source: CredentialSource;
@@ -21,6 +21,164 @@ export interface EnvironmentInfo {
   activeSource: CredentialSource;
   homeDir: string;
   windowsHomeDir?: string;
+  arch: string;
+  capabilities: Capability[];
+  sourceHealth: SourceHealth[];
+  sourceIdentity?: string;
+}
+
+export interface SourceHealth {
+  source: CredentialSource;
+  description: string;
+  kind: 'config' | 'credentials' | 'directory';
+  path: string;
+  active: boolean;
+  status: 'ok' | 'missing' | 'unreachable' | 'unreadable' | 'invalid' | 'timeout';
+  error?: string;
+  lastChecked: string;
+  lastHealthy?: string;
+  since: string;
+}
+
+export interface Capability {
+  name: string;
+  available: boolean;
+  reason?: string;
+}
+
+export interface TeamSyncSettings {
+  bucket: string;
+  key?: string;
+  region?: string;
+  profile?: string;
+}
+
+export interface RemoteAccessSettings {
+  enabled: boolean;
+  listen?: string;
+}
+
+export interface PolicyRule {
+  name: string;
+  type: 'clearAt' | 'maxLifetime';
+  profiles?: string[];
+  at?: string;
+  cron?: string;
+  timezone?: string;
+  maxHours?: number;
+}
+
+export interface JobSpec {
+  name: string;
+  cron: string;
+  timezone?: string;
+  profiles?: string[];
+}
+
+export interface JobValidateRequest {
+  jobs?: JobSpec[];
+  policies?: PolicyRule[];
+  timezone?: string;
+  count?: number;
+}
+
+export interface JobPreview {
+  name: string;
+  valid: boolean;
+  error?: string;
+  timezone?: string;
+  next?: string[];
+}
+
+export interface JobValidation {
+  valid: boolean;
+  jobs: JobPreview[];
+  conflicts: { jobs: string[]; reason: string }[];
+}
+
+export interface ProfileSettings {
+  fips?: boolean;
+  dualStack?: boolean;
+  preLogin?: LoginHook[];
+  postLogin?: LoginHook[];
+  expectedAccount?: string;
+  duration?: SessionDuration;
+  sensitive?: boolean;
+  sessionPolicy?: string;
+  sessionPolicyArns?: string[];
+  yubikeyAccount?: string;
+  onePasswordItem?: string;
+  onePasswordVault?: string;
+  bitwardenItem?: string;
+  passEntry?: string;
+  tokenSources?: TokenSource[];
+  stsEndpoint?: 'global' | 'regional';
+  endpointUrl?: string;
+  endpoints?: Record<string, string>;
+  canaries?: Canary[];
+  credentialsFileSession?: boolean;
+  viewerRoleArn?: string;
+}
+
+export type CanaryAction =
+  | 'sts:GetCallerIdentity'
+  | 'ecr:GetAuthorizationToken'
+  | 's3:ListBucket'
+  | 's3:GetObject'
+  | 'ssm:GetParameter'
+  | 'secretsmanager:DescribeSecret'
+  | 'dynamodb:DescribeTable'
+  | 'sqs:GetQueueAttributes'
+  | 'sns:GetTopicAttributes';
+
+export interface Canary {
+  action: CanaryAction;
+  resource?: string;
+  region?: string;
+  timeoutSeconds?: number;
+}
+
+export interface CanaryResult {
+  action: CanaryAction;
+  resource?: string;
+  ok: boolean;
+  code?: string;
+  error?: string;
+  durationMs: number;
+}
+
+export type ConfirmAction = 'login' | 'export' | 'login-and-export';
+
+export interface Confirmation {
+  token: string;
+  action: ConfirmAction;
+  profiles: string[];
+  expiresAt: string;
+}
+
+export interface LoginHook {
+  command: string;
+  timeoutSeconds?: number;
+}
+
+export interface HookResult {
+  stage: 'preLogin' | 'postLogin';
+  command: string;
+  exitCode: number;
+  output?: string;
+  error?: string;
+  timedOut?: boolean;
+  durationMs: number;
+}
+
+export interface BrokerConsumer {
+  name: string;
+  profiles: string[];
+}
+
+export interface BrokerSettings {
+  enabled: boolean;
+  consumers?: BrokerConsumer[];
 }
 
 export interface Settings {
@@ -28,20 +186,553 @@ export interface Settings {
   customConfigPath?: string;
   customCredsPath?: string;
   wsl2Distro?: string;
+  deviceBinding?: boolean;
+  teamSync?: TeamSyncSettings;
+  exportRegions?: string[];
+  latencyRegions?: string[];
+  keyValidation?: KeyValidationSettings;
+  viewerSessions?: boolean;
+  viewerPolicy?: string;
+  envPrefixes?: Record<string, string>;
+  remoteAccess?: RemoteAccessSettings;
+  policies?: PolicyRule[];
+  endpoints?: Record<string, string>;
+  profiles?: Record<string, ProfileSettings>;
+  broker?: BrokerSettings;
+  defaultProfile?: string;
+  notifications?: NotificationSettings;
+  autoProvision?: AutoProvisionSettings;
+  containerEndpoint?: ContainerEndpointSettings;
+  hygiene?: HygieneSettings;
+  ecrCache?: ECRCacheSettings;
+  defaultDuration?: SessionDuration;
+  pathRemaps?: PathRemap[];
+  stsRegionalEndpoints?: 'regional' | 'legacy';
+  processScope?: ProcessScopeSettings;
+  yubikey?: YubiKeySettings;
+  onePassword?: OnePasswordSettings;
+  bitwarden?: BitwardenSettings;
+  pass?: PassSettings;
+  timezone?: string;
+  cliCache?: boolean;
+  credentialsFileSessions?: boolean;
+  cliImage?: string;
+}
+
+export interface PassSettings {
+  enabled: boolean;
+  command?: string;
+  storeDir?: string;
+  gnupgHome?: string;
+}
+
+export type TokenSource = 'yubikey' | '1password' | 'bitwarden' | 'pass' | 'mfa_process' | 'totp' | 'manual';
+
+export interface BitwardenSettings {
+  enabled: boolean;
+  command?: string;
+  sessionFile?: string;
+  appDataDir?: string;
+}
+
+export interface TokenSourceInfo {
+  name: TokenSource;
+  description: string;
+  configured: boolean;
+}
+
+export interface TokenSourceChain {
+  profile: string;
+  custom: boolean;
+  chain: TokenSourceInfo[];
+  selected: TokenSource;
+}
+
+export interface StoredTOTPInfo {
+  profile: string;
+  savedAt: string;
+}
+
+export interface MFAProviderStatus {
+  name: 'yubikey' | '1password' | 'bitwarden' | 'pass';
+  enabled: boolean;
+  command?: string;
+  state: string;
+  details?: string;
+  profiles: string[];
+}
+
+export interface OnePasswordSettings {
+  enabled: boolean;
+  command?: string;
+  account?: string;
+  serviceAccountTokenFile?: string;
+}
+
+export interface YubiKeySettings {
+  enabled: boolean;
+  command?: string;
+  device?: string;
+}
+
+export interface YubiKeyAccount {
+  name: string;
+  profiles: string[];
+}
+
+export interface MFADevice {
+  serialNumber: string;
+  type: 'totp' | 'fido';
+  usableForCli: boolean;
+}
+
+export interface MFADiscovery {
+  profile: string;
+  current?: string;
+  devices: MFADevice[];
+  suggested?: string;
+  written?: boolean;
+}
+
+export interface MFAImportEntry {
+  profile: string;
+  user?: string;
+  accessKeyId?: string;
+  serialNumber?: string;
+  status: 'written' | 'planned' | 'skipped';
+  reason?: string;
+}
+
+export interface MFAImportResult {
+  profile: string;
+  dryRun?: boolean;
+  reportGeneratedAt?: string;
+  entries: MFAImportEntry[];
+  written: number;
+}
+
+export interface QuarantinedFile {
+  path: string;
+  quarantinePath: string;
+  error: string;
+  time: string;
+}
+
+export interface CacheIntegrityStats {
+  lastScan?: string;
+  filesChecked: number;
+  corrupt: number;
+  quarantined: number;
+  quarantineFailures: number;
+  inQuarantine: number;
+  recent: QuarantinedFile[];
+}
+
+export interface CacheRepairReport {
+  filesChecked: number;
+  quarantined: QuarantinedFile[];
+  tempFilesRemoved: number;
+  backups: { kept: number; added: number; dropped: number };
+}
+
+export interface ListenerChange {
+  name: string;
+  action: 'started' | 'stopped' | 'moved' | 'reconfigured' | 'rebound' | 'unchanged' | 'disabled' | 'failed';
+  address?: string;
+  error?: string;
+}
+
+export interface ReloadResult {
+  reloadedAt: string;
+  changed: string[];
+  listeners: ListenerChange[];
+}
+
+export interface StartupInfo {
+  event: 'ready';
+  startedAt: string;
+  readyAt: string;
+  pid: number;
+  version: string;
+  goVersion: string;
+  socket: string;
+  listeners: ListenerChange[];
+  environment: {
+    os: string;
+    arch: string;
+    isWsl2: boolean;
+    behindRouter: boolean;
+  };
+  settings: {
+    path: string;
+    loaded: boolean;
+    credentialSource: CredentialSource;
+    defaultProfile?: string;
+    defaultDuration?: SessionDuration;
+    profileSettings: number;
+    policies: number;
+  };
+  features: Record<string, boolean>;
+  quarantined: number;
+  migratedSessions: number;
+}
+
+export interface ProcessScopeSettings {
+  enabled: boolean;
+}
+
+export interface ScopeParent {
+  pid: number;
+  command?: string;
+  registeredAt: string;
+}
+
+export interface ProcessScopeStatus {
+  enabled: boolean;
+  available: boolean;
+  reason?: string;
+  parents: ScopeParent[];
+}
+
+export interface PathRemap {
+  host: string;
+  mounted: string;
+}
+
+export type ExportJobStatus = 'pending' | 'running' | 'succeeded' | 'failed';
+
+export interface ExportJob {
+  id: string;
+  sink: string;
+  profile?: string;
+  profiles?: string;
+  target: Record<string, unknown>;
+  status: ExportJobStatus;
+  attempts: number;
+  maxAttempts: number;
+  lastError?: string;
+  nextAttemptAt?: string;
+  createdAt: string;
+  updatedAt: string;
+  receipt?: { sink: string; location: string; details?: Record<string, string> };
+}
+
+export interface ECRCacheSettings {
+  enabled: boolean;
+  ttlSeconds?: number;
+}
+
+export interface ECRRepository {
+  name: string;
+  uri: string;
+  createdAt?: string;
+}
+
+export interface ECRImage {
+  digest: string;
+  tags: string[];
+  pushedAt?: string;
+  sizeBytes: number;
+  mediaType?: string;
+}
+
+export interface ECRManifest {
+  repository: string;
+  digest: string;
+  tag?: string;
+  mediaType: string;
+  manifest: string;
+}
+
+export interface HygieneSettings {
+  disabled?: boolean;
+  intervalHours?: number;
+  maxKeyAgeDays?: number;
+}
+
+export type HygieneCheck =
+  | 'key-age'
+  | 'no-mfa'
+  | 'session-duration'
+  | 'plaintext-cache'
+  | 'risky-export';
+
+export interface KeyAge {
+  profile: string;
+  keyId: string;
+  createdAt?: string;
+  firstSeen: string;
+  ageDays: number;
+  source: 'iam' | 'first-seen';
+}
+
+export interface HygieneFinding {
+  check: HygieneCheck;
+  severity: 'warning' | 'info';
+  profile?: string;
+  location?: string;
+  message: string;
+}
+
+export interface HygieneReport {
+  generatedAt: string;
+  keys: KeyAge[];
+  findings: HygieneFinding[];
+  counts: Partial<Record<HygieneCheck, number>>;
+}
+
+export interface ProfileTimeline {
+  profile: string;
+  coverage: number[];
+  coveredSeconds: number;
+  coverageRatio: number;
+  logins: number;
+  expired: number;
+  cleared: number;
+}
+
+export interface SessionTimeline {
+  from: string;
+  to: string;
+  bucketSeconds: number;
+  buckets: string[];
+  profiles: ProfileTimeline[];
+}
+
+export interface TrustCondition {
+  operator: string;
+  key: string;
+  values: string[];
+  status: 'satisfied' | 'unsatisfied' | 'unknown';
+  actual?: string;
+}
+
+export interface TrustStatement {
+  sid?: string;
+  effect: 'Allow' | 'Deny';
+  actions: string[];
+  principals: Record<string, string[]>;
+  matchesCaller: boolean;
+  matchesAction: boolean;
+  conditions?: TrustCondition[];
+  conditionStatus: TrustCondition['status'];
+}
+
+export interface TrustInspection {
+  roleArn: string;
+  caller: string;
+  callerAccount: string;
+  policy: string;
+  statements: TrustStatement[];
+  principalTrusted: boolean;
+  mfaRequired: boolean;
+  mfaPresent: boolean;
+  externalIdRequired: boolean;
+  verdict: 'allowed' | 'denied' | 'unknown';
+  reasons?: string[];
+}
+
+export interface ContainerEndpointSettings {
+  enabled: boolean;
+  listen?: string;
+  volume?: string;
+  profiles: string[];
+  rotateMinutes?: number;
+  allowedSources?: string[];
+  allowForwarded?: boolean;
+  tokenTtlMinutes?: number;
+  tokenOwners?: Record<string, string>;
+}
+
+export interface AutoProvisionSettings {
+  enabled: boolean;
+  profiles: string[];
+}
+
+export interface NotificationChannel {
+  name: string;
+  type: 'desktop' | 'webhook' | 'slack' | 'log';
+  url?: string;
+  path?: string;
+}
+
+export interface NotificationSettings {
+  channels?: NotificationChannel[];
+  routes?: Record<string, string[]>;
+}
+
+export interface KeyValidationSettings {
+  disabled?: boolean;
+  intervalMinutes?: number;
+}
+
+export interface KeyValidation {
+  profile: string;
+  status: 'valid' | 'invalid' | 'unknown';
+  code?: string;
+  error?: string;
+  keyId: string;
+  checkedAt: string;
+}
+
+export interface CredentialValidation {
+  profile: string;
+  valid: boolean;
+  status: 'valid' | 'expired' | 'invalid' | 'clockSkew' | 'unknown';
+  code?: string;
+  error?: string;
+  account?: string;
+  arn?: string;
+  expiration: string;
+  clockSkewSeconds?: number;
+  warning?: string;
+  checkedAt: string;
+}
+
+export interface KeyOrigin {
+  file: string;
+  section: string;
+  line: number;
+  kind: 'config' | 'credentials';
+  source: CredentialSource;
+}
+
+export type ProfileSourceType =
+  | 'mfa'
+  | 'assumeRole'
+  | 'credentialProcess'
+  | 'saml'
+  | 'ssoSession'
+  | 'ssoLegacy'
+  | 'grantedSSO';
+
+export interface GrantedInfo {
+  generatedFrom?: string;
+  credentialProcess: boolean;
+}
+
+export interface SSOSessionInfo {
+  name: string;
+  startUrl?: string;
+  region?: string;
+  registrationScopes?: string[];
+  error?: string;
 }
 
 export interface Profile {
   name: string;
   region: string;
   mfaSerial: string;
+  mfaType?: 'totp' | 'fido';
+  sso?: boolean;
+  sourceType: ProfileSourceType;
+  ssoSession?: SSOSessionInfo;
   source?: string;
+  configFile?: string;
+  configSection?: string;
+  credentialsFile?: string;
+  credentialsSection?: string;
+  keys?: Record<string, KeyOrigin>;
+  keyStatus?: KeyValidation;
+  role?: RoleInfo;
+  granted?: GrantedInfo;
+  account?: ProfileAccount;
+}
+
+export interface SettingsSummary {
+  credentialSource: CredentialSource;
+  defaultProfile?: string;
+  timezone?: string;
+  stsRegionalEndpoints?: 'regional' | 'legacy';
+  deviceBinding?: boolean;
+  viewerSessions?: boolean;
+  cliCache?: boolean;
+  configuredProfiles: number;
+  policies: number;
+  features: string[];
+}
+
+export interface Snapshot {
+  generatedAt: string;
+  version: {
+    version: string;
+    goVersion: string;
+    os: string;
+    arch: string;
+    capabilities: Capability[];
+  };
+  environment: EnvironmentInfo;
+  settings: SettingsSummary;
+  profiles: Profile[];
+  statuses: Status[];
+  exports: ExportJob[];
+  events: { type: string; profile?: string; time: string; data?: unknown }[];
+  errors?: Record<string, string>;
+}
+
+export interface ConsoleURL {
+  profile: string;
+  url: string;
+  destination: string;
+  linkExpiresAt: string;
+  sessionExpiresAt: string;
+}
+
+export interface ProfileAccount {
+  id?: string;
+  alias?: string;
+  label?: string;
+  source?: 'identity' | 'config';
+  fetchedAt?: string;
+  error?: string;
+}
+
+export interface RoleInfo {
+  arn: string;
+  accountId?: string;
+  name?: string;
+  sourceProfile?: string;
+  credentialSource?: string;
+  baseProfile?: string;
+  chain?: string[];
+  roleSessionName?: string;
+  hasExternalId?: boolean;
+  mfaRequired: boolean;
+  mfaSerial?: string;
+  error?: string;
 }
 
 export interface Status {
   profile: string;
   authenticated: boolean;
   expiration?: string;
+  expiresAt?: string;
+  secondsRemaining: number;
   timeRemaining?: string;
+  expiresAtLocal?: string;
+  timezone?: string;
+  expiresDay?: 'today' | 'tomorrow' | 'later' | 'expired';
+  expiresHint?: string;
+  warning?: string;
+  throttle?: ThrottleStatus;
+  profileResolution?: 'explicit' | 'settings' | 'AWS_PROFILE' | 'fallback';
+  hooks?: HookResult[];
+  accountMismatch?: AccountMismatch;
+  canaries?: CanaryResult[];
+  durationSeconds?: number;
+  pollIntervalSeconds?: number;
+}
+
+export interface AccountMismatch {
+  expected: string;
+  actual: string;
+}
+
+export interface ThrottleStatus {
+  throttled: boolean;
+  events: number;
+  calls: number;
+  refreshIntervalSeconds: number;
 }
 
 export interface Credentials {
@@ -50,12 +741,151 @@ export interface Credentials {
   sessionToken: string;
   expiration: string;
   profile?: string;
+  stsEndpoint?: string;
+  stsGlobal?: boolean;
+}
+
+export interface ComposeDelivery {
+  profile: string;
+  generation: string;
+  path?: string;
+  deliveredAt: string;
+  expiresAt: string;
+  expired: boolean;
+  current: boolean;
+}
+
+export interface ComposeContainer {
+  id: string;
+  name: string;
+  state: string;
+  delivery?: ComposeDelivery;
+}
+
+export interface ComposeService {
+  name: string;
+  profile?: string;
+  envFile?: string;
+  status: 'none' | 'pending' | 'provisioned' | 'stale' | 'denied';
+  containers: ComposeContainer[];
+}
+
+export interface ComposeProject {
+  name: string;
+  workingDir?: string;
+  configFiles?: string;
+  services: ComposeService[];
+}
+
+export interface SAMLRolePair {
+  roleArn: string;
+  principalArn: string;
+}
+
+export interface SAMLRoles {
+  profile: string;
+  roles: SAMLRolePair[];
+  selected?: SAMLRolePair;
+  maxDuration?: number;
+  expiresAt: string;
+}
+
+export interface SAMLLoginRequest {
+  profile: string;
+  roleArn?: string;
+  principalArn?: string;
+  duration?: SessionDuration;
+}
+
+export interface SSOLoginStart {
+  status: 'pending' | 'complete';
+  id?: string;
+  profile: string;
+  userCode?: string;
+  verificationUri?: string;
+  verificationUriComplete?: string;
+  interval?: number;
+  expiresAt?: string;
+  session?: Status;
+}
+
+export interface OrgAccount {
+  id: string;
+  name: string;
+  email?: string;
+  status: string;
+  joinedAt?: string;
+  management?: boolean;
+  profiles?: string[];
+  roles: string[];
+}
+
+export interface OrgAccountList {
+  profile: string;
+  organizationId?: string;
+  managementAccountId?: string;
+  roleNames: string[];
+  accounts: OrgAccount[];
+}
+
+export interface SSOCachedSession {
+  file: string;
+  session?: string;
+  startUrl: string;
+  region: string;
+  expiresAt: string;
+  expired: boolean;
+  refreshable: boolean;
+  profiles: string[];
+}
+
+export interface SSOLoginPoll {
+  status: 'pending' | 'authorized' | 'complete';
+  interval?: number;
+  session?: Status;
+}
+
+export interface Sandbox {
+  name: string;
+  kind: 's3' | 'dynamodb';
+  profile: string;
+  region: string;
+  session: string;
+  createdAt: string;
+  sessionActive: boolean;
+}
+
+// Seconds, or a duration string such as "8h", "45m" or "1d12h". The
+// backend always returns seconds.
+export type SessionDuration = number | string;
+
+export interface PromptConsumer {
+  container?: string;
+  path?: string;
+  expiresAt: string;
+}
+
+export interface Prompt {
+  id: string;
+  profile: string;
+  reason: 'renewal';
+  mfaSerial?: string;
+  consumers: PromptConsumer[];
+  createdAt: string;
+  deadline: string;
 }
 
 export interface LoginRequest {
   profile: string;
+  // May be empty for profiles mapped to a YubiKey OATH account or a
+  // 1Password item
   tokenCode: string;
-  duration?: number;
+  duration?: SessionDuration;
+  externalId?: string;
+  tags?: Record<string, string>;
+  transitiveTags?: string[];
+  policy?: string;
+  policyArns?: string[];
 }
 
 @Injectable({
@@ -81,30 +911,167 @@ export class DockerExtensionService {
     return response as Settings;
   }
 
+  async reload(): Promise<ReloadResult> {
+    const response = await this.ddClient.extension.vm?.service?.post('/reload', {});
+    return response as ReloadResult;
+  }
+
+  async getStartupInfo(): Promise<StartupInfo> {
+    const response = await this.ddClient.extension.vm?.service?.get('/startup-info');
+    return response as StartupInfo;
+  }
+
   // Profiles and Authentication
 
-  async getProfiles(): Promise<Profile[]> {
-    const response = await this.ddClient.extension.vm?.service?.get('/profiles');
+  async getProfiles(accounts = false): Promise<Profile[]> {
+    const response = await this.ddClient.extension.vm?.service?.get(accounts ? '/profiles?accounts=true' : '/profiles');
     return response as Profile[];
   }
 
+  // The backend runs on UTC in the VM, so calls that return statuses send
+  // the browser's timezone for the local expiry times
+  private timezoneHeaders(): Record<string, string> {
+    return { 'X-Timezone': Intl.DateTimeFormat().resolvedOptions().timeZone };
+  }
+
   async getStatus(profile: string): Promise<Status> {
-    const response = await this.ddClient.extension.vm?.service?.get(
-      `/status?profile=${profile}`
-    );
+    const response = await this.ddClient.extension.vm?.service?.request({
+      url: `/status?profile=${profile}`,
+      method: 'GET',
+      headers: this.timezoneHeaders(),
+      data: undefined,
+    });
     return response as Status;
   }
 
   async getAllStatuses(): Promise<Status[]> {
-    const response = await this.ddClient.extension.vm?.service?.get('/status/all');
+    const response = await this.ddClient.extension.vm?.service?.request({
+      url: '/status/all',
+      method: 'GET',
+      headers: this.timezoneHeaders(),
+      data: undefined,
+    });
     return response as Status[];
   }
 
-  async login(request: LoginRequest): Promise<Status> {
-    const response = await this.ddClient.extension.vm?.service?.post('/login', request);
+  async getSnapshot(): Promise<Snapshot> {
+    const response = await this.ddClient.extension.vm?.service?.request({
+      url: '/snapshot',
+      method: 'GET',
+      headers: this.timezoneHeaders(),
+      data: undefined,
+    });
+    return response as Snapshot;
+  }
+
+  async getConsoleURL(profile: string, service?: string, region?: string): Promise<ConsoleURL> {
+    const page =
+      (service ? `&service=${encodeURIComponent(service)}` : '') +
+      (region ? `&region=${encodeURIComponent(region)}` : '');
+    const response = await this.ddClient.extension.vm?.service?.get(
+      `/console-url?profile=${encodeURIComponent(profile)}${page}`
+    );
+    return response as ConsoleURL;
+  }
+
+  async login(request: LoginRequest, confirmToken?: string): Promise<Status> {
+    const headers = this.timezoneHeaders();
+    if (confirmToken) {
+      headers['X-Confirm-Token'] = confirmToken;
+    }
+    const response = await this.ddClient.extension.vm?.service?.request({
+      url: '/login',
+      method: 'POST',
+      headers,
+      data: request,
+    });
     return response as Status;
   }
 
+  async confirm(action: ConfirmAction, profile: string): Promise<Confirmation> {
+    const response = await this.ddClient.extension.vm?.service?.get(
+      `/confirm?action=${action}&profile=${profile}`
+    );
+    return response as Confirmation;
+  }
+
+  async discoverMFA(profile: string): Promise<MFADiscovery> {
+    const response = await this.ddClient.extension.vm?.service?.get(
+      `/profiles/${encodeURIComponent(profile)}/discover-mfa`
+    );
+    return response as MFADiscovery;
+  }
+
+  async writeDiscoveredMFA(profile: string, serialNumber?: string, replace = false): Promise<MFADiscovery> {
+    const response = await this.ddClient.extension.vm?.service?.post(
+      `/profiles/${encodeURIComponent(profile)}/discover-mfa`,
+      { serialNumber, replace }
+    );
+    return response as MFADiscovery;
+  }
+
+  async importMFASerials(profile: string, profiles?: string[], dryRun = false): Promise<MFAImportResult> {
+    const response = await this.ddClient.extension.vm?.service?.post(
+      '/profiles/mfa-import',
+      { profile, profiles, dryRun }
+    );
+    return response as MFAImportResult;
+  }
+
+  async getYubiKeyAccounts(): Promise<YubiKeyAccount[]> {
+    const response = await this.ddClient.extension.vm?.service?.get('/mfa/devices?source=yubikey');
+    return response as YubiKeyAccount[];
+  }
+
+  async getMFAProvidersStatus(): Promise<MFAProviderStatus[]> {
+    const response = await this.ddClient.extension.vm?.service?.get('/mfa/providers/status');
+    return response as MFAProviderStatus[];
+  }
+
+  async getTokenSources(profile: string): Promise<TokenSourceChain> {
+    const response = await this.ddClient.extension.vm?.service?.get(`/token-sources?profile=${profile}`);
+    return response as TokenSourceChain;
+  }
+
+  async storeTOTPSeed(profile: string, secret: string): Promise<StoredTOTPInfo> {
+    const response = await this.ddClient.extension.vm?.service?.put(`/token-sources/totp/${profile}`, { secret });
+    return response as StoredTOTPInfo;
+  }
+
+  async deleteTOTPSeed(profile: string): Promise<void> {
+    await this.ddClient.extension.vm?.service?.delete(`/token-sources/totp/${profile}`);
+  }
+
+  async startSSOLogin(profile: string, force = false): Promise<SSOLoginStart> {
+    const response = await this.ddClient.extension.vm?.service?.post('/sso/start', { profile, force });
+    return response as SSOLoginStart;
+  }
+
+  async getSSOSessions(): Promise<SSOCachedSession[]> {
+    const response = await this.ddClient.extension.vm?.service?.get('/sso/sessions');
+    return response as SSOCachedSession[];
+  }
+
+  async pollSSOLogin(id: string): Promise<SSOLoginPoll> {
+    const response = await this.ddClient.extension.vm?.service?.post('/sso/poll', { id });
+    return response as SSOLoginPoll;
+  }
+
+  async getOrganizationAccounts(profile: string, roleNames: string[] = []): Promise<OrgAccountList> {
+    const roleName = roleNames.length ? `&roleName=${encodeURIComponent(roleNames.join(','))}` : '';
+    const response = await this.ddClient.extension.vm?.service?.get(
+      `/organizations/accounts?profile=${encodeURIComponent(profile)}${roleName}`
+    );
+    return response as OrgAccountList;
+  }
+
+  async inspectRoleTrust(profile: string, role: string, externalId = ''): Promise<TrustInspection> {
+    const response = await this.ddClient.extension.vm?.service?.get(
+      `/roles/${encodeURIComponent(role)}/trust?profile=${profile}&externalId=${encodeURIComponent(externalId)}`
+    );
+    return response as TrustInspection;
+  }
+
   async getCredentials(profile: string): Promise<Credentials> {
     const response = await this.ddClient.extension.vm?.service?.get(
       `/credentials?profile=${profile}`
@@ -112,11 +1079,168 @@ export class DockerExtensionService {
     return { ...(response as Credentials), profile };
   }
 
+  async validateCredentials(profile: string): Promise<CredentialValidation> {
+    const response = await this.ddClient.extension.vm?.service?.post(
+      '/credentials/validate',
+      { profile }
+    );
+    return response as CredentialValidation;
+  }
+
   async clearCredentials(profile?: string): Promise<void> {
     const query = profile ? `?profile=${profile}` : '';
     await this.ddClient.extension.vm?.service?.delete(`/credentials${query}`);
   }
 
+  async getCacheIntegrity(): Promise<CacheIntegrityStats> {
+    const response = await this.ddClient.extension.vm?.service?.get('/cache/integrity');
+    return response as CacheIntegrityStats;
+  }
+
+  async repairCache(): Promise<CacheRepairReport> {
+    const response = await this.ddClient.extension.vm?.service?.post('/cache/repair', {});
+    return response as CacheRepairReport;
+  }
+
+  // MFA prompts
+
+  async getPrompts(): Promise<Prompt[]> {
+    const response = await this.ddClient.extension.vm?.service?.get('/prompts');
+    return response as Prompt[];
+  }
+
+  async completePrompt(id: string, tokenCode: string, duration?: SessionDuration): Promise<Status> {
+    const response = await this.ddClient.extension.vm?.service?.request({
+      url: `/prompts/${id}/complete`,
+      method: 'POST',
+      headers: this.timezoneHeaders(),
+      data: { tokenCode, duration },
+    });
+    return response as Status;
+  }
+
+  async dismissPrompt(id: string): Promise<void> {
+    await this.ddClient.extension.vm?.service?.delete(`/prompts/${id}`);
+  }
+
+  // SAML federation
+
+  async getSAMLRoles(profile: string, refresh = false): Promise<SAMLRoles> {
+    const query = refresh ? '&refresh=true' : '';
+    const response = await this.ddClient.extension.vm?.service?.get(`/saml/roles?profile=${profile}${query}`);
+    return response as SAMLRoles;
+  }
+
+  async samlLogin(request: SAMLLoginRequest): Promise<Status> {
+    const response = await this.ddClient.extension.vm?.service?.request({
+      url: '/saml/login',
+      method: 'POST',
+      headers: this.timezoneHeaders(),
+      data: request,
+    });
+    return response as Status;
+  }
+
+  // Scheduling
+
+  async validateJobs(request: JobValidateRequest): Promise<JobValidation> {
+    const response = await this.ddClient.extension.vm?.service?.post('/jobs/validate', request);
+    return response as JobValidation;
+  }
+
+  // Reports
+
+  async getHygieneReport(): Promise<HygieneReport> {
+    const response = await this.ddClient.extension.vm?.service?.get('/reports/hygiene');
+    return response as HygieneReport;
+  }
+
+  async refreshHygieneReport(): Promise<HygieneReport> {
+    const response = await this.ddClient.extension.vm?.service?.post('/reports/hygiene/refresh', {});
+    return response as HygieneReport;
+  }
+
+  async getSessionTimeline(days = 7, bucket = '1h', profile = ''): Promise<SessionTimeline> {
+    const timezone = Intl.DateTimeFormat().resolvedOptions().timeZone;
+    const response = await this.ddClient.extension.vm?.service?.get(
+      `/history/timeline?days=${days}&bucket=${bucket}&profile=${profile}&timezone=${encodeURIComponent(timezone)}`
+    );
+    return response as SessionTimeline;
+  }
+
+  // Containers
+
+  async getComposeProjects(): Promise<ComposeProject[]> {
+    const response = await this.ddClient.extension.vm?.service?.get('/compose/projects');
+    return response as ComposeProject[];
+  }
+
+  // ECR
+
+  async getECRRepositories(profile: string, refresh = false): Promise<ECRRepository[]> {
+    const response = await this.ddClient.extension.vm?.service?.get(
+      `/ecr/repositories?profile=${profile}&refresh=${refresh}`
+    );
+    return response as ECRRepository[];
+  }
+
+  async getECRImages(profile: string, repository: string, refresh = false): Promise<ECRImage[]> {
+    const response = await this.ddClient.extension.vm?.service?.get(
+      `/ecr/images?profile=${profile}&repository=${encodeURIComponent(repository)}&refresh=${refresh}`
+    );
+    return response as ECRImage[];
+  }
+
+  async getECRManifest(profile: string, repository: string, digest: string): Promise<ECRManifest> {
+    const response = await this.ddClient.extension.vm?.service?.get(
+      `/ecr/manifest?profile=${profile}&repository=${encodeURIComponent(repository)}&digest=${digest}`
+    );
+    return response as ECRManifest;
+  }
+
+  // Sandboxes
+
+  async getSandboxes(): Promise<Sandbox[]> {
+    const response = await this.ddClient.extension.vm?.service?.get('/sandboxes');
+    return response as Sandbox[];
+  }
+
+  async createSandbox(profile: string, kind: Sandbox['kind'], region?: string): Promise<Sandbox> {
+    const response = await this.ddClient.extension.vm?.service?.post('/sandboxes', {
+      profile,
+      kind,
+      region,
+    });
+    return response as Sandbox;
+  }
+
+  async destroySandbox(name: string): Promise<void> {
+    await this.ddClient.extension.vm?.service?.delete(`/sandboxes/${name}`);
+  }
+
+  // Export queue
+
+  async getExportJobs(status?: ExportJobStatus): Promise<ExportJob[]> {
+    const query = status ? `?status=${status}` : '';
+    const response = await this.ddClient.extension.vm?.service?.get(`/export/jobs${query}`);
+    return response as ExportJob[];
+  }
+
+  async deleteExportJob(id: string): Promise<void> {
+    await this.ddClient.extension.vm?.service?.delete(`/export/jobs/${id}`);
+  }
+
+  // Process scoping
+
+  async getProcessScope(): Promise<ProcessScopeStatus> {
+    const response = await this.ddClient.extension.vm?.service?.get('/process-scope');
+    return response as ProcessScopeStatus;
+  }
+
+  async unregisterScopeParent(pid: number): Promise<void> {
+    await this.ddClient.extension.vm?.service?.delete(`/process-scope/parents/${pid}`);
+  }
+
   async exportEnvFile(profile: string, path: string): Promise<void> {
     await this.ddClient.extension.host?.cli.exec('docker-aws', ['env', '-p', profile, '-o', path]);
   }